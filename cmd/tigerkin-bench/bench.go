@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tnet"
+	"github.com/HOU-SZ/tigerkin/utils"
+)
+
+// 同时建立连接的最大数量
+const maxDialing = 100
+
+// 限速时每个连接未回复消息的最大数量，超出时发送被阻塞，延迟仍然从计划发送的时间开始计算
+const maxInflight = 4096
+
+/*
+	压力测试的参数
+*/
+type config struct {
+	Addr     string
+	Conns    int
+	Duration time.Duration
+	// 所有连接每秒发送的消息总数，为0时不限速
+	Rate float64
+	// 不限速时每个连接未回复消息的数量
+	Window int
+	// 轮流发送的msgId和数据长度
+	MsgIds []uint32
+	Sizes  []int
+	// 发送结束之后等待剩余回复的时长
+	Timeout time.Duration
+	// 鉴权Token，为空时不鉴权
+	Token string
+}
+
+/*
+	一个连接的压力测试
+*/
+type worker struct {
+	conf   config
+	client *tnet.Client
+	// 计划发送的时间，按发送顺序排列，收到回复时取出计算延迟
+	inflight chan time.Time
+	// 不限速时限制未回复消息的数量
+	window chan struct{}
+
+	sent      uint64
+	sentBytes uint64
+	received  uint64
+	errors    uint64
+	// 等待回复超时之后关闭连接，此时的读取错误不计入errors
+	stopping int32
+	// 每个回复的往返延迟
+	latencies []time.Duration
+}
+
+// 执行压力测试
+func bench(conf config) (*Result, error) {
+	if conf.Conns <= 0 || len(conf.MsgIds) == 0 || len(conf.Sizes) == 0 {
+		return nil, errors.New("conns, msgids and sizes are required")
+	}
+	if conf.Rate <= 0 && conf.Window <= 0 {
+		conf.Window = 1
+	}
+	host, portStr, err := net.SplitHostPort(conf.Addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		Addr:     conf.Addr,
+		Conns:    conf.Conns,
+		Duration: conf.Duration.Seconds(),
+		Rate:     conf.Rate,
+		Window:   conf.Window,
+		MsgIds:   conf.MsgIds,
+		Sizes:    conf.Sizes,
+		Started:  time.Now(),
+	}
+
+	// 建立全部连接之后再开始计时
+	workers, connectTimes, connectErrors := dialAll(conf, host, port)
+	result.Connected = len(workers)
+	result.ConnectErrors = connectErrors
+	result.Connect = latencyStats(connectTimes)
+	if len(workers) == 0 {
+		return result, fmt.Errorf("all %d connections failed", conf.Conns)
+	}
+
+	payloads := make([][]byte, len(conf.Sizes))
+	for i, size := range conf.Sizes {
+		payloads[i] = make([]byte, size)
+		rand.Read(payloads[i])
+	}
+
+	start := time.Now()
+	deadline := start.Add(conf.Duration)
+	var wg sync.WaitGroup
+	for i, w := range workers {
+		wg.Add(1)
+		go func(i int, w *worker) {
+			defer wg.Done()
+			w.run(start, deadline, i, len(workers), payloads)
+		}(i, w)
+	}
+	wg.Wait()
+	result.Elapsed = time.Since(start).Seconds()
+
+	var latencies []time.Duration
+	for _, w := range workers {
+		result.Sent += w.sent
+		result.SentBytes += w.sentBytes
+		result.Received += w.received
+		result.Errors += w.errors
+		latencies = append(latencies, w.latencies...)
+	}
+	result.Lost = result.Sent - result.Received
+	sendSeconds := conf.Duration.Seconds()
+	result.SentRate = float64(result.Sent) / sendSeconds
+	result.ReceivedRate = float64(result.Received) / sendSeconds
+	result.SentBytesRate = float64(result.SentBytes) / sendSeconds
+	result.Latency = latencyStats(latencies)
+	return result, nil
+}
+
+// 并发建立全部连接，返回成功的连接、每个连接建立（包括握手和鉴权）的时长以及失败的数量
+func dialAll(conf config, host string, port int) ([]*worker, []time.Duration, int) {
+	var (
+		workers []*worker
+		times   []time.Duration
+		failed  int
+		lock    sync.Mutex
+		wg      sync.WaitGroup
+	)
+	dialing := make(chan struct{}, maxDialing)
+	for i := 0; i < conf.Conns; i++ {
+		wg.Add(1)
+		dialing <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-dialing }()
+
+			begin := time.Now()
+			w, err := dial(conf, host, port)
+			elapsed := time.Since(begin)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				if failed == 0 {
+					fmt.Fprintln(os.Stderr, "tigerkin-bench: connect error:", err)
+				}
+				failed++
+				return
+			}
+			workers = append(workers, w)
+			times = append(times, elapsed)
+		}()
+	}
+	wg.Wait()
+	return workers, times, failed
+}
+
+// 建立一个连接，配置了Token时进行鉴权
+func dial(conf config, host string, port int) (*worker, error) {
+	client := tnet.NewClient(host, port)
+	if err := client.Start(); err != nil {
+		return nil, err
+	}
+	if conf.Token != "" {
+		if err := authenticate(client, conf.Token); err != nil {
+			client.Stop()
+			return nil, err
+		}
+	}
+
+	w := &worker{conf: conf, client: client}
+	if conf.Rate > 0 {
+		w.inflight = make(chan time.Time, maxInflight)
+	} else {
+		w.inflight = make(chan time.Time, conf.Window)
+		w.window = make(chan struct{}, conf.Window)
+	}
+	return w, nil
+}
+
+// 发送鉴权Token并等待服务器的回复
+func authenticate(client *tnet.Client, token string) error {
+	if err := client.SendMsg(utils.GlobalObject.AuthMsgId, []byte(token)); err != nil {
+		return err
+	}
+	for {
+		msg, err := client.ReadMsg()
+		if err != nil {
+			return err
+		}
+		if msg.GetMsgId() != utils.GlobalObject.AuthMsgId {
+			continue
+		}
+		if data := msg.GetData(); len(data) == 0 || data[0] != tnet.AuthStatusOK {
+			return fmt.Errorf("auth failed: %q", data)
+		}
+		return nil
+	}
+}
+
+// 在deadline之前发送消息，之后等待剩余的回复，index和count用于错开各个连接的发送时间
+func (w *worker) run(start, deadline time.Time, index, count int, payloads [][]byte) {
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		w.read()
+	}()
+
+	w.send(start, deadline, index, count, payloads)
+	close(w.inflight)
+
+	// 剩余的回复全部收到（读取结束）或者超时之后关闭连接
+	select {
+	case <-readDone:
+	case <-time.After(w.conf.Timeout):
+	}
+	atomic.StoreInt32(&w.stopping, 1)
+	w.client.Stop()
+	<-readDone
+}
+
+// 发送消息，限速时按照计划的时间发送，否则在未回复的消息少于window时立即发送
+func (w *worker) send(start, deadline time.Time, index, count int, payloads [][]byte) {
+	var interval time.Duration
+	next := start
+	if w.conf.Rate > 0 {
+		interval = time.Duration(float64(time.Second) * float64(count) / w.conf.Rate)
+		next = start.Add(interval * time.Duration(index) / time.Duration(count))
+	}
+	// 服务器不再回复时，等待window或者inflight的发送在deadline结束
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	for i := 0; ; i++ {
+		now := time.Now()
+		if w.conf.Rate > 0 {
+			if !next.Before(deadline) {
+				return
+			}
+			if now.Before(next) {
+				time.Sleep(next.Sub(now))
+			}
+		} else {
+			if !now.Before(deadline) {
+				return
+			}
+			select {
+			case w.window <- struct{}{}:
+			case <-timer.C:
+				return
+			}
+			next = time.Now()
+		}
+
+		msgId := w.conf.MsgIds[i%len(w.conf.MsgIds)]
+		payload := payloads[i%len(payloads)]
+		if err := w.client.SendMsg(msgId, payload); err != nil {
+			atomic.AddUint64(&w.errors, 1)
+			return
+		}
+		// 回复可能在此之前到达，读取时先取出发送时间再读取回复，不影响延迟的计算
+		select {
+		case w.inflight <- next:
+		case <-timer.C:
+			return
+		}
+		atomic.AddUint64(&w.sent, 1)
+		atomic.AddUint64(&w.sentBytes, uint64(len(payload)))
+		next = next.Add(interval)
+	}
+}
+
+// 读取回复，每个回复对应最早的一个未回复消息
+func (w *worker) read() {
+	for scheduled := range w.inflight {
+		if _, err := w.client.ReadMsg(); err != nil {
+			if atomic.LoadInt32(&w.stopping) == 0 {
+				atomic.AddUint64(&w.errors, 1)
+			}
+			return
+		}
+		atomic.AddUint64(&w.received, 1)
+		w.latencies = append(w.latencies, time.Since(scheduled))
+		if w.window != nil {
+			<-w.window
+		}
+	}
+}
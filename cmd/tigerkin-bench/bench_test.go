@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyStats(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1000; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	require.Equal(t, LatencyStats{Min: 1, Mean: 500.5, P50: 500, P90: 900, P99: 990, P999: 999, Max: 1000}, latencyStats(latencies))
+	require.Equal(t, LatencyStats{}, latencyStats(nil))
+	require.Equal(t, LatencyStats{Min: 3, Mean: 3, P50: 3, P90: 3, P99: 3, P999: 3, Max: 3}, latencyStats([]time.Duration{3 * time.Millisecond}))
+}
+
+func TestBench(t *testing.T) {
+	// 找一个空闲的端口启动回显服务器
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+	s, err := newEchoServer(addr)
+	require.NoError(t, err)
+	s.Start()
+	defer s.Stop()
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// 不限速
+	result, err := bench(config{Addr: addr, Conns: 4, Duration: 300 * time.Millisecond, Window: 2, MsgIds: []uint32{1, 2}, Sizes: []int{0, 100}, Timeout: time.Second})
+	require.NoError(t, err)
+	require.Equal(t, 4, result.Connected)
+	require.Zero(t, result.ConnectErrors)
+	require.NotZero(t, result.Sent)
+	require.Equal(t, result.Sent, result.Received)
+	require.Zero(t, result.Lost)
+	require.Zero(t, result.Errors)
+	// 每个连接轮流发送0和100字节的数据
+	require.InDelta(t, result.Sent*50, result.SentBytes, 4*50)
+	require.Greater(t, result.Latency.Max, 0.0)
+	require.LessOrEqual(t, result.Latency.P50, result.Latency.P99)
+
+	// 按照目标速率发送
+	result, err = bench(config{Addr: addr, Conns: 2, Duration: 500 * time.Millisecond, Rate: 200, MsgIds: []uint32{3}, Sizes: []int{16}, Timeout: time.Second})
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), result.Sent)
+	require.Equal(t, uint64(100), result.Received)
+	require.InDelta(t, 200, result.SentRate, 0.001)
+
+	// 保存结果并与之前的结果比较
+	path := filepath.Join(t.TempDir(), "result.json")
+	require.NoError(t, saveResult(path, result))
+	loaded, err := loadResult(path)
+	require.NoError(t, err)
+	require.Equal(t, result.Latency, loaded.Latency)
+	require.Equal(t, result.Sent, loaded.Sent)
+	var out bytes.Buffer
+	printComparison(&out, loaded, result)
+	require.Contains(t, out.String(), "received msg/s")
+	require.Contains(t, out.String(), "+0.0%")
+	out.Reset()
+	printResult(&out, result)
+	require.Contains(t, out.String(), "Sent:       100 msgs, 200.0 msg/s")
+
+	// 服务器不存在
+	_, err = bench(config{Addr: "127.0.0.1:1", Conns: 2, Duration: time.Second, MsgIds: []uint32{1}, Sizes: []int{1}})
+	require.Error(t, err)
+}
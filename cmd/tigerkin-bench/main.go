@@ -0,0 +1,135 @@
+/**
+*    tigerkin-bench: Tigerkin服务器的压力测试工具
+*
+*    同时建立N个连接，按照目标速率（或者每个连接保持固定数量的未回复消息）轮流发送指定的msgId和数据长度，
+*    统计吞吐量以及往返延迟的分位数，结果以文本输出，并可以保存为JSON与之前的结果比较
+*
+*    被测试的服务器需要对每个消息按顺序回复一个消息，可以使用-serve启动一个回显所有消息的服务器：
+*        tigerkin-bench -serve 0.0.0.0:8999
+*        tigerkin-bench -addr 127.0.0.1:8999 -conns 100 -duration 30s -rate 50000 -msgids 1,2 -sizes 64,1024 -json result.json
+*        tigerkin-bench -addr 127.0.0.1:8999 -conns 100 -duration 30s -rate 50000 -baseline result.json
+*
+*    封包方式、压缩、加密等与服务器保持一致的配置从当前目录的conf/tigerkin.json或者-config指定的文件中读取
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/utils"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8999", "服务器地址")
+	conns := flag.Int("conns", 10, "并发连接数")
+	duration := flag.Duration("duration", 10*time.Second, "发送消息的时长")
+	rate := flag.Float64("rate", 0, "所有连接每秒发送的消息总数，为0时不限速，每个连接保持window个未回复的消息")
+	window := flag.Int("window", 1, "不限速时每个连接未回复消息的数量")
+	msgIds := flag.String("msgids", "1", "轮流发送的msgId，以逗号分隔")
+	sizes := flag.String("sizes", "64", "轮流使用的消息数据长度（字节），以逗号分隔")
+	timeout := flag.Duration("timeout", 5*time.Second, "发送结束之后等待剩余回复的时长")
+	token := flag.String("token", "", "连接建立之后发送的鉴权Token，为空时不鉴权")
+	jsonOut := flag.String("json", "", "将结果以JSON保存到文件，为-时输出到标准输出")
+	baseline := flag.String("baseline", "", "与之前保存的JSON结果进行比较")
+	configFile := flag.String("config", "", "Tigerkin配置文件路径，默认为conf/tigerkin.json")
+	serveAddr := flag.String("serve", "", "在该地址启动回显所有消息的服务器，不进行测试")
+	flag.Parse()
+
+	if *configFile != "" {
+		utils.GlobalObject.ConfFilePath = *configFile
+		utils.GlobalObject.Reload()
+	}
+
+	if *serveAddr != "" {
+		if err := serve(*serveAddr); err != nil {
+			fmt.Fprintln(os.Stderr, "tigerkin-bench:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	conf := config{
+		Addr:     *addr,
+		Conns:    *conns,
+		Duration: *duration,
+		Rate:     *rate,
+		Window:   *window,
+		Timeout:  *timeout,
+		Token:    *token,
+	}
+	var err error
+	if conf.MsgIds, err = parseUints(*msgIds); err != nil {
+		fmt.Fprintln(os.Stderr, "tigerkin-bench: -msgids:", err)
+		os.Exit(2)
+	}
+	if conf.Sizes, err = parseInts(*sizes); err != nil {
+		fmt.Fprintln(os.Stderr, "tigerkin-bench: -sizes:", err)
+		os.Exit(2)
+	}
+
+	if err := run(conf, *jsonOut, *baseline); err != nil {
+		fmt.Fprintln(os.Stderr, "tigerkin-bench:", err)
+		os.Exit(1)
+	}
+}
+
+// 执行压力测试并输出结果
+func run(conf config, jsonOut, baseline string) error {
+	result, err := bench(conf)
+	if err != nil {
+		return err
+	}
+
+	// JSON输出到标准输出时，文本结果输出到标准错误
+	out := os.Stdout
+	if jsonOut == "-" {
+		out = os.Stderr
+	}
+	printResult(out, result)
+	if baseline != "" {
+		base, err := loadResult(baseline)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out)
+		printComparison(out, base, result)
+	}
+	if jsonOut != "" {
+		return saveResult(jsonOut, result)
+	}
+	return nil
+}
+
+// 解析以逗号分隔的msgId列表
+func parseUints(s string) ([]uint32, error) {
+	var values []uint32
+	for _, field := range strings.Split(s, ",") {
+		value, err := strconv.ParseUint(strings.TrimSpace(field), 0, 32)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, uint32(value))
+	}
+	return values, nil
+}
+
+// 解析以逗号分隔的数据长度列表
+func parseInts(s string) ([]int, error) {
+	var values []int
+	for _, field := range strings.Split(s, ",") {
+		value, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		if value < 0 {
+			return nil, fmt.Errorf("negative size %d", value)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
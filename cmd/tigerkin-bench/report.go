@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+/*
+	延迟的统计结果，单位为毫秒
+*/
+type LatencyStats struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p999"`
+	Max  float64 `json:"max"`
+}
+
+/*
+	压力测试的结果
+*/
+type Result struct {
+	// 测试参数
+	Addr     string    `json:"addr"`
+	Conns    int       `json:"conns"`
+	Duration float64   `json:"duration"` // 发送消息的时长（秒）
+	Rate     float64   `json:"rate"`     // 目标速率（消息/秒），为0表示不限速
+	Window   int       `json:"window"`   // 不限速时每个连接未回复消息的数量
+	MsgIds   []uint32  `json:"msgIds"`
+	Sizes    []int     `json:"sizes"`
+	Started  time.Time `json:"started"`
+
+	// 连接
+	Connected     int          `json:"connected"`
+	ConnectErrors int          `json:"connectErrors"`
+	Connect       LatencyStats `json:"connect"` // 建立连接（包括握手和鉴权）的时长
+
+	// 消息
+	Elapsed       float64      `json:"elapsed"` // 从开始发送到收到全部回复（或者超时）的时长（秒）
+	Sent          uint64       `json:"sent"`
+	SentBytes     uint64       `json:"sentBytes"`
+	Received      uint64       `json:"received"`
+	Lost          uint64       `json:"lost"`   // 没有收到回复的消息数量
+	Errors        uint64       `json:"errors"` // 发送或者读取出错的次数
+	SentRate      float64      `json:"sentRate"`
+	ReceivedRate  float64      `json:"receivedRate"`
+	SentBytesRate float64      `json:"sentBytesRate"`
+	Latency       LatencyStats `json:"latency"` // 往返延迟
+}
+
+// 计算延迟的统计结果
+func latencyStats(latencies []time.Duration) LatencyStats {
+	if len(latencies) == 0 {
+		return LatencyStats{}
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum time.Duration
+	for _, latency := range sorted {
+		sum += latency
+	}
+	return LatencyStats{
+		Min:  ms(sorted[0]),
+		Mean: ms(sum / time.Duration(len(sorted))),
+		P50:  ms(percentile(sorted, 50)),
+		P90:  ms(percentile(sorted, 90)),
+		P99:  ms(percentile(sorted, 99)),
+		P999: ms(percentile(sorted, 99.9)),
+		Max:  ms(sorted[len(sorted)-1]),
+	}
+}
+
+// 已排序的延迟中的分位数（nearest-rank）
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	// 减去一个很小的值，避免99.9这样的分位数因为浮点误差多进一位
+	rank := int(math.Ceil(p/100*float64(len(sorted)) - 1e-9))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// 输出文本格式的结果
+func printResult(w io.Writer, r *Result) {
+	rate := "unlimited"
+	if r.Rate > 0 {
+		rate = fmt.Sprintf("%.0f msg/s", r.Rate)
+	} else {
+		rate += fmt.Sprintf(" (window %d)", r.Window)
+	}
+	fmt.Fprintf(w, "Target:     %s, %d conns, %gs, rate %s, msgIds %v, sizes %v\n", r.Addr, r.Conns, r.Duration, rate, r.MsgIds, r.Sizes)
+	fmt.Fprintf(w, "Connect:    %d ok, %d failed, %s\n", r.Connected, r.ConnectErrors, formatLatency(r.Connect))
+	fmt.Fprintf(w, "Sent:       %d msgs, %.1f msg/s, %.2f MB/s\n", r.Sent, r.SentRate, r.SentBytesRate/1e6)
+	fmt.Fprintf(w, "Received:   %d msgs, %.1f msg/s, lost %d, errors %d\n", r.Received, r.ReceivedRate, r.Lost, r.Errors)
+	fmt.Fprintf(w, "Latency:    %s\n", formatLatency(r.Latency))
+}
+
+func formatLatency(l LatencyStats) string {
+	return fmt.Sprintf("min %.3fms mean %.3fms p50 %.3fms p90 %.3fms p99 %.3fms p99.9 %.3fms max %.3fms",
+		l.Min, l.Mean, l.P50, l.P90, l.P99, l.P999, l.Max)
+}
+
+// 输出与之前结果的比较
+func printComparison(w io.Writer, base, r *Result) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "METRIC\tBASELINE\tCURRENT\tCHANGE\n")
+	for _, m := range []struct {
+		name          string
+		base, current float64
+	}{
+		{"connected", float64(base.Connected), float64(r.Connected)},
+		{"sent msg/s", base.SentRate, r.SentRate},
+		{"received msg/s", base.ReceivedRate, r.ReceivedRate},
+		{"lost", float64(base.Lost), float64(r.Lost)},
+		{"errors", float64(base.Errors), float64(r.Errors)},
+		{"latency mean ms", base.Latency.Mean, r.Latency.Mean},
+		{"latency p50 ms", base.Latency.P50, r.Latency.P50},
+		{"latency p90 ms", base.Latency.P90, r.Latency.P90},
+		{"latency p99 ms", base.Latency.P99, r.Latency.P99},
+		{"latency p99.9 ms", base.Latency.P999, r.Latency.P999},
+		{"latency max ms", base.Latency.Max, r.Latency.Max},
+	} {
+		fmt.Fprintf(tw, "%s\t%.3f\t%.3f\t%s\n", m.name, m.base, m.current, change(m.base, m.current))
+	}
+	tw.Flush()
+}
+
+// 相对于基准的变化百分比
+func change(base, current float64) string {
+	if base == 0 {
+		if current == 0 {
+			return "0.0%"
+		}
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f%%", (current-base)/base*100)
+}
+
+// 将结果以JSON保存到文件，path为-时输出到标准输出
+func saveResult(path string, r *Result) error {
+	data, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// 读取之前保存的结果
+func loadResult(path string) (*Result, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Result{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
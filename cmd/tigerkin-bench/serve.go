@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/tnet"
+	"github.com/HOU-SZ/tigerkin/utils"
+)
+
+/*
+	回显所有消息的Router，作为没有注册处理方法的消息的NotFound Router
+*/
+type echoRouter struct {
+	tnet.BaseRouter
+}
+
+func (router *echoRouter) Handle(request tiface.IRequest) {
+	if err := request.GetConnection().SendBuffMsg(request.GetMsgID(), request.GetData()); err != nil {
+		fmt.Println("tigerkin-bench: echo error: ", err)
+	}
+}
+
+// 创建回显所有消息的服务器，监听addr
+func newEchoServer(addr string) (tiface.IServer, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+	utils.GlobalObject.Host = host
+	utils.GlobalObject.TcpPort = port
+	utils.GlobalObject.Name = "tigerkin-bench"
+
+	s := tnet.NewServer()
+	s.SetNotFoundRouter(&echoRouter{})
+	return s, nil
+}
+
+// 启动回显所有消息的服务器，一直运行
+func serve(addr string) error {
+	s, err := newEchoServer(addr)
+	if err != nil {
+		return err
+	}
+	s.Serve()
+	return nil
+}
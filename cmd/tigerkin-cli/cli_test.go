@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/demo_app/mmo_game/pb"
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/tnet"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// 回显所有消息的Router，msgId 9的消息先推送一个msgId 8的消息再回显
+type echoRouter struct {
+	tnet.BaseRouter
+}
+
+func (router *echoRouter) Handle(request tiface.IRequest) {
+	if request.GetMsgID() == 9 {
+		request.GetConnection().SendMsg(8, []byte("push"))
+	}
+	request.GetConnection().SendMsg(request.GetMsgID(), request.GetData())
+}
+
+// 多个协程同时写入的输出
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Reset() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.buf.Reset()
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+// 在空闲的端口启动回显服务器，返回服务器地址
+func startEchoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+	host, portStr, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	utils.GlobalObject.Host = host
+	utils.GlobalObject.TcpPort, err = strconv.Atoi(portStr)
+	require.NoError(t, err)
+
+	s := tnet.NewServer()
+	s.SetNotFoundRouter(&echoRouter{})
+	s.Start()
+	t.Cleanup(s.Stop)
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 3*time.Second, 10*time.Millisecond)
+	return addr
+}
+
+// 将mmo_game的proto定义写入描述符集文件
+func writeDescriptorSet(t *testing.T) string {
+	set := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(pb.File_pb_msg_proto)},
+	}
+	data, err := proto.Marshal(set)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "msg.pb")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	return path
+}
+
+func TestPayloadFormat(t *testing.T) {
+	files, err := loadDescriptorSets([]string{writeDescriptorSet(t)})
+	require.NoError(t, err)
+
+	f, err := parseFormat("text", files)
+	require.NoError(t, err)
+	data, err := f.encode(`"a\tb\n"`)
+	require.NoError(t, err)
+	require.Equal(t, []byte("a\tb\n"), data)
+
+	f, err = parseFormat("hex", nil)
+	require.NoError(t, err)
+	data, err = f.encode("0a 0B ff")
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x0a, 0x0b, 0xff}, data)
+
+	f, err = parseFormat("json", nil)
+	require.NoError(t, err)
+	data, err = f.encode(`{"a": 1, "b": [2]}`)
+	require.NoError(t, err)
+	require.Equal(t, `{"a":1,"b":[2]}`, string(data))
+	require.True(t, f.equal(data, []byte(`{"b":[2],"a":1.0}`)))
+	_, err = f.encode(`{"a":`)
+	require.Error(t, err)
+
+	f, err = parseFormat("proto:pb.Talk", files)
+	require.NoError(t, err)
+	require.Equal(t, "proto:pb.Talk", f.String())
+	data, err = f.encode(`{"Content": "hi"}`)
+	require.NoError(t, err)
+	expected, err := proto.Marshal(&pb.Talk{Content: "hi"})
+	require.NoError(t, err)
+	require.Equal(t, expected, data)
+	s, err := f.decode(data)
+	require.NoError(t, err)
+	require.Equal(t, `{"Content":"hi"}`, s)
+	_, err = f.encode(`{"Unknown": 1}`)
+	require.Error(t, err)
+
+	// 未指定格式时按照内容选择text或者hex，按照格式解析失败时输出hex
+	require.Equal(t, `text "hello"`, formatData(nil, []byte("hello")))
+	require.Equal(t, "hex 00ff", formatData(nil, []byte{0, 0xff}))
+	require.Equal(t, "hex 7b", formatData(&payloadFormat{kind: FormatJSON}, []byte("{")))
+
+	for _, name := range []string{"xml", "text:a", "proto", "proto:pb.Missing", "proto:pb.Talk.Content"} {
+		_, err = parseFormat(name, files)
+		require.Error(t, err, name)
+	}
+	_, err = parseFormat("proto:pb.Talk", nil)
+	require.Error(t, err)
+}
+
+func TestScript(t *testing.T) {
+	conf := *utils.GlobalObject
+	defer func() { *utils.GlobalObject = conf }()
+	addr := startEchoServer(t)
+	files, err := loadDescriptorSets([]string{writeDescriptorSet(t)})
+	require.NoError(t, err)
+
+	var out syncBuffer
+	s, err := dialSession(addr, &out, files, 500*time.Millisecond)
+	require.NoError(t, err)
+	defer s.Close()
+
+	script := `# 注释和空行被跳过
+
+send 1 text hello world
+expect 1 text hello world
+send 0x2 hex 00ff
+expect 2 hex 00 FF
+decode 3 json
+send 3 json {"a": 1}
+expect 3 json {"a": 1.0}
+decode 4 proto:pb.Talk
+send 4 proto:pb.Talk {"Content": "hi"}
+expect 4 proto:pb.Talk {"Content": "hi"}
+send 9
+expect 8 text push
+expect 9
+# 等待msgId 9时跳过之前推送的msgId 8
+send 9
+expect 9
+sleep 1ms
+quit
+send 1 text not sent
+`
+	require.NoError(t, s.runScript(strings.NewReader(script), "ok.txt"))
+	require.Contains(t, out.String(), "==> msgId = 1, len = 11, text \"hello world\"\n")
+	require.Contains(t, out.String(), "<== msgId = 2, len = 2, hex 00ff\n")
+	require.Contains(t, out.String(), "<== msgId = 3, len = 7, json {\"a\":1}\n")
+	require.Contains(t, out.String(), "<== msgId = 4, len = 4, proto:pb.Talk {\"Content\":\"hi\"}\n")
+	require.NotContains(t, out.String(), "not sent")
+
+	// 数据不一致、等待超时、命令错误时返回错误，包含行号
+	err = s.runScript(strings.NewReader("send 5 text a\nexpect 5 text b\n"), "diff.txt")
+	require.EqualError(t, err, `diff.txt:2: expect msgId = 5: got text "a", want text "b"`)
+	err = s.runScript(strings.NewReader("\nexpect 6\n"), "timeout.txt")
+	require.EqualError(t, err, "timeout.txt:2: expect msgId = 6: timeout after 500ms")
+	for _, line := range []string{"jump", "send", "send x", "send 1 xml a", "send 1 hex zz", "sleep x", "decode 1 xml"} {
+		require.Error(t, s.runScript(strings.NewReader(line), "bad.txt"), line)
+	}
+
+	// 交互模式下命令失败时继续执行
+	out.Reset()
+	require.NoError(t, s.interact(strings.NewReader("jump\nhelp\nsend 7 text go on\nexpect 7\n")))
+	require.Contains(t, out.String(), "error: unknown command \"jump\"")
+	require.Contains(t, out.String(), "commands:")
+	require.Contains(t, out.String(), "==> msgId = 7")
+
+	// 连接断开之后expect立即失败
+	s.client.Stop()
+	err = s.runScript(strings.NewReader("expect 1"), "closed.txt")
+	require.ErrorContains(t, err, "connection closed")
+}
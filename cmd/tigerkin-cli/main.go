@@ -0,0 +1,96 @@
+/**
+*    tigerkin-cli: 手动测试Tigerkin服务器协议的命令行客户端
+*
+*    连接服务器之后逐行执行命令，按照msgId发送text、hex、json或者proto格式的数据，收到的消息全部输出：
+*        tigerkin-cli -addr 127.0.0.1:8999 -descriptor msg.pb -decode 200=proto:pb.BroadCast
+*        > send 2 proto:pb.Talk {"Content": "hello"}
+*        > send 100 text "line\n"
+*        > expect 200
+*
+*    proto消息的定义来自protoc --include_imports --descriptor_set_out生成的描述符集，数据以protojson书写
+*    指定脚本文件时（-表示标准输入）依次执行其中的send、expect等命令，任何一个命令失败时以状态码1退出，可以用于自动化检查：
+*        tigerkin-cli -addr 127.0.0.1:8999 -descriptor msg.pb login.txt
+*
+*    封包方式、压缩、加密等与服务器保持一致的配置从当前目录的conf/tigerkin.json或者-config指定的文件中读取
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/utils"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8999", "服务器地址")
+	descriptors := flag.String("descriptor", "", "proto描述符集文件，以逗号分隔")
+	decode := flag.String("decode", "", "输出收到的消息时msgId使用的格式，例如200=proto:pb.BroadCast,3=json，以逗号分隔")
+	timeout := flag.Duration("timeout", 3*time.Second, "expect等待消息的时长")
+	configFile := flag.String("config", "", "Tigerkin配置文件路径，默认为conf/tigerkin.json")
+	flag.Parse()
+
+	if *configFile != "" {
+		utils.GlobalObject.ConfFilePath = *configFile
+		utils.GlobalObject.Reload()
+	}
+
+	var files *protoregistry.Files
+	if *descriptors != "" {
+		var err error
+		if files, err = loadDescriptorSets(strings.Split(*descriptors, ",")); err != nil {
+			fmt.Fprintln(os.Stderr, "tigerkin-cli: -descriptor:", err)
+			os.Exit(2)
+		}
+	}
+
+	s, err := dialSession(*addr, os.Stdout, files, *timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "tigerkin-cli:", err)
+		os.Exit(1)
+	}
+	defer s.Close()
+	if *decode != "" {
+		for _, field := range strings.Split(*decode, ",") {
+			if err := s.decode(strings.Replace(field, "=", " ", 1)); err != nil {
+				fmt.Fprintln(os.Stderr, "tigerkin-cli: -decode:", err)
+				os.Exit(2)
+			}
+		}
+	}
+
+	if flag.NArg() == 0 {
+		fmt.Printf("connected to %s, type help for commands\n", *addr)
+		if err := s.interact(os.Stdin); err != nil {
+			fmt.Fprintln(os.Stderr, "tigerkin-cli:", err)
+		}
+		return
+	}
+
+	for _, path := range flag.Args() {
+		if err := runScriptFile(s, path); err != nil {
+			fmt.Fprintln(os.Stderr, "tigerkin-cli:", err)
+			s.Close()
+			os.Exit(1)
+		}
+	}
+}
+
+// 执行脚本文件中的命令，path为-时从标准输入读取
+func runScriptFile(s *session, path string) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	return s.runScript(r, path)
+}
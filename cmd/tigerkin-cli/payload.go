@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// 消息数据的格式
+const (
+	FormatText  = "text"
+	FormatHex   = "hex"
+	FormatJSON  = "json"
+	FormatProto = "proto"
+)
+
+/*
+	消息数据的格式：text、hex、json，或者proto:<消息的完整名称>
+	proto格式的数据以protojson书写，发送前根据描述符集中的消息定义序列化
+*/
+type payloadFormat struct {
+	// 格式的名称
+	kind string
+	// proto格式的消息定义
+	desc protoreflect.MessageDescriptor
+}
+
+// 解析格式名称，proto格式从files中查找消息定义
+func parseFormat(name string, files *protoregistry.Files) (payloadFormat, error) {
+	kind, message, _ := strings.Cut(name, ":")
+	switch kind {
+	case FormatText, FormatHex, FormatJSON:
+		if message != "" {
+			return payloadFormat{}, fmt.Errorf("unknown format %q", name)
+		}
+		return payloadFormat{kind: kind}, nil
+	case FormatProto:
+		if message == "" {
+			return payloadFormat{}, errors.New("proto format needs a message name, e.g. proto:pb.Talk")
+		}
+		if files == nil {
+			return payloadFormat{}, errors.New("proto format needs -descriptor")
+		}
+		d, err := files.FindDescriptorByName(protoreflect.FullName(message))
+		if err != nil {
+			return payloadFormat{}, fmt.Errorf("proto message %s: %w", message, err)
+		}
+		desc, ok := d.(protoreflect.MessageDescriptor)
+		if !ok {
+			return payloadFormat{}, fmt.Errorf("%s is not a proto message", message)
+		}
+		return payloadFormat{kind: kind, desc: desc}, nil
+	}
+	return payloadFormat{}, fmt.Errorf("unknown format %q", name)
+}
+
+// 格式的名称
+func (f payloadFormat) String() string {
+	if f.desc != nil {
+		return FormatProto + ":" + string(f.desc.FullName())
+	}
+	return f.kind
+}
+
+// 将命令中书写的数据转换为消息数据
+// text以双引号开始时按照Go的字符串字面量解析转义字符，hex中可以包含空格
+func (f payloadFormat) encode(payload string) ([]byte, error) {
+	switch f.kind {
+	case FormatText:
+		if strings.HasPrefix(payload, `"`) {
+			s, err := strconv.Unquote(payload)
+			return []byte(s), err
+		}
+		return []byte(payload), nil
+	case FormatHex:
+		return hex.DecodeString(strings.Join(strings.Fields(payload), ""))
+	case FormatJSON:
+		if !json.Valid([]byte(payload)) {
+			return nil, errors.New("invalid json")
+		}
+		var buf bytes.Buffer
+		err := json.Compact(&buf, []byte(payload))
+		return buf.Bytes(), err
+	case FormatProto:
+		msg := dynamicpb.NewMessage(f.desc)
+		if err := protojson.Unmarshal([]byte(payload), msg); err != nil {
+			return nil, err
+		}
+		return proto.Marshal(msg)
+	}
+	return nil, fmt.Errorf("unknown format %q", f.kind)
+}
+
+// 将消息数据转换为便于阅读的形式
+func (f payloadFormat) decode(data []byte) (string, error) {
+	switch f.kind {
+	case FormatText:
+		return strconv.Quote(string(data)), nil
+	case FormatHex:
+		return hex.EncodeToString(data), nil
+	case FormatJSON:
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	case FormatProto:
+		msg := dynamicpb.NewMessage(f.desc)
+		if err := proto.Unmarshal(data, msg); err != nil {
+			return "", err
+		}
+		// protojson的输出中随机插入空格，压缩之后输出保持稳定
+		out, err := protojson.Marshal(msg)
+		if err != nil {
+			return "", err
+		}
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, out); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	return "", fmt.Errorf("unknown format %q", f.kind)
+}
+
+// 比较两个消息数据，json比较解析之后的值，proto比较反序列化之后的消息，其他格式比较字节
+func (f payloadFormat) equal(expected, actual []byte) bool {
+	switch f.kind {
+	case FormatJSON:
+		var want, got interface{}
+		if json.Unmarshal(expected, &want) != nil || json.Unmarshal(actual, &got) != nil {
+			return false
+		}
+		return reflect.DeepEqual(want, got)
+	case FormatProto:
+		want, got := dynamicpb.NewMessage(f.desc), dynamicpb.NewMessage(f.desc)
+		if proto.Unmarshal(expected, want) != nil || proto.Unmarshal(actual, got) != nil {
+			return false
+		}
+		return proto.Equal(want, got)
+	}
+	return bytes.Equal(expected, actual)
+}
+
+// 输出消息数据，使用指定的格式，未指定格式时可打印的文本以text输出，其他以hex输出
+// 按照指定的格式解析失败时以hex输出
+func formatData(f *payloadFormat, data []byte) string {
+	if f != nil {
+		if s, err := f.decode(data); err == nil {
+			return f.String() + " " + s
+		}
+	} else if isPrintable(data) {
+		return FormatText + " " + strconv.Quote(string(data))
+	}
+	return FormatHex + " " + hex.EncodeToString(data)
+}
+
+// 数据是否为可打印的UTF-8文本
+func isPrintable(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// 读取protoc --include_imports --descriptor_set_out生成的描述符集，多个文件合并在一起
+func loadDescriptorSets(paths []string) (*protoregistry.Files, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var s descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(data, &s); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, file := range s.File {
+			if !seen[file.GetName()] {
+				seen[file.GetName()] = true
+				set.File = append(set.File, file)
+			}
+		}
+	}
+	return protodesc.NewFiles(set)
+}
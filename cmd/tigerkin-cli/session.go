@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/tnet"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// 等待expect时最多保留的未被匹配的消息数量，超过时丢弃最早的消息
+const maxQueued = 1024
+
+// 一行命令的最大长度
+const maxLineSize = 4 << 20
+
+// 命令的帮助信息
+const helpText = `commands:
+  send <msgId> [<format> <payload>]    send a message, format is text, hex, json or proto:<message>
+  expect <msgId> [<format> <payload>]  wait for a message with msgId, skipping others, and compare its data
+  decode <msgId> <format>              print received messages with msgId in format
+  sleep <duration>                     wait, e.g. 500ms
+  help                                 show this help
+  quit                                 close the connection and exit
+`
+
+// 执行quit命令
+var errQuit = errors.New("quit")
+
+/*
+	与服务器的一个连接，执行send、expect等命令，收到的消息全部输出
+*/
+type session struct {
+	client *tnet.Client
+	// 命令和收到的消息的输出
+	out io.Writer
+	// 收到的消息在读取协程中输出，与命令的输出互斥
+	outLock sync.Mutex
+	// 描述符集中的proto消息定义，未指定-descriptor时为nil
+	files *protoregistry.Files
+	// 输出收到的消息时每个msgId使用的格式
+	decoders map[uint32]payloadFormat
+	// expect等待消息的时长
+	timeout time.Duration
+
+	// 保护queued、decoders和readErr
+	lock sync.Mutex
+	// 收到之后还没有被expect处理的消息
+	queued []tiface.IMessage
+	// 收到消息时通知正在等待的expect
+	notify chan struct{}
+	// 读取协程退出时关闭
+	readDone chan struct{}
+	// 读取协程退出的原因
+	readErr error
+}
+
+// 连接addr，之后开始读取并输出服务器发来的消息
+func dialSession(addr string, out io.Writer, files *protoregistry.Files, timeout time.Duration) (*session, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+	client := tnet.NewClient(host, port)
+	if err := client.Start(); err != nil {
+		return nil, err
+	}
+
+	s := &session{
+		client:   client,
+		out:      out,
+		files:    files,
+		decoders: make(map[uint32]payloadFormat),
+		timeout:  timeout,
+		notify:   make(chan struct{}, 1),
+		readDone: make(chan struct{}),
+	}
+	go s.read()
+	return s, nil
+}
+
+// 关闭连接
+func (s *session) Close() {
+	s.client.Stop()
+	<-s.readDone
+}
+
+// 输出一行
+func (s *session) printf(format string, args ...interface{}) {
+	s.outLock.Lock()
+	defer s.outLock.Unlock()
+	fmt.Fprintf(s.out, format+"\n", args...)
+}
+
+// 读取服务器发来的消息，输出之后放入队列等待expect
+func (s *session) read() {
+	defer close(s.readDone)
+	for {
+		msg, err := s.client.ReadMsg()
+		if err != nil {
+			s.lock.Lock()
+			s.readErr = err
+			s.lock.Unlock()
+			return
+		}
+		s.printf("<== %s", s.describe(msg.GetMsgId(), msg.GetData()))
+
+		s.lock.Lock()
+		if len(s.queued) >= maxQueued {
+			s.queued = s.queued[1:]
+		}
+		s.queued = append(s.queued, msg)
+		s.lock.Unlock()
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 描述一个消息，数据使用decode为msgId指定的格式
+func (s *session) describe(msgId uint32, data []byte) string {
+	s.lock.Lock()
+	f, ok := s.decoders[msgId]
+	s.lock.Unlock()
+	var format *payloadFormat
+	if ok {
+		format = &f
+	}
+	return fmt.Sprintf("msgId = %d, len = %d, %s", msgId, len(data), formatData(format, data))
+}
+
+// 执行一行命令，空行和#开始的注释不执行
+func (s *session) exec(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	cmd, args := nextField(line)
+	switch cmd {
+	case "send":
+		return s.send(args)
+	case "expect":
+		return s.expect(args)
+	case "decode":
+		return s.decode(args)
+	case "sleep":
+		d, err := time.ParseDuration(args)
+		if err != nil {
+			return err
+		}
+		time.Sleep(d)
+		return nil
+	case "help":
+		s.printf("%s", strings.TrimSuffix(helpText, "\n"))
+		return nil
+	case "quit", "exit":
+		return errQuit
+	}
+	return fmt.Errorf("unknown command %q, type help for commands", cmd)
+}
+
+// 解析send和expect的参数：msgId，以及可选的格式和数据
+func (s *session) parseMsg(args string) (uint32, *payloadFormat, []byte, error) {
+	idStr, rest := nextField(args)
+	if idStr == "" {
+		return 0, nil, nil, errors.New("missing msgId")
+	}
+	msgId, err := parseMsgId(idStr)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	name, payload := nextField(rest)
+	if name == "" {
+		return msgId, nil, nil, nil
+	}
+	f, err := parseFormat(name, s.files)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	data, err := f.encode(payload)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("%s payload: %w", f, err)
+	}
+	return msgId, &f, data, nil
+}
+
+// send <msgId> [<format> <payload>]
+func (s *session) send(args string) error {
+	msgId, f, data, err := s.parseMsg(args)
+	if err != nil {
+		return err
+	}
+	if err := s.client.SendMsg(msgId, data); err != nil {
+		return err
+	}
+	s.printf("==> msgId = %d, len = %d, %s", msgId, len(data), formatData(f, data))
+	return nil
+}
+
+// expect <msgId> [<format> <payload>]
+// 等待下一个msgId的消息，之前收到的其他消息被跳过，指定了数据时比较数据
+func (s *session) expect(args string) error {
+	msgId, f, want, err := s.parseMsg(args)
+	if err != nil {
+		return err
+	}
+	msg, err := s.waitMsg(msgId)
+	if err != nil {
+		return fmt.Errorf("expect msgId = %d: %w", msgId, err)
+	}
+	if f != nil && !f.equal(want, msg.GetData()) {
+		return fmt.Errorf("expect msgId = %d: got %s, want %s", msgId, formatData(f, msg.GetData()), formatData(f, want))
+	}
+	return nil
+}
+
+// 从队列中取出下一个msgId的消息，跳过之前的其他消息，超时或者连接断开时返回错误
+func (s *session) waitMsg(msgId uint32) (tiface.IMessage, error) {
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	for {
+		s.lock.Lock()
+		for len(s.queued) > 0 {
+			msg := s.queued[0]
+			s.queued = s.queued[1:]
+			if msg.GetMsgId() == msgId {
+				s.lock.Unlock()
+				return msg, nil
+			}
+		}
+		s.lock.Unlock()
+
+		select {
+		case <-s.notify:
+		case <-s.readDone:
+			// 读取协程退出之前收到的消息可能还在队列中
+			s.lock.Lock()
+			empty := len(s.queued) == 0
+			readErr := s.readErr
+			s.lock.Unlock()
+			if empty {
+				return nil, fmt.Errorf("connection closed: %v", readErr)
+			}
+		case <-timer.C:
+			return nil, fmt.Errorf("timeout after %v", s.timeout)
+		}
+	}
+}
+
+// decode <msgId> <format>
+func (s *session) decode(args string) error {
+	idStr, name := nextField(args)
+	msgId, err := parseMsgId(idStr)
+	if err != nil {
+		return err
+	}
+	f, err := parseFormat(strings.TrimSpace(name), s.files)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.decoders[msgId] = f
+	s.lock.Unlock()
+	return nil
+}
+
+// 依次执行脚本中的命令，遇到第一个失败的命令时返回错误，错误中包含脚本名称和行号
+func (s *session) runScript(r io.Reader, name string) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if err := s.exec(scanner.Text()); err != nil {
+			if err == errQuit {
+				return nil
+			}
+			return fmt.Errorf("%s:%d: %w", name, lineNo, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// 交互执行命令，命令失败时输出错误并继续，直到输入结束或者quit
+func (s *session) interact(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		if err := s.exec(scanner.Text()); err != nil {
+			if err == errQuit {
+				return nil
+			}
+			s.printf("error: %v", err)
+		}
+	}
+	return scanner.Err()
+}
+
+// 解析msgId，支持0x开始的十六进制
+func parseMsgId(s string) (uint32, error) {
+	id, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid msgId %q", s)
+	}
+	return uint32(id), nil
+}
+
+// 分割出第一个以空白分隔的字段，rest为去掉首尾空白的剩余部分
+func nextField(s string) (field, rest string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexFunc(s, func(r rune) bool { return r == ' ' || r == '\t' }); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i:])
+	}
+	return s, ""
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProto(t *testing.T) {
+	src, err := os.ReadFile("testdata/game.proto")
+	require.NoError(t, err)
+
+	file, err := parseProto(string(src))
+	require.NoError(t, err)
+	require.Equal(t, "gamepb", file.GoPackage)
+	require.Equal(t, []Route{
+		{MsgId: 16, Direction: DirHandle, Name: "Login", Type: "LoginReq", Line: 6},
+		{MsgId: 17, Direction: DirPush, Name: "LoginReq_Result", Type: "LoginReq_Result", Line: 10},
+		{MsgId: 18, Direction: DirPush, Name: "Kick", Type: "Kick", Line: 21},
+	}, file.Routes)
+}
+
+func TestParseProtoErrors(t *testing.T) {
+	for _, src := range []string{
+		"// @msgId 1 handle\nenum A {}",
+		"// @msgId 1 send\nmessage A {}",
+		"// @msgId x handle\nmessage A {}",
+		"// @msgId 0xFFFF0001 handle\nmessage A {}",
+		"// @msgId 1 handle\nmessage A {}\n// @msgId 1 push\nmessage B {}",
+		"// @msgId 1 handle\n// @msgId 2 handle\nmessage A {}",
+		"message A {}}",
+		"// @msgId 1 handle",
+	} {
+		_, err := parseProto(src)
+		require.Error(t, err, src)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "game_tigerkin.go")
+	require.NoError(t, run("testdata/game.proto", out, "", ""))
+	code, err := os.ReadFile(out)
+	require.NoError(t, err)
+
+	for _, s := range []string{
+		"package gamepb",
+		"MsgIdLogin           uint32 = 16",
+		"type GameHandler interface",
+		"Login(ctx context.Context, request tiface.IRequest, msg *LoginReq) error",
+		"func RegisterGameHandler(s tiface.IServer, h GameHandler)",
+		"func SendLogin(c *tnet.Client, msg *LoginReq) error",
+		"func PushLoginReq_Result(conn tiface.IConnection, msg *LoginReq_Result) error",
+		"func PushKick(conn tiface.IConnection, msg *Kick) error",
+	} {
+		require.Contains(t, string(code), s)
+	}
+}
+
+// MMO示例中生成的代码与msg.proto保持一致
+func TestGeneratedUpToDate(t *testing.T) {
+	dir := "../../demo_app/mmo_game/pb"
+	out := filepath.Join(t.TempDir(), "msg_tigerkin.go")
+	require.NoError(t, run(filepath.Join(dir, "msg.proto"), out, "", ""))
+
+	expected, err := os.ReadFile(out)
+	require.NoError(t, err)
+	actual, err := os.ReadFile(filepath.Join(dir, "msg_tigerkin.go"))
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(expected, actual), "msg_tigerkin.go is out of date, run go generate")
+}
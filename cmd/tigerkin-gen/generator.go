@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"sort"
+	"text/template"
+)
+
+var codeTemplate = template.Must(template.New("code").Parse(`// Code generated by tigerkin-gen from {{.Source}}. DO NOT EDIT.
+
+package {{.File.GoPackage}}
+
+import (
+{{- if .Handles}}
+	"context"
+{{end}}
+	"github.com/HOU-SZ/tigerkin/tiface"
+{{- if .Handles}}
+	"github.com/HOU-SZ/tigerkin/tnet"
+{{- end}}
+)
+
+// 消息ID
+const (
+{{- range .Consts}}
+	MsgId{{.Name}} uint32 = {{.MsgId}}
+{{- end}}
+)
+{{if .Handles}}
+// 客户端发送给服务端的消息的业务处理接口
+type {{.Name}}Handler interface {
+{{- range .Handles}}
+	{{.Name}}(ctx context.Context, request tiface.IRequest, msg *{{.Type}}) error
+{{- end}}
+}
+
+// 将h中的业务处理方法注册到Server
+func Register{{.Name}}Handler(s tiface.IServer, h {{.Name}}Handler) {
+{{- range .Handles}}
+	tnet.AddProtoHandler(s, MsgId{{.Name}}, h.{{.Name}})
+{{- end}}
+}
+{{range .Handles}}
+// 客户端发送{{.Name}}消息
+func Send{{.Name}}(c *tnet.Client, msg *{{.Type}}) error {
+	return c.SendProto(MsgId{{.Name}}, msg)
+}
+{{end}}
+{{- end}}
+{{- range .Pushes}}
+// 服务端推送{{.Name}}消息
+func Push{{.Name}}(conn tiface.IConnection, msg *{{.Type}}) error {
+	return conn.SendProto(MsgId{{.Name}}, msg)
+}
+{{end}}`))
+
+// 根据解析得到的proto文件生成Go代码
+func generate(file *ProtoFile, source, name string) ([]byte, error) {
+	data := struct {
+		Source  string
+		Name    string
+		File    *ProtoFile
+		Handles []Route
+		Pushes  []Route
+		Consts  []Route
+	}{Source: source, Name: name, File: file}
+
+	// 常量按照msgId排列
+	data.Consts = append(data.Consts, file.Routes...)
+	sort.Slice(data.Consts, func(i, j int) bool { return data.Consts[i].MsgId < data.Consts[j].MsgId })
+	for _, route := range file.Routes {
+		if route.Direction == DirHandle {
+			data.Handles = append(data.Handles, route)
+		} else {
+			data.Pushes = append(data.Pushes, route)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := codeTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
@@ -0,0 +1,70 @@
+/**
+*    tigerkin-gen: 根据proto文件中的@msgId注释生成msgId常量、业务处理接口、路由注册方法和发送方法
+*
+*    在proto文件中，为消息添加一行或多行注释：
+*        // @msgId <id> handle [Name]   客户端发送给服务端的消息，生成业务处理接口方法和客户端发送方法
+*        // @msgId <id> push [Name]     服务端推送给客户端的消息，生成服务端推送方法
+*    Name默认为消息名称，同一个消息类型使用多个msgId时需要分别指定不同的Name
+*
+*    使用go:generate调用：
+*        //go:generate go run github.com/HOU-SZ/tigerkin/cmd/tigerkin-gen -proto msg.proto
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	protoFile := flag.String("proto", "", "proto文件路径")
+	out := flag.String("out", "", "生成的Go文件路径，默认为proto文件所在目录下的<name>_tigerkin.go")
+	pkg := flag.String("package", "", "生成的Go文件的包名，默认从go_package或package中获取")
+	name := flag.String("name", "", "业务处理接口名称前缀，默认为proto文件名，例如msg.proto生成MsgHandler")
+	flag.Parse()
+
+	if *protoFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*protoFile, *out, *pkg, *name); err != nil {
+		fmt.Fprintln(os.Stderr, "tigerkin-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(protoFile, out, pkg, name string) error {
+	src, err := os.ReadFile(protoFile)
+	if err != nil {
+		return err
+	}
+
+	file, err := parseProto(string(src))
+	if err != nil {
+		return fmt.Errorf("%s: %w", protoFile, err)
+	}
+	if len(file.Routes) == 0 {
+		return fmt.Errorf("%s: no @msgId annotation found", protoFile)
+	}
+
+	base := strings.TrimSuffix(filepath.Base(protoFile), filepath.Ext(protoFile))
+	if pkg != "" {
+		file.GoPackage = pkg
+	}
+	if name == "" {
+		name = exportName(base)
+	}
+	if out == "" {
+		out = filepath.Join(filepath.Dir(protoFile), base+"_tigerkin.go")
+	}
+
+	code, err := generate(file, filepath.Base(protoFile), name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(out, code, 0644)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 消息方向
+const (
+	DirHandle = "handle" // 客户端发送给服务端，由服务端处理
+	DirPush   = "push"   // 服务端推送给客户端
+)
+
+// 一个带有msgId的消息
+type Route struct {
+	MsgId     uint32 // 消息ID
+	Direction string // 消息方向
+	Name      string // 常量和方法使用的名称
+	Type      string // 消息对应的Go类型名称
+	Line      int    // 注释所在的行号
+}
+
+// 解析得到的proto文件
+type ProtoFile struct {
+	GoPackage string  // Go包名
+	Routes    []Route // 按照出现顺序排列的消息
+}
+
+var (
+	msgIdPattern     = regexp.MustCompile(`^//\s*@msgId\s+(\S+)\s+(\S+)(?:\s+(\S+))?\s*$`)
+	messagePattern   = regexp.MustCompile(`^message\s+([A-Za-z_][A-Za-z0-9_]*)\s*\{`)
+	packagePattern   = regexp.MustCompile(`^package\s+([A-Za-z0-9_.]+)\s*;`)
+	goPackagePattern = regexp.MustCompile(`^option\s+go_package\s*=\s*"([^"]*)"\s*;`)
+	identPattern     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// 逐行解析proto文件，找到@msgId注释和紧随其后的message定义
+func parseProto(src string) (*ProtoFile, error) {
+	file := &ProtoFile{}
+	var protoPackage string
+	// 等待message定义的@msgId注释
+	var pending []Route
+	// 当前所在的message嵌套层级，用于生成嵌套消息的Go类型名称
+	var scopes []string
+	// 每一层大括号是否为message
+	var braces []bool
+
+	for i, line := range strings.Split(src, "\n") {
+		lineNo := i + 1
+		line = strings.TrimSpace(line)
+
+		if m := msgIdPattern.FindStringSubmatch(line); m != nil {
+			route, err := parseRoute(m, lineNo)
+			if err != nil {
+				return nil, err
+			}
+			pending = append(pending, route)
+			continue
+		}
+		if strings.HasPrefix(line, "//") {
+			continue
+		}
+		if idx := strings.Index(line, "//"); idx >= 0 {
+			line = strings.TrimSpace(line[:idx])
+		}
+
+		if m := packagePattern.FindStringSubmatch(line); m != nil {
+			protoPackage = m[1]
+		} else if m := goPackagePattern.FindStringSubmatch(line); m != nil {
+			file.GoPackage = goPackageName(m[1])
+		}
+
+		isMessage := false
+		if m := messagePattern.FindStringSubmatch(line); m != nil {
+			isMessage = true
+			goType := strings.Join(append(scopes, goCamelCase(m[1])), "_")
+			for _, route := range pending {
+				route.Type = goType
+				if route.Name == "" {
+					route.Name = goType
+				}
+				file.Routes = append(file.Routes, route)
+			}
+			pending = nil
+			scopes = append(scopes, goCamelCase(m[1]))
+		} else if len(pending) > 0 && line != "" {
+			return nil, fmt.Errorf("line %d: @msgId must be followed by a message definition", pending[0].Line)
+		}
+
+		// 根据大括号维护message的嵌套层级
+		for j, ch := range line {
+			switch ch {
+			case '{':
+				braces = append(braces, isMessage && j == strings.Index(line, "{"))
+			case '}':
+				if len(braces) == 0 {
+					return nil, fmt.Errorf("line %d: unbalanced braces", lineNo)
+				}
+				if braces[len(braces)-1] {
+					scopes = scopes[:len(scopes)-1]
+				}
+				braces = braces[:len(braces)-1]
+			}
+		}
+	}
+
+	if len(pending) > 0 {
+		return nil, fmt.Errorf("line %d: @msgId must be followed by a message definition", pending[0].Line)
+	}
+	if file.GoPackage == "" {
+		file.GoPackage = goPackageName(strings.ReplaceAll(protoPackage, ".", "_"))
+	}
+	if err := checkRoutes(file.Routes); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// 解析一行@msgId注释
+func parseRoute(m []string, lineNo int) (Route, error) {
+	id, err := strconv.ParseUint(m[1], 0, 32)
+	if err != nil {
+		return Route{}, fmt.Errorf("line %d: invalid msgId %q", lineNo, m[1])
+	}
+	if id >= 0xFFFF0000 {
+		return Route{}, fmt.Errorf("line %d: msgId %#x is reserved by tigerkin", lineNo, id)
+	}
+	if m[2] != DirHandle && m[2] != DirPush {
+		return Route{}, fmt.Errorf("line %d: direction must be %s or %s, got %q", lineNo, DirHandle, DirPush, m[2])
+	}
+	if m[3] != "" && !identPattern.MatchString(m[3]) {
+		return Route{}, fmt.Errorf("line %d: invalid name %q", lineNo, m[3])
+	}
+	return Route{MsgId: uint32(id), Direction: m[2], Name: exportName(m[3]), Line: lineNo}, nil
+}
+
+// 检查msgId和名称没有重复
+func checkRoutes(routes []Route) error {
+	ids := make(map[uint32]Route)
+	names := make(map[string]Route)
+	for _, route := range routes {
+		if prev, ok := ids[route.MsgId]; ok {
+			return fmt.Errorf("line %d: msgId %d already used at line %d", route.Line, route.MsgId, prev.Line)
+		}
+		if prev, ok := names[route.Name]; ok {
+			return fmt.Errorf("line %d: name %s already used at line %d", route.Line, route.Name, prev.Line)
+		}
+		ids[route.MsgId] = route
+		names[route.Name] = route
+	}
+	return nil
+}
+
+// 从go_package中得到包名："example.com/game/pb;pb"、"/pb"
+func goPackageName(goPackage string) string {
+	if idx := strings.LastIndex(goPackage, ";"); idx >= 0 {
+		return goPackage[idx+1:]
+	}
+	if idx := strings.LastIndex(goPackage, "/"); idx >= 0 {
+		return goPackage[idx+1:]
+	}
+	return goPackage
+}
+
+// 首字母大写
+func exportName(name string) string {
+	if name == "" {
+		return ""
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// 与protoc-gen-go一致的消息类型名称：首字母大写，下划线之后的小写字母转换为大写并去掉下划线
+func goCamelCase(name string) string {
+	var b strings.Builder
+	upper := true
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		switch {
+		case ch == '_' && i+1 < len(name) && 'a' <= name[i+1] && name[i+1] <= 'z':
+			upper = true
+		case upper && 'a' <= ch && ch <= 'z':
+			b.WriteByte(ch - 'a' + 'A')
+			upper = false
+		default:
+			b.WriteByte(ch)
+			upper = false
+		}
+	}
+	return b.String()
+}
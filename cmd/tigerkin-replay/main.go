@@ -0,0 +1,86 @@
+/**
+*    tigerkin-replay: 回放Tigerkin服务器录制的连接流量
+*
+*    服务器配置RecordDir之后，每个连接收发的消息被录制到该目录中的一个.tkrc文件，
+*    将录制文件回放给服务器，按照录制时的时间间隔（或者加速）发送客户端的消息，并将服务器的回复与录制进行比较：
+*        tigerkin-replay -addr 127.0.0.1:8999 records/20261019-143000.000000-3.tkrc
+*        tigerkin-replay -addr 127.0.0.1:8999 -speed 10 -ignore 0xFFFF0006,100 records/*.tkrc
+*        tigerkin-replay -dump records/20261019-143000.000000-3.tkrc
+*
+*    多个录制文件同时回放，每个文件使用一个连接，回复与录制不一致时以状态码1退出
+*    封包方式、压缩、加密等与服务器保持一致的配置从当前目录的conf/tigerkin.json或者-config指定的文件中读取
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tnet"
+	"github.com/HOU-SZ/tigerkin/utils"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8999", "服务器地址")
+	speed := flag.Float64("speed", 1, "回放速度，1为录制时的速度，0表示不等待，依次发送全部消息")
+	timeout := flag.Duration("timeout", time.Second, "按照录制的时间最后一个消息应当被收到之后，继续等待剩余回复的时长")
+	ignore := flag.String("ignore", "", "不进行比较的msgId，以逗号分隔，例如包含时间戳、随机数的消息")
+	dump := flag.Bool("dump", false, "输出录制的全部消息，不进行回放")
+	configFile := flag.String("config", "", "Tigerkin配置文件路径，默认为conf/tigerkin.json")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: tigerkin-replay [flags] recording.tkrc...")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	if *configFile != "" {
+		utils.GlobalObject.ConfFilePath = *configFile
+		utils.GlobalObject.Reload()
+	}
+
+	if *dump {
+		for _, path := range flag.Args() {
+			if err := dumpFile(os.Stdout, path); err != nil {
+				fmt.Fprintln(os.Stderr, "tigerkin-replay:", err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	opts := tnet.ReplayOptions{Speed: *speed, Timeout: *timeout}
+	if *ignore != "" {
+		var err error
+		if opts.IgnoreMsgIds, err = parseUints(*ignore); err != nil {
+			fmt.Fprintln(os.Stderr, "tigerkin-replay: -ignore:", err)
+			os.Exit(2)
+		}
+	}
+
+	ok, err := run(os.Stdout, *addr, flag.Args(), opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "tigerkin-replay:", err)
+		os.Exit(1)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// 解析以逗号分隔的msgId列表
+func parseUints(s string) ([]uint32, error) {
+	var values []uint32
+	for _, field := range strings.Split(s, ",") {
+		value, err := strconv.ParseUint(strings.TrimSpace(field), 0, 32)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, uint32(value))
+	}
+	return values, nil
+}
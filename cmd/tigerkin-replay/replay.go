@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/HOU-SZ/tigerkin/tnet"
+)
+
+// dump时显示的消息数据的最大长度
+const dumpDataPreview = 32
+
+/*
+	一个录制文件的回放结果
+*/
+type fileResult struct {
+	path   string
+	result *tnet.ReplayResult
+	err    error
+}
+
+// 同时回放全部录制文件，按照文件的顺序输出结果，返回回复是否都与录制一致
+func run(out io.Writer, addr string, paths []string, opts tnet.ReplayOptions) (bool, error) {
+	// 先读取全部录制文件，任何一个读取失败都不进行回放
+	recordings := make([]*tnet.Recording, len(paths))
+	for i, path := range paths {
+		rec, err := tnet.LoadRecording(path)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", path, err)
+		}
+		recordings[i] = rec
+	}
+
+	results := make([]fileResult, len(paths))
+	var wg sync.WaitGroup
+	for i := range paths {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := tnet.ReplayAddr(recordings[i], addr, opts)
+			results[i] = fileResult{path: paths[i], result: result, err: err}
+		}(i)
+	}
+	wg.Wait()
+
+	ok := true
+	for _, r := range results {
+		if !printResult(out, r) {
+			ok = false
+		}
+	}
+	return ok, nil
+}
+
+// 输出一个录制文件的回放结果，返回回复是否与录制一致
+func printResult(out io.Writer, r fileResult) bool {
+	if r.err != nil {
+		fmt.Fprintf(out, "FAIL %s: %v\n", r.path, r.err)
+		return false
+	}
+	result := r.result
+	status := "ok  "
+	if !result.Match() {
+		status = "FAIL"
+	}
+	closed := ""
+	if result.Closed {
+		closed = ", closed by server"
+	}
+	fmt.Fprintf(out, "%s %s: sent %d, expected %d, received %d, %d diffs in %v%s\n",
+		status, r.path, result.Sent, result.Expected, result.Received, len(result.Diffs), result.Elapsed.Round(1e6), closed)
+	for _, diff := range result.Diffs {
+		fmt.Fprintf(out, "    %s\n", diff)
+	}
+	return result.Match()
+}
+
+// 输出录制文件中的全部消息
+func dumpFile(out io.Writer, path string) error {
+	rec, err := tnet.LoadRecording(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	fmt.Fprintf(out, "%s: connID = %d, remote addr = %s, start = %s, %d records\n",
+		path, rec.ConnID, rec.RemoteAddr, rec.Start.Format("2006-01-02 15:04:05.000000"), len(rec.Records))
+	for _, r := range rec.Records {
+		if r.Type == tnet.RecordClose {
+			fmt.Fprintf(out, "%12.3fms %-5s\n", r.Offset.Seconds()*1000, r.Type)
+			continue
+		}
+		data := r.Data
+		suffix := ""
+		if len(data) > dumpDataPreview {
+			data = data[:dumpDataPreview]
+			suffix = "..."
+		}
+		fmt.Fprintf(out, "%12.3fms %-5s msgId = %d, flags = %d, len = %d, data = %s%s\n",
+			r.Offset.Seconds()*1000, r.Type, r.MsgId, r.Flags, len(r.Data), hex.EncodeToString(data), suffix)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/tnet"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// 回显所有消息的Router
+type echoRouter struct {
+	tnet.BaseRouter
+}
+
+func (router *echoRouter) Handle(request tiface.IRequest) {
+	request.GetConnection().SendMsg(request.GetMsgID(), request.GetData())
+}
+
+// 写入一个录制文件：msgId 1的回复与请求相同，msgId 2的回复与请求不同
+func writeRecording(t *testing.T, path string) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	start := time.Now()
+	w, err := tnet.NewRecordWriter(f, 3, "127.0.0.1:50000", start)
+	require.NoError(t, err)
+	records := []struct {
+		typ tnet.RecordType
+		at  time.Duration
+		msg tiface.IMessage
+	}{
+		{tnet.RecordInbound, 0, tnet.NewMsgPackage(1, []byte("ping"))},
+		{tnet.RecordOutbound, time.Millisecond, tnet.NewMsgPackage(1, []byte("ping"))},
+		{tnet.RecordInbound, 10 * time.Millisecond, tnet.NewMsgPackage(2, []byte("x"))},
+		{tnet.RecordOutbound, 11 * time.Millisecond, tnet.NewMsgPackage(2, []byte("y"))},
+		{tnet.RecordClose, 20 * time.Millisecond, nil},
+	}
+	for _, r := range records {
+		require.NoError(t, w.WriteRecord(r.typ, start.Add(r.at), r.msg))
+	}
+}
+
+func TestReplay(t *testing.T) {
+	conf := *utils.GlobalObject
+	defer func() { *utils.GlobalObject = conf }()
+
+	// 找一个空闲的端口启动回显服务器
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+	host, portStr, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	utils.GlobalObject.Host = host
+	utils.GlobalObject.TcpPort, err = strconv.Atoi(portStr)
+	require.NoError(t, err)
+
+	s := tnet.NewServer()
+	s.SetNotFoundRouter(&echoRouter{})
+	s.Start()
+	defer s.Stop()
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	path := filepath.Join(t.TempDir(), "conn.tkrc")
+	writeRecording(t, path)
+
+	// msgId 2的回复与录制不一致
+	var out bytes.Buffer
+	ok, err := run(&out, addr, []string{path, path}, tnet.ReplayOptions{Speed: 4})
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Contains(t, out.String(), "FAIL "+path+": sent 2, expected 2, received 2, 1 diffs")
+	require.Contains(t, out.String(), "    msgId = 2 #0: expected flags = 0, data = 79, got flags = 0, data = 78\n")
+
+	// 忽略msgId 2
+	out.Reset()
+	ok, err = run(&out, addr, []string{path}, tnet.ReplayOptions{IgnoreMsgIds: []uint32{2}})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Contains(t, out.String(), "ok   "+path+": sent 2, expected 1, received 1, 0 diffs")
+
+	// 录制文件不存在时不进行回放
+	_, err = run(&out, addr, []string{path, path + ".missing"}, tnet.ReplayOptions{})
+	require.Error(t, err)
+
+	// 服务器不存在
+	out.Reset()
+	ok, err = run(&out, "127.0.0.1:1", []string{path}, tnet.ReplayOptions{})
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.Contains(t, out.String(), "FAIL "+path+": ")
+}
+
+func TestDump(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conn.tkrc")
+	writeRecording(t, path)
+
+	var out bytes.Buffer
+	require.NoError(t, dumpFile(&out, path))
+	require.Contains(t, out.String(), "connID = 3, remote addr = 127.0.0.1:50000")
+	require.Contains(t, out.String(), "5 records")
+	require.Contains(t, out.String(), "      10.000ms in    msgId = 2, flags = 0, len = 1, data = 78\n")
+	require.Contains(t, out.String(), "      20.000ms close\n")
+
+	require.Error(t, dumpFile(&out, path+".missing"))
+}
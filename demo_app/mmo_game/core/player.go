@@ -12,14 +12,19 @@ import (
 
 // 玩家类型
 type Player struct {
-	Pid  int32              // 玩家ID
-	Conn tiface.IConnection // 当前玩家的连接（用于和客户端连接）
-	X    float32            // 平面x坐标
-	Y    float32            // 高度
-	Z    float32            // 平面y坐标 (注意不是Y)
-	V    float32            // 旋转0-360度
+	Pid int32   // 玩家ID
+	X   float32 // 平面x坐标
+	Y   float32 // 高度
+	Z   float32 // 平面y坐标 (注意不是Y)
+	V   float32 // 旋转0-360度
+
+	conn     tiface.IConnection // 当前玩家的连接（用于和客户端连接），恢复会话时会被替换
+	connLock sync.RWMutex       // 保护conn的读写锁
 }
 
+// 所有在线玩家的连接都加入的世界分组，用于世界聊天广播
+const WorldGroup = "world"
+
 /*
 	Player ID 生成器
 */
@@ -36,7 +41,7 @@ func NewPlayer(conn tiface.IConnection) *Player {
 
 	p := &Player{
 		Pid:  id,
-		Conn: conn,
+		conn: conn,
 		X:    float32(160 + rand.Intn(20)), // 随机在160坐标点 基于X轴偏移若干坐标
 		Y:    0,                            // 高度为0
 		Z:    float32(134 + rand.Intn(20)), // 随机在134坐标点 基于Y轴偏移若干坐标
@@ -46,6 +51,20 @@ func NewPlayer(conn tiface.IConnection) *Player {
 	return p
 }
 
+// 获取玩家当前的连接
+func (p *Player) GetConn() tiface.IConnection {
+	p.connLock.RLock()
+	defer p.connLock.RUnlock()
+	return p.conn
+}
+
+// 将玩家绑定到新的连接上，其他玩家的广播可能同时在读取连接
+func (p *Player) SetConn(conn tiface.IConnection) {
+	p.connLock.Lock()
+	defer p.connLock.Unlock()
+	p.conn = conn
+}
+
 // 告知客户端pid,同步已经生成的玩家ID给客户端
 func (p *Player) SyncPid() {
 	data := &pb.SyncPid{
@@ -53,7 +72,7 @@ func (p *Player) SyncPid() {
 	}
 
 	// 发送数据给客户端
-	p.SendMsg(1, data)
+	p.SendMsg(pb.MsgIdSyncPid, data)
 }
 
 // 广播玩家自己的出生地点
@@ -72,7 +91,7 @@ func (p *Player) BroadCastStartPosition() {
 		},
 	}
 
-	p.SendMsg(200, msg)
+	p.SendMsg(pb.MsgIdBroadCast, msg)
 }
 
 // 广播玩家的自身地理位置信息（告知周边玩家自己上线信息，并告知自己周边玩家信息）
@@ -100,7 +119,7 @@ func (p *Player) SyncSurrounding() {
 	}
 	// 3.2 每个玩家分别给对应的客户端发送200消息，显示人物
 	for _, player := range players {
-		player.SendMsg(200, msg)
+		player.SendMsg(pb.MsgIdBroadCast, msg)
 	}
 
 	// 4 让周围九宫格内的玩家出现在自己的视野中
@@ -125,7 +144,7 @@ func (p *Player) SyncSurrounding() {
 	}
 
 	// 4.3 给当前玩家发送需要显示周围的全部玩家数据
-	p.SendMsg(202, SyncPlayersMsg)
+	p.SendMsg(pb.MsgIdSyncPlayers, SyncPlayersMsg)
 }
 
 // 玩家广播聊天消息
@@ -139,13 +158,16 @@ func (p *Player) Talk(content string) {
 		},
 	}
 
-	// 2. 得到当前世界所有的在线玩家
-	players := WorldMgrObj.GetAllPlayers()
+	// 2. 将proto Message结构体序列化，只需要序列化一次
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		fmt.Println("marshal msg err: ", err)
+		return
+	}
 
-	// 3. 向所有的玩家发送MsgId:200消息
-	for _, player := range players {
-		// 每个player分别给对应的客户端发送消息
-		player.SendMsg(200, msg)
+	// 3. 向世界分组中所有的在线玩家发送MsgId:200消息，框架只封包一次
+	if err := p.GetConn().GetTcpServer().GetConnMgr().Multicast(WorldGroup, pb.MsgIdBroadCast, data); err != nil {
+		fmt.Println("Player Talk error: ", err)
 	}
 }
 
@@ -192,7 +214,7 @@ func (p *Player) UpdatePos(x float32, y float32, z float32, v float32) {
 	players := p.GetSurroundingPlayers()
 	// 向周边的每个玩家发送MsgID:200消息，移动位置更新消息
 	for _, player := range players {
-		player.SendMsg(200, msg)
+		player.SendMsg(pb.MsgIdBroadCast, msg)
 	}
 }
 
@@ -246,13 +268,13 @@ func (p *Player) OnExchangeAoiGrid(oldGid, newGid int) error {
 		players := WorldMgrObj.GetPlayersByGid(grid.GID)
 		for _, player := range players {
 			// 让自己在其他玩家的客户端中消失
-			player.SendMsg(201, offline_msg)
+			player.SendMsg(pb.MsgIdOffline, offline_msg)
 
 			// 将其他玩家信息 在自己的客户端中消失
 			another_offline_msg := &pb.SyncPid{
 				Pid: player.Pid,
 			}
-			p.SendMsg(201, another_offline_msg)
+			p.SendMsg(pb.MsgIdOffline, another_offline_msg)
 		}
 	}
 
@@ -285,7 +307,7 @@ func (p *Player) OnExchangeAoiGrid(oldGid, newGid int) error {
 
 		for _, player := range players {
 			// 让自己出现在其他人视野中
-			player.SendMsg(200, online_msg)
+			player.SendMsg(pb.MsgIdBroadCast, online_msg)
 
 			// 让其他人出现在自己的视野中
 			another_online_msg := &pb.BroadCast{
@@ -301,7 +323,7 @@ func (p *Player) OnExchangeAoiGrid(oldGid, newGid int) error {
 				},
 			}
 
-			p.SendMsg(200, another_online_msg)
+			p.SendMsg(pb.MsgIdBroadCast, another_online_msg)
 		}
 	}
 
@@ -320,7 +342,7 @@ func (p *Player) LostConnection() {
 
 	// 3 向周围玩家发送消息
 	for _, player := range players {
-		player.SendMsg(201, msg)
+		player.SendMsg(pb.MsgIdOffline, msg)
 	}
 
 	// 4 世界管理器将当前玩家从AOI中移除
@@ -333,23 +355,15 @@ func (p *Player) LostConnection() {
 	主要是将pb的protobuf数据序列化之后发送
 */
 func (p *Player) SendMsg(msgId uint32, data proto.Message) {
-	// fmt.Printf("before Marshal data = %+v\n", data)
-	// 将proto Message结构体序列化，转换成二进制
-	msg, err := proto.Marshal(data)
-	if err != nil {
-		fmt.Println("marshal msg err: ", err)
-		return
-	}
-	// fmt.Printf("after Marshal data = %+v\n", msg)
-
-	if p.Conn == nil {
+	conn := p.GetConn()
+	if conn == nil {
 		fmt.Println("connection in player is nil")
 		return
 	}
 
-	// 调用Tigerkin框架的SendMsg发包
-	if err := p.Conn.SendMsg(msgId, msg); err != nil {
-		fmt.Println("Player SendMsg error !")
+	// 调用Tigerkin框架的SendProto，将proto Message结构体序列化之后发包
+	if err := conn.SendProto(msgId, data); err != nil {
+		fmt.Println("Player SendMsg error: ", err)
 		return
 	}
 
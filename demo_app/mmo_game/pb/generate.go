@@ -0,0 +1,3 @@
+package pb
+
+//go:generate go run github.com/HOU-SZ/tigerkin/cmd/tigerkin-gen -proto msg.proto
@@ -0,0 +1,62 @@
+// Code generated by tigerkin-gen from msg.proto. DO NOT EDIT.
+
+package pb
+
+import (
+	"context"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/tnet"
+)
+
+// 消息ID
+const (
+	MsgIdSyncPid     uint32 = 1
+	MsgIdWorldChat   uint32 = 2
+	MsgIdMove        uint32 = 3
+	MsgIdBroadCast   uint32 = 200
+	MsgIdOffline     uint32 = 201
+	MsgIdSyncPlayers uint32 = 202
+)
+
+// 客户端发送给服务端的消息的业务处理接口
+type MsgHandler interface {
+	Move(ctx context.Context, request tiface.IRequest, msg *Position) error
+	WorldChat(ctx context.Context, request tiface.IRequest, msg *Talk) error
+}
+
+// 将h中的业务处理方法注册到Server
+func RegisterMsgHandler(s tiface.IServer, h MsgHandler) {
+	tnet.AddProtoHandler(s, MsgIdMove, h.Move)
+	tnet.AddProtoHandler(s, MsgIdWorldChat, h.WorldChat)
+}
+
+// 客户端发送Move消息
+func SendMove(c *tnet.Client, msg *Position) error {
+	return c.SendProto(MsgIdMove, msg)
+}
+
+// 客户端发送WorldChat消息
+func SendWorldChat(c *tnet.Client, msg *Talk) error {
+	return c.SendProto(MsgIdWorldChat, msg)
+}
+
+// 服务端推送SyncPid消息
+func PushSyncPid(conn tiface.IConnection, msg *SyncPid) error {
+	return conn.SendProto(MsgIdSyncPid, msg)
+}
+
+// 服务端推送Offline消息
+func PushOffline(conn tiface.IConnection, msg *SyncPid) error {
+	return conn.SendProto(MsgIdOffline, msg)
+}
+
+// 服务端推送BroadCast消息
+func PushBroadCast(conn tiface.IConnection, msg *BroadCast) error {
+	return conn.SendProto(MsgIdBroadCast, msg)
+}
+
+// 服务端推送SyncPlayers消息
+func PushSyncPlayers(conn tiface.IConnection, msg *SyncPlayers) error {
+	return conn.SendProto(MsgIdSyncPlayers, msg)
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/HOU-SZ/tigerkin/demo_app/mmo_game/apis"
 	"github.com/HOU-SZ/tigerkin/demo_app/mmo_game/core"
+	"github.com/HOU-SZ/tigerkin/demo_app/mmo_game/pb"
 	"github.com/HOU-SZ/tigerkin/tiface"
 	"github.com/HOU-SZ/tigerkin/tnet"
 )
@@ -21,6 +22,8 @@ func OnConnecionAdd(conn tiface.IConnection) {
 	core.WorldMgrObj.AddPlayer(player)
 	// 将该连接绑定属性Pid
 	conn.SetProperty("pid", player.Pid)
+	// 加入世界分组，接收世界聊天消息，连接断开时自动离开
+	conn.GetTcpServer().GetConnMgr().JoinGroup(core.WorldGroup, conn)
 	// 告知周边玩家自己上线信息，并告知自己周边玩家信息
 	player.SyncSurrounding()
 
@@ -43,6 +46,17 @@ func OnConnectionLost(conn tiface.IConnection) {
 	fmt.Println("====> Player ", pid, " left =====")
 }
 
+// 当客户端断线重连并恢复会话的时候的hook函数（需要配置SessionGracePeriod）
+// 玩家仍然在世界中，只需要将玩家绑定到新的连接上
+func OnConnectionResume(conn tiface.IConnection) {
+	pid, _ := conn.GetProperty("pid")
+	if player := core.WorldMgrObj.GetPlayerByPid(pid.(int32)); player != nil {
+		player.SetConn(conn)
+	}
+
+	fmt.Println("====> Player ", pid, " resumed =====")
+}
+
 func main() {
 	// 创建服务器句柄
 	s := tnet.NewServer()
@@ -50,10 +64,10 @@ func main() {
 	// 注册客户端连接建立和丢失函数
 	s.SetOnConnStart(OnConnecionAdd)
 	s.SetOnConnStop(OnConnectionLost)
+	s.SetOnConnResume(OnConnectionResume)
 
 	// 注册路由
-	s.AddRouter(2, &apis.WorldChatApi{})
-	s.AddRouter(3, &apis.MoveApi{})
+	pb.RegisterMsgHandler(s, &apis.GameApi{})
 
 	// 启动服务
 	s.Serve()
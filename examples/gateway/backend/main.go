@@ -0,0 +1,50 @@
+/**
+*    tigerkin gateway example: backend logic server
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/tnet"
+	"github.com/HOU-SZ/tigerkin/utils"
+)
+
+// 回复"后端名称: 数据"
+type HelloRouter struct {
+	tnet.BaseRouter
+	name string
+}
+
+func (router *HelloRouter) Handle(request tiface.IRequest) {
+	fmt.Println("recv from gateway ConnID = ", request.GetConnection().GetConnID(), " msgId = ", request.GetMsgID(), " data = ", string(request.GetData()))
+	reply := fmt.Sprintf("%s: %s", router.name, request.GetData())
+	if err := request.GetConnection().SendMsg(request.GetMsgID(), []byte(reply)); err != nil {
+		fmt.Println("SendMsg error: ", err)
+	}
+}
+
+func main() {
+	name := flag.String("name", "backend-a", "backend name")
+	port := flag.Int("port", 9001, "listen port")
+	msgId := flag.Uint("msgid", 10, "msgId handled by the backend")
+	flag.Parse()
+
+	utils.GlobalObject.Name = *name
+	utils.GlobalObject.TcpPort = *port
+	s := tnet.NewServer()
+
+	// 处理网关转发来的消息，网关上的客户端连接在后端上对应虚拟连接
+	backend := tnet.NewGatewayBackend(s)
+	backend.SetOnConnect(func(conn tiface.IConnection) {
+		fmt.Println("client ConnID = ", conn.GetConnID(), " from ", conn.RemoteAddr(), " connected through gateway")
+	})
+	backend.SetOnDisconnect(func(conn tiface.IConnection) {
+		fmt.Println("client ConnID = ", conn.GetConnID(), " disconnected")
+	})
+	s.AddRouter(uint32(*msgId), &HelloRouter{name: *name})
+
+	s.Serve()
+}
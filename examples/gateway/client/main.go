@@ -0,0 +1,37 @@
+/**
+*    tigerkin gateway example: client connecting to the gateway
+ */
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tnet"
+)
+
+func main() {
+	client := tnet.NewClient("127.0.0.1", 8999)
+	if err := client.Start(); err != nil {
+		fmt.Println("client start error, exit!")
+		return
+	}
+	defer client.Stop()
+
+	// msgId 1由网关处理，10和100分别由两个后端处理
+	for {
+		for _, msgId := range []uint32{1, 10, 100} {
+			if err := client.SendMsg(msgId, []byte("hello")); err != nil {
+				fmt.Println("send error: ", err)
+				return
+			}
+			msg, err := client.ReadMsg()
+			if err != nil {
+				fmt.Println("read error: ", err)
+				return
+			}
+			fmt.Println("==> Recv Msg: ID=", msg.GetMsgId(), ", data=", string(msg.GetData()))
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,50 @@
+/**
+*    tigerkin gateway example: gateway holding client connections
+ */
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/tnet"
+	"github.com/HOU-SZ/tigerkin/utils"
+)
+
+// 网关本地处理的ping消息
+type PingRouter struct {
+	tnet.BaseRouter
+}
+
+func (router *PingRouter) Handle(request tiface.IRequest) {
+	if err := request.GetConnection().SendMsg(1, []byte("pong")); err != nil {
+		fmt.Println("SendMsg error: ", err)
+	}
+}
+
+func main() {
+	port := flag.Int("port", 8999, "listen port")
+	backendA := flag.String("a", "127.0.0.1:9001", "address of backend-a")
+	backendB := flag.String("b", "127.0.0.1:9002", "address of backend-b")
+	flag.Parse()
+
+	utils.GlobalObject.Name = "gateway"
+	utils.GlobalObject.TcpPort = *port
+	s := tnet.NewServer()
+	s.AddRouter(1, &PingRouter{})
+
+	// msgId 10-99转发给backend-a，100-199转发给backend-b，回复由网关转发给原来的客户端连接
+	gw := tnet.NewGateway(s)
+	if err := gw.AddBackend("backend-a", *backendA); err != nil {
+		panic(err)
+	}
+	if err := gw.AddBackend("backend-b", *backendB); err != nil {
+		panic(err)
+	}
+	gw.RouteRange(10, 99, "backend-a")
+	gw.RouteRange(100, 199, "backend-b")
+	gw.Start()
+
+	s.Serve()
+}
@@ -1,10 +1,13 @@
 module github.com/HOU-SZ/tigerkin
 
-go 1.18
+go 1.20
 
-require github.com/stretchr/testify v1.8.0
+require (
+	github.com/stretchr/testify v1.8.0
+	golang.org/x/crypto v0.17.0
+)
 
-require golang.org/x/sys v0.0.0-20191026070338-33540a1f6037 // indirect
+require golang.org/x/sys v0.15.0 // indirect
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -0,0 +1,11 @@
+package tiface
+
+/*
+	鉴权抽象层
+	Server设置了鉴权器之后，新建立的连接只能发送鉴权消息，鉴权通过之后才会将消息分发给Router
+*/
+type IAuthenticator interface {
+	// 校验连接发送来的鉴权消息，校验通过返回nil，可以在其中通过SetProperty设置连接的身份信息
+	// 恢复会话时使用reflect.DeepEqual比较身份信息，身份信息设置之后不应再修改
+	Authenticate(conn IConnection, data []byte) error
+}
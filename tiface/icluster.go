@@ -0,0 +1,27 @@
+package tiface
+
+/*
+	集群消息总线抽象层
+	每个节点以节点名称订阅发给自己的消息，同一个发送者发给同一个节点的消息按顺序到达
+*/
+type IBus interface {
+	Subscribe(node string, handler func(data []byte)) error // 接收发给节点的消息
+	Unsubscribe(node string)                                // 停止接收发给节点的消息
+	Send(node string, data []byte) error                    // 发送消息给节点
+	Nodes() []string                                        // 获取全部节点的名称
+}
+
+/*
+	集群抽象层
+	记录每个用户所在的节点，通过消息总线将消息发送给其他节点上的连接
+*/
+type ICluster interface {
+	GetNodeName() string                                                // 获取本节点的名称
+	Start() error                                                       // 订阅消息总线，从其他节点同步用户所在的节点
+	Stop()                                                              // 停止订阅，通知其他节点本节点的用户全部下线
+	BindUser(userID string, conn IConnection)                           // 将用户绑定到本节点的连接上，连接停止时自动解除
+	UnbindUser(userID string)                                           // 解除用户与本节点连接的绑定
+	GetUserNode(userID string) (string, bool)                           // 获取用户所在的节点
+	SendTo(node string, connID uint32, msgId uint32, data []byte) error // 给节点上的连接发送消息
+	SendToUser(userID string, msgId uint32, data []byte) error          // 给用户发送消息，无论用户在哪个节点上
+}
@@ -0,0 +1,11 @@
+package tiface
+
+/*
+消息序列化抽象层
+Server可以设置默认的序列化方式，也可以为某个msgId单独指定；客户端在连接建立时协商本次连接使用的序列化方式
+*/
+type ICodec interface {
+	Name() string                               // 序列化方式名称，用于配置文件和连接协商
+	Marshal(v interface{}) ([]byte, error)      // 将v序列化为二进制数据
+	Unmarshal(data []byte, v interface{}) error // 将二进制数据反序列化到v中，v必须是指针
+}
@@ -0,0 +1,22 @@
+package tiface
+
+import "io"
+
+/*
+	消息压缩抽象层
+	压缩算法的ID写入消息头部flags的低4位，接收方根据ID选择对应的压缩算法进行解压
+*/
+type ICompressor interface {
+	ID() byte                                              // 压缩算法ID，取值范围1-15
+	Name() string                                          // 压缩算法名称，用于配置文件中指定压缩算法
+	Compress(data []byte) ([]byte, error)                  // 压缩数据
+	Decompress(data []byte, maxLen uint32) ([]byte, error) // 解压数据，解压后的长度超过maxLen时返回错误（maxLen为0表示不限制）
+}
+
+/*
+	流式解压接口（可选）
+	压缩算法实现了该接口时，流式路由可以边接收分片边解压，不需要缓存整个消息
+*/
+type IStreamDecompressor interface {
+	NewReader(r io.Reader) (io.ReadCloser, error) // 创建从r中读取压缩数据的解压Reader
+}
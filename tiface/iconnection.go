@@ -1,6 +1,11 @@
 package tiface
 
-import "net"
+import (
+	"context"
+	"net"
+
+	"google.golang.org/protobuf/proto"
+)
 
 //定义连接接口
 type IConnection interface {
@@ -10,13 +15,16 @@ type IConnection interface {
 
 	// 停止连接，结束当前连接状态
 	Stop()
-
+	// 从当前连接获取原始的socket TCPConn，连接不是TCP连接（例如net.Pipe）时返回nil
 	// 从当前连接获取原始的socket TCPConn
 	GetTCPConnection() *net.TCPConn
 
 	// 获取当前连接ID
 	GetConnID() uint32
 
+	// 获取当前连接所属的Server
+	GetTcpServer() IServer
+
 	// 获取远程客户端地址信息
 	RemoteAddr() net.Addr
 
@@ -26,6 +34,21 @@ type IConnection interface {
 	// 将数据发送给有缓冲队列，通过专门从缓冲队列读数据的goroutine写给TCP客户端（有缓冲）
 	SendBuffMsg(msgId uint32, data []byte) error
 
+	// 将proto消息序列化之后发送给客户端（无缓冲）
+	SendProto(msgId uint32, msg proto.Message) error
+
+	// 带序号发送消息（有缓冲），消息保留到客户端确认为止，开启会话时恢复会话之后重发，客户端按照序号去重，返回消息的序号
+	SendReliable(msgId uint32, data []byte) (uint64, error)
+
+	// 获取被客户端确认的最大序号，序号小于等于它的可靠消息都已经送达
+	GetAckedSeq() uint64
+
+	// 使用msgId对应的序列化方式将v序列化之后发送给客户端（无缓冲）
+	SendValue(msgId uint32, v interface{}) error
+
+	// 获取msgId使用的序列化方式：Server为msgId单独指定的、连接协商的、Server默认的
+	GetCodec(msgId uint32) ICodec
+
 	// 设置链接属性
 	SetProperty(key string, value interface{})
 
@@ -34,6 +57,18 @@ type IConnection interface {
 
 	// 移除链接属性
 	RemoveProperty(key string)
+
+	// 获取当前连接的限流统计信息
+	GetRateLimitStats() RateLimitStats
+
+	// 当前连接是否已经通过鉴权
+	IsAuthenticated() bool
+
+	// 获取当前连接绑定的会话ID，没有会话时返回空字符串
+	GetSessionID() string
+
+	// 获取当前连接的context，连接停止时被取消
+	Context() context.Context
 }
 
 // //定义一个统一处理链接业务的接口
@@ -1,12 +1,20 @@
 package tiface
 
 /*
-	连接管理抽象层
+连接管理抽象层
 */
 type IConnManager interface {
 	Add(conn IConnection)                   // 添加链接
-	Remove(conn IConnection)                // 删除连接
+	Remove(conn IConnection)                // 删除连接，同时将其移出加入的全部分组
 	Get(connID uint32) (IConnection, error) // 根据ConnID获取链接
 	Len() int                               // 获取当前连接总数
 	ClearConn()                             // 删除并停止所有链接
+
+	JoinGroup(group string, conn IConnection)  // 将连接加入分组（房间），分组不存在时自动创建
+	LeaveGroup(group string, conn IConnection) // 将连接移出分组，分组为空时自动删除
+	GetGroup(group string) []IConnection       // 获取分组中的全部连接
+	GetConnGroups(connID uint32) []string      // 获取连接加入的全部分组
+
+	Broadcast(msgId uint32, data []byte, exclude ...uint32) error               // 给全部连接发送消息，exclude中的ConnID除外，消息只封包一次
+	Multicast(group string, msgId uint32, data []byte, exclude ...uint32) error // 给分组中的全部连接发送消息，exclude中的ConnID除外，消息只封包一次
 }
@@ -9,3 +9,11 @@ type IDataPack interface {
 	Pack(msg IMessage) ([]byte, error) // 封包方法
 	Unpack([]byte) (IMessage, error)   // 拆包方法
 }
+
+/*
+	消息体拆包接口（可选）
+	IDataPack实现了该接口时，在读取完消息体之后会调用UnpackData对消息体进行还原（例如解密、校验）
+*/
+type IDataUnpacker interface {
+	UnpackData(msg IMessage) error // 还原消息体，还原失败时返回错误，连接将被关闭
+}
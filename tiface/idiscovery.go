@@ -0,0 +1,24 @@
+package tiface
+
+import "context"
+
+/*
+	服务实例：一个提供某个服务的节点
+*/
+type ServiceInstance struct {
+	ID       string            `json:"id"`                 // 实例ID，同一个服务中唯一
+	Name     string            `json:"name"`               // 服务名称
+	Addr     string            `json:"addr"`               // 实例的地址，"host:port"
+	Metadata map[string]string `json:"metadata,omitempty"` // 实例的附加信息
+}
+
+/*
+	服务发现抽象层
+	节点通过Register注册自己提供的服务，其他节点通过GetInstances或者Watch找到服务的全部实例
+*/
+type IDiscovery interface {
+	Register(instance ServiceInstance) error                                  // 注册服务实例，ID相同的实例被替换
+	Deregister(name, id string) error                                         // 注销服务实例
+	GetInstances(name string) ([]ServiceInstance, error)                      // 获取服务的全部实例，按照ID排序
+	Watch(ctx context.Context, name string) (<-chan []ServiceInstance, error) // 监听服务的实例列表，先发送当前的列表，之后每次变化时发送新的列表，ctx结束时关闭
+}
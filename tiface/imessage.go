@@ -7,8 +7,10 @@ type IMessage interface {
 	GetMsgId() uint32   // 获取消息ID
 	GetDataLen() uint32 // 获取消息数据段长度
 	GetData() []byte    // 获取消息内容
+	GetFlags() byte     // 获取消息标志位（压缩算法等）
 
 	SetMsgId(uint32)   // 设置消息ID
 	SetDataLen(uint32) // 设置消息数据段长度
 	SetData([]byte)    // 设置消息内容
+	SetFlags(byte)     // 设置消息标志位
 }
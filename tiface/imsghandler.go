@@ -8,4 +8,25 @@ type IMsgHandle interface {
 	AddRouter(msgId uint32, router IRouter) // 为消息添加具体的处理逻辑
 	StartWorkerPool()                       // 启动worker工作池
 	SendMsgToTaskQueue(request IRequest)    // 将消息交给TaskQueue，由worker进行处理
+
+	SendTaskToQueue(workerKey uint32, task func()) // 将任务交给workerKey对应的worker执行（与ConnID为workerKey的连接的消息由同一个worker处理）
+
+	AddStreamRouter(msgId uint32, router IStreamRouter) // 为大消息添加流式处理逻辑
+	HasStreamRouter(msgId uint32) bool                  // 判断msgId是否注册了流式处理逻辑
+
+	AddNamedRouter(name string, msgId uint32, router IRouter) // 注册带有字符串路由名称的处理逻辑，名称出现在路由表中
+	GetRoutes() []RouteInfo                                   // 获取路由表，按照msgId排序
+
+	SetNotFoundRouter(router IRouter)                   // 设置处理没有注册处理方法的消息的Router
+	SetForwarder(forwarder func(request IRequest) bool) // 设置转发没有注册处理方法的消息的方法，返回true表示已经转发，不作为未知消息处理
+	GetUnknownMsgStats() UnknownMsgStats                // 获取未知消息的统计信息
+}
+
+/*
+	没有注册处理方法的消息的统计信息
+*/
+type UnknownMsgStats struct {
+	NotFound     uint64 // 没有注册处理方法的消息数量
+	Replied      uint64 // 回复了错误消息的数量
+	Disconnected uint64 // 因为未知消息过多被断开的连接数量
 }
@@ -0,0 +1,28 @@
+package tiface
+
+/*
+	限流抽象层
+	在消息被分发给Router之前，基于令牌桶对消息进行限流（全局、单连接、单连接上的单个MsgId）
+*/
+type IRateLimiter interface {
+	NewConnLimiter() IConnLimiter // 为新建立的连接创建一个连接级别的限流器
+}
+
+/*
+	连接级别的限流器，每个连接拥有一个
+*/
+type IConnLimiter interface {
+	Allow(conn IConnection, msgId uint32) bool // 检查该连接上收到的消息是否允许继续分发，内部执行对应的限流策略
+	GetStats() RateLimitStats                  // 获取当前连接的限流统计信息
+}
+
+/*
+	连接的限流统计信息
+*/
+type RateLimitStats struct {
+	Passed     uint64 // 通过限流检查的消息数量
+	Dropped    uint64 // 因限流被丢弃的消息数量
+	Delayed    uint64 // 因限流被延迟分发的消息数量
+	Replied    uint64 // 因限流被回复了错误消息的消息数量
+	Violations uint64 // 超出限流的总次数
+}
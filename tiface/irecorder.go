@@ -0,0 +1,20 @@
+package tiface
+
+/*
+	流量录制抽象层
+	记录每个连接收到和发出的消息及其时间，录制的结果可以回放给服务器，用来复现客户端报告的问题
+*/
+type IRecorder interface {
+	// 为新建立的连接创建一个连接级别的录制器，返回nil时不录制该连接
+	NewConnRecorder(conn IConnection) (IConnRecorder, error)
+}
+
+/*
+	连接级别的录制器，每个连接拥有一个
+	收到的消息在Reader goroutine中、发出的消息在Writer goroutine中记录，实现需要保证并发安全
+*/
+type IConnRecorder interface {
+	RecordInbound(msg IMessage)  // 记录一个收到的消息（解密之后，重组、解压之前）
+	RecordOutbound(msg IMessage) // 记录一个发出的消息（压缩、拆分之后，加密之前）
+	Close() error                // 连接关闭时调用，之后不再记录
+}
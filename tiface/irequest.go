@@ -1,5 +1,10 @@
 package tiface
 
+import (
+	"context"
+	"io"
+)
+
 /*
 	IRequest 接口：
 	实际上是把客户端请求的链接信息 和 请求的数据 包装到了 Request里
@@ -8,4 +13,9 @@ type IRequest interface {
 	GetConnection() IConnection // 获取请求的链接信息
 	GetData() []byte            // 获取请求的消息数据
 	GetMsgID() uint32           //获取请求的消息ID
+	GetBodyReader() io.Reader   //获取读取消息数据的Reader，流式请求的数据只能通过它读取
+
+	Context() context.Context           //获取请求的context，由连接的context派生，连接停止、处理超时或者处理结束时被取消
+	Set(key string, value interface{})  //设置请求范围内的值，可以在中间件和处理方法之间传递数据
+	Get(key string) (interface{}, bool) //获取请求范围内的值
 }
@@ -0,0 +1,29 @@
+package tiface
+
+/*
+	中间件，在处理方法之前和之后执行，调用next执行后续的中间件和处理方法，不调用next则中断请求的处理
+*/
+type Middleware func(request IRequest, next func())
+
+/*
+	路由分组抽象层
+	字符串路由（例如"player.move"）通过RouteID映射为固定的msgId，客户端和服务端各自计算即可，不需要传输路由表；
+	分组中的路由名称带有分组的前缀，并依次执行各级分组的中间件
+*/
+type IRouteGroup interface {
+	Group(prefix string, middlewares ...Middleware) IRouteGroup // 创建子分组，子分组的前缀和中间件在当前分组之后
+	Use(middlewares ...Middleware)                              // 为分组添加中间件，只对之后注册的路由生效
+	AddRoute(name string, router IRouter) uint32                // 注册字符串路由，名称为"分组前缀.name"，返回映射的msgId
+	AddRouter(msgId uint32, router IRouter)                     // 注册数字路由，同样执行分组的中间件
+}
+
+/*
+	路由表中的一条路由
+*/
+type RouteInfo struct {
+	MsgId       uint32 // 消息ID
+	Name        string // 字符串路由名称，数字路由为空
+	Router      string // 处理方法的类型
+	Middlewares int    // 执行的中间件数量
+	Stream      bool   // 是否为流式处理方法
+}
@@ -0,0 +1,20 @@
+package tiface
+
+import "time"
+
+/*
+	定时任务调度抽象层
+	定时任务在Worker中执行：绑定连接的任务与该连接的消息由同一个Worker执行，绑定key的任务总是由同一个Worker执行，
+	因此同一个连接（或key）的消息处理和定时任务之间不需要加锁；未开启工作池时每个任务在新的goroutine中执行
+*/
+type IScheduler interface {
+	AfterFunc(d time.Duration, f func()) uint64                       // d之后执行一次f，返回定时任务ID
+	Every(d time.Duration, f func()) uint64                           // 每隔d执行一次f
+	AfterFuncConn(conn IConnection, d time.Duration, f func()) uint64 // d之后在连接所在的Worker中执行一次f，连接停止之后不再执行
+	EveryConn(conn IConnection, d time.Duration, f func()) uint64     // 每隔d在连接所在的Worker中执行一次f，连接停止之后不再执行
+	AfterFuncKey(key string, d time.Duration, f func()) uint64        // d之后在key对应的Worker中执行一次f
+	EveryKey(key string, d time.Duration, f func()) uint64            // 每隔d在key对应的Worker中执行一次f
+	Cancel(timerID uint64) bool                                       // 取消定时任务，返回false表示任务不存在或者已经执行完
+	Len() int                                                         // 获取尚未执行完的定时任务数量
+	Stop()                                                            // 停止调度，之后不再执行任何定时任务
+}
@@ -1,5 +1,10 @@
 package tiface
 
+import (
+	"io"
+	"time"
+)
+
 type IServer interface {
 	//启动服务器方法
 	Start()
@@ -13,9 +18,42 @@ type IServer interface {
 	//路由功能：给当前的服务注册一个路由方法，供客户端链接处理使用
 	AddRouter(msgId uint32, router IRouter)
 
+	//路由功能：给当前的服务注册一个流式路由方法，用于处理被拆分为多个分片的大消息
+	AddStreamRouter(msgId uint32, router IStreamRouter)
+
+	//路由功能：注册字符串路由，返回映射的msgId
+	AddRoute(name string, router IRouter) uint32
+
+	//路由功能：创建带有前缀和中间件的路由分组
+	Group(prefix string, middlewares ...Middleware) IRouteGroup
+
+	//得到路由表，按照msgId排序
+	GetRoutes() []RouteInfo
+
+	//将路由表以表格形式输出到w
+	DumpRoutes(w io.Writer)
+
+	//设置处理没有注册处理方法的消息的Router，替代默认的错误回复
+	SetNotFoundRouter(router IRouter)
+
+	//得到未知消息的统计信息
+	GetUnknownMsgStats() UnknownMsgStats
+
 	//得到当前server的链接管理模块
 	GetConnMgr() IConnManager
 
+	//得到当前server的主题订阅管理模块
+	GetTopicMgr() ITopicManager
+
+	//得到当前server的会话管理模块
+	GetSessionMgr() ISessionManager
+
+	//得到当前server的定时任务调度模块
+	GetScheduler() IScheduler
+
+	//得到当前server的消息处理模块
+	GetMsgHandler() IMsgHandle
+
 	//设置该Server的连接创建时Hook函数
 	SetOnConnStart(func(IConnection))
 
@@ -27,4 +65,58 @@ type IServer interface {
 
 	//调用连接OnConnStop Hook函数
 	CallOnConnStop(conn IConnection)
+
+	//设置该Server的会话恢复时的Hook函数，会话被绑定到新的连接之后调用，可以用来更新业务中保存的连接
+	SetOnConnResume(func(IConnection))
+
+	//调用会话恢复时的Hook函数
+	CallOnConnResume(conn IConnection)
+
+	//设置该Server的业务处理出错时的Hook函数（例如proto消息解码失败、handler返回错误）
+	SetOnHandlerError(func(request IRequest, err error))
+
+	//调用业务处理出错时的Hook函数，未设置时打印错误信息
+	CallOnHandlerError(request IRequest, err error)
+
+	//设置该Server的限流器
+	SetRateLimiter(limiter IRateLimiter)
+
+	//得到该Server的限流器
+	GetRateLimiter() IRateLimiter
+
+	//设置该Server的鉴权器，设置之后连接必须先通过鉴权才能使用路由
+	SetAuthenticator(authenticator IAuthenticator)
+
+	//得到该Server的鉴权器
+	GetAuthenticator() IAuthenticator
+
+	//设置该Server的流量录制器，为nil时不录制
+	SetRecorder(recorder IRecorder)
+
+	//得到该Server的流量录制器
+	GetRecorder() IRecorder
+
+	//设置该Server的封包拆包模块
+	SetDataPack(dataPack IDataPack)
+
+	//得到该Server的封包拆包模块
+	GetDataPack() IDataPack
+
+	//设置该Server默认的序列化方式
+	SetCodec(codec ICodec)
+
+	//得到该Server默认的序列化方式
+	GetCodec() ICodec
+
+	//为某个msgId单独指定序列化方式，不受连接协商结果的影响
+	SetMsgCodec(msgId uint32, codec ICodec)
+
+	//得到为msgId单独指定的序列化方式，未指定时返回nil
+	GetMsgCodec(msgId uint32) ICodec
+
+	//为某个msgId设置处理超时时间，超时之后请求的context被取消
+	SetMsgTimeout(msgId uint32, timeout time.Duration)
+
+	//得到msgId的处理超时时间，为0表示不超时
+	GetMsgTimeout(msgId uint32) time.Duration
 }
@@ -0,0 +1,16 @@
+package tiface
+
+/*
+会话管理抽象层
+会话在连接断开之后保留一段时间（SessionGracePeriod），客户端使用恢复令牌重新连接时，
+会话（链接属性、加入的分组、订阅的主题、期间未发送的消息）被绑定到新的连接上，不会调用OnConnStop/OnConnStart
+*/
+type ISessionManager interface {
+	Create(conn IConnection) (token string)                 // 为连接创建新的会话，返回客户端恢复会话使用的令牌
+	Resume(conn IConnection, token string) error            // 将令牌对应的会话绑定到新的连接上
+	Detach(conn IConnection) bool                           // 连接断开时保留其会话，返回false表示连接没有会话，需要调用OnConnStop
+	Send(sessionID string, msgId uint32, data []byte) error // 给会话当前绑定的连接发送消息，会话等待恢复期间消息暂存在会话中
+	Close(sessionID string)                                 // 立即结束会话，调用OnConnStop
+	Len() int                                               // 获取当前会话总数
+	ClearSession()                                          // 结束全部会话
+}
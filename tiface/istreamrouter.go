@@ -0,0 +1,11 @@
+package tiface
+
+import "io"
+
+/*
+	流式路由接口，用于处理超过MaxPacketSize、被拆分为多个分片发送的大消息
+	消息体通过body逐块读取，不需要将整个消息缓存在内存中；body中未读完的数据会在Handle返回后被丢弃
+*/
+type IStreamRouter interface {
+	Handle(request IRequest, body io.Reader) // 处理流式消息的主方法
+}
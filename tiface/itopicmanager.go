@@ -0,0 +1,16 @@
+package tiface
+
+/*
+主题订阅管理抽象层
+主题由'.'分隔的多段组成，例如"market.gold.price"
+订阅时可以使用通配符：'*'匹配任意一段，'>'只能出现在最后，匹配之后的一段或多段
+*/
+type ITopicManager interface {
+	Subscribe(conn IConnection, pattern string) error                      // 连接订阅主题
+	Unsubscribe(conn IConnection, pattern string)                          // 连接取消订阅主题
+	UnsubscribeAll(conn IConnection)                                       // 连接取消全部订阅，连接停止时自动调用
+	GetSubscriptions(connID uint32) []string                               // 获取连接的全部订阅
+	Publish(topic string, msgId uint32, data []byte) error                 // 给订阅了主题的全部连接发送消息，消息只封包一次
+	SetAuthorizer(authorizer func(conn IConnection, pattern string) error) // 设置客户端通过消息订阅主题时的权限检查
+	Authorize(conn IConnection, pattern string) error                      // 检查客户端能否订阅主题，未设置权限检查时全部允许
+}
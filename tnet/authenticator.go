@@ -0,0 +1,97 @@
+package tnet
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+)
+
+// 鉴权通过后，保存连接身份信息的属性名
+const AuthIdentityProperty = "identity"
+
+// 鉴权回复消息的状态码，位于回复消息数据的第一个字节，之后为具体的说明信息
+const (
+	AuthStatusOK     byte = 0 // 鉴权通过
+	AuthStatusFailed byte = 1 // 鉴权失败，连接可以在超时之前重新发送鉴权消息
+)
+
+// 将普通函数适配为鉴权器（类似http.HandlerFunc）
+type AuthenticatorFunc func(conn tiface.IConnection, data []byte) error
+
+// 校验连接发送来的鉴权消息
+func (f AuthenticatorFunc) Authenticate(conn tiface.IConnection, data []byte) error {
+	return f(conn, data)
+}
+
+/*
+	基于HMAC-SHA256的Token鉴权器，主要用于测试和简单的内部服务
+	Token格式为 identity:expireUnix:hex(HMAC(secret, identity:expireUnix))
+*/
+type HMACAuthenticator struct {
+	// 签名使用的密钥
+	secret []byte
+}
+
+// 创建一个HMAC Token鉴权器
+func NewHMACAuthenticator(secret []byte) *HMACAuthenticator {
+	return &HMACAuthenticator{
+		secret: secret,
+	}
+}
+
+// 计算签名
+func (ha *HMACAuthenticator) sign(payload string) string {
+	mac := hmac.New(sha256.New, ha.secret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 为身份identity生成一个有效期为ttl的Token
+func (ha *HMACAuthenticator) GenerateToken(identity string, ttl time.Duration) string {
+	payload := identity + ":" + strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	return payload + ":" + ha.sign(payload)
+}
+
+// 校验Token，返回Token中的身份信息
+func (ha *HMACAuthenticator) VerifyToken(token string) (string, error) {
+	// identity中可能含有冒号，从后往前拆分出签名和过期时间
+	sep := strings.LastIndex(token, ":")
+	if sep < 0 {
+		return "", errors.New("malformed auth token")
+	}
+	payload, signature := token[:sep], token[sep+1:]
+	if !hmac.Equal([]byte(signature), []byte(ha.sign(payload))) {
+		return "", errors.New("invalid auth token signature")
+	}
+
+	sep = strings.LastIndex(payload, ":")
+	if sep < 0 {
+		return "", errors.New("malformed auth token")
+	}
+	expire, err := strconv.ParseInt(payload[sep+1:], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("malformed auth token expire time: %v", err)
+	}
+	if time.Now().Unix() > expire {
+		return "", errors.New("auth token expired")
+	}
+
+	return payload[:sep], nil
+}
+
+// 校验连接发送来的Token，通过后将身份信息设置到连接的identity属性中
+func (ha *HMACAuthenticator) Authenticate(conn tiface.IConnection, data []byte) error {
+	identity, err := ha.VerifyToken(string(data))
+	if err != nil {
+		return err
+	}
+	conn.SetProperty(AuthIdentityProperty, identity)
+	return nil
+}
@@ -0,0 +1,135 @@
+package tnet
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+type HelloRouter struct {
+	BaseRouter
+}
+
+// HelloRouter Handle
+func (router *HelloRouter) Handle(request tiface.IRequest) {
+	fmt.Println("Call HelloRouter Handle")
+	// 先读取并验证客户端的数据，再回复客户端
+	fmt.Println("recv from client : msgId=", request.GetMsgID(), ", data=", string(request.GetData()))
+
+	err := request.GetConnection().SendMsg(1, []byte("Hello Tigerkin"))
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// 从客户端连接中读取一个完整的消息
+func readTestMsg(t *testing.T, conn net.Conn) *Message {
+	dp := NewDataPack()
+	headData := make([]byte, dp.GetHeadLen())
+	_, err := io.ReadFull(conn, headData)
+	require.NoError(t, err)
+
+	msgHead, err := dp.Unpack(headData)
+	require.NoError(t, err)
+	msg := msgHead.(*Message)
+	msg.Data = make([]byte, msg.GetDataLen())
+	_, err = io.ReadFull(conn, msg.Data)
+	require.NoError(t, err)
+	return msg
+}
+
+// 客户端发送一个消息
+func writeTestMsg(t *testing.T, conn net.Conn, msgId uint32, data []byte) {
+	msg, err := NewDataPack().Pack(NewMsgPackage(msgId, data))
+	require.NoError(t, err)
+	_, err = conn.Write(msg)
+	require.NoError(t, err)
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	auth := NewHMACAuthenticator([]byte("secret"))
+
+	identity, err := auth.VerifyToken(auth.GenerateToken("user:1001", time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, "user:1001", identity)
+
+	// 过期的Token
+	_, err = auth.VerifyToken(auth.GenerateToken("user:1001", -time.Minute))
+	require.Error(t, err)
+
+	// 其他密钥签发的Token
+	_, err = auth.VerifyToken(NewHMACAuthenticator([]byte("other")).GenerateToken("user:1001", time.Minute))
+	require.Error(t, err)
+
+	// 格式错误的Token
+	_, err = auth.VerifyToken("user")
+	require.Error(t, err)
+}
+
+func TestConnectionAuth(t *testing.T) {
+	conf := *utils.GlobalObject
+	defer func() { *utils.GlobalObject = conf }()
+	utils.GlobalObject.WorkerPoolSize = 0
+	utils.GlobalObject.AuthTimeout = 1
+
+	auth := NewHMACAuthenticator([]byte("secret"))
+	s := NewServer()
+	s.SetAuthenticator(auth)
+	s.AddRouter(1, &HelloRouter{})
+
+	identity := make(chan interface{}, 1)
+	s.SetOnConnStart(func(conn tiface.IConnection) {
+		value, _ := conn.GetProperty(AuthIdentityProperty)
+		identity <- value
+	})
+
+	t.Run("handshake", func(t *testing.T) {
+		serverConn, client := newTCPPair(t)
+		defer client.Close()
+		c := NewConnection(s, serverConn, 200, s.(*Server).msgHandler)
+		go c.Start()
+		defer c.stopAndWait()
+
+		// 鉴权之前发送的业务消息被丢弃
+		writeTestMsg(t, client, 1, []byte("hello"))
+
+		// 错误的Token
+		writeTestMsg(t, client, utils.GlobalObject.AuthMsgId, []byte("bad token"))
+		reply := readTestMsg(t, client)
+		require.Equal(t, utils.GlobalObject.AuthMsgId, reply.Id)
+		require.Equal(t, AuthStatusFailed, reply.Data[0])
+		require.False(t, c.IsAuthenticated())
+
+		// 正确的Token
+		writeTestMsg(t, client, utils.GlobalObject.AuthMsgId, []byte(auth.GenerateToken("player-1", time.Minute)))
+		reply = readTestMsg(t, client)
+		require.Equal(t, []byte{AuthStatusOK}, reply.Data)
+		require.True(t, c.IsAuthenticated())
+		require.Equal(t, "player-1", <-identity)
+
+		// 鉴权通过之后消息正常分发
+		writeTestMsg(t, client, 1, []byte("hello"))
+		reply = readTestMsg(t, client)
+		require.Equal(t, "Hello Tigerkin", string(reply.Data))
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		serverConn, client := newTCPPair(t)
+		defer client.Close()
+		c := NewConnection(s, serverConn, 201, s.(*Server).msgHandler)
+		go c.Start()
+		defer c.stopAndWait()
+
+		// 超时未鉴权的连接被服务端关闭
+		client.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, err := client.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF)
+		require.True(t, c.closed())
+	})
+}
@@ -0,0 +1,347 @@
+package tnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+)
+
+// TCP消息总线上消息的msgId，节点之间使用单独的连接，不会与业务的msgId冲突
+const busMsgId uint32 = 1
+
+var ErrBusNoNode = errors.New("bus node not found")
+
+/*
+	进程内的消息总线，适用于同一个进程中的多个节点，消息在发送者的goroutine中同步交给接收者处理
+*/
+type MemoryBus struct {
+	// 节点名称 -> 接收消息的函数
+	handlers map[string]func(data []byte)
+	// 保护handlers
+	lock sync.RWMutex
+}
+
+// 创建进程内的消息总线
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{handlers: make(map[string]func(data []byte))}
+}
+
+// 接收发给节点的消息
+func (bus *MemoryBus) Subscribe(node string, handler func(data []byte)) error {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+
+	if _, ok := bus.handlers[node]; ok {
+		return fmt.Errorf("bus node %s already subscribed", node)
+	}
+	bus.handlers[node] = handler
+	return nil
+}
+
+// 停止接收发给节点的消息
+func (bus *MemoryBus) Unsubscribe(node string) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	delete(bus.handlers, node)
+}
+
+// 发送消息给节点，接收者得到的是数据的副本
+func (bus *MemoryBus) Send(node string, data []byte) error {
+	bus.lock.RLock()
+	handler, ok := bus.handlers[node]
+	bus.lock.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrBusNoNode, node)
+	}
+	handler(append([]byte(nil), data...))
+	return nil
+}
+
+// 获取全部节点的名称
+func (bus *MemoryBus) Nodes() []string {
+	bus.lock.RLock()
+	defer bus.lock.RUnlock()
+
+	nodes := make([]string, 0, len(bus.handlers))
+	for node := range bus.handlers {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+/*
+	基于TCP的消息总线，用于不同进程中的节点
+	节点订阅时在服务发现中注册为service服务的实例（ID为节点名称，地址为总线监听的地址），
+	发送时通过服务发现找到节点的地址；每个进程与每个节点之间保持一条连接，消息使用DataPack封包
+*/
+type TCPBus struct {
+	// 总线监听的地址
+	addr string
+	// 服务发现以及节点注册的服务名称
+	discovery tiface.IDiscovery
+	service   string
+
+	// 监听的Listener，第一个节点订阅时创建
+	listener net.Listener
+	// 本进程中的节点名称 -> 接收消息的函数
+	handlers map[string]func(data []byte)
+	// 节点名称 -> 发送消息的连接
+	peers map[string]*busPeer
+	// 其他进程连接过来的连接，关闭总线时关闭
+	accepted map[net.Conn]struct{}
+	// 保护以上字段
+	lock sync.Mutex
+}
+
+/*
+	发送消息给一个节点的连接
+*/
+type busPeer struct {
+	// 节点的地址
+	addr string
+	conn net.Conn
+	// 保证消息完整地写入连接
+	lock sync.Mutex
+}
+
+// 创建基于TCP的消息总线，addr为监听的地址，例如"10.0.0.2:9100"，需要是其他进程可以连接的地址
+func NewTCPBus(addr string, discovery tiface.IDiscovery, service string) *TCPBus {
+	return &TCPBus{
+		addr:      addr,
+		discovery: discovery,
+		service:   service,
+		handlers:  make(map[string]func(data []byte)),
+		peers:     make(map[string]*busPeer),
+		accepted:  make(map[net.Conn]struct{}),
+	}
+}
+
+// 接收发给节点的消息，并在服务发现中注册节点
+func (bus *TCPBus) Subscribe(node string, handler func(data []byte)) error {
+	if len(node) == 0 || len(node) > 255 {
+		return fmt.Errorf("invalid bus node name %q", node)
+	}
+
+	bus.lock.Lock()
+	if _, ok := bus.handlers[node]; ok {
+		bus.lock.Unlock()
+		return fmt.Errorf("bus node %s already subscribed", node)
+	}
+	if bus.listener == nil {
+		listener, err := net.Listen("tcp", bus.addr)
+		if err != nil {
+			bus.lock.Unlock()
+			return err
+		}
+		bus.listener = listener
+		go bus.accept(listener)
+	}
+	bus.handlers[node] = handler
+	addr := bus.listener.Addr().String()
+	bus.lock.Unlock()
+
+	err := bus.discovery.Register(tiface.ServiceInstance{ID: node, Name: bus.service, Addr: addr})
+	if err != nil {
+		bus.lock.Lock()
+		delete(bus.handlers, node)
+		bus.lock.Unlock()
+	}
+	return err
+}
+
+// 停止接收发给节点的消息，并在服务发现中注销节点
+func (bus *TCPBus) Unsubscribe(node string) {
+	bus.lock.Lock()
+	_, ok := bus.handlers[node]
+	delete(bus.handlers, node)
+	bus.lock.Unlock()
+
+	if ok {
+		if err := bus.discovery.Deregister(bus.service, node); err != nil {
+			fmt.Println("Bus deregister node ", node, " error: ", err)
+		}
+	}
+}
+
+// 发送消息给节点，本进程中的节点直接交给接收者处理
+func (bus *TCPBus) Send(node string, data []byte) error {
+	bus.lock.Lock()
+	handler, local := bus.handlers[node]
+	peer := bus.peers[node]
+	bus.lock.Unlock()
+
+	if local {
+		handler(append([]byte(nil), data...))
+		return nil
+	}
+	if peer == nil {
+		var err error
+		if peer, err = bus.dial(node); err != nil {
+			return err
+		}
+	}
+
+	frame := make([]byte, 1+len(node)+len(data))
+	frame[0] = byte(len(node))
+	copy(frame[1:], node)
+	copy(frame[1+len(node):], data)
+	binaryMsg, err := NewDataPack().Pack(NewMsgPackage(busMsgId, frame))
+	if err != nil {
+		return err
+	}
+
+	peer.lock.Lock()
+	_, err = peer.conn.Write(binaryMsg)
+	peer.lock.Unlock()
+	if err != nil {
+		// 连接断开，下次发送时重新查找节点的地址并连接
+		bus.lock.Lock()
+		if bus.peers[node] == peer {
+			delete(bus.peers, node)
+		}
+		bus.lock.Unlock()
+		peer.conn.Close()
+	}
+	return err
+}
+
+// 通过服务发现找到节点的地址并连接，同一个地址上的节点共用一条连接
+func (bus *TCPBus) dial(node string) (*busPeer, error) {
+	instances, err := bus.discovery.GetInstances(bus.service)
+	if err != nil {
+		return nil, err
+	}
+	addr := ""
+	for _, instance := range instances {
+		if instance.ID == node {
+			addr = instance.Addr
+		}
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("%w: %s", ErrBusNoNode, node)
+	}
+
+	bus.lock.Lock()
+	for _, peer := range bus.peers {
+		if peer.addr == addr {
+			bus.peers[node] = peer
+			bus.lock.Unlock()
+			return peer, nil
+		}
+	}
+	bus.lock.Unlock()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	// 并发发送时只保留一条连接
+	if peer, ok := bus.peers[node]; ok {
+		conn.Close()
+		return peer, nil
+	}
+	peer := &busPeer{addr: addr, conn: conn}
+	bus.peers[node] = peer
+	return peer, nil
+}
+
+// 接受其他进程的连接
+func (bus *TCPBus) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		bus.lock.Lock()
+		bus.accepted[conn] = struct{}{}
+		bus.lock.Unlock()
+		go bus.read(conn)
+	}
+}
+
+// 读取连接上的消息，交给目标节点的接收者处理，同一条连接上的消息按顺序处理
+func (bus *TCPBus) read(conn net.Conn) {
+	defer func() {
+		bus.lock.Lock()
+		delete(bus.accepted, conn)
+		bus.lock.Unlock()
+		conn.Close()
+	}()
+
+	dp := NewDataPack()
+	for {
+		msg, err := readFrame(conn, dp)
+		if err != nil {
+			return
+		}
+		if err := unpackData(dp, msg); err != nil {
+			fmt.Println("Bus unpack msg error: ", err)
+			return
+		}
+		frame := msg.GetData()
+		if msg.GetMsgId() != busMsgId || len(frame) == 0 || len(frame) < 1+int(frame[0]) {
+			fmt.Println("Bus received invalid msg from ", conn.RemoteAddr())
+			return
+		}
+		node := string(frame[1 : 1+frame[0]])
+
+		bus.lock.Lock()
+		handler, ok := bus.handlers[node]
+		bus.lock.Unlock()
+		if !ok {
+			fmt.Println("Bus received msg of unknown node ", node)
+			continue
+		}
+		handler(frame[1+frame[0]:])
+	}
+}
+
+// 获取服务发现中的全部节点
+func (bus *TCPBus) Nodes() []string {
+	instances, err := bus.discovery.GetInstances(bus.service)
+	if err != nil {
+		fmt.Println("Bus get nodes error: ", err)
+		return nil
+	}
+	nodes := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		nodes = append(nodes, instance.ID)
+	}
+	return nodes
+}
+
+// 注销本进程中的全部节点，关闭监听和全部连接
+func (bus *TCPBus) Close() {
+	bus.lock.Lock()
+	nodes := make([]string, 0, len(bus.handlers))
+	for node := range bus.handlers {
+		nodes = append(nodes, node)
+	}
+	bus.lock.Unlock()
+	for _, node := range nodes {
+		bus.Unsubscribe(node)
+	}
+
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	if bus.listener != nil {
+		bus.listener.Close()
+		bus.listener = nil
+	}
+	for _, peer := range bus.peers {
+		peer.conn.Close()
+	}
+	bus.peers = make(map[string]*busPeer)
+	for conn := range bus.accepted {
+		conn.Close()
+	}
+}
@@ -0,0 +1,578 @@
+package tnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+	Tigerkin客户端，使用与服务端一致的配置（FrameFlags、压缩、加密）和封包格式与服务端通信
+*/
+type Client struct {
+	// 服务端的IP地址
+	IP string
+	// 服务端的端口
+	Port int
+
+	// 与服务端的TCP连接
+	conn net.Conn
+	// 封包拆包模块，建立加密通道后被替换为SecureDataPack
+	dataPack tiface.IDataPack
+	// 发送消息时使用的压缩算法，为nil时不压缩
+	compressor tiface.ICompressor
+	// 保证发送消息的顺序与封包顺序一致
+	sendLock sync.Mutex
+	// 从连接中逐个读取并校验消息
+	frames *frameReader
+	// 大消息的分片重组
+	assembler fragmentAssembler
+
+	// 连接时向服务端提出的序列化方式，为空时不协商
+	codecs []string
+	// 本次连接使用的序列化方式，协商之后为服务端选中的序列化方式
+	codec tiface.ICodec
+	// 为msgId单独指定的序列化方式，需要与服务端一致
+	msgCodecs map[uint32]tiface.ICodec
+	// 协商序列化方式期间收到的其他消息，之后由ReadMsg返回
+	pending []tiface.IMessage
+	// 服务端颁发的会话令牌，重新连接之后用来恢复会话
+	sessionToken string
+	// 可靠消息的收发状态，恢复会话之后继续使用
+	reliable *reliableState
+
+	// 通过服务发现连接时使用的服务发现，为nil时直接连接IP和Port
+	discovery tiface.IDiscovery
+	// 连接的服务名称
+	service string
+	// 选择服务实例使用的key，同一个key总是选择同一个实例
+	balanceKey string
+	// 停止监听服务的实例列表
+	stopWatch context.CancelFunc
+}
+
+// 创建一个客户端
+func NewClient(ip string, port int) *Client {
+	c := &Client{
+		IP:        ip,
+		Port:      port,
+		dataPack:  NewDataPack(),
+		codec:     GetCodec(utils.GlobalObject.Codec),
+		msgCodecs: make(map[uint32]tiface.ICodec),
+		reliable:  newReliableState(),
+	}
+	if c.codec == nil {
+		c.codec = GetCodec(CodecProto)
+	}
+
+	if utils.GlobalObject.FrameFlags && utils.GlobalObject.Compressor != "" {
+		c.compressor = GetCompressor(utils.GlobalObject.Compressor)
+	}
+
+	return c
+}
+
+// 创建一个通过服务发现连接名称为service的服务的客户端
+// 每次Start时根据balanceKey从服务的实例中选择一个连接；连接期间实例列表变化，
+// 应当选择的实例不再是当前实例时（实例下线或者加入了更合适的实例）关闭连接，重新连接时连接到新的实例
+func NewServiceClient(discovery tiface.IDiscovery, service string) *Client {
+	c := NewClient("", 0)
+	c.discovery = discovery
+	c.service = service
+	c.balanceKey = randomHex(8)
+	return c
+}
+
+// 设置选择服务实例使用的key，例如玩家ID，使同一个玩家总是连接到同一个实例，需要在Start之前调用
+func (c *Client) SetBalanceKey(key string) {
+	c.balanceKey = key
+}
+
+// 从服务的实例中选择一个，设置需要连接的IP和Port
+func (c *Client) pickInstance() (tiface.ServiceInstance, error) {
+	instances, err := c.discovery.GetInstances(c.service)
+	if err != nil {
+		return tiface.ServiceInstance{}, err
+	}
+	instance, err := pickInstance(instances, c.balanceKey)
+	if err != nil {
+		return instance, fmt.Errorf("%w: %s", err, c.service)
+	}
+	host, portStr, err := net.SplitHostPort(instance.Addr)
+	if err != nil {
+		return instance, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return instance, err
+	}
+	c.IP, c.Port = host, port
+	return instance, nil
+}
+
+// 监听服务的实例列表，应当选择的实例不再是当前实例时关闭连接
+func (c *Client) watchService(ctx context.Context, conn net.Conn, current string) {
+	instances, err := c.discovery.Watch(ctx, c.service)
+	if err != nil {
+		fmt.Println("Watch service ", c.service, " error: ", err)
+		return
+	}
+	for list := range instances {
+		instance, err := pickInstance(list, c.balanceKey)
+		if err == nil && instance.ID == current {
+			continue
+		}
+		fmt.Println("Service ", c.service, " instance ", current, " is no longer selected, close connection")
+		conn.Close()
+		return
+	}
+}
+
+// 设置客户端的封包拆包模块，需要在Start之前调用
+func (c *Client) SetDataPack(dataPack tiface.IDataPack) {
+	c.dataPack = dataPack
+}
+
+// 设置连接时向服务端提出的序列化方式（按照优先级排列），需要在Start之前调用
+func (c *Client) SetCodecs(names ...string) {
+	c.codecs = names
+}
+
+// 为某个msgId单独指定序列化方式，需要与服务端一致
+func (c *Client) SetMsgCodec(msgId uint32, codec tiface.ICodec) {
+	c.msgCodecs[msgId] = codec
+}
+
+// 获取msgId使用的序列化方式
+func (c *Client) GetCodec(msgId uint32) tiface.ICodec {
+	if codec, ok := c.msgCodecs[msgId]; ok {
+		return codec
+	}
+	return c.codec
+}
+
+// 连接服务端，开启加密通道时完成密钥交换，设置了序列化方式时与服务端协商
+func (c *Client) Start() error {
+	c.reset()
+
+	var instance tiface.ServiceInstance
+	if c.discovery != nil {
+		// 重新连接时停止监听之前的实例
+		if c.stopWatch != nil {
+			c.stopWatch()
+		}
+		var err error
+		if instance, err = c.pickInstance(); err != nil {
+			return err
+		}
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(c.IP, strconv.Itoa(c.Port)))
+	if err != nil {
+		return err
+	}
+	if err := c.handshake(conn); err != nil {
+		return err
+	}
+
+	if c.discovery != nil {
+		var ctx context.Context
+		ctx, c.stopWatch = context.WithCancel(context.Background())
+		go c.watchService(ctx, conn, instance.ID)
+	}
+	return nil
+}
+
+// 使用已经建立的连接（例如net.Pipe的一端）启动客户端，之后与Start一样完成密钥交换和序列化方式的协商
+// Reconnect仍然根据IP和Port重新连接
+func (c *Client) StartConn(conn net.Conn) error {
+	c.reset()
+	return c.handshake(conn)
+}
+
+// 重置上一次连接的状态
+func (c *Client) reset() {
+	// 重新连接时使用明文的封包拆包模块重新进行密钥交换
+	if secure, ok := c.dataPack.(*SecureDataPack); ok {
+		c.dataPack = secure.inner
+	}
+	c.assembler = fragmentAssembler{}
+	// 没有可以恢复的会话时，服务端的可靠消息状态是新的
+	if c.sessionToken == "" {
+		c.reliable = newReliableState()
+	}
+}
+
+// 在新的连接上完成密钥交换和序列化方式的协商，失败时关闭连接
+func (c *Client) handshake(conn net.Conn) error {
+	c.conn = conn
+	c.frames = newFrameReader(conn)
+
+	if utils.GlobalObject.SecureChannel != "" {
+		if err := c.keyExchange(); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	if len(c.codecs) > 0 {
+		if err := c.negotiateCodec(); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// 与服务端协商序列化方式，期间收到的其他消息暂存起来
+func (c *Client) negotiateCodec() error {
+	if err := c.SendMsg(utils.GlobalObject.CodecMsgId, []byte(strings.Join(c.codecs, ","))); err != nil {
+		return err
+	}
+	msg, err := c.waitReply(utils.GlobalObject.CodecMsgId)
+	if err != nil {
+		return err
+	}
+
+	codec := GetCodec(string(msg.GetData()))
+	if codec == nil {
+		return fmt.Errorf("server accepts none of codecs %v", c.codecs)
+	}
+	c.codec = codec
+	return nil
+}
+
+/*
+	创建或者恢复会话，开启会话（SessionGracePeriod）时需要在Start之后（需要鉴权时在鉴权之后）调用
+	已经有会话令牌时先尝试恢复会话，会话已经过期时创建新的会话，resumed表示是否恢复了原来的会话
+*/
+func (c *Client) StartSession() (resumed bool, err error) {
+	if c.sessionToken != "" {
+		token, err := c.sessionOp(c.sessionToken)
+		if err == nil {
+			// 恢复会话之后重发尚未被服务端确认的可靠消息
+			c.sessionToken = token
+			return true, c.reliable.resend(c.sendEnvelope)
+		}
+		fmt.Println("resume session error: ", err)
+	}
+
+	token, err := c.sessionOp("")
+	if err != nil {
+		return false, err
+	}
+	c.sessionToken = token
+	c.reliable = newReliableState()
+	return false, nil
+}
+
+// 发送会话令牌（为空表示创建新的会话），返回服务端回复的会话令牌
+func (c *Client) sessionOp(token string) (string, error) {
+	if err := c.SendMsg(utils.GlobalObject.SessionMsgId, []byte(token)); err != nil {
+		return "", err
+	}
+	msg, err := c.waitReply(utils.GlobalObject.SessionMsgId)
+	if err != nil {
+		return "", err
+	}
+	if msg.GetDataLen() == 0 {
+		return "", errors.New("session error: empty reply")
+	}
+	if msg.GetData()[0] != AuthStatusOK {
+		return "", fmt.Errorf("session error: %s", msg.GetData()[1:])
+	}
+	return string(msg.GetData()[1:]), nil
+}
+
+// 获取服务端颁发的会话令牌，没有会话时为空
+func (c *Client) SessionToken() string {
+	return c.sessionToken
+}
+
+// 设置会话令牌，例如客户端进程重启之后恢复保存的会话
+func (c *Client) SetSessionToken(token string) {
+	c.sessionToken = token
+}
+
+// 断开当前连接并重新连接服务端，之后需要重新鉴权并调用StartSession恢复会话
+func (c *Client) Reconnect() error {
+	c.Stop()
+	return c.Start()
+}
+
+// 读取服务端对msgId的回复，期间收到的其他消息暂存起来，之后由ReadMsg返回
+func (c *Client) waitReply(msgId uint32) (tiface.IMessage, error) {
+	for {
+		msg, err := c.readMsg()
+		if err != nil {
+			return nil, err
+		}
+		if msg.GetMsgId() == msgId {
+			return msg, nil
+		}
+		c.pending = append(c.pending, msg)
+	}
+}
+
+// 订阅主题，等待服务端的回复
+func (c *Client) Subscribe(pattern string) error {
+	return c.topicOp(TopicOpSubscribe, pattern)
+}
+
+// 取消订阅主题，等待服务端的回复
+func (c *Client) Unsubscribe(pattern string) error {
+	return c.topicOp(TopicOpUnsubscribe, pattern)
+}
+
+func (c *Client) topicOp(op byte, pattern string) error {
+	if err := c.SendMsg(utils.GlobalObject.TopicMsgId, append([]byte{op}, pattern...)); err != nil {
+		return err
+	}
+	msg, err := c.waitReply(utils.GlobalObject.TopicMsgId)
+	if err != nil {
+		return err
+	}
+	if msg.GetDataLen() == 0 {
+		return fmt.Errorf("topic %q error: empty reply", pattern)
+	}
+	if msg.GetData()[0] != AuthStatusOK {
+		return fmt.Errorf("topic %q error: %s", pattern, msg.GetData()[1:])
+	}
+	return nil
+}
+
+// 与服务端进行密钥交换：读取服务端公钥和加密算法，发送本端公钥
+func (c *Client) keyExchange() error {
+	msg, _, err := c.frames.ReadFrame(c.dataPack)
+	if err != nil {
+		return err
+	}
+	if msg.GetMsgId() != utils.GlobalObject.KeyExchangeMsgId || msg.GetDataLen() < 32 {
+		return fmt.Errorf("expect key exchange msg, but got msgId = %d", msg.GetMsgId())
+	}
+	peerKey, cipherName := msg.GetData()[:32], string(msg.GetData()[32:])
+
+	kx, err := NewKeyExchange()
+	if err != nil {
+		return err
+	}
+	reply, err := c.dataPack.Pack(NewMsgPackage(utils.GlobalObject.KeyExchangeMsgId, kx.PublicKey()))
+	if err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(reply); err != nil {
+		return err
+	}
+
+	secure, err := kx.NewSecureDataPack(c.dataPack, peerKey, cipherName, false)
+	if err != nil {
+		return err
+	}
+	c.dataPack = secure
+	return nil
+}
+
+// 停止客户端，关闭与服务端的连接
+func (c *Client) Stop() {
+	if c.stopWatch != nil {
+		c.stopWatch()
+	}
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+// 获取与服务端的原始连接
+func (c *Client) Conn() net.Conn {
+	return c.conn
+}
+
+// 发送消息给服务端，数据达到压缩阈值时先进行压缩，超过MaxPacketSize时拆分为多个分片
+func (c *Client) SendMsg(msgId uint32, data []byte) error {
+	if c.conn == nil {
+		return errors.New("client not started")
+	}
+
+	msg := NewMsgPackage(msgId, data)
+	if err := CompressMsg(msg, c.compressor, utils.GlobalObject.CompressThreshold); err != nil {
+		return err
+	}
+
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
+
+	packed, err := packFragments(c.dataPack, msg, maxFrameDataLen(utils.GlobalObject.SecureChannel != ""))
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.Write(packed)
+	return err
+}
+
+// 将已经压缩、拆分好的消息原样封包发送，不再进行压缩和拆分，用于回放录制的消息
+func (c *Client) sendFrame(msg tiface.IMessage) error {
+	if c.conn == nil {
+		return errors.New("client not started")
+	}
+
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
+
+	packed, err := c.dataPack.Pack(msg)
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.Write(packed)
+	return err
+}
+
+// 发送字符串路由消息，msgId为路由名称映射的RouteID(name)
+func (c *Client) SendRoute(name string, data []byte) error {
+	return c.SendMsg(RouteID(name), data)
+}
+
+// 带序号发送消息，消息保留到服务端确认为止，恢复会话之后重发，服务端按照序号去重，返回消息的序号
+func (c *Client) SendReliable(msgId uint32, data []byte) (uint64, error) {
+	return c.reliable.send(msgId, data, c.sendEnvelope)
+}
+
+// 获取被服务端确认的最大序号，序号小于等于它的可靠消息都已经送达
+func (c *Client) GetAckedSeq() uint64 {
+	return c.reliable.acked()
+}
+
+// 发送可靠消息或者确认消息
+func (c *Client) sendEnvelope(envelope []byte) error {
+	return c.SendMsg(utils.GlobalObject.ReliableMsgId, envelope)
+}
+
+// 将proto消息序列化之后发送给服务端
+func (c *Client) SendProto(msgId uint32, msg proto.Message) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.SendMsg(msgId, data)
+}
+
+// 使用msgId对应的序列化方式将v序列化之后发送给服务端
+func (c *Client) SendValue(msgId uint32, v interface{}) error {
+	data, err := c.GetCodec(msgId).Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.SendMsg(msgId, data)
+}
+
+// 阻塞读取服务端发来的一个消息，返回解密、重组、解压之后的原始数据
+func (c *Client) ReadMsg() (tiface.IMessage, error) {
+	if c.conn == nil {
+		return nil, errors.New("client not started")
+	}
+	if len(c.pending) > 0 {
+		msg := c.pending[0]
+		c.pending = c.pending[1:]
+		return msg, nil
+	}
+	return c.readMsg()
+}
+
+// 阻塞读取服务端发来的一个消息，将其数据使用msgId对应的序列化方式反序列化到v中
+func (c *Client) ReadValue(v interface{}) (tiface.IMessage, error) {
+	msg, err := c.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.GetCodec(msg.GetMsgId()).Unmarshal(msg.GetData(), v); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+// 从连接中读取一个消息，可靠消息回复确认之后返回原始消息，重复的消息和确认消息不返回
+func (c *Client) readMsg() (tiface.IMessage, error) {
+	for {
+		msg, err := c.readWholeMsg()
+		if err != nil {
+			return nil, err
+		}
+		if msg.GetMsgId() != utils.GlobalObject.ReliableMsgId {
+			return msg, nil
+		}
+		if msg, err = c.handleReliable(msg); msg != nil || err != nil {
+			return msg, err
+		}
+	}
+}
+
+// 从连接中读取一个完整的消息（重组、解压之后）
+func (c *Client) readWholeMsg() (tiface.IMessage, error) {
+	var msg tiface.IMessage
+	for msg == nil {
+		frame, dropped, err := c.frames.ReadFrame(c.dataPack)
+		if err != nil {
+			return nil, err
+		}
+		if dropped {
+			// 丢弃过损坏的消息，正在重组的大消息已经不完整
+			msg = nil
+			c.assembler.discard()
+		}
+		if err := unpackData(c.dataPack, frame); err != nil {
+			return nil, err
+		}
+		if frame.GetFlags()&FlagFragment == 0 {
+			c.assembler.discarding = false
+			msg = frame
+		} else if c.assembler.skip(frame) {
+			continue
+		} else if msg, err = c.assembler.add(frame); err != nil {
+			return nil, err
+		}
+	}
+	if err := DecompressMsg(msg, utils.GlobalObject.MaxMsgSize); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// 从连接中读取一个消息，只进行解密，不进行重组和解压，用于与录制的消息比较
+func (c *Client) readFrame() (tiface.IMessage, error) {
+	msg, _, err := c.frames.ReadFrame(c.dataPack)
+	if err != nil {
+		return nil, err
+	}
+	if err := unpackData(c.dataPack, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// 处理服务端发来的可靠消息和确认消息，回复确认之后返回原始消息，重复的消息和确认消息返回nil
+func (c *Client) handleReliable(msg tiface.IMessage) (tiface.IMessage, error) {
+	kind, seq, msgId, data, err := unpackReliable(msg.GetData())
+	if err != nil {
+		return nil, err
+	}
+	if kind == ReliableAck {
+		c.reliable.ack(seq)
+		return nil, nil
+	}
+
+	isNew, ackSeq := c.reliable.receive(seq)
+	if err := c.sendEnvelope(packAck(ackSeq)); err != nil {
+		return nil, err
+	}
+	if !isNew {
+		return nil, nil
+	}
+	return NewMsgPackage(msgId, data), nil
+}
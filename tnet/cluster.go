@@ -0,0 +1,288 @@
+package tnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+)
+
+// 集群节点之间的消息类型，位于总线消息的第一个字节
+const (
+	ClusterSendConn byte = 1 // 给接收者节点上的连接发送消息
+	ClusterSendUser byte = 2 // 给接收者节点上的用户发送消息
+	ClusterBind     byte = 3 // 用户绑定到了发送者节点上
+	ClusterUnbind   byte = 4 // 用户不在发送者节点上
+	ClusterSync     byte = 5 // 请求接收者发送其上全部用户的ClusterBind
+	ClusterLeave    byte = 6 // 发送者节点停止，其上的用户全部下线
+)
+
+var ErrClusterUserNotFound = errors.New("cluster user not found")
+
+/*
+	集群节点之间的消息
+	格式：类型(1字节) + 发送者节点名称长度(1字节) + 发送者节点名称 + 连接ID(uint32) + msgId(uint32) + 用户ID长度(uint16) + 用户ID + 数据
+*/
+type clusterMsg struct {
+	kind   byte
+	from   string
+	connID uint32
+	msgId  uint32
+	user   string
+	data   []byte
+}
+
+// 封装集群节点之间的消息
+func packCluster(msg clusterMsg) []byte {
+	envelope := make([]byte, 0, 12+len(msg.from)+len(msg.user)+len(msg.data))
+	envelope = append(envelope, msg.kind, byte(len(msg.from)))
+	envelope = append(envelope, msg.from...)
+	envelope = binary.LittleEndian.AppendUint32(envelope, msg.connID)
+	envelope = binary.LittleEndian.AppendUint32(envelope, msg.msgId)
+	envelope = binary.LittleEndian.AppendUint16(envelope, uint16(len(msg.user)))
+	envelope = append(envelope, msg.user...)
+	return append(envelope, msg.data...)
+}
+
+// 解析集群节点之间的消息
+func unpackCluster(envelope []byte) (clusterMsg, error) {
+	var msg clusterMsg
+	if len(envelope) < 2 || len(envelope) < 12+int(envelope[1]) {
+		return msg, errors.New("too short cluster msg")
+	}
+	msg.kind = envelope[0]
+	if msg.kind < ClusterSendConn || msg.kind > ClusterLeave {
+		return msg, fmt.Errorf("unknown cluster msg kind = %d", msg.kind)
+	}
+	n := 2 + int(envelope[1])
+	msg.from = string(envelope[2:n])
+	msg.connID = binary.LittleEndian.Uint32(envelope[n:])
+	msg.msgId = binary.LittleEndian.Uint32(envelope[n+4:])
+	userLen := int(binary.LittleEndian.Uint16(envelope[n+8:]))
+	n += 10
+	if len(envelope) < n+userLen {
+		return msg, errors.New("too short cluster msg")
+	}
+	msg.user = string(envelope[n : n+userLen])
+	msg.data = envelope[n+userLen:]
+	return msg, nil
+}
+
+/*
+	集群中的一个节点
+	每个节点记录全部用户所在的节点：用户绑定、解除绑定时通知其他节点，节点启动时向其他节点同步，
+	给其他节点上的用户发送的消息通过消息总线转发，由用户所在的节点发送给用户的连接
+*/
+type Cluster struct {
+	// 本节点的名称
+	node string
+	// 本节点的Server
+	server tiface.IServer
+	// 节点之间的消息总线
+	bus tiface.IBus
+
+	// 用户ID -> 用户所在的节点
+	users map[string]string
+	// 用户ID -> 本节点上用户的连接
+	local map[string]tiface.IConnection
+	// 保护users和local
+	lock sync.RWMutex
+}
+
+// 创建集群中名称为node的节点，节点名称在集群中唯一，不超过255字节
+func NewCluster(node string, server tiface.IServer, bus tiface.IBus) *Cluster {
+	return &Cluster{
+		node:   node,
+		server: server,
+		bus:    bus,
+		users:  make(map[string]string),
+		local:  make(map[string]tiface.IConnection),
+	}
+}
+
+// 获取本节点的名称
+func (c *Cluster) GetNodeName() string {
+	return c.node
+}
+
+// 订阅消息总线，从其他节点同步用户所在的节点
+func (c *Cluster) Start() error {
+	if err := c.bus.Subscribe(c.node, c.handle); err != nil {
+		return err
+	}
+	c.broadcast(clusterMsg{kind: ClusterSync})
+	return nil
+}
+
+// 停止订阅，通知其他节点本节点的用户全部下线
+func (c *Cluster) Stop() {
+	c.bus.Unsubscribe(c.node)
+	c.broadcast(clusterMsg{kind: ClusterLeave})
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.users = make(map[string]string)
+	c.local = make(map[string]tiface.IConnection)
+}
+
+// 将用户绑定到本节点的连接上，连接停止时自动解除；用户在其他节点上的绑定被替换
+func (c *Cluster) BindUser(userID string, conn tiface.IConnection) {
+	c.lock.Lock()
+	c.users[userID] = c.node
+	c.local[userID] = conn
+	c.lock.Unlock()
+
+	c.broadcast(clusterMsg{kind: ClusterBind, user: userID})
+
+	go func() {
+		<-conn.Context().Done()
+		c.unbind(userID, conn)
+	}()
+}
+
+// 解除用户与本节点连接的绑定
+func (c *Cluster) UnbindUser(userID string) {
+	c.unbind(userID, nil)
+}
+
+// 解除用户与本节点连接的绑定，conn不为nil时只在用户仍然绑定在conn上时解除
+func (c *Cluster) unbind(userID string, conn tiface.IConnection) {
+	c.lock.Lock()
+	current, ok := c.local[userID]
+	if !ok || (conn != nil && current != conn) {
+		c.lock.Unlock()
+		return
+	}
+	delete(c.local, userID)
+	delete(c.users, userID)
+	c.lock.Unlock()
+
+	c.broadcast(clusterMsg{kind: ClusterUnbind, user: userID})
+}
+
+// 获取用户所在的节点
+func (c *Cluster) GetUserNode(userID string) (string, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	node, ok := c.users[userID]
+	return node, ok
+}
+
+// 给节点上的连接发送消息
+func (c *Cluster) SendTo(node string, connID uint32, msgId uint32, data []byte) error {
+	if node == c.node {
+		conn, err := c.server.GetConnMgr().Get(connID)
+		if err != nil {
+			return err
+		}
+		return conn.SendBuffMsg(msgId, data)
+	}
+	return c.send(node, clusterMsg{kind: ClusterSendConn, connID: connID, msgId: msgId, data: data})
+}
+
+// 给用户发送消息，用户在其他节点上时由该节点发送给用户的连接
+func (c *Cluster) SendToUser(userID string, msgId uint32, data []byte) error {
+	c.lock.RLock()
+	conn, local := c.local[userID]
+	node, ok := c.users[userID]
+	c.lock.RUnlock()
+
+	if local {
+		return conn.SendBuffMsg(msgId, data)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrClusterUserNotFound, userID)
+	}
+	return c.send(node, clusterMsg{kind: ClusterSendUser, user: userID, msgId: msgId, data: data})
+}
+
+// 通过消息总线发送消息给节点，节点已经不存在时移除其上的用户
+func (c *Cluster) send(node string, msg clusterMsg) error {
+	msg.from = c.node
+	err := c.bus.Send(node, packCluster(msg))
+	if errors.Is(err, ErrBusNoNode) {
+		c.removeNode(node)
+	}
+	return err
+}
+
+// 发送消息给除本节点之外的全部节点
+func (c *Cluster) broadcast(msg clusterMsg) {
+	for _, node := range c.bus.Nodes() {
+		if node == c.node {
+			continue
+		}
+		if err := c.send(node, msg); err != nil {
+			fmt.Println("Cluster node ", c.node, " send to node ", node, " error: ", err)
+		}
+	}
+}
+
+// 移除节点上的全部用户
+func (c *Cluster) removeNode(node string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for userID, userNode := range c.users {
+		if userNode == node {
+			delete(c.users, userID)
+		}
+	}
+}
+
+// 处理其他节点发来的消息
+func (c *Cluster) handle(data []byte) {
+	msg, err := unpackCluster(data)
+	if err != nil {
+		fmt.Println("Cluster node ", c.node, " received invalid msg: ", err)
+		return
+	}
+
+	switch msg.kind {
+	case ClusterSendConn:
+		if err := c.SendTo(c.node, msg.connID, msg.msgId, msg.data); err != nil {
+			fmt.Println("Cluster node ", c.node, " send to ConnID = ", msg.connID, " error: ", err)
+		}
+	case ClusterSendUser:
+		c.lock.RLock()
+		conn, ok := c.local[msg.user]
+		c.lock.RUnlock()
+		if !ok {
+			// 用户已经不在本节点上，通知发送者更新用户所在的节点
+			fmt.Println("Cluster node ", c.node, " received msg of absent user ", msg.user)
+			c.send(msg.from, clusterMsg{kind: ClusterUnbind, user: msg.user})
+			return
+		}
+		if err := conn.SendBuffMsg(msg.msgId, msg.data); err != nil {
+			fmt.Println("Cluster node ", c.node, " send to user ", msg.user, " error: ", err)
+		}
+	case ClusterBind:
+		c.lock.Lock()
+		c.users[msg.user] = msg.from
+		// 用户在其他节点上重新登录，本节点上的连接不再接收发给该用户的消息
+		delete(c.local, msg.user)
+		c.lock.Unlock()
+	case ClusterUnbind:
+		c.lock.Lock()
+		if c.users[msg.user] == msg.from {
+			delete(c.users, msg.user)
+		}
+		c.lock.Unlock()
+	case ClusterSync:
+		c.lock.RLock()
+		users := make([]string, 0, len(c.local))
+		for userID := range c.local {
+			users = append(users, userID)
+		}
+		c.lock.RUnlock()
+		for _, userID := range users {
+			if err := c.send(msg.from, clusterMsg{kind: ClusterBind, user: userID}); err != nil {
+				fmt.Println("Cluster node ", c.node, " sync to node ", msg.from, " error: ", err)
+				return
+			}
+		}
+	case ClusterLeave:
+		c.removeNode(msg.from)
+	}
+}
@@ -0,0 +1,175 @@
+package tnet
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// 启动客户端并等待Server上对应的连接建立
+func startClientConn(t *testing.T, s tiface.IServer, connID uint32) (*Client, tiface.IConnection) {
+	client := newTestClient(t, s, connID)
+	require.NoError(t, client.Start())
+	var conn tiface.IConnection
+	require.Eventually(t, func() bool {
+		var err error
+		conn, err = s.GetConnMgr().Get(connID)
+		return err == nil
+	}, 3*time.Second, 5*time.Millisecond)
+	return client, conn
+}
+
+// 读取客户端收到的下一个消息
+func readClientMsg(t *testing.T, client *Client) (uint32, string) {
+	msg, err := client.ReadMsg()
+	require.NoError(t, err)
+	return msg.GetMsgId(), string(msg.GetData())
+}
+
+// 用户所在的节点，用户不存在时为空
+func userNode(cluster *Cluster, userID string) string {
+	node, _ := cluster.GetUserNode(userID)
+	return node
+}
+
+func TestCluster(t *testing.T) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 0
+
+	bus := NewMemoryBus()
+	serverA, serverB, serverC := NewServer(), NewServer(), NewServer()
+	a, b := NewCluster("a", serverA, bus), NewCluster("b", serverB, bus)
+	require.NoError(t, a.Start())
+	require.NoError(t, b.Start())
+	require.Error(t, NewCluster("a", serverA, bus).Start())
+
+	alice, aliceConn := startClientConn(t, serverA, 580)
+	bob, bobConn := startClientConn(t, serverB, 585)
+	a.BindUser("alice", aliceConn)
+	b.BindUser("bob", bobConn)
+	require.Equal(t, "b", userNode(a, "bob"))
+	require.Equal(t, "a", userNode(b, "alice"))
+
+	// 给其他节点上的用户和连接发送消息
+	require.NoError(t, a.SendToUser("bob", 2, []byte("hi bob")))
+	msgId, data := readClientMsg(t, bob)
+	require.Equal(t, uint32(2), msgId)
+	require.Equal(t, "hi bob", data)
+	require.NoError(t, b.SendToUser("alice", 3, []byte("hi alice")))
+	msgId, data = readClientMsg(t, alice)
+	require.Equal(t, uint32(3), msgId)
+	require.Equal(t, "hi alice", data)
+	require.NoError(t, a.SendTo("b", 585, 4, []byte("by conn")))
+	_, data = readClientMsg(t, bob)
+	require.Equal(t, "by conn", data)
+	require.NoError(t, a.SendToUser("alice", 5, []byte("local")))
+	_, data = readClientMsg(t, alice)
+	require.Equal(t, "local", data)
+	require.ErrorIs(t, a.SendToUser("carol", 1, nil), ErrClusterUserNotFound)
+
+	// 后启动的节点同步已有的用户
+	c := NewCluster("c", serverC, bus)
+	require.NoError(t, c.Start())
+	require.Equal(t, "a", userNode(c, "alice"))
+	require.Equal(t, "b", userNode(c, "bob"))
+
+	// 用户在其他节点上重新登录，发给用户的消息发送到新的连接
+	alice2, alice2Conn := startClientConn(t, serverC, 590)
+	c.BindUser("alice", alice2Conn)
+	require.Equal(t, "c", userNode(a, "alice"))
+	require.NoError(t, a.SendToUser("alice", 6, []byte("moved")))
+	_, data = readClientMsg(t, alice2)
+	require.Equal(t, "moved", data)
+
+	// 用户断开连接之后其他节点上的记录被删除
+	bob.Stop()
+	require.Eventually(t, func() bool {
+		return userNode(a, "bob") == "" && userNode(c, "bob") == ""
+	}, 3*time.Second, 5*time.Millisecond)
+
+	// 节点停止之后其上的用户下线
+	c.Stop()
+	require.Equal(t, "", userNode(a, "alice"))
+	require.ErrorIs(t, b.SendToUser("alice", 1, nil), ErrClusterUserNotFound)
+	require.ErrorIs(t, a.SendTo("c", 590, 1, nil), ErrBusNoNode)
+}
+
+func TestTCPBus(t *testing.T) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 0
+
+	d := NewMemoryDiscovery()
+	bus1 := NewTCPBus("127.0.0.1:0", d, "cluster")
+	bus2 := NewTCPBus("127.0.0.1:0", d, "cluster")
+	defer bus1.Close()
+	defer bus2.Close()
+
+	received := make(chan string, 200)
+	receive := func(node string) func(data []byte) {
+		return func(data []byte) { received <- node + ":" + string(data) }
+	}
+	require.NoError(t, bus1.Subscribe("a", receive("a")))
+	require.NoError(t, bus2.Subscribe("b", receive("b")))
+	require.NoError(t, bus2.Subscribe("c", receive("c")))
+	require.Error(t, bus1.Subscribe("a", receive("a")))
+	require.Equal(t, []string{"a", "b", "c"}, bus1.Nodes())
+
+	// 发给同一个节点的消息按顺序到达
+	for i := 0; i < 100; i++ {
+		require.NoError(t, bus1.Send("b", []byte(fmt.Sprint(i))))
+	}
+	for i := 0; i < 100; i++ {
+		require.Equal(t, fmt.Sprintf("b:%d", i), <-received)
+	}
+	for _, c := range []struct {
+		bus  *TCPBus
+		node string
+	}{{bus1, "c"}, {bus2, "a"}, {bus1, "a"}} {
+		require.NoError(t, c.bus.Send(c.node, []byte("hello")))
+		require.Equal(t, c.node+":hello", <-received)
+	}
+	require.ErrorIs(t, bus1.Send("x", nil), ErrBusNoNode)
+
+	// 节点换到新的地址之后重新连接
+	bus2.Close()
+	require.Equal(t, []string{"a"}, bus1.Nodes())
+	bus3 := NewTCPBus("127.0.0.1:0", d, "cluster")
+	defer bus3.Close()
+	require.NoError(t, bus3.Subscribe("b", receive("b")))
+	require.Eventually(t, func() bool {
+		if bus1.Send("b", []byte("again")) != nil {
+			return false
+		}
+		// 写入旧连接的消息会丢失
+		select {
+		case msg := <-received:
+			return msg == "b:again"
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}, 3*time.Second, 5*time.Millisecond)
+
+	// 集群通过TCP消息总线给其他进程中的用户发送消息
+	serverX, serverY := NewServer(), NewServer()
+	x := NewCluster("x", serverX, bus1)
+	y := NewCluster("y", serverY, bus3)
+	require.NoError(t, x.Start())
+	require.NoError(t, y.Start())
+	defer x.Stop()
+	defer y.Stop()
+	client, conn := startClientConn(t, serverY, 595)
+	y.BindUser("dave", conn)
+	require.Eventually(t, func() bool {
+		return userNode(x, "dave") == "y"
+	}, 3*time.Second, 5*time.Millisecond)
+	require.NoError(t, x.SendToUser("dave", 7, []byte("over tcp")))
+	msgId, data := readClientMsg(t, client)
+	require.Equal(t, uint32(7), msgId)
+	require.Equal(t, "over tcp", data)
+}
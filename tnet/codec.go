@@ -0,0 +1,137 @@
+package tnet
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+// 内置序列化方式的名称
+const (
+	CodecJSON  = "json"
+	CodecProto = "proto"
+	CodecGob   = "gob"
+)
+
+var (
+	// 已注册的序列化方式，按照名称索引
+	codecs = make(map[string]tiface.ICodec)
+	// 保护序列化方式注册表的读写锁
+	codecLock sync.RWMutex
+)
+
+func init() {
+	RegisterCodec(&JSONCodec{})
+	RegisterCodec(&ProtoCodec{})
+	RegisterCodec(&GobCodec{})
+}
+
+// 注册一个序列化方式，名称重复时panic
+func RegisterCodec(c tiface.ICodec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+
+	if c.Name() == "" || strings.Contains(c.Name(), ",") {
+		panic("invalid codec name = " + c.Name())
+	}
+	if _, ok := codecs[c.Name()]; ok {
+		panic("repeated codec, name = " + c.Name())
+	}
+	codecs[c.Name()] = c
+}
+
+// 根据名称获取序列化方式，不存在时返回nil
+func GetCodec(name string) tiface.ICodec {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	return codecs[name]
+}
+
+// 从客户端提出的序列化方式列表（按照优先级用逗号分隔）中选择第一个Server支持的
+// 配置了Codecs时只能选择其中的序列化方式，否则可以选择任意已注册的序列化方式
+func chooseCodec(names string) tiface.ICodec {
+	for _, name := range strings.Split(names, ",") {
+		codec := GetCodec(name)
+		if codec == nil {
+			continue
+		}
+		if len(utils.GlobalObject.Codecs) == 0 {
+			return codec
+		}
+		for _, allowed := range utils.GlobalObject.Codecs {
+			if allowed == name {
+				return codec
+			}
+		}
+	}
+	return nil
+}
+
+// 使用了某个序列化方式的连接（atomic.Value中保存的类型必须一致）
+type codecChoice struct {
+	codec tiface.ICodec
+}
+
+// JSON序列化
+type JSONCodec struct{}
+
+func (c *JSONCodec) Name() string {
+	return CodecJSON
+}
+
+func (c *JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (c *JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// protobuf序列化，v必须实现proto.Message
+type ProtoCodec struct{}
+
+func (c *ProtoCodec) Name() string {
+	return CodecProto
+}
+
+func (c *ProtoCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a proto.Message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (c *ProtoCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+// encoding/gob序列化，每个消息单独编码，包含完整的类型信息
+type GobCodec struct{}
+
+func (c *GobCodec) Name() string {
+	return CodecGob
+}
+
+func (c *GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
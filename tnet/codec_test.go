@@ -0,0 +1,93 @@
+package tnet
+
+import (
+	"context"
+	"testing"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type loginReq struct {
+	Name  string
+	Level int
+}
+
+type loginResp struct {
+	Greeting string
+}
+
+func TestCodecs(t *testing.T) {
+	for _, name := range []string{CodecJSON, CodecGob} {
+		codec := GetCodec(name)
+		data, err := codec.Marshal(&loginReq{Name: "player", Level: 3})
+		require.NoError(t, err)
+		req := &loginReq{}
+		require.NoError(t, codec.Unmarshal(data, req))
+		require.Equal(t, loginReq{Name: "player", Level: 3}, *req)
+	}
+
+	codec := GetCodec(CodecProto)
+	data, err := codec.Marshal(wrapperspb.String("player"))
+	require.NoError(t, err)
+	msg := &wrapperspb.StringValue{}
+	require.NoError(t, codec.Unmarshal(data, msg))
+	require.Equal(t, "player", msg.Value)
+	_, err = codec.Marshal(&loginReq{})
+	require.Error(t, err)
+
+	require.Panics(t, func() { RegisterCodec(&JSONCodec{}) })
+}
+
+func TestCodecNegotiation(t *testing.T) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 0
+
+	s := NewServer()
+	s.SetMsgCodec(6, GetCodec(CodecGob))
+	s.SetOnConnStart(func(conn tiface.IConnection) {
+		conn.SendMsg(1, []byte("welcome"))
+	})
+	login := func(ctx context.Context, request tiface.IRequest, msg *loginReq) error {
+		return request.GetConnection().SendValue(request.GetMsgID(), &loginResp{Greeting: "hello " + msg.Name})
+	}
+	AddHandler(s, 5, login)
+	AddHandler(s, 6, login)
+
+	client := newTestClient(t, s, 440)
+	client.SetCodecs("yaml", CodecJSON)
+	client.SetMsgCodec(6, GetCodec(CodecGob))
+	require.NoError(t, client.Start())
+	require.Equal(t, CodecJSON, client.GetCodec(5).Name())
+
+	// 协商期间收到的消息不会丢失
+	msg, err := client.ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, "welcome", string(msg.GetData()))
+
+	// msgId 6单独指定了gob，不受协商结果影响
+	for _, msgId := range []uint32{5, 6} {
+		require.NoError(t, client.SendValue(msgId, &loginReq{Name: "player"}))
+		resp := &loginResp{}
+		msg, err = client.ReadValue(resp)
+		require.NoError(t, err)
+		require.Equal(t, msgId, msg.GetMsgId())
+		require.Equal(t, "hello player", resp.Greeting)
+	}
+
+}
+
+func TestCodecNegotiationRejected(t *testing.T) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 0
+	utils.GlobalObject.Codecs = []string{CodecProto, CodecJSON}
+
+	s := NewServer()
+	client := newTestClient(t, s, 441)
+	client.SetCodecs(CodecGob)
+	require.Error(t, client.Start())
+}
@@ -0,0 +1,197 @@
+package tnet
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+)
+
+// 消息标志位中压缩算法ID所占的位
+const FlagCompressMask byte = 0x0F
+
+// 内置压缩算法的ID
+const (
+	CompressorGzip    byte = 1
+	CompressorDeflate byte = 2
+)
+
+var (
+	// 已注册的压缩算法，分别按照ID和名称索引
+	compressorsById   = make(map[byte]tiface.ICompressor)
+	compressorsByName = make(map[string]tiface.ICompressor)
+	// 保护压缩算法注册表的读写锁
+	compressorLock sync.RWMutex
+)
+
+func init() {
+	RegisterCompressor(&GzipCompressor{})
+	RegisterCompressor(&DeflateCompressor{})
+}
+
+// 注册一个压缩算法，ID或名称重复时panic
+func RegisterCompressor(c tiface.ICompressor) {
+	compressorLock.Lock()
+	defer compressorLock.Unlock()
+
+	if c.ID() == 0 || c.ID() > FlagCompressMask {
+		panic(fmt.Sprintf("invalid compressor id = %d, must be in 1-15", c.ID()))
+	}
+	if _, ok := compressorsById[c.ID()]; ok {
+		panic(fmt.Sprintf("repeated compressor, id = %d", c.ID()))
+	}
+	if _, ok := compressorsByName[c.Name()]; ok {
+		panic("repeated compressor, name = " + c.Name())
+	}
+	compressorsById[c.ID()] = c
+	compressorsByName[c.Name()] = c
+}
+
+// 根据名称获取压缩算法，不存在时返回nil
+func GetCompressor(name string) tiface.ICompressor {
+	compressorLock.RLock()
+	defer compressorLock.RUnlock()
+	return compressorsByName[name]
+}
+
+// 根据ID获取压缩算法，不存在时返回nil
+func GetCompressorById(id byte) tiface.ICompressor {
+	compressorLock.RLock()
+	defer compressorLock.RUnlock()
+	return compressorsById[id]
+}
+
+// 如果消息数据长度达到threshold，使用compressor压缩消息数据，并在flags中记录压缩算法
+// 压缩后数据没有变小时保持原样发送
+func CompressMsg(msg tiface.IMessage, compressor tiface.ICompressor, threshold uint32) error {
+	if compressor == nil || msg.GetDataLen() == 0 || msg.GetDataLen() < threshold {
+		return nil
+	}
+
+	data, err := compressor.Compress(msg.GetData())
+	if err != nil {
+		return err
+	}
+	if len(data) >= len(msg.GetData()) {
+		return nil
+	}
+
+	msg.SetData(data)
+	msg.SetDataLen(uint32(len(data)))
+	msg.SetFlags(msg.GetFlags()&^FlagCompressMask | compressor.ID())
+	return nil
+}
+
+// 根据flags中记录的压缩算法解压消息数据，解压后的数据长度不能超过maxLen（0表示不限制）
+func DecompressMsg(msg tiface.IMessage, maxLen uint32) error {
+	id := msg.GetFlags() & FlagCompressMask
+	if id == 0 {
+		return nil
+	}
+
+	compressor := GetCompressorById(id)
+	if compressor == nil {
+		return fmt.Errorf("unknown compressor id = %d", id)
+	}
+	data, err := compressor.Decompress(msg.GetData(), maxLen)
+	if err != nil {
+		return err
+	}
+
+	msg.SetData(data)
+	msg.SetDataLen(uint32(len(data)))
+	msg.SetFlags(msg.GetFlags() &^ FlagCompressMask)
+	return nil
+}
+
+// 从r中读取全部解压后的数据，超过maxLen时返回错误
+func readAllLimited(r io.Reader, maxLen uint32) ([]byte, error) {
+	if maxLen == 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, int64(maxLen)+1))
+	if err != nil {
+		return nil, err
+	}
+	if uint32(len(data)) > maxLen {
+		return nil, errors.New("too large msg data after decompress")
+	}
+	return data, nil
+}
+
+// gzip压缩算法
+type GzipCompressor struct{}
+
+func (c *GzipCompressor) ID() byte {
+	return CompressorGzip
+}
+
+func (c *GzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (c *GzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *GzipCompressor) Decompress(data []byte, maxLen uint32) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readAllLimited(r, maxLen)
+}
+
+func (c *GzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// deflate压缩算法
+type DeflateCompressor struct{}
+
+func (c *DeflateCompressor) ID() byte {
+	return CompressorDeflate
+}
+
+func (c *DeflateCompressor) Name() string {
+	return "deflate"
+}
+
+func (c *DeflateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *DeflateCompressor) Decompress(data []byte, maxLen uint32) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return readAllLimited(r, maxLen)
+}
+
+func (c *DeflateCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
@@ -0,0 +1,92 @@
+package tnet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// 原样返回客户端数据的路由
+type EchoRouter struct {
+	BaseRouter
+}
+
+func (router *EchoRouter) Handle(request tiface.IRequest) {
+	if err := request.GetConnection().SendMsg(request.GetMsgID(), request.GetData()); err != nil {
+		panic(err)
+	}
+}
+
+func TestCompressMsg(t *testing.T) {
+	data := bytes.Repeat([]byte("tigerkin "), 512)
+
+	for _, name := range []string{"gzip", "deflate"} {
+		compressor := GetCompressor(name)
+		require.NotNil(t, compressor)
+
+		// 未达到阈值不压缩
+		msg := NewMsgPackage(1, data)
+		require.NoError(t, CompressMsg(msg, compressor, uint32(len(data)+1)))
+		require.Equal(t, byte(0), msg.GetFlags())
+
+		msg = NewMsgPackage(1, data)
+		require.NoError(t, CompressMsg(msg, compressor, 128))
+		require.Equal(t, compressor.ID(), msg.GetFlags()&FlagCompressMask)
+		require.Less(t, msg.GetDataLen(), uint32(len(data)))
+
+		// 解压后的数据超过限制
+		bomb := &Message{Id: msg.Id, DataLen: msg.DataLen, Data: msg.Data, Flags: msg.Flags}
+		require.Error(t, DecompressMsg(bomb, 1024))
+
+		require.NoError(t, DecompressMsg(msg, 0))
+		require.Equal(t, byte(0), msg.GetFlags())
+		require.Equal(t, data, msg.GetData())
+	}
+
+	// 未注册的压缩算法
+	require.Error(t, DecompressMsg(&Message{Flags: 0x0F}, 0))
+}
+
+func TestConnectionCompress(t *testing.T) {
+	conf := *utils.GlobalObject
+	defer func() { *utils.GlobalObject = conf }()
+	utils.GlobalObject.WorkerPoolSize = 0
+	utils.GlobalObject.FrameFlags = true
+	utils.GlobalObject.Compressor = "gzip"
+	utils.GlobalObject.CompressThreshold = 256
+
+	require.Equal(t, uint32(9), NewDataPack().GetHeadLen())
+
+	s := NewServer()
+	s.AddRouter(202, &EchoRouter{})
+
+	serverConn, client := newTCPPair(t)
+	defer client.Close()
+	c := NewConnection(s, serverConn, 300, s.(*Server).msgHandler)
+	go c.Start()
+	defer c.stopAndWait()
+
+	// 客户端发送压缩过的消息，Router拿到原始数据并原样返回，服务端发送时再次压缩
+	data := bytes.Repeat([]byte("player "), 500)
+	msg := NewMsgPackage(202, data)
+	require.NoError(t, CompressMsg(msg, GetCompressor("deflate"), 0))
+	packed, err := NewDataPack().Pack(msg)
+	require.NoError(t, err)
+	_, err = client.Write(packed)
+	require.NoError(t, err)
+
+	reply := readTestMsg(t, client)
+	require.Equal(t, CompressorGzip, reply.GetFlags()&FlagCompressMask)
+	require.Less(t, int(reply.GetDataLen()), len(data))
+	require.NoError(t, DecompressMsg(reply, 0))
+	require.Equal(t, data, reply.GetData())
+
+	// 小于阈值的消息不压缩
+	writeTestMsg(t, client, 202, []byte("hello"))
+	reply = readTestMsg(t, client)
+	require.Equal(t, byte(0), reply.GetFlags())
+	require.Equal(t, "hello", string(reply.GetData()))
+}
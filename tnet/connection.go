@@ -1,14 +1,18 @@
 package tnet
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/HOU-SZ/tigerkin/tiface"
 	"github.com/HOU-SZ/tigerkin/utils"
+	"google.golang.org/protobuf/proto"
 )
 
 // 创建连接的方法
@@ -16,14 +20,16 @@ type Connection struct {
 	// 当前Conn属于哪个Server
 	TcpServer tiface.IServer
 
-	// 当前连接的socket TCP套接字
-	Conn *net.TCPConn
+	// 当前连接的socket套接字，通常为*net.TCPConn，也可以是net.Pipe等其他实现
+	Conn net.Conn
 
 	// 当前连接的ID 也可以称作为SessionID，ID全局唯一
 	ConnID uint32
 
 	// 当前连接的关闭状态
 	isClosed bool
+	// 保护连接关闭状态的锁
+	closeLock sync.RWMutex
 
 	// // V0.2 该连接的处理方法api
 	// handleAPI tiface.HandFunc
@@ -36,6 +42,13 @@ type Connection struct {
 
 	// 告知该链接已经退出/停止的channel（由Reader告知Writer退出）
 	ExitBuffChan chan bool
+	// Reader退出之后被关闭，之后该连接不再读取数据
+	readerExit chan struct{}
+
+	// 未开启工作池时限制同时处理的请求数量，以及同时处理的流式消息数量，为nil时不限制
+	requests chan struct{}
+	// 未开启工作池时正在处理请求的goroutine，以及正在处理流式消息的goroutine
+	handlers sync.WaitGroup
 
 	// 无缓冲管道，用于读、写两个goroutine之间的消息通信
 	msgChan chan []byte
@@ -47,21 +60,133 @@ type Connection struct {
 	property map[string]interface{}
 	// 保护链接属性修改的锁
 	propertyLock sync.RWMutex
+
+	// 当前连接的限流器，为nil时不限流
+	limiter tiface.IConnLimiter
+
+	// 当前连接的录制器，为nil时不录制
+	recorder tiface.IConnRecorder
+
+	// 发送消息时使用的压缩算法，为nil时不压缩
+	compressor tiface.ICompressor
+
+	// 当前连接使用的封包拆包模块（明文）
+	dataPack tiface.IDataPack
+	// 是否需要建立加密通道
+	secureRequired bool
+	// 连接建立时生成的临时密钥
+	keyExchange *KeyExchange
+	// 密钥交换完成后建立的加密通道（*SecureDataPack），对dataPack进行包装
+	secure atomic.Value
+
+	// 从连接中逐个读取并校验消息，只在Reader goroutine中使用
+	frames *frameReader
+	// 大消息的分片重组，只在Reader goroutine中使用
+	assembler fragmentAssembler
+	// 正在接收的流式消息，只在Reader goroutine中使用
+	stream *streamState
+
+	// 当前连接的鉴权器，为nil时不需要鉴权
+	authenticator tiface.IAuthenticator
+	// 当前连接是否已经通过鉴权（1表示通过）
+	authenticated int32
+	// 鉴权、会话握手超时定时器
+	authTimer *time.Timer
+	// 客户端协商的序列化方式（codecChoice），未协商时使用Server默认的序列化方式
+	codec atomic.Value
+
+	// 是否已经调用过OnConnStart Hook函数或者恢复了会话（1表示是），只有是才会调用OnConnStop
+	started int32
+	// 是否需要在开始处理业务之前创建或者恢复会话
+	sessionRequired bool
+	// 当前连接绑定的会话ID（string），没有会话时为空
+	sessionID atomic.Value
+	// 可靠消息的收发状态（*reliableState），开启会话时与会话共享
+	reliable atomic.Value
+
+	// 当前连接的context，连接停止时被取消，请求的context由它派生
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// 当前时间窗口内收到的未知消息数量
+	unknownMsgs uint32
+	// 当前时间窗口的开始时间
+	unknownStart time.Time
+	// 保护未知消息计数，未开启工作池时消息在不同的goroutine中处理
+	unknownLock sync.Mutex
 }
 
-func NewConnection(server tiface.IServer, conn *net.TCPConn, connID uint32, msgHandler tiface.IMsgHandle) *Connection {
+func NewConnection(server tiface.IServer, conn net.Conn, connID uint32, msgHandler tiface.IMsgHandle) *Connection {
 	c := &Connection{
 		TcpServer:    server,
 		Conn:         conn,
+		frames:       newFrameReader(conn),
 		ConnID:       connID,
 		isClosed:     false,
 		MsgHandler:   msgHandler,
 		ExitBuffChan: make(chan bool, 1),
+		readerExit:   make(chan struct{}),
 		msgChan:      make(chan []byte),
 		msgBuffChan:  make(chan []byte, utils.GlobalObject.MaxMsgChanLen),
 		property:     make(map[string]interface{}),
 	}
 
+	c.reliable.Store(newReliableState())
+	// 开启工作池时只用来限制流式消息
+	if utils.GlobalObject.MaxConnRequests > 0 {
+		c.requests = make(chan struct{}, utils.GlobalObject.MaxConnRequests)
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	// 创建当前连接的限流器
+	if rateLimiter := server.GetRateLimiter(); rateLimiter != nil {
+		c.limiter = rateLimiter.NewConnLimiter()
+	}
+
+	// 使用Server的封包拆包模块
+	c.dataPack = server.GetDataPack()
+	if c.dataPack == nil {
+		c.dataPack = NewDataPack()
+	}
+
+	// 开启加密通道时，生成本次连接的临时密钥
+	if utils.GlobalObject.SecureChannel != "" {
+		c.secureRequired = true
+		keyExchange, err := NewKeyExchange()
+		if err != nil {
+			fmt.Println("Generate key exchange error: ", err)
+		}
+		c.keyExchange = keyExchange
+	}
+
+	// 开启FrameFlags时，根据配置选择发送消息时使用的压缩算法
+	if utils.GlobalObject.FrameFlags && utils.GlobalObject.Compressor != "" {
+		c.compressor = GetCompressor(utils.GlobalObject.Compressor)
+		if c.compressor == nil {
+			fmt.Println("Compressor ", utils.GlobalObject.Compressor, " is NOT FOUND, send msg without compress")
+		}
+	}
+
+	// 创建当前连接的录制器，创建失败时不录制该连接
+	if recorder := server.GetRecorder(); recorder != nil {
+		connRecorder, err := recorder.NewConnRecorder(c)
+		if err != nil {
+			fmt.Println("connID = ", connID, " create recorder error: ", err)
+		} else {
+			c.recorder = connRecorder
+		}
+	}
+
+	// 需要鉴权或者创建会话的连接，启动超时定时器
+	c.authenticator = server.GetAuthenticator()
+	if c.authenticator == nil {
+		c.authenticated = 1
+	}
+	c.sessionRequired = sessionEnabled()
+	if (c.authenticator != nil || c.sessionRequired) && utils.GlobalObject.AuthTimeout > 0 {
+		c.authTimer = time.AfterFunc(time.Duration(utils.GlobalObject.AuthTimeout)*time.Second, c.authTimeout)
+	}
+
 	// 将新创建的Conn添加到链接管理中
 	c.TcpServer.GetConnMgr().Add(c)
 
@@ -73,8 +198,10 @@ func NewConnection(server tiface.IServer, conn *net.TCPConn, connID uint32, msgH
 */
 func (c *Connection) StartReader() {
 	fmt.Println("[Reader Goroutine is running]")
+	defer close(c.readerExit)
 	defer fmt.Println(c.RemoteAddr().String(), " [conn reader exit!]")
 	defer c.Stop()
+	defer c.abortStream()
 
 	for {
 		// // 读取客户端的数据到buf中
@@ -86,34 +213,120 @@ func (c *Connection) StartReader() {
 		// 	continue
 		// }
 
-		// 创建拆包解包的对象
-		dp := NewDataPack()
+		// 拆包解包的对象
+		dp := c.dataPack
 
-		// 读取客户端的Msg head（8个字节的二进制流）
-		headData := make([]byte, dp.GetHeadLen())
-		if _, err := io.ReadFull(c.GetTCPConnection(), headData); err != nil {
-			fmt.Println("read msg head error: ", err)
+		// 读取客户端的Msg head，拆包得到msgId 和 dataLen，再根据 dataLen 读取 data，放在msg.Data中
+		// 开启校验时同时校验同步标记和校验和，校验失败时根据FrameErrorPolicy关闭连接或重新同步
+		msg, dropped, err := c.frames.ReadFrame(dp)
+		if err != nil {
+			if errors.Is(err, ErrFrameCorrupt) {
+				fmt.Println("connID = ", c.ConnID, " close for frame error: ", err)
+			} else {
+				fmt.Println("read msg error: ", err)
+			}
 			break
 		}
-		// fmt.Printf("read headData: %+v\n", headData)
+		if dropped {
+			// 丢弃过损坏的消息，正在接收的大消息已经不完整，丢弃其剩余的分片
+			fmt.Println("connID = ", c.ConnID, " dropped corrupt frames and resynced")
+			c.abortStream()
+			c.assembler.discard()
+		}
 
-		// 拆包，得到msgId 和 dataLen 放在msg中
-		msg, err := dp.Unpack(headData)
-		if err != nil {
-			fmt.Println("unpack error: ", err)
+		// 需要加密的连接，在密钥交换完成之前只接受密钥交换消息，之后的消息都需要解密
+		if c.secureRequired {
+			secure := c.getSecure()
+			if secure == nil {
+				if err := c.handleKeyExchange(msg); err != nil {
+					fmt.Println("key exchange error: ", err)
+					break
+				}
+				continue
+			}
+			if err := secure.UnpackData(msg); err != nil {
+				fmt.Println("decrypt msg error: ", err)
+				break
+			}
+		} else if err := unpackData(dp, msg); err != nil {
+			fmt.Println("unpack msg data error: ", err)
 			break
 		}
 
-		//根据 dataLen 读取 data，放在msg.Data中
-		var data []byte
-		if msg.GetDataLen() > 0 {
-			data = make([]byte, msg.GetDataLen())
-			if _, err := io.ReadFull(c.GetTCPConnection(), data); err != nil {
-				fmt.Println("read msg data error: ", err)
+		// 录制解密之后的消息，重组、解压之前，回放时原样发送
+		if c.recorder != nil {
+			c.recorder.RecordInbound(msg)
+		}
+
+		// 大消息的分片：注册了流式路由的消息边收边交给Router处理，其他消息重组为完整消息之后再处理
+		if msg.GetFlags()&FlagFragment != 0 {
+			if c.assembler.skip(msg) {
+				continue
+			}
+			if c.stream != nil || (c.IsAuthenticated() && c.MsgHandler.HasStreamRouter(msg.GetMsgId())) {
+				if err := c.handleStreamFragment(msg); err != nil {
+					fmt.Println("handle stream fragment error: ", err)
+					break
+				}
+				continue
+			}
+			whole, err := c.assembler.add(msg)
+			if err != nil {
+				fmt.Println("assemble fragment error: ", err)
 				break
 			}
+			if whole == nil {
+				continue
+			}
+			msg = whole
+		} else if c.stream != nil || c.assembler.msg != nil {
+			fmt.Println("msgId = ", msg.GetMsgId(), " interleaved with fragments")
+			break
+		} else {
+			c.assembler.discarding = false
+		}
+
+		// 在分发之前进行限流检查，超出限流的消息不再分发
+		if c.limiter != nil && !c.limiter.Allow(c, msg.GetMsgId()) {
+			continue
+		}
+
+		// 根据flags对数据进行解压，Router拿到的始终是原始数据
+		if err := DecompressMsg(msg, utils.GlobalObject.MaxMsgSize); err != nil {
+			fmt.Println("decompress msg error: ", err)
+			break
+		}
+
+		// 协商序列化方式的消息由连接直接处理，可以在鉴权之前进行
+		if msg.GetMsgId() == utils.GlobalObject.CodecMsgId {
+			c.handleCodec(msg)
+			continue
+		}
+
+		// 未通过鉴权的连接只能发送鉴权消息，不会分发给Router
+		if !c.IsAuthenticated() {
+			c.handleAuth(msg)
+			continue
+		}
+
+		// 开启会话时，连接需要先创建或者恢复会话
+		if c.sessionRequired && atomic.LoadInt32(&c.started) == 0 {
+			c.handleSession(msg)
+			continue
+		}
+
+		// 订阅主题的消息由连接直接处理
+		if msg.GetMsgId() == utils.GlobalObject.TopicMsgId {
+			c.handleTopic(msg)
+			continue
+		}
+
+		// 可靠消息回复确认之后，取出原始消息分发给Router，重复的消息和确认消息不再分发
+		if msg.GetMsgId() == utils.GlobalObject.ReliableMsgId {
+			if msg = c.handleReliable(msg); msg == nil {
+				continue
+			}
 		}
-		msg.SetData(data)
 
 		// // V0.2 调用当前链接业务所绑定的handleAPI
 		// if err := c.handleAPI(c.Conn, buf, cnt); err != nil {
@@ -138,18 +351,398 @@ func (c *Connection) StartReader() {
 		// // V0.6 从绑定好的消息和对应的处理方法中执行对应的Handle方法
 		// go c.MsgHandler.DoMsgHandler(&req)
 
-		// V0.8 添加工作池机制，应对大量并发请求
-		if utils.GlobalObject.WorkerPoolSize > 0 {
-			// 已经启动工作池机制，将消息交给Worker处理
-			// fmt.Println("Has started worker pool, send request to TaskQueue")
-			c.MsgHandler.SendMsgToTaskQueue(&req)
-		} else {
-			// 未启用工作池机制，从绑定好的消息和对应的处理方法中执行对应的Handle方法
-			go c.MsgHandler.DoMsgHandler(&req)
+		c.dispatch(&req)
+	}
+}
+
+// 将请求交给Router处理
+func (c *Connection) dispatch(req tiface.IRequest) {
+	// V0.8 添加工作池机制，应对大量并发请求
+	if utils.GlobalObject.WorkerPoolSize > 0 {
+		// 已经启动工作池机制，将消息交给Worker处理
+		// fmt.Println("Has started worker pool, send request to TaskQueue")
+		c.MsgHandler.SendMsgToTaskQueue(req)
+	} else {
+		// 未启用工作池机制，从绑定好的消息和对应的处理方法中执行对应的Handle方法
+		c.goHandleLimited(req)
+	}
+}
+
+// 在新的goroutine中处理请求，同时处理的请求达到上限时Reader阻塞，不再读取新的消息，
+// 每个连接占用的goroutine和内存是有上限的
+func (c *Connection) goHandleLimited(req tiface.IRequest) {
+	if c.requests == nil {
+		c.goHandle(req, nil)
+		return
+	}
+	select {
+	case c.requests <- struct{}{}:
+		c.goHandle(req, func() { <-c.requests })
+	case <-c.ctx.Done():
+		// 连接已经停止，不再等待
+		c.goHandle(req, nil)
+	}
+}
+
+// 在新的goroutine中处理请求，处理完成之后调用done（可以为nil）
+func (c *Connection) goHandle(req tiface.IRequest, done func()) {
+	c.handlers.Add(1)
+	go func() {
+		defer c.handlers.Done()
+		if done != nil {
+			defer done()
 		}
+		c.MsgHandler.DoMsgHandler(req)
+	}()
+}
+
+/*
+	正在接收的流式消息
+*/
+type streamState struct {
+	// 流式消息的ID
+	msgId uint32
+	// 已经收到的数据长度
+	size uint32
+	// 管道的写入端，为nil表示该消息被限流或Router已经不再读取，丢弃剩余的分片
+	writer *io.PipeWriter
+}
+
+// 处理流式消息的分片，第一个分片到达时就将请求交给Router，之后的分片依次写入管道
+// 管道没有缓冲，Router读取较慢时Reader会阻塞等待，因此每个连接占用的内存是有上限的
+func (c *Connection) handleStreamFragment(msg tiface.IMessage) error {
+	if c.stream == nil {
+		c.stream = &streamState{msgId: msg.GetMsgId()}
+
+		// 在第一个分片到达时进行限流检查，被限流的消息丢弃剩余分片
+		if c.limiter == nil || c.limiter.Allow(c, msg.GetMsgId()) {
+			pipeReader, pipeWriter := io.Pipe()
+			body := &streamBody{pipe: pipeReader}
+			if id := msg.GetFlags() & FlagCompressMask; id != 0 {
+				decompressor, ok := GetCompressorById(id).(tiface.IStreamDecompressor)
+				if !ok {
+					return fmt.Errorf("compressor id = %d can not decompress stream", id)
+				}
+				body.decompressor = decompressor
+			}
+			c.stream.writer = pipeWriter
+
+			// 流式Router读取管道时等待后续的分片，不交给工作池处理，避免客户端停止发送分片时
+			// 占用Worker，阻塞同一个Worker上其他连接的请求
+			c.goHandleLimited(&Request{
+				conn: c,
+				msg:  NewMsgPackage(msg.GetMsgId(), nil),
+				body: body,
+			})
+		}
+	} else if c.stream.msgId != msg.GetMsgId() {
+		return fmt.Errorf("fragment msgId = %d interleaved with msgId = %d", msg.GetMsgId(), c.stream.msgId)
+	}
+
+	c.stream.size += msg.GetDataLen()
+	if utils.GlobalObject.MaxMsgSize > 0 && c.stream.size > utils.GlobalObject.MaxMsgSize {
+		return errors.New("too large stream msg recieved")
+	}
+
+	if c.stream.writer != nil {
+		if _, err := c.stream.writer.Write(msg.GetData()); err != nil {
+			c.stream.writer = nil
+		}
+	}
+
+	// 最后一个分片，关闭管道，Router读到EOF
+	if msg.GetFlags()&FlagFragmentEnd != 0 {
+		if c.stream.writer != nil {
+			c.stream.writer.Close()
+		}
+		c.stream = nil
 	}
-	c.ExitBuffChan <- true
+	return c.setStreamDeadline()
+}
+
+// 流式消息接收期间，下一个分片需要在StreamIdleTimeout之内到达，否则读取超时，连接被关闭
+// 停止发送分片的客户端不会一直占用处理流式消息的goroutine
+func (c *Connection) setStreamDeadline() error {
+	timeout := utils.GlobalObject.StreamIdleTimeout
+	if timeout <= 0 {
+		return nil
+	}
+	var deadline time.Time
+	if c.stream != nil {
+		deadline = time.Now().Add(time.Duration(timeout) * time.Millisecond)
+	}
+	return c.Conn.SetReadDeadline(deadline)
+}
 
+// Reader退出时，通知正在读取流式消息的Router消息不完整
+func (c *Connection) abortStream() {
+	if c.stream != nil && c.stream.writer != nil {
+		c.stream.writer.CloseWithError(io.ErrUnexpectedEOF)
+	}
+	c.stream = nil
+}
+
+// 发送本端公钥以及加密算法，开始密钥交换（明文发送）
+func (c *Connection) sendKeyExchange() error {
+	if c.keyExchange == nil {
+		return errors.New("key exchange not initialized")
+	}
+	data := append(c.keyExchange.PublicKey(), utils.GlobalObject.SecureChannel...)
+	msg, err := c.dataPack.Pack(NewMsgPackage(utils.GlobalObject.KeyExchangeMsgId, data))
+	if err != nil {
+		return err
+	}
+
+	select {
+	case c.msgChan <- msg:
+	case <-c.ExitBuffChan:
+		return errors.New("Connection closed when send key exchange")
+	}
+	return nil
+}
+
+// 处理客户端发来的公钥，协商会话密钥并建立加密通道
+func (c *Connection) handleKeyExchange(msg tiface.IMessage) error {
+	if msg.GetMsgId() != utils.GlobalObject.KeyExchangeMsgId {
+		return fmt.Errorf("expect key exchange msg, but got msgId = %d", msg.GetMsgId())
+	}
+
+	if c.keyExchange == nil {
+		return errors.New("key exchange not initialized")
+	}
+	secure, err := c.keyExchange.NewSecureDataPack(c.dataPack, msg.GetData(), utils.GlobalObject.SecureChannel, true)
+	if err != nil {
+		return err
+	}
+	c.secure.Store(secure)
+	fmt.Println("ConnID = ", c.ConnID, " secure channel established")
+
+	// 加密通道建立之后，不需要鉴权的连接可以开始处理业务
+	if c.IsAuthenticated() {
+		c.ready()
+	}
+	return nil
+}
+
+// 获取当前连接的加密通道，尚未建立时返回nil
+func (c *Connection) getSecure() *SecureDataPack {
+	secure, _ := c.secure.Load().(*SecureDataPack)
+	return secure
+}
+
+/*
+	处理未鉴权连接发来的消息，只接受鉴权消息
+*/
+func (c *Connection) handleAuth(msg tiface.IMessage) {
+	if msg.GetMsgId() != utils.GlobalObject.AuthMsgId {
+		fmt.Println("ConnID = ", c.ConnID, " is not authenticated, drop msgId = ", msg.GetMsgId())
+		return
+	}
+
+	if err := c.authenticator.Authenticate(c, msg.GetData()); err != nil {
+		// 鉴权失败，告知客户端原因，客户端可以在超时之前重试
+		fmt.Println("ConnID = ", c.ConnID, " auth failed: ", err)
+		if err := c.SendBuffMsg(utils.GlobalObject.AuthMsgId, append([]byte{AuthStatusFailed}, err.Error()...)); err != nil {
+			fmt.Println("Send auth reply error: ", err)
+		}
+		return
+	}
+
+	// 鉴权通过，之后的消息正常分发
+	atomic.StoreInt32(&c.authenticated, 1)
+	if err := c.SendBuffMsg(utils.GlobalObject.AuthMsgId, []byte{AuthStatusOK}); err != nil {
+		fmt.Println("Send auth reply error: ", err)
+	}
+
+	// 鉴权通过之后才执行创建连接时的hook方法，此时连接身份信息已经可用
+	c.ready()
+}
+
+// 鉴权、会话握手超时，关闭仍未通过鉴权或者仍未创建会话的连接
+func (c *Connection) authTimeout() {
+	if !c.IsAuthenticated() {
+		fmt.Println("ConnID = ", c.ConnID, " auth timeout, close connection")
+		c.Stop()
+	} else if atomic.LoadInt32(&c.started) == 0 {
+		fmt.Println("ConnID = ", c.ConnID, " session timeout, close connection")
+		c.Stop()
+	}
+}
+
+// 连接完成密钥交换和鉴权，开启会话时等待客户端创建或者恢复会话，否则开始处理业务
+func (c *Connection) ready() {
+	if c.sessionRequired {
+		return
+	}
+	if c.authTimer != nil {
+		c.authTimer.Stop()
+	}
+	c.callOnConnStart()
+}
+
+/*
+	处理客户端创建、恢复会话的消息，数据为空表示创建新的会话，否则为要恢复的会话令牌
+	回复的数据与鉴权一致：第一个字节为0表示成功，之后为会话令牌；1表示失败，之后为失败原因，客户端可以重试或者创建新的会话
+*/
+func (c *Connection) handleSession(msg tiface.IMessage) {
+	if msg.GetMsgId() != utils.GlobalObject.SessionMsgId {
+		fmt.Println("ConnID = ", c.ConnID, " has no session, drop msgId = ", msg.GetMsgId())
+		return
+	}
+
+	// 先停止超时定时器，避免连接在绑定会话的同时被超时关闭
+	if c.authTimer != nil && !c.authTimer.Stop() {
+		return
+	}
+
+	sessionMgr := c.TcpServer.GetSessionMgr()
+	resume := msg.GetDataLen() > 0
+	var token string
+	if resume {
+		token = string(msg.GetData())
+		if err := sessionMgr.Resume(c, token); err != nil {
+			fmt.Println("ConnID = ", c.ConnID, " resume session failed: ", err)
+			if c.authTimer != nil {
+				c.authTimer.Reset(time.Duration(utils.GlobalObject.AuthTimeout) * time.Second)
+			}
+			if err := c.SendBuffMsg(utils.GlobalObject.SessionMsgId, append([]byte{AuthStatusFailed}, err.Error()...)); err != nil {
+				fmt.Println("Send session reply error: ", err)
+			}
+			return
+		}
+	} else {
+		token = sessionMgr.Create(c)
+	}
+
+	if err := c.SendBuffMsg(utils.GlobalObject.SessionMsgId, append([]byte{AuthStatusOK}, token...)); err != nil {
+		fmt.Println("Send session reply error: ", err)
+	}
+
+	// 恢复的会话不再执行创建连接时的hook方法，业务可以通过OnConnResume更新保存的连接
+	if resume {
+		atomic.StoreInt32(&c.started, 1)
+		c.TcpServer.CallOnConnResume(c)
+	} else {
+		c.callOnConnStart()
+	}
+}
+
+// 获取当前连接绑定的会话ID，没有会话时返回空字符串
+func (c *Connection) GetSessionID() string {
+	sessionID, _ := c.sessionID.Load().(string)
+	return sessionID
+}
+
+// 绑定会话
+func (c *Connection) setSessionID(sessionID string) {
+	c.sessionID.Store(sessionID)
+}
+
+// 当前连接是否已经通过鉴权
+func (c *Connection) IsAuthenticated() bool {
+	return atomic.LoadInt32(&c.authenticated) == 1
+}
+
+/*
+	处理客户端协商序列化方式的消息，数据为按照优先级用逗号分隔的序列化方式名称
+	回复选中的序列化方式名称，没有可以使用的序列化方式时回复空数据，继续使用默认的序列化方式
+*/
+func (c *Connection) handleCodec(msg tiface.IMessage) {
+	var reply []byte
+	if codec := chooseCodec(string(msg.GetData())); codec != nil {
+		c.codec.Store(codecChoice{codec: codec})
+		reply = []byte(codec.Name())
+	}
+	if err := c.SendBuffMsg(utils.GlobalObject.CodecMsgId, reply); err != nil {
+		fmt.Println("Send codec reply error: ", err)
+	}
+}
+
+/*
+	处理客户端订阅、取消订阅主题的消息，数据为操作类型（1字节）和主题
+	回复的数据与鉴权一致：第一个字节为0表示成功，1表示失败，失败时之后为失败原因
+*/
+func (c *Connection) handleTopic(msg tiface.IMessage) {
+	var err error
+	if msg.GetDataLen() < 1 {
+		err = errors.New("empty topic msg")
+	} else {
+		topicMgr := c.TcpServer.GetTopicMgr()
+		pattern := string(msg.GetData()[1:])
+		switch msg.GetData()[0] {
+		case TopicOpSubscribe:
+			if err = topicMgr.Authorize(c, pattern); err == nil {
+				err = topicMgr.Subscribe(c, pattern)
+			}
+		case TopicOpUnsubscribe:
+			topicMgr.Unsubscribe(c, pattern)
+		default:
+			err = fmt.Errorf("unknown topic op = %d", msg.GetData()[0])
+		}
+	}
+
+	reply := []byte{AuthStatusOK}
+	if err != nil {
+		fmt.Println("ConnID = ", c.ConnID, " topic error: ", err)
+		reply = append([]byte{AuthStatusFailed}, err.Error()...)
+	}
+	if err := c.SendBuffMsg(utils.GlobalObject.TopicMsgId, reply); err != nil {
+		fmt.Println("Send topic reply error: ", err)
+	}
+}
+
+/*
+	处理客户端发来的可靠消息和确认消息，返回需要分发的原始消息
+	可靠消息在交给Router之前就回复确认，确认表示消息已经送达，不表示已经处理完成
+*/
+func (c *Connection) handleReliable(msg tiface.IMessage) tiface.IMessage {
+	kind, seq, msgId, data, err := unpackReliable(msg.GetData())
+	if err != nil {
+		fmt.Println("ConnID = ", c.ConnID, " reliable msg error: ", err)
+		return nil
+	}
+
+	reliable := c.getReliable()
+	if kind == ReliableAck {
+		reliable.ack(seq)
+		return nil
+	}
+
+	isNew, ackSeq := reliable.receive(seq)
+	if err := c.SendBuffMsg(utils.GlobalObject.ReliableMsgId, packAck(ackSeq)); err != nil {
+		fmt.Println("Send reliable ack error: ", err)
+	}
+	if !isNew {
+		return nil
+	}
+	return NewMsgPackage(msgId, data)
+}
+
+// 获取可靠消息的收发状态
+func (c *Connection) getReliable() *reliableState {
+	return c.reliable.Load().(*reliableState)
+}
+
+// 设置可靠消息的收发状态，恢复会话时使用会话中保存的状态
+func (c *Connection) setReliable(reliable *reliableState) {
+	c.reliable.Store(reliable)
+}
+
+// 获取msgId使用的序列化方式：Server为msgId单独指定的、连接协商的、Server默认的
+func (c *Connection) GetCodec(msgId uint32) tiface.ICodec {
+	if codec := c.TcpServer.GetMsgCodec(msgId); codec != nil {
+		return codec
+	}
+	if choice, ok := c.codec.Load().(codecChoice); ok {
+		return choice.codec
+	}
+	return c.TcpServer.GetCodec()
+}
+
+// 调用创建连接时的hook方法
+func (c *Connection) callOnConnStart() {
+	atomic.StoreInt32(&c.started, 1)
+	c.TcpServer.CallOnConnStart(c)
 }
 
 /*
@@ -166,7 +759,7 @@ func (c *Connection) StartWriter() {
 		case data, ok := <-c.msgChan:
 			if ok {
 				// 有数据要写给客户端
-				if _, err := c.Conn.Write(data); err != nil {
+				if err := c.write(data); err != nil {
 					fmt.Println("Send Data error:, ", err, " Conn Writer exit")
 					return
 				}
@@ -176,7 +769,7 @@ func (c *Connection) StartWriter() {
 		case data, ok := <-c.msgBuffChan:
 			if ok {
 				// 有数据要写给客户端
-				if _, err := c.Conn.Write(data); err != nil {
+				if err := c.write(data); err != nil {
 					fmt.Println("Send Buff Data error:, ", err, " Conn Writer exit")
 					return
 				}
@@ -189,6 +782,43 @@ func (c *Connection) StartWriter() {
 	}
 }
 
+// 将封包好的数据写给客户端，已经建立加密通道时先加密
+// 加密在Writer中进行，保证加密序号与实际发送顺序一致
+func (c *Connection) write(data []byte) error {
+	frames := data
+	if secure := c.getSecure(); secure != nil {
+		sealed, err := secure.SealFrames(data)
+		if err != nil {
+			return err
+		}
+		data = sealed
+	}
+	if _, err := c.Conn.Write(data); err != nil {
+		return err
+	}
+
+	// 录制加密之前的消息，与实际发送的顺序一致
+	if c.recorder != nil {
+		if err := recordFrames(c.recorder, c.dataPack, frames); err != nil {
+			fmt.Println("connID = ", c.ConnID, " record msg error: ", err)
+		}
+	}
+	return nil
+}
+
+// 等待连接的Reader以及未开启工作池时正在处理的请求退出，需要在Start之后调用
+// 连接停止之后返回，之后连接不再读取配置，测试可以安全地修改配置
+func (c *Connection) Wait() {
+	<-c.readerExit
+	c.handlers.Wait()
+}
+
+// 停止连接并等待连接退出
+func (c *Connection) stopAndWait() {
+	c.Stop()
+	c.Wait()
+}
+
 //启动连接，让当前连接开始工作
 func (c *Connection) Start() {
 	// Start()函数结束的时候调用stop处理善后业务
@@ -200,7 +830,15 @@ func (c *Connection) Start() {
 	go c.StartWriter()
 
 	// 按照用户传递进来的创建连接时需要处理的业务，执行对应hook方法
-	c.TcpServer.CallOnConnStart(c)
+	// 需要加密的连接在密钥交换完成之后、需要鉴权的连接在鉴权通过之后再执行
+	if c.secureRequired {
+		if err := c.sendKeyExchange(); err != nil {
+			fmt.Println("Send key exchange error: ", err)
+			return
+		}
+	} else if c.IsAuthenticated() {
+		c.ready()
+	}
 
 	for {
 		select {
@@ -216,32 +854,84 @@ func (c *Connection) Start() {
 func (c *Connection) Stop() {
 	fmt.Println("Conn Stop()...ConnID = ", c.ConnID)
 	// 如果当前链接已经关闭
+	// Stop可能同时被Reader、Writer以及业务goroutine调用，需要加锁保证只执行一次
+	c.closeLock.Lock()
 	if c.isClosed {
+		c.closeLock.Unlock()
 		return
 	}
 	c.isClosed = true
+	c.closeLock.Unlock()
+
+	// 停止鉴权超时定时器
+	if c.authTimer != nil {
+		c.authTimer.Stop()
+	}
 
 	// 如果用户注册了该链接的关闭回调业务，那么在此刻应该显示调用对应的hook方法
-	c.TcpServer.CallOnConnStop(c)
+	// 没有执行过OnConnStart的连接（例如未通过鉴权）不执行OnConnStop
+	// 绑定了会话的连接，会话等待恢复超时之后才执行OnConnStop
+	if atomic.LoadInt32(&c.started) == 1 && !c.TcpServer.GetSessionMgr().Detach(c) {
+		c.TcpServer.CallOnConnStop(c)
+	}
+
+	// 取消连接的context，正在处理的请求可以据此提前结束
+	c.cancel()
 
 	// 关闭socket链接
 	c.Conn.Close()
 
-	// 通知从缓冲队列读数据的Writer，该链接已经关闭
-	c.ExitBuffChan <- true
+	// 结束录制
+	if c.recorder != nil {
+		if err := c.recorder.Close(); err != nil {
+			fmt.Println("connID = ", c.ConnID, " close recorder error: ", err)
+		}
+	}
 
-	//将链接从连接管理器中删除
+	// 关闭ExitBuffChan，通知Writer和Start该链接已经关闭
+	// msgChan和msgBuffChan不关闭，避免其他goroutine发送消息时向已关闭的管道写数据，由GC回收
+	close(c.ExitBuffChan)
+
+	//将链接从连接管理器中删除，同时取消全部主题订阅
 	c.TcpServer.GetConnMgr().Remove(c)
+	c.TcpServer.GetTopicMgr().UnsubscribeAll(c)
+}
 
-	// 关闭该链接全部管道，回收资源
-	close(c.ExitBuffChan)
-	close(c.msgChan)
-	close(c.msgBuffChan)
+// 记录一个未知消息，返回当前时间窗口内的未知消息数量
+func (c *Connection) countUnknownMsg(window time.Duration) uint32 {
+	c.unknownLock.Lock()
+	defer c.unknownLock.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.unknownStart) >= window {
+		c.unknownStart = now
+		c.unknownMsgs = 0
+	}
+	c.unknownMsgs++
+	return c.unknownMsgs
+}
+
+// 获取当前连接的context，连接停止时被取消
+func (c *Connection) Context() context.Context {
+	return c.ctx
+}
+
+// 判断当前连接是否已经关闭
+func (c *Connection) closed() bool {
+	c.closeLock.RLock()
+	defer c.closeLock.RUnlock()
+	return c.isClosed
+}
+
+// 获取当前连接所属的Server
+func (c *Connection) GetTcpServer() tiface.IServer {
+	return c.TcpServer
 }
 
-//从当前连接获取原始的socket TCPConn
+//从当前连接获取原始的socket TCPConn，连接不是TCP连接时返回nil
 func (c *Connection) GetTCPConnection() *net.TCPConn {
-	return c.Conn
+	tcpConn, _ := c.Conn.(*net.TCPConn)
+	return tcpConn
 }
 
 //获取当前连接ID
@@ -254,44 +944,157 @@ func (c *Connection) RemoteAddr() net.Addr {
 	return c.Conn.RemoteAddr()
 }
 
+// 将要发送给客户端的数据封包，数据达到压缩阈值时先进行压缩，超过MaxPacketSize时拆分为多个分片
+func (c *Connection) packMsg(msgId uint32, data []byte) ([]byte, error) {
+	msg := NewMsgPackage(msgId, data)
+	if err := CompressMsg(msg, c.compressor, utils.GlobalObject.CompressThreshold); err != nil {
+		return nil, err
+	}
+	// 超过MaxPacketSize的消息拆分为多个分片
+	return packFragments(c.dataPack, msg, maxFrameDataLen(c.secureRequired))
+}
+
+/*
+	封包结果相同的连接具有相同的packKey，广播时每种packKey只需要封包一次
+	加密在Writer中对每个连接单独进行，不影响封包结果
+*/
+type packKey struct {
+	dataPack   tiface.IDataPack
+	compressor tiface.ICompressor
+	maxDataLen uint32
+}
+
+// 获取当前连接的packKey
+func (c *Connection) packKey() packKey {
+	return packKey{
+		dataPack:   c.dataPack,
+		compressor: c.compressor,
+		maxDataLen: maxFrameDataLen(c.secureRequired),
+	}
+}
+
 // 将要发送给客户端的数据，先进行封包，再发送给远程的TCP客户端
 func (c *Connection) SendMsg(msgId uint32, data []byte) error {
-	if c.isClosed {
-		return errors.New("Connection closed when send msg")
+	if c.closed() {
+		return c.sendToSession(msgId, data, errors.New("Connection closed when send msg"))
+	}
+	if c.secureRequired && c.getSecure() == nil {
+		return errors.New("Secure channel not established when send msg")
 	}
 	// 将data封包，并且发送
-	dp := NewDataPack()
-	msg, err := dp.Pack(NewMsgPackage(msgId, data))
+	msg, err := c.packMsg(msgId, data)
 	if err != nil {
-		fmt.Println("Pack error msg id = ", msgId)
+		fmt.Println("Pack error msg id = ", msgId, " err = ", err)
 		return errors.New("Pack error msg")
 	}
 
-	// 写进消息管道
-	c.msgChan <- msg
+	// 写进消息管道，如果连接在等待期间被关闭则放弃发送
+	select {
+	case c.msgChan <- msg:
+	case <-c.ExitBuffChan:
+		return errors.New("Connection closed when send msg")
+	}
 
 	return nil
 }
 
+// 带序号发送消息，消息保留到客户端确认为止
+func (c *Connection) SendReliable(msgId uint32, data []byte) (uint64, error) {
+	return c.getReliable().send(msgId, data, func(envelope []byte) error {
+		if c.closed() {
+			// 会话等待恢复时消息保留在发送缓冲中，恢复之后重发；会话已经绑定到新的连接时转发给新的连接
+			return c.forwardToSession(utils.GlobalObject.ReliableMsgId, envelope, errors.New("Connection closed when send reliable msg"))
+		}
+		return c.SendBuffMsg(utils.GlobalObject.ReliableMsgId, envelope)
+	})
+}
+
+// 获取被客户端确认的最大序号
+func (c *Connection) GetAckedSeq() uint64 {
+	return c.getReliable().acked()
+}
+
+// 将proto消息序列化之后，通过SendMsg发送给客户端
+func (c *Connection) SendProto(msgId uint32, msg proto.Message) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.SendMsg(msgId, data)
+}
+
+// 使用msgId对应的序列化方式将v序列化之后，通过SendMsg发送给客户端
+func (c *Connection) SendValue(msgId uint32, v interface{}) error {
+	data, err := c.GetCodec(msgId).Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.SendMsg(msgId, data)
+}
+
 //将数据发送给缓冲队列，通过专门从缓冲队列读数据的go routine写给客户端
 func (c *Connection) SendBuffMsg(msgId uint32, data []byte) error {
-	if c.isClosed {
-		return errors.New("Connection closed when send buff msg")
+	if c.closed() {
+		return c.sendToSession(msgId, data, errors.New("Connection closed when send buff msg"))
+	}
+	if c.secureRequired && c.getSecure() == nil {
+		return errors.New("Secure channel not established when send buff msg")
 	}
 	// 将data封包，并且发送
-	dp := NewDataPack()
-	msg, err := dp.Pack(NewMsgPackage(msgId, data))
+	msg, err := c.packMsg(msgId, data)
 	if err != nil {
-		fmt.Println("Pack error msg id = ", msgId)
+		fmt.Println("Pack error msg id = ", msgId, " err = ", err)
 		return errors.New("Pack error msg ")
 	}
 
-	// 写进消息管道
-	c.msgBuffChan <- msg
+	return c.sendBuffFrames(msg)
+}
+
+// 将已经封包好的数据发送给缓冲队列，广播时frames被多个连接共享，不能被修改
+func (c *Connection) sendBuffFrames(frames []byte) error {
+	if c.closed() {
+		return errors.New("Connection closed when send buff msg")
+	}
+	if c.secureRequired && c.getSecure() == nil {
+		return errors.New("Secure channel not established when send buff msg")
+	}
+
+	// 写进消息管道，如果连接在等待期间被关闭则放弃发送
+	select {
+	case c.msgBuffChan <- frames:
+	case <-c.ExitBuffChan:
+		return errors.New("Connection closed when send buff msg")
+	}
 
 	return nil
 }
 
+// 已经关闭的连接仍然绑定着会话时，消息交给会话暂存或者转发给会话的新连接，否则返回closedErr
+func (c *Connection) sendToSession(msgId uint32, data []byte, closedErr error) error {
+	sessionID := c.GetSessionID()
+	if sessionID == "" {
+		return closedErr
+	}
+	sessionMgr, ok := c.TcpServer.GetSessionMgr().(*SessionManager)
+	if !ok {
+		return closedErr
+	}
+	return sessionMgr.queue(c, sessionID, msgId, data)
+}
+
+// 已经关闭的连接仍然绑定着会话时，会话已经绑定到新的连接则转发给新的连接，会话等待恢复则不发送，否则返回closedErr
+func (c *Connection) forwardToSession(msgId uint32, data []byte, closedErr error) error {
+	sessionID := c.GetSessionID()
+	if sessionID == "" {
+		return closedErr
+	}
+	sessionMgr, ok := c.TcpServer.GetSessionMgr().(*SessionManager)
+	if !ok {
+		return closedErr
+	}
+	return sessionMgr.forward(c, sessionID, msgId, data)
+}
+
 // 设置链接属性
 func (c *Connection) SetProperty(key string, value interface{}) {
 	c.propertyLock.Lock()
@@ -312,6 +1115,18 @@ func (c *Connection) GetProperty(key string) (interface{}, error) {
 	}
 }
 
+// 获取全部链接属性的副本
+func (c *Connection) getProperties() map[string]interface{} {
+	c.propertyLock.RLock()
+	defer c.propertyLock.RUnlock()
+
+	properties := make(map[string]interface{}, len(c.property))
+	for key, value := range c.property {
+		properties[key] = value
+	}
+	return properties
+}
+
 // 移除链接属性
 func (c *Connection) RemoveProperty(key string) {
 	c.propertyLock.Lock()
@@ -319,3 +1134,11 @@ func (c *Connection) RemoveProperty(key string) {
 
 	delete(c.property, key)
 }
+
+// 获取当前连接的限流统计信息
+func (c *Connection) GetRateLimitStats() tiface.RateLimitStats {
+	if c.limiter == nil {
+		return tiface.RateLimitStats{}
+	}
+	return c.limiter.GetStats()
+}
@@ -14,16 +14,31 @@ import (
 type ConnManager struct {
 	// 管理的连接信息
 	connections map[uint32]tiface.IConnection
+	// 分组（房间）中的连接信息，分组名称 -> ConnID -> 连接
+	groups map[string]map[uint32]tiface.IConnection
+	// 每个连接加入的分组，ConnID -> 分组名称
+	connGroups map[uint32]map[string]struct{}
 	// 读写连接的读写锁
 	connLock sync.RWMutex
 }
 
+/*
+	支持发送已经封包好的数据的连接，广播时相同packKey的连接共享一次封包的结果
+*/
+type framedConn interface {
+	packKey() packKey
+	packMsg(msgId uint32, data []byte) ([]byte, error)
+	sendBuffFrames(frames []byte) error
+}
+
 /*
 	创建一个链接管理
 */
 func NewConnManager() *ConnManager {
 	return &ConnManager{
 		connections: make(map[uint32]tiface.IConnection),
+		groups:      make(map[string]map[uint32]tiface.IConnection),
+		connGroups:  make(map[uint32]map[string]struct{}),
 	}
 }
 
@@ -48,6 +63,11 @@ func (connMgr *ConnManager) Remove(conn tiface.IConnection) {
 	// 删除连接信息
 	delete(connMgr.connections, conn.GetConnID())
 
+	// 将连接移出加入的全部分组
+	for group := range connMgr.connGroups[conn.GetConnID()] {
+		connMgr.leaveGroup(group, conn.GetConnID())
+	}
+
 	fmt.Println("connection withcConnID = ", conn.GetConnID(), "has been removed from ConnManager successfully: conn num = ", connMgr.Len())
 }
 
@@ -71,17 +91,162 @@ func (connMgr *ConnManager) Len() int {
 
 // 清除并停止所有连接
 func (connMgr *ConnManager) ClearConn() {
-	// 保护共享资源Map 加写锁
-	connMgr.connLock.Lock()
-	defer connMgr.connLock.Unlock()
+	// 先在锁内取出全部连接，再在锁外停止，连接的Stop会调用Remove加写锁
+	connMgr.connLock.RLock()
+	conns := make([]tiface.IConnection, 0, len(connMgr.connections))
+	for _, conn := range connMgr.connections {
+		conns = append(conns, conn)
+	}
+	connMgr.connLock.RUnlock()
 
-	// 停止全部链接并删除全部的连接信息
-	for connID, conn := range connMgr.connections {
-		// 停止
+	// 停止全部链接，Stop会将连接从ConnManager中删除
+	for _, conn := range conns {
 		conn.Stop()
-		// 删除
-		delete(connMgr.connections, connID)
 	}
 
+	// 删除没有通过Stop删除的连接信息
+	connMgr.connLock.Lock()
+	for _, conn := range conns {
+		delete(connMgr.connections, conn.GetConnID())
+		for group := range connMgr.connGroups[conn.GetConnID()] {
+			connMgr.leaveGroup(group, conn.GetConnID())
+		}
+	}
+	connMgr.connLock.Unlock()
+
 	fmt.Println("Clear All Connections successfully: conn num = ", connMgr.Len())
 }
+
+// 将连接加入分组（房间），分组不存在时自动创建
+func (connMgr *ConnManager) JoinGroup(group string, conn tiface.IConnection) {
+	connMgr.connLock.Lock()
+	defer connMgr.connLock.Unlock()
+
+	// 已经被删除的连接不能再加入分组，避免分组中残留已经关闭的连接
+	if _, ok := connMgr.connections[conn.GetConnID()]; !ok {
+		return
+	}
+
+	members, ok := connMgr.groups[group]
+	if !ok {
+		members = make(map[uint32]tiface.IConnection)
+		connMgr.groups[group] = members
+	}
+	members[conn.GetConnID()] = conn
+
+	groups, ok := connMgr.connGroups[conn.GetConnID()]
+	if !ok {
+		groups = make(map[string]struct{})
+		connMgr.connGroups[conn.GetConnID()] = groups
+	}
+	groups[group] = struct{}{}
+}
+
+// 将连接移出分组，分组为空时自动删除
+func (connMgr *ConnManager) LeaveGroup(group string, conn tiface.IConnection) {
+	connMgr.connLock.Lock()
+	defer connMgr.connLock.Unlock()
+
+	connMgr.leaveGroup(group, conn.GetConnID())
+}
+
+// 将连接移出分组，调用者需要持有写锁
+func (connMgr *ConnManager) leaveGroup(group string, connID uint32) {
+	if members, ok := connMgr.groups[group]; ok {
+		delete(members, connID)
+		if len(members) == 0 {
+			delete(connMgr.groups, group)
+		}
+	}
+	if groups, ok := connMgr.connGroups[connID]; ok {
+		delete(groups, group)
+		if len(groups) == 0 {
+			delete(connMgr.connGroups, connID)
+		}
+	}
+}
+
+// 获取分组中的全部连接
+func (connMgr *ConnManager) GetGroup(group string) []tiface.IConnection {
+	connMgr.connLock.RLock()
+	defer connMgr.connLock.RUnlock()
+
+	conns := make([]tiface.IConnection, 0, len(connMgr.groups[group]))
+	for _, conn := range connMgr.groups[group] {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// 获取连接加入的全部分组
+func (connMgr *ConnManager) GetConnGroups(connID uint32) []string {
+	connMgr.connLock.RLock()
+	defer connMgr.connLock.RUnlock()
+
+	groups := make([]string, 0, len(connMgr.connGroups[connID]))
+	for group := range connMgr.connGroups[connID] {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// 给全部连接发送消息，exclude中的ConnID除外
+func (connMgr *ConnManager) Broadcast(msgId uint32, data []byte, exclude ...uint32) error {
+	connMgr.connLock.RLock()
+	conns := make([]tiface.IConnection, 0, len(connMgr.connections))
+	for _, conn := range connMgr.connections {
+		conns = append(conns, conn)
+	}
+	connMgr.connLock.RUnlock()
+
+	return sendToConns(conns, msgId, data, exclude)
+}
+
+// 给分组中的全部连接发送消息，exclude中的ConnID除外
+func (connMgr *ConnManager) Multicast(group string, msgId uint32, data []byte, exclude ...uint32) error {
+	return sendToConns(connMgr.GetGroup(group), msgId, data, exclude)
+}
+
+// 给多个连接发送同一个消息，封包结果相同的连接只封包一次，之后将同一份数据写入每个连接的缓冲队列
+// 封包失败时返回错误；个别连接已经关闭等发送失败不影响其他连接
+func sendToConns(conns []tiface.IConnection, msgId uint32, data []byte, exclude []uint32) error {
+	packed := make(map[packKey][]byte)
+
+	for _, conn := range conns {
+		if isExcluded(conn.GetConnID(), exclude) {
+			continue
+		}
+
+		fc, ok := conn.(framedConn)
+		if !ok {
+			if err := conn.SendBuffMsg(msgId, data); err != nil {
+				fmt.Println("connID = ", conn.GetConnID(), " send msgId = ", msgId, " error: ", err)
+			}
+			continue
+		}
+
+		key := fc.packKey()
+		frames, ok := packed[key]
+		if !ok {
+			var err error
+			if frames, err = fc.packMsg(msgId, data); err != nil {
+				return err
+			}
+			packed[key] = frames
+		}
+		if err := fc.sendBuffFrames(frames); err != nil {
+			fmt.Println("connID = ", conn.GetConnID(), " send msgId = ", msgId, " error: ", err)
+		}
+	}
+	return nil
+}
+
+// 判断ConnID是否在exclude中
+func isExcluded(connID uint32, exclude []uint32) bool {
+	for _, id := range exclude {
+		if id == connID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package tnet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnManagerGroups(t *testing.T) {
+	s := NewServer()
+	connMgr := s.GetConnMgr()
+
+	var conns []*Connection
+	for i := uint32(1); i <= 3; i++ {
+		serverConn, client := newTCPPair(t)
+		defer client.Close()
+		conns = append(conns, NewConnection(s, serverConn, 450+i, s.(*Server).msgHandler))
+	}
+	connMgr.JoinGroup("room", conns[0])
+	connMgr.JoinGroup("room", conns[1])
+	connMgr.JoinGroup("guild", conns[1])
+	require.Len(t, connMgr.GetGroup("room"), 2)
+	require.ElementsMatch(t, []string{"room", "guild"}, connMgr.GetConnGroups(452))
+
+	// 同一个分组中的连接共享同一份封包数据
+	require.NoError(t, connMgr.Multicast("room", 1, []byte("hello")))
+	frames0, frames1 := <-conns[0].msgBuffChan, <-conns[1].msgBuffChan
+	require.Equal(t, &frames0[0], &frames1[0])
+	require.Empty(t, conns[2].msgBuffChan)
+
+	msg, err := readFrame(bytes.NewReader(frames0), NewDataPack())
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(msg.GetData()))
+
+	// 排除指定的连接
+	require.NoError(t, connMgr.Broadcast(2, []byte("all"), 452))
+	require.Len(t, conns[0].msgBuffChan, 1)
+	require.Empty(t, conns[1].msgBuffChan)
+	require.Len(t, conns[2].msgBuffChan, 1)
+
+	// 连接停止之后自动离开全部分组，空分组被删除
+	connMgr.LeaveGroup("room", conns[0])
+	conns[1].Stop()
+	require.Empty(t, connMgr.GetGroup("room"))
+	require.Empty(t, connMgr.GetGroup("guild"))
+	require.Empty(t, connMgr.GetConnGroups(452))
+
+	// 已经停止的连接不能再加入分组
+	connMgr.JoinGroup("room", conns[1])
+	require.Empty(t, connMgr.GetGroup("room"))
+
+	// 清除全部连接不会死锁
+	connMgr.JoinGroup("room", conns[2])
+	done := make(chan struct{})
+	go func() {
+		connMgr.ClearConn()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ClearConn deadlock")
+	}
+	require.Equal(t, 0, connMgr.Len())
+	require.Empty(t, connMgr.GetGroup("room"))
+}
+
+func TestMulticastClients(t *testing.T) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 0
+	utils.GlobalObject.SecureChannel = SecureAESGCM
+
+	s := NewServer()
+	joined := make(chan struct{}, 3)
+	s.SetOnConnStart(func(conn tiface.IConnection) {
+		conn.GetTcpServer().GetConnMgr().JoinGroup("world", conn)
+		joined <- struct{}{}
+	})
+	// 在Router中给分组中的其他连接发送聊天消息
+	s.AddRouter(2, &chatRouter{})
+
+	var clients []*Client
+	for i := uint32(0); i < 3; i++ {
+		client := newTestClient(t, s, 460+i)
+		require.NoError(t, client.Start())
+		<-joined
+		clients = append(clients, client)
+	}
+
+	require.NoError(t, clients[0].SendMsg(2, []byte("hi")))
+	for _, client := range clients[1:] {
+		msg, err := client.ReadMsg()
+		require.NoError(t, err)
+		require.Equal(t, "hi", string(msg.GetData()))
+	}
+}
+
+type chatRouter struct {
+	BaseRouter
+}
+
+func (r *chatRouter) Handle(request tiface.IRequest) {
+	conn := request.GetConnection()
+	conn.GetTcpServer().GetConnMgr().Multicast("world", 2, request.GetData(), conn.GetConnID())
+}
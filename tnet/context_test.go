@@ -0,0 +1,80 @@
+package tnet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+	在PreHandle中设置请求范围内的值，Handle等待请求的context结束之后回复
+*/
+type contextRouter struct {
+	BaseRouter
+	// Handle开始执行时通知测试
+	started chan struct{}
+	// 处理结束之后请求的context
+	finished chan context.Context
+}
+
+func (router *contextRouter) PreHandle(request tiface.IRequest) {
+	request.Set("traceId", "trace-1")
+}
+
+func (router *contextRouter) Handle(request tiface.IRequest) {
+	traceID, _ := request.Get("traceId")
+	router.started <- struct{}{}
+	<-request.Context().Done()
+	request.GetConnection().SendMsg(request.GetMsgID(), []byte(traceID.(string)+" "+request.Context().Err().Error()))
+}
+
+func (router *contextRouter) PostHandle(request tiface.IRequest) {
+	router.finished <- request.Context()
+}
+
+func TestRequestContext(t *testing.T) {
+	conf := *utils.GlobalObject
+	defer func() { *utils.GlobalObject = conf }()
+	utils.GlobalObject.WorkerPoolSize = 0
+
+	s := NewServer()
+	router := &contextRouter{started: make(chan struct{}, 2), finished: make(chan context.Context, 2)}
+	s.AddRouter(1, router)
+	s.AddRouter(2, router)
+	s.SetMsgTimeout(1, 20*time.Millisecond)
+
+	serverConn, client := newTCPPair(t)
+	defer client.Close()
+	conn := NewConnection(s, serverConn, 530, s.(*Server).msgHandler)
+	go conn.Start()
+	defer conn.stopAndWait()
+
+	// 超过msgId的处理超时时间之后请求的context被取消
+	start := time.Now()
+	writeTestMsg(t, client, 1, nil)
+	<-router.started
+	msg := readTestMsg(t, client)
+	require.Equal(t, "trace-1 context deadline exceeded", string(msg.GetData()))
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	<-router.finished
+
+	// 请求的context由连接的context派生，连接停止时被取消
+	writeTestMsg(t, client, 2, nil)
+	<-router.started
+	require.NoError(t, conn.Context().Err())
+	conn.Stop()
+	require.ErrorIs(t, conn.Context().Err(), context.Canceled)
+	ctx := <-router.finished
+	require.ErrorIs(t, ctx.Err(), context.Canceled)
+
+	// 处理结束之后请求的context被取消，请求范围内的值互不影响
+	request := &Request{conn: conn, msg: NewMsgPackage(3, nil)}
+	_, ok := request.Get("traceId")
+	require.False(t, ok)
+	s.(*Server).msgHandler.DoMsgHandler(request)
+	require.ErrorIs(t, request.Context().Err(), context.Canceled)
+}
@@ -4,11 +4,30 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
 
 	"github.com/HOU-SZ/tigerkin/tiface"
 	"github.com/HOU-SZ/tigerkin/utils"
 )
 
+// 消息校验失败时的处理策略
+const (
+	FrameErrorClose  = "close"  // 关闭连接
+	FrameErrorResync = "resync" // 丢弃损坏的消息，通过同步标记找到下一个消息的开头
+)
+
+var (
+	// 消息损坏：同步标记不匹配、长度超出限制或校验和不匹配
+	ErrFrameCorrupt = errors.New("corrupt frame")
+	// 校验和不匹配，此时消息头部的长度已经被读取，可以跳过整个消息
+	errFrameChecksum = fmt.Errorf("%w: checksum mismatch", ErrFrameCorrupt)
+)
+
+// 计算校验和使用的CRC32C表
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
 // 封包拆包类，暂时不需要成员
 type DataPack struct{}
 
@@ -20,7 +39,24 @@ func NewDataPack() *DataPack {
 // 获取包头长度方法
 func (dp *DataPack) GetHeadLen() uint32 {
 	//DataLen uint32(4字节) +  ID uint32(4字节)
-	return 8
+	headLen := uint32(8)
+	if utils.GlobalObject.FrameFlags {
+		//开启FrameFlags时，再加上 Flags byte(1字节)
+		headLen += 1
+	}
+	if utils.GlobalObject.FrameChecksum {
+		//开启FrameChecksum时，再加上 Checksum uint32(4字节)
+		headLen += 4
+	}
+	return headLen + frameMagicLen()
+}
+
+// 消息开头的同步标记长度
+func frameMagicLen() uint32 {
+	if utils.GlobalObject.FrameMagic != 0 {
+		return 2
+	}
+	return 0
 }
 
 // 封包方法(压缩数据)
@@ -28,6 +64,13 @@ func (dp *DataPack) Pack(msg tiface.IMessage) ([]byte, error) {
 	// 创建一个存放bytes字节的缓冲
 	dataBuff := bytes.NewBuffer([]byte{})
 
+	// 配置了同步标记时，将magic 写进dataBuff中
+	if utils.GlobalObject.FrameMagic != 0 {
+		if err := binary.Write(dataBuff, binary.LittleEndian, utils.GlobalObject.FrameMagic); err != nil {
+			return nil, err
+		}
+	}
+
 	// 将dataLen 写进dataBuff中
 	if err := binary.Write(dataBuff, binary.LittleEndian, msg.GetDataLen()); err != nil {
 		return nil, err
@@ -38,6 +81,22 @@ func (dp *DataPack) Pack(msg tiface.IMessage) ([]byte, error) {
 		return nil, err
 	}
 
+	// 开启FrameFlags时，将flags 写进dataBuff中
+	if utils.GlobalObject.FrameFlags {
+		if err := dataBuff.WriteByte(msg.GetFlags()); err != nil {
+			return nil, err
+		}
+	}
+
+	// 开启FrameChecksum时，计算头部（不含magic）和data的CRC32C，写进dataBuff中
+	if utils.GlobalObject.FrameChecksum {
+		checksum := crc32.Checksum(dataBuff.Bytes()[frameMagicLen():], crc32cTable)
+		checksum = crc32.Update(checksum, crc32cTable, msg.GetData())
+		if err := binary.Write(dataBuff, binary.LittleEndian, checksum); err != nil {
+			return nil, err
+		}
+	}
+
 	// 将data数据 写进dataBuff中
 	if err := binary.Write(dataBuff, binary.LittleEndian, msg.GetData()); err != nil {
 		return nil, err
@@ -56,6 +115,17 @@ func (dp *DataPack) Unpack(binaryData []byte) (tiface.IMessage, error) {
 	// 只解压head的信息，得到dataLen和msgID
 	msg := &Message{}
 
+	// 配置了同步标记时，读magic并校验
+	if utils.GlobalObject.FrameMagic != 0 {
+		var magic uint16
+		if err := binary.Read(dataBuff, binary.LittleEndian, &magic); err != nil {
+			return nil, err
+		}
+		if magic != utils.GlobalObject.FrameMagic {
+			return nil, fmt.Errorf("%w: magic mismatch", ErrFrameCorrupt)
+		}
+	}
+
 	// 读dataLen
 	if err := binary.Read(dataBuff, binary.LittleEndian, &msg.DataLen); err != nil {
 		return nil, err
@@ -66,11 +136,58 @@ func (dp *DataPack) Unpack(binaryData []byte) (tiface.IMessage, error) {
 		return nil, err
 	}
 
+	// 开启FrameFlags时，读flags
+	if utils.GlobalObject.FrameFlags {
+		if err := binary.Read(dataBuff, binary.LittleEndian, &msg.Flags); err != nil {
+			return nil, err
+		}
+	}
+
 	// 判断dataLen的长度是否超出我们允许的最大包长度
 	if utils.GlobalObject.MaxPacketSize > 0 && msg.DataLen > utils.GlobalObject.MaxPacketSize {
-		return nil, errors.New("too large msg data recieved")
+		return nil, fmt.Errorf("%w: too large msg data recieved", ErrFrameCorrupt)
 	}
 
+	// 校验和需要读取data之后才能校验，见checkFrame
+
 	// 这里只需要把head的数据拆包出来就可以了，然后再通过head的长度，再从conn读取一次数据
 	return msg, nil
 }
+
+// 校验一个完整消息（头部和data）的校验和，未开启FrameChecksum时不校验
+func (dp *DataPack) checkFrame(frame []byte) error {
+	if !utils.GlobalObject.FrameChecksum {
+		return nil
+	}
+	headLen := dp.GetHeadLen()
+	if uint32(len(frame)) < headLen {
+		return errors.New("truncated frame")
+	}
+	checksum := crc32.Checksum(frame[frameMagicLen():headLen-4], crc32cTable)
+	checksum = crc32.Update(checksum, crc32cTable, frame[headLen:])
+	if checksum != binary.LittleEndian.Uint32(frame[headLen-4:headLen]) {
+		return errFrameChecksum
+	}
+	return nil
+}
+
+/*
+	完整消息校验接口，DataPack实现该接口对头部和data一起进行校验
+*/
+type frameChecker interface {
+	checkFrame(frame []byte) error
+}
+
+// 从r中读取一个完整的消息：先读取并拆包head，再根据dataLen读取data，不进行重新同步
+func readFrame(r io.Reader, dp tiface.IDataPack) (tiface.IMessage, error) {
+	msg, _, err := newFrameReader(r).readFrame(dp)
+	return msg, err
+}
+
+// 如果dp实现了IDataUnpacker，调用其对消息体进行还原
+func unpackData(dp tiface.IDataPack, msg tiface.IMessage) error {
+	if unpacker, ok := dp.(tiface.IDataUnpacker); ok {
+		return unpacker.UnpackData(msg)
+	}
+	return nil
+}
@@ -5,6 +5,9 @@ import (
 	"io"
 	"net"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
 )
 
 // 测试datapack拆包，封包功能的单元测试
@@ -13,11 +16,12 @@ func TestDataPack(t *testing.T) {
 		模拟服务器
 	*/
 	// 创建socket TCP Server
-	listener, err := net.Listen("tcp", "127.0.0.1:7777")
-	if err != nil {
-		fmt.Println("server listen error: ", err)
-		return
-	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// 服务器拆包得到的消息
+	received := make(chan *Message, 2)
 
 	// 创建服务器goroutine，负责从客户端goroutine读取粘包的数据，然后进行解析
 	go func() {
@@ -25,6 +29,7 @@ func TestDataPack(t *testing.T) {
 			conn, err := listener.Accept()
 			if err != nil {
 				fmt.Println("server accept error: ", err)
+				return
 			}
 
 			//处理客户端请求
@@ -61,6 +66,7 @@ func TestDataPack(t *testing.T) {
 						}
 
 						fmt.Println("==> Recv Msg: ID=", msg.Id, ", dataLen=", msg.DataLen, ", data=", string(msg.Data))
+						received <- msg
 					}
 				}
 			}(conn)
@@ -72,11 +78,9 @@ func TestDataPack(t *testing.T) {
 		模拟客户端
 	*/
 	// 客户端goroutine，负责模拟粘包的数据，然后进行发送
-	conn, err := net.Dial("tcp", "127.0.0.1:7777")
-	if err != nil {
-		fmt.Println("client dial err:", err)
-		return
-	}
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
 
 	// 创建一个封包对象 dp
 	dp := NewDataPack()
@@ -90,10 +94,7 @@ func TestDataPack(t *testing.T) {
 	}
 
 	sendData1, err := dp.Pack(msg1) // 封包成二进制数据
-	if err != nil {
-		fmt.Println("client pack msg1 error: ", err)
-		return
-	}
+	require.NoError(t, err)
 
 	// 封装第二个msg包
 	msg2 := &Message{
@@ -102,17 +103,24 @@ func TestDataPack(t *testing.T) {
 		Data:    []byte{'t', 'i', 'g', 'e', 'r', 'k', 'i', 'n'},
 	}
 	sendData2, err := dp.Pack(msg2) // 封包成二进制数据
-	if err != nil {
-		fmt.Println("client pack msg2 error: ", err)
-		return
-	}
+	require.NoError(t, err)
 
 	// 将两个sendData1和sendData2拼接一起，组成粘包
 	sendData1 = append(sendData1, sendData2...)
 
 	// 一次性发给服务器端
-	conn.Write(sendData1)
-
-	// 客户端阻塞
-	select {}
+	_, err = conn.Write(sendData1)
+	require.NoError(t, err)
+
+	// 服务器依次拆出两个msg
+	for _, expect := range []*Message{msg1, msg2} {
+		select {
+		case msg := <-received:
+			require.Equal(t, expect.Id, msg.Id)
+			require.Equal(t, expect.DataLen, msg.DataLen)
+			require.Equal(t, expect.Data, msg.Data)
+		case <-time.After(3 * time.Second):
+			t.Fatal("server did not receive msg")
+		}
+	}
 }
@@ -0,0 +1,347 @@
+package tnet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+)
+
+var ErrNoInstance = errors.New("no service instance")
+
+// 检查服务实例的必填字段
+func checkInstance(instance tiface.ServiceInstance) error {
+	if instance.Name == "" || instance.ID == "" || instance.Addr == "" {
+		return fmt.Errorf("service instance requires name, id and addr: %+v", instance)
+	}
+	return nil
+}
+
+// 按照ID排序服务实例
+func sortInstances(instances []tiface.ServiceInstance) []tiface.ServiceInstance {
+	sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+	return instances
+}
+
+// 发送最新的实例列表，监听者还没有取走的旧列表被丢弃，调用方保证同一个管道只有一个发送者
+func sendLatest(ch chan []tiface.ServiceInstance, instances []tiface.ServiceInstance) {
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- instances
+}
+
+// 使用最高随机权重（rendezvous hash）为key选择实例：同一个key总是选择同一个实例，
+// 实例列表变化时只有选择了被删除的实例或者新加入的实例得分更高的key会改变选择
+func pickInstance(instances []tiface.ServiceInstance, key string) (tiface.ServiceInstance, error) {
+	var best tiface.ServiceInstance
+	var bestScore uint64
+	for i, instance := range instances {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(instance.ID))
+		if score := h.Sum64(); i == 0 || score > bestScore {
+			best, bestScore = instance, score
+		}
+	}
+	if len(instances) == 0 {
+		return best, ErrNoInstance
+	}
+	return best, nil
+}
+
+/*
+	内存中的服务发现，适用于同一个进程中的节点以及静态配置的实例列表
+*/
+type MemoryDiscovery struct {
+	// 服务名称 -> 实例ID -> 实例
+	services map[string]map[string]tiface.ServiceInstance
+	// 服务名称 -> 监听者
+	watchers map[string]map[chan []tiface.ServiceInstance]struct{}
+	// 保护services和watchers
+	lock sync.Mutex
+}
+
+// 创建内存中的服务发现，可以传入静态的实例列表
+func NewMemoryDiscovery(instances ...tiface.ServiceInstance) *MemoryDiscovery {
+	d := &MemoryDiscovery{
+		services: make(map[string]map[string]tiface.ServiceInstance),
+		watchers: make(map[string]map[chan []tiface.ServiceInstance]struct{}),
+	}
+	for _, instance := range instances {
+		if err := d.Register(instance); err != nil {
+			panic(err)
+		}
+	}
+	return d
+}
+
+// 注册服务实例
+func (d *MemoryDiscovery) Register(instance tiface.ServiceInstance) error {
+	if err := checkInstance(instance); err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	instances, ok := d.services[instance.Name]
+	if !ok {
+		instances = make(map[string]tiface.ServiceInstance)
+		d.services[instance.Name] = instances
+	}
+	instances[instance.ID] = instance
+	d.notify(instance.Name)
+	return nil
+}
+
+// 注销服务实例
+func (d *MemoryDiscovery) Deregister(name, id string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if _, ok := d.services[name][id]; !ok {
+		return fmt.Errorf("%w: %s/%s", ErrNoInstance, name, id)
+	}
+	delete(d.services[name], id)
+	d.notify(name)
+	return nil
+}
+
+// 获取服务的全部实例
+func (d *MemoryDiscovery) GetInstances(name string) ([]tiface.ServiceInstance, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.instances(name), nil
+}
+
+// 监听服务的实例列表
+func (d *MemoryDiscovery) Watch(ctx context.Context, name string) (<-chan []tiface.ServiceInstance, error) {
+	ch := make(chan []tiface.ServiceInstance, 1)
+
+	d.lock.Lock()
+	watchers, ok := d.watchers[name]
+	if !ok {
+		watchers = make(map[chan []tiface.ServiceInstance]struct{})
+		d.watchers[name] = watchers
+	}
+	watchers[ch] = struct{}{}
+	ch <- d.instances(name)
+	d.lock.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		d.lock.Lock()
+		delete(d.watchers[name], ch)
+		close(ch)
+		d.lock.Unlock()
+	}()
+	return ch, nil
+}
+
+// 服务的全部实例，调用时需要持有锁
+func (d *MemoryDiscovery) instances(name string) []tiface.ServiceInstance {
+	instances := make([]tiface.ServiceInstance, 0, len(d.services[name]))
+	for _, instance := range d.services[name] {
+		instances = append(instances, instance)
+	}
+	return sortInstances(instances)
+}
+
+// 通知服务的全部监听者，调用时需要持有锁
+func (d *MemoryDiscovery) notify(name string) {
+	for ch := range d.watchers[name] {
+		sendLatest(ch, d.instances(name))
+	}
+}
+
+/*
+	基于文件的服务发现，适用于同一台机器上的多个进程组成的本地集群
+	文件内容为JSON：服务名称 -> 实例列表，注册和注销时加锁修改文件，监听时定时检查文件内容的变化
+*/
+type FileDiscovery struct {
+	// 实例列表文件的路径
+	path string
+	// 检查文件变化的间隔
+	interval time.Duration
+	// 保护同一个进程中对文件的修改，进程之间通过锁文件互斥
+	lock sync.Mutex
+}
+
+// 创建基于文件的服务发现，interval为检查文件变化的间隔，不大于0时为1秒
+func NewFileDiscovery(path string, interval time.Duration) *FileDiscovery {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &FileDiscovery{path: path, interval: interval}
+}
+
+// 注册服务实例
+func (d *FileDiscovery) Register(instance tiface.ServiceInstance) error {
+	if err := checkInstance(instance); err != nil {
+		return err
+	}
+	return d.update(func(services map[string][]tiface.ServiceInstance) error {
+		instances := services[instance.Name]
+		for i := range instances {
+			if instances[i].ID == instance.ID {
+				instances[i] = instance
+				return nil
+			}
+		}
+		services[instance.Name] = append(instances, instance)
+		return nil
+	})
+}
+
+// 注销服务实例
+func (d *FileDiscovery) Deregister(name, id string) error {
+	return d.update(func(services map[string][]tiface.ServiceInstance) error {
+		instances := services[name]
+		for i := range instances {
+			if instances[i].ID == id {
+				services[name] = append(instances[:i], instances[i+1:]...)
+				if len(services[name]) == 0 {
+					delete(services, name)
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s/%s", ErrNoInstance, name, id)
+	})
+}
+
+// 获取服务的全部实例
+func (d *FileDiscovery) GetInstances(name string) ([]tiface.ServiceInstance, error) {
+	services, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	return d.instances(services, name), nil
+}
+
+// 监听服务的实例列表，文件读取失败时保留之前的列表
+func (d *FileDiscovery) Watch(ctx context.Context, name string) (<-chan []tiface.ServiceInstance, error) {
+	last, err := d.GetInstances(name)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan []tiface.ServiceInstance, 1)
+	ch <- last
+
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			instances, err := d.GetInstances(name)
+			if err != nil {
+				fmt.Println("File discovery read ", d.path, " error: ", err)
+				continue
+			}
+			if !reflect.DeepEqual(instances, last) {
+				last = instances
+				sendLatest(ch, instances)
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// 服务的全部实例，文件中的实例没有填写服务名称时使用name
+func (d *FileDiscovery) instances(services map[string][]tiface.ServiceInstance, name string) []tiface.ServiceInstance {
+	instances := make([]tiface.ServiceInstance, 0, len(services[name]))
+	for _, instance := range services[name] {
+		instance.Name = name
+		instances = append(instances, instance)
+	}
+	return sortInstances(instances)
+}
+
+// 读取实例列表文件，文件不存在时为空
+func (d *FileDiscovery) load() (map[string][]tiface.ServiceInstance, error) {
+	services := make(map[string][]tiface.ServiceInstance)
+	data, err := os.ReadFile(d.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return services, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return services, nil
+	}
+	if err := json.Unmarshal(data, &services); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+// 加锁之后读取实例列表，修改之后写入临时文件再替换，监听者不会读到写了一半的文件
+func (d *FileDiscovery) update(modify func(services map[string][]tiface.ServiceInstance) error) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	unlock, err := d.lockFile()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	services, err := d.load()
+	if err != nil {
+		return err
+	}
+	if err := modify(services); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(services, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.path)
+}
+
+// 创建锁文件实现进程之间的互斥，超过5秒没有释放的锁文件视为持有者已经退出
+func (d *FileDiscovery) lockFile() (func(), error) {
+	lockPath := d.path + ".lock"
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > 5*time.Second {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("lock %s timeout", lockPath)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
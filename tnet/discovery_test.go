@@ -0,0 +1,191 @@
+package tnet
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// 实例列表中的实例ID
+func instanceIDs(instances []tiface.ServiceInstance) []string {
+	ids := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		ids = append(ids, instance.ID)
+	}
+	return ids
+}
+
+// 读取监听到的下一个实例列表
+func nextInstances(t *testing.T, ch <-chan []tiface.ServiceInstance) []string {
+	select {
+	case instances := <-ch:
+		return instanceIDs(instances)
+	case <-time.After(3 * time.Second):
+		t.Fatal("instances not changed")
+		return nil
+	}
+}
+
+func TestMemoryDiscovery(t *testing.T) {
+	d := NewMemoryDiscovery(tiface.ServiceInstance{ID: "b", Name: "game", Addr: "127.0.0.1:9002"})
+	require.NoError(t, d.Register(tiface.ServiceInstance{ID: "a", Name: "game", Addr: "127.0.0.1:9001"}))
+	require.Error(t, d.Register(tiface.ServiceInstance{ID: "c", Name: "game"}))
+
+	instances, err := d.GetInstances("game")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, instanceIDs(instances))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := d.Watch(ctx, "game")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, nextInstances(t, ch))
+	require.NoError(t, d.Register(tiface.ServiceInstance{ID: "c", Name: "game", Addr: "127.0.0.1:9003"}))
+	require.Equal(t, []string{"a", "b", "c"}, nextInstances(t, ch))
+	require.NoError(t, d.Deregister("game", "a"))
+	require.Equal(t, []string{"b", "c"}, nextInstances(t, ch))
+	require.ErrorIs(t, d.Deregister("game", "a"), ErrNoInstance)
+
+	// 其他服务的变化不通知
+	require.NoError(t, d.Register(tiface.ServiceInstance{ID: "a", Name: "chat", Addr: "127.0.0.1:9101"}))
+	cancel()
+	_, ok := <-ch
+	require.False(t, ok)
+}
+
+func TestFileDiscovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.json")
+	// 两个实例模拟使用同一个文件的两个进程
+	d1 := NewFileDiscovery(path, 5*time.Millisecond)
+	d2 := NewFileDiscovery(path, 5*time.Millisecond)
+
+	instances, err := d2.GetInstances("game")
+	require.NoError(t, err)
+	require.Empty(t, instances)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := d2.Watch(ctx, "game")
+	require.NoError(t, err)
+	require.Empty(t, nextInstances(t, ch))
+
+	require.NoError(t, d1.Register(tiface.ServiceInstance{ID: "a", Name: "game", Addr: "127.0.0.1:9001"}))
+	require.Equal(t, []string{"a"}, nextInstances(t, ch))
+	require.NoError(t, d1.Register(tiface.ServiceInstance{ID: "b", Name: "game", Addr: "127.0.0.1:9002", Metadata: map[string]string{"zone": "1"}}))
+	require.Equal(t, []string{"a", "b"}, nextInstances(t, ch))
+	require.NoError(t, d2.Deregister("game", "a"))
+	require.Equal(t, []string{"b"}, nextInstances(t, ch))
+	require.ErrorIs(t, d1.Deregister("game", "a"), ErrNoInstance)
+
+	instances, err = d1.GetInstances("game")
+	require.NoError(t, err)
+	require.Equal(t, []tiface.ServiceInstance{{ID: "b", Name: "game", Addr: "127.0.0.1:9002", Metadata: map[string]string{"zone": "1"}}}, instances)
+	cancel()
+	for range ch {
+	}
+
+	// 多个进程同时注册时不会丢失修改
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d := d1
+			if i%2 == 1 {
+				d = NewFileDiscovery(path, 0)
+			}
+			require.NoError(t, d.Register(tiface.ServiceInstance{ID: fmt.Sprintf("chat-%02d", i), Name: "chat", Addr: "127.0.0.1:9100"}))
+		}(i)
+	}
+	wg.Wait()
+	instances, err = d2.GetInstances("chat")
+	require.NoError(t, err)
+	require.Len(t, instances, 20)
+
+	// 手工编写的文件可以省略服务名称
+	require.NoError(t, os.WriteFile(path, []byte(`{"game": [{"id": "x", "addr": "127.0.0.1:9009"}]}`), 0644))
+	instances, err = d1.GetInstances("game")
+	require.NoError(t, err)
+	require.Equal(t, []tiface.ServiceInstance{{ID: "x", Name: "game", Addr: "127.0.0.1:9009"}}, instances)
+}
+
+func TestServiceClient(t *testing.T) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 0
+
+	serverA, serverB := NewServer(), NewServer()
+	serverA.AddRouter(1, &EchoRouter{})
+	serverB.AddRouter(1, &EchoRouter{})
+	a := tiface.ServiceInstance{ID: "a", Name: "echo", Addr: listenServer(t, serverA, 570)}
+	b := tiface.ServiceInstance{ID: "b", Name: "echo", Addr: listenServer(t, serverB, 575)}
+
+	// 找到一个在a和b都存在时选择b的key
+	key := ""
+	for i := 0; ; i++ {
+		key = fmt.Sprintf("player-%d", i)
+		if instance, _ := pickInstance([]tiface.ServiceInstance{a, b}, key); instance.ID == "b" {
+			break
+		}
+	}
+	instance, err := pickInstance([]tiface.ServiceInstance{b, a}, key)
+	require.NoError(t, err)
+	require.Equal(t, "b", instance.ID)
+
+	d := NewMemoryDiscovery(a)
+	client := NewServiceClient(d, "echo")
+	client.SetBalanceKey(key)
+	defer client.Stop()
+	echo := func() error {
+		if err := client.SendMsg(1, []byte("ping")); err != nil {
+			return err
+		}
+		_, err := client.ReadMsg()
+		return err
+	}
+
+	require.NoError(t, client.Start())
+	require.NoError(t, echo())
+	_, err = serverA.GetConnMgr().Get(570)
+	require.NoError(t, err)
+
+	// 加入了更合适的实例，关闭连接之后重新连接到新的实例
+	require.NoError(t, d.Register(b))
+	_, err = client.ReadMsg()
+	require.Error(t, err)
+	require.NoError(t, client.Start())
+	require.NoError(t, echo())
+	_, err = serverB.GetConnMgr().Get(575)
+	require.NoError(t, err)
+
+	// 当前实例下线
+	require.NoError(t, d.Deregister("echo", "b"))
+	_, err = client.ReadMsg()
+	require.Error(t, err)
+	require.NoError(t, client.Start())
+	require.NoError(t, echo())
+	_, err = serverA.GetConnMgr().Get(571)
+	require.NoError(t, err)
+
+	// 加入的实例不比当前实例更合适时不影响当前连接
+	other := tiface.ServiceInstance{Name: "echo", Addr: "127.0.0.1:1"}
+	for i := 0; ; i++ {
+		other.ID = fmt.Sprintf("c-%d", i)
+		if instance, _ := pickInstance([]tiface.ServiceInstance{a, other}, key); instance.ID == "a" {
+			break
+		}
+	}
+	require.NoError(t, d.Register(other))
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, echo())
+
+	require.NoError(t, d.Deregister("echo", "a"))
+	require.NoError(t, d.Deregister("echo", other.ID))
+	require.ErrorIs(t, client.Start(), ErrNoInstance)
+}
@@ -0,0 +1,150 @@
+package tnet
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+)
+
+// 消息分片相关的标志位
+const (
+	FlagFragment    byte = 0x10 // 当前消息是一个大消息的分片
+	FlagFragmentEnd byte = 0x20 // 当前分片是大消息的最后一个分片
+)
+
+// 计算单个消息数据段允许的最大长度，加密通道会额外占用SecureOverhead字节，0表示不限制
+func maxFrameDataLen(secure bool) uint32 {
+	maxLen := utils.GlobalObject.MaxPacketSize
+	if maxLen > 0 && secure {
+		if maxLen <= SecureOverhead {
+			return 1
+		}
+		maxLen -= SecureOverhead
+	}
+	return maxLen
+}
+
+// 封包消息，数据超过maxDataLen时将其拆分为多个分片，依次封包后拼接在一起，保证分片在连接上连续发送
+func packFragments(dp tiface.IDataPack, msg tiface.IMessage, maxDataLen uint32) ([]byte, error) {
+	if maxDataLen == 0 || msg.GetDataLen() <= maxDataLen {
+		return dp.Pack(msg)
+	}
+	if !utils.GlobalObject.FrameFlags {
+		return nil, fmt.Errorf("msg data len = %d is too large, enable FrameFlags to send it in fragments", msg.GetDataLen())
+	}
+	if utils.GlobalObject.MaxMsgSize > 0 && msg.GetDataLen() > utils.GlobalObject.MaxMsgSize {
+		return nil, fmt.Errorf("msg data len = %d exceeds MaxMsgSize", msg.GetDataLen())
+	}
+
+	var frames []byte
+	data := msg.GetData()
+	for len(data) > 0 {
+		n := maxDataLen
+		if uint32(len(data)) < n {
+			n = uint32(len(data))
+		}
+		fragment := &Message{
+			Id:      msg.GetMsgId(),
+			DataLen: n,
+			Data:    data[:n],
+			Flags:   msg.GetFlags() | FlagFragment,
+		}
+		data = data[n:]
+		if len(data) == 0 {
+			fragment.Flags |= FlagFragmentEnd
+		}
+
+		frame, err := dp.Pack(fragment)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame...)
+	}
+	return frames, nil
+}
+
+/*
+	分片重组，将连续收到的分片拼接为完整的消息，总长度不能超过MaxMsgSize
+*/
+type fragmentAssembler struct {
+	// 正在重组的消息，为nil表示当前没有正在重组的消息
+	msg tiface.IMessage
+	// 已经收到的数据
+	data []byte
+	// 是否正在丢弃不完整消息的剩余分片
+	discarding bool
+}
+
+// 丢弃正在重组的消息，并丢弃之后连续收到的分片，直到最后一个分片或者非分片消息
+func (fa *fragmentAssembler) discard() {
+	fa.msg, fa.data = nil, nil
+	fa.discarding = true
+}
+
+// 判断分片是否属于被丢弃的消息
+func (fa *fragmentAssembler) skip(fragment tiface.IMessage) bool {
+	if !fa.discarding {
+		return false
+	}
+	if fragment.GetFlags()&FlagFragmentEnd != 0 {
+		fa.discarding = false
+	}
+	return true
+}
+
+// 加入一个分片，收到最后一个分片时返回完整的消息，否则返回nil
+func (fa *fragmentAssembler) add(fragment tiface.IMessage) (tiface.IMessage, error) {
+	if fa.msg == nil {
+		fa.msg = fragment
+		fa.data = nil
+	} else if fa.msg.GetMsgId() != fragment.GetMsgId() {
+		return nil, fmt.Errorf("fragment msgId = %d interleaved with msgId = %d", fragment.GetMsgId(), fa.msg.GetMsgId())
+	}
+
+	if utils.GlobalObject.MaxMsgSize > 0 && uint32(len(fa.data))+fragment.GetDataLen() > utils.GlobalObject.MaxMsgSize {
+		fa.msg = nil
+		return nil, errors.New("too large fragmented msg recieved")
+	}
+	fa.data = append(fa.data, fragment.GetData()...)
+
+	if fragment.GetFlags()&FlagFragmentEnd == 0 {
+		return nil, nil
+	}
+
+	msg := fa.msg
+	msg.SetData(fa.data)
+	msg.SetDataLen(uint32(len(fa.data)))
+	msg.SetFlags(msg.GetFlags() &^ (FlagFragment | FlagFragmentEnd))
+	fa.msg, fa.data = nil, nil
+	return msg, nil
+}
+
+/*
+	流式消息体，Reader goroutine将收到的分片写入管道，Router从管道中逐块读取
+	被压缩的消息在第一次读取时创建解压Reader，边读边解压
+*/
+type streamBody struct {
+	// 管道的读取端
+	pipe *io.PipeReader
+	// 消息使用的压缩算法，为nil表示未压缩
+	decompressor tiface.IStreamDecompressor
+	// 实际读取数据的Reader
+	reader io.Reader
+}
+
+func (sb *streamBody) Read(p []byte) (int, error) {
+	if sb.reader == nil {
+		sb.reader = sb.pipe
+		if sb.decompressor != nil {
+			r, err := sb.decompressor.NewReader(sb.pipe)
+			if err != nil {
+				return 0, err
+			}
+			sb.reader = r
+		}
+	}
+	return sb.reader.Read(p)
+}
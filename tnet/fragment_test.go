@@ -0,0 +1,240 @@
+package tnet
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// 流式读取消息体，统计每次读取到的数据长度，读完后回复读取到的总数据
+type ChunkRouter struct {
+	chunks chan int
+}
+
+func (r *ChunkRouter) Handle(request tiface.IRequest, body io.Reader) {
+	var data []byte
+	buf := make([]byte, 1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			r.chunks <- n
+			data = append(data, buf[:n]...)
+		}
+		if err != nil {
+			break
+		}
+	}
+	request.GetConnection().SendMsg(request.GetMsgID(), data)
+}
+
+func TestPackFragments(t *testing.T) {
+	conf := *utils.GlobalObject
+	defer func() { *utils.GlobalObject = conf }()
+	utils.GlobalObject.FrameFlags = true
+	utils.GlobalObject.MaxMsgSize = 100
+
+	dp := NewDataPack()
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	frames, err := packFragments(dp, NewMsgPackage(5, data), 30)
+	require.NoError(t, err)
+
+	var assembler fragmentAssembler
+	r := bytes.NewReader(frames)
+	var msg tiface.IMessage
+	for i := 0; i < 4; i++ {
+		require.Nil(t, msg)
+		frame, err := readFrame(r, dp)
+		require.NoError(t, err)
+		require.NotZero(t, frame.GetFlags()&FlagFragment)
+		msg, err = assembler.add(frame)
+		require.NoError(t, err)
+	}
+	require.NotNil(t, msg)
+	require.Zero(t, r.Len())
+	require.Equal(t, uint32(5), msg.GetMsgId())
+	require.Zero(t, msg.GetFlags())
+	require.Equal(t, data, msg.GetData())
+
+	// 超过MaxMsgSize的消息不能发送
+	_, err = packFragments(dp, NewMsgPackage(5, append(data, 'x')), 30)
+	require.Error(t, err)
+
+	// 未开启FrameFlags时无法标记分片
+	utils.GlobalObject.FrameFlags = false
+	_, err = packFragments(NewDataPack(), NewMsgPackage(5, data), 30)
+	require.Error(t, err)
+}
+
+func TestFragmentAssemblerLimit(t *testing.T) {
+	conf := *utils.GlobalObject
+	defer func() { *utils.GlobalObject = conf }()
+	utils.GlobalObject.MaxMsgSize = 50
+
+	var assembler fragmentAssembler
+	fragment := &Message{Id: 1, DataLen: 30, Data: make([]byte, 30), Flags: FlagFragment}
+	msg, err := assembler.add(fragment)
+	require.NoError(t, err)
+	require.Nil(t, msg)
+	_, err = assembler.add(fragment)
+	require.Error(t, err)
+
+	// 不同消息的分片交错被拒绝
+	_, err = assembler.add(fragment)
+	require.NoError(t, err)
+	_, err = assembler.add(&Message{Id: 2, DataLen: 1, Data: []byte{1}, Flags: FlagFragment | FlagFragmentEnd})
+	require.Error(t, err)
+}
+
+func TestFragmentConnection(t *testing.T) {
+	for _, secure := range []string{"", SecureAESGCM} {
+		t.Run("secure="+secure, func(t *testing.T) {
+			conf := *utils.GlobalObject
+			t.Cleanup(func() { *utils.GlobalObject = conf })
+			utils.GlobalObject.WorkerPoolSize = 0
+			utils.GlobalObject.FrameFlags = true
+			utils.GlobalObject.SecureChannel = secure
+
+			s := NewServer()
+			s.AddRouter(2, &EchoRouter{})
+			client := newTestClient(t, s, 410)
+			require.NoError(t, client.Start())
+
+			// 超过MaxPacketSize的消息被透明地拆分和重组
+			data := make([]byte, 3*utils.GlobalObject.MaxPacketSize+100)
+			for i := range data {
+				data[i] = byte(i)
+			}
+			for i := 0; i < 2; i++ {
+				require.NoError(t, client.SendMsg(2, data))
+				msg, err := client.ReadMsg()
+				require.NoError(t, err)
+				require.Equal(t, uint32(2), msg.GetMsgId())
+				require.Equal(t, data, msg.GetData())
+			}
+		})
+	}
+}
+
+func TestFragmentTooLarge(t *testing.T) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 0
+	utils.GlobalObject.FrameFlags = true
+
+	s := NewServer()
+	s.AddRouter(2, &EchoRouter{})
+	client := newTestClient(t, s, 411)
+	require.NoError(t, client.Start())
+
+	// 客户端以更大的MaxMsgSize发送，服务端重组时超过限制断开连接
+	data := make([]byte, 2*utils.GlobalObject.MaxPacketSize)
+	utils.GlobalObject.MaxMsgSize = uint32(len(data))
+	frames, err := packFragments(NewDataPack(), NewMsgPackage(2, data), utils.GlobalObject.MaxPacketSize)
+	require.NoError(t, err)
+	utils.GlobalObject.MaxMsgSize = uint32(len(data)) - 1
+
+	_, err = client.Conn().Write(frames)
+	require.NoError(t, err)
+	_, err = client.ReadMsg()
+	require.Error(t, err)
+}
+
+func TestStreamRouter(t *testing.T) {
+	for _, compressor := range []string{"", "gzip"} {
+		t.Run("compressor="+compressor, func(t *testing.T) {
+			conf := *utils.GlobalObject
+			t.Cleanup(func() { *utils.GlobalObject = conf })
+			utils.GlobalObject.WorkerPoolSize = 0
+			utils.GlobalObject.FrameFlags = true
+			utils.GlobalObject.Compressor = compressor
+			utils.GlobalObject.CompressThreshold = 64
+
+			router := &ChunkRouter{chunks: make(chan int, 1024)}
+			s := NewServer()
+			s.AddStreamRouter(3, router)
+			client := newTestClient(t, s, 412)
+			require.NoError(t, client.Start())
+
+			// 随机内容不可压缩，压缩时保证压缩后仍然需要分片
+			data := make([]byte, 4*utils.GlobalObject.MaxPacketSize)
+			seed := uint32(1)
+			for i := range data {
+				seed = seed*1664525 + 1013904223
+				data[i] = byte(seed >> 24)
+			}
+			require.NoError(t, client.SendMsg(3, data))
+			msg, err := client.ReadMsg()
+			require.NoError(t, err)
+			require.Equal(t, uint32(3), msg.GetMsgId())
+			require.Equal(t, data, msg.GetData())
+
+			// Router每次最多读取1024字节，分多次读取完整的消息
+			require.Greater(t, len(router.chunks), 1)
+		})
+	}
+}
+
+// 读取完整的消息体，通知开始读取以及读取的结果
+type stallRouter struct {
+	started chan struct{}
+	result  chan error
+}
+
+func (r *stallRouter) Handle(request tiface.IRequest, body io.Reader) {
+	r.started <- struct{}{}
+	_, err := io.ReadAll(body)
+	r.result <- err
+}
+
+func TestStreamStalled(t *testing.T) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	// 只有一个Worker，所有连接的请求都由它处理
+	utils.GlobalObject.WorkerPoolSize = 1
+	utils.GlobalObject.FrameFlags = true
+	utils.GlobalObject.StreamIdleTimeout = 1000
+
+	router := &stallRouter{started: make(chan struct{}, 1), result: make(chan error, 1)}
+	s := NewServer()
+	s.AddStreamRouter(3, router)
+	s.AddRouter(2, &EchoRouter{})
+	s.(*Server).msgHandler.StartWorkerPool()
+
+	// 只发送第一个分片之后停止发送
+	stalled := newTestClient(t, s, 700)
+	require.NoError(t, stalled.Start())
+	frame, err := NewDataPack().Pack(&Message{Id: 3, DataLen: 4, Data: []byte("part"), Flags: FlagFragment})
+	require.NoError(t, err)
+	_, err = stalled.Conn().Write(frame)
+	require.NoError(t, err)
+	select {
+	case <-router.started:
+	case <-time.After(3 * time.Second):
+		t.Fatal("stream router not started")
+	}
+
+	// 流式Router等待分片时不占用Worker，其他连接的请求在StreamIdleTimeout之前就被处理
+	other := newTestClient(t, s, 701)
+	require.NoError(t, other.Start())
+	start := time.Now()
+	require.NoError(t, other.SendMsg(2, []byte("hello")))
+	msg, err := other.ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), msg.GetData())
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// 超过StreamIdleTimeout没有收到下一个分片，连接被关闭，Router读到消息不完整
+	select {
+	case err := <-router.result:
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	case <-time.After(3 * time.Second):
+		t.Fatal("stalled stream not aborted")
+	}
+	_, err = stalled.ReadMsg()
+	require.Error(t, err)
+}
@@ -0,0 +1,146 @@
+package tnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+)
+
+// 读取消息data时每次分配的最大长度
+const frameReadChunk = 64 * 1024
+
+/*
+	消息读取模块，从数据流中逐个读取完整的消息并进行校验
+	校验失败时根据FrameErrorPolicy返回错误（关闭连接），或丢弃损坏的消息并通过同步标记找到下一个消息的开头
+*/
+type frameReader struct {
+	// 底层的数据流
+	r io.Reader
+	// 重新同步时退回的数据，pending[off:]优先于r读取
+	pending []byte
+	off     int
+}
+
+// 创建一个从r中读取消息的frameReader
+func newFrameReader(r io.Reader) *frameReader {
+	return &frameReader{r: r}
+}
+
+func (fr *frameReader) Read(p []byte) (int, error) {
+	if fr.off < len(fr.pending) {
+		n := copy(p, fr.pending[fr.off:])
+		fr.off += n
+		return n, nil
+	}
+	return fr.r.Read(p)
+}
+
+// 读取下一个通过校验的消息，dropped表示在此之前是否丢弃过损坏的消息
+func (fr *frameReader) ReadFrame(dp tiface.IDataPack) (tiface.IMessage, bool, error) {
+	dropped := false
+	for {
+		msg, frame, err := fr.readFrame(dp)
+		if err == nil {
+			return msg, dropped, nil
+		}
+		if !errors.Is(err, ErrFrameCorrupt) || utils.GlobalObject.FrameErrorPolicy != FrameErrorResync {
+			return nil, dropped, err
+		}
+		if !fr.resync(frame, errors.Is(err, errFrameChecksum)) {
+			return nil, dropped, err
+		}
+		dropped = true
+	}
+}
+
+// 读取一个消息，校验失败时同时返回已经读取的原始数据
+func (fr *frameReader) readFrame(dp tiface.IDataPack) (tiface.IMessage, []byte, error) {
+	headLen := dp.GetHeadLen()
+	frame := make([]byte, headLen)
+	if _, err := io.ReadFull(fr, frame); err != nil {
+		return nil, nil, err
+	}
+
+	msg, err := dp.Unpack(frame)
+	if err != nil {
+		return nil, frame, err
+	}
+
+	if msg.GetDataLen() > 0 {
+		if frame, err = fr.readData(frame, msg.GetDataLen()); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if checker, ok := dp.(frameChecker); ok {
+		if err := checker.checkFrame(frame); err != nil {
+			return nil, frame, err
+		}
+	}
+
+	var data []byte
+	if msg.GetDataLen() > 0 {
+		data = frame[headLen:]
+	}
+	msg.SetData(data)
+	return msg, nil, nil
+}
+
+// 读取n字节的data追加到frame之后
+// 按块读取，缓冲随着实际收到的数据增长，头部声明了很大的dataLen却不发送数据时不会预先占用同样大的内存
+func (fr *frameReader) readData(frame []byte, n uint32) ([]byte, error) {
+	for n > 0 {
+		chunk := n
+		if chunk > frameReadChunk {
+			chunk = frameReadChunk
+		}
+		start := len(frame)
+		frame = append(frame, make([]byte, chunk)...)
+		if _, err := io.ReadFull(fr, frame[start:]); err != nil {
+			return nil, err
+		}
+		n -= chunk
+	}
+	return frame, nil
+}
+
+// 丢弃损坏的消息，返回能否继续读取
+// complete表示头部的长度可信、整个消息已经被读取（只有校验和不匹配）
+func (fr *frameReader) resync(frame []byte, complete bool) bool {
+	magic := utils.GlobalObject.FrameMagic
+	if magic == 0 {
+		// 没有同步标记时只能根据头部的长度跳过整个消息，头部本身损坏时无法重新同步
+		return complete
+	}
+
+	// 从损坏消息的第二个字节开始查找同步标记，将找到的位置之后的数据退回，重新读取
+	// 没有找到时，最后一个字节可能是被截断的同步标记，同样退回
+	var marker [2]byte
+	binary.LittleEndian.PutUint16(marker[:], magic)
+	rest := frame[1:]
+	if i := bytes.Index(rest, marker[:]); i >= 0 {
+		rest = rest[i:]
+	} else if rest[len(rest)-1] == marker[0] {
+		rest = rest[len(rest)-1:]
+	} else {
+		rest = nil
+	}
+	fr.unread(rest)
+	return true
+}
+
+// 退回数据，之后优先读取
+// 退回的数据不超过已经从pending中读出的数据时原地退回，避免每次重新同步都复制剩余的全部数据
+func (fr *frameReader) unread(data []byte) {
+	if fr.off >= len(data) {
+		fr.off -= len(data)
+		copy(fr.pending[fr.off:], data)
+		return
+	}
+	fr.pending = append(data[:len(data):len(data)], fr.pending[fr.off:]...)
+	fr.off = 0
+}
@@ -0,0 +1,197 @@
+package tnet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// 开启校验和与同步标记
+func enableFrameCheck(t *testing.T, policy string) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.FrameFlags = true
+	utils.GlobalObject.FrameChecksum = true
+	utils.GlobalObject.FrameMagic = 0x7A6B
+	utils.GlobalObject.FrameErrorPolicy = policy
+}
+
+// 依次封包msgId为1-n的消息，返回每个消息封包后的数据
+func packTestFrames(t testing.TB, n int) [][]byte {
+	var frames [][]byte
+	for i := 1; i <= n; i++ {
+		frame, err := NewDataPack().Pack(NewMsgPackage(uint32(i), bytes.Repeat([]byte{byte(i)}, 10*i)))
+		require.NoError(t, err)
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// 读取stream中的全部消息，返回读到的msgId
+func readTestFrames(t testing.TB, stream []byte) ([]uint32, error) {
+	fr := newFrameReader(bytes.NewReader(stream))
+	var ids []uint32
+	for {
+		msg, _, err := fr.ReadFrame(NewDataPack())
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return ids, err
+		}
+		require.Equal(t, bytes.Repeat([]byte{byte(msg.GetMsgId())}, 10*int(msg.GetMsgId())), msg.GetData())
+		ids = append(ids, msg.GetMsgId())
+	}
+}
+
+func TestFrameChecksum(t *testing.T) {
+	enableFrameCheck(t, FrameErrorClose)
+	frames := packTestFrames(t, 3)
+	require.Equal(t, uint32(15), NewDataPack().GetHeadLen())
+
+	// 篡改第二个消息的data
+	frames[1][len(frames[1])-1] ^= 0xFF
+	stream := bytes.Join(frames, nil)
+
+	ids, err := readTestFrames(t, stream)
+	require.True(t, errors.Is(err, ErrFrameCorrupt))
+	require.Equal(t, []uint32{1}, ids)
+
+	// resync策略下丢弃损坏的消息，继续读取之后的消息
+	utils.GlobalObject.FrameErrorPolicy = FrameErrorResync
+	ids, err = readTestFrames(t, stream)
+	require.NoError(t, err)
+	require.Equal(t, []uint32{1, 3}, ids)
+
+	// 没有同步标记时，根据头部的长度跳过校验失败的消息
+	utils.GlobalObject.FrameMagic = 0
+	frames = packTestFrames(t, 3)
+	frames[1][len(frames[1])-1] ^= 0xFF
+	ids, err = readTestFrames(t, bytes.Join(frames, nil))
+	require.NoError(t, err)
+	require.Equal(t, []uint32{1, 3}, ids)
+
+	// 头部损坏时无法重新同步
+	frames = packTestFrames(t, 3)
+	frames[1][0] = 0xFF
+	frames[1][3] = 0xFF
+	_, err = readTestFrames(t, bytes.Join(frames, nil))
+	require.True(t, errors.Is(err, ErrFrameCorrupt))
+}
+
+func TestFrameResync(t *testing.T) {
+	enableFrameCheck(t, FrameErrorResync)
+	frames := packTestFrames(t, 5)
+
+	// 第二个消息被截断，第四个消息之前插入垃圾数据
+	frames[1] = frames[1][:len(frames[1])-7]
+	frames[3] = append([]byte{0x6B, 0x01, 0x02, 0x6B, 0x7A, 0x03}, frames[3]...)
+	ids, err := readTestFrames(t, bytes.Join(frames, nil))
+	require.NoError(t, err)
+	require.Equal(t, []uint32{1, 3, 4, 5}, ids)
+
+	// 数据流末尾的消息不完整
+	stream := bytes.Join(packTestFrames(t, 2), nil)
+	ids, err = readTestFrames(t, stream[:len(stream)-1])
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+	require.Equal(t, []uint32{1}, ids)
+}
+
+func TestFrameResyncFragments(t *testing.T) {
+	enableFrameCheck(t, FrameErrorResync)
+	utils.GlobalObject.WorkerPoolSize = 0
+
+	s := NewServer()
+	s.AddRouter(2, &EchoRouter{})
+	client := newTestClient(t, s, 420)
+	require.NoError(t, client.Start())
+
+	// 大消息的一个分片损坏，整个大消息被丢弃，之后的消息正常处理
+	large, err := packFragments(NewDataPack(), NewMsgPackage(2, make([]byte, 3*utils.GlobalObject.MaxPacketSize)), utils.GlobalObject.MaxPacketSize)
+	require.NoError(t, err)
+	large[len(large)/2] ^= 0xFF
+	small, err := NewDataPack().Pack(NewMsgPackage(2, []byte("ping")))
+	require.NoError(t, err)
+
+	_, err = client.Conn().Write(append(large, small...))
+	require.NoError(t, err)
+	msg, err := client.ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(msg.GetData()))
+}
+
+func TestFrameErrorClose(t *testing.T) {
+	enableFrameCheck(t, FrameErrorClose)
+	utils.GlobalObject.WorkerPoolSize = 0
+
+	s := NewServer()
+	s.AddRouter(2, &EchoRouter{})
+	client := newTestClient(t, s, 421)
+	require.NoError(t, client.Start())
+
+	frame, err := NewDataPack().Pack(NewMsgPackage(2, []byte("ping")))
+	require.NoError(t, err)
+	frame[len(frame)-1] ^= 0xFF
+	_, err = client.Conn().Write(frame)
+	require.NoError(t, err)
+
+	// 校验失败，服务端关闭连接
+	_, err = client.ReadMsg()
+	require.Error(t, err)
+}
+
+// 开启校验和与同步标记，并使用resync策略进行模糊测试
+func enableFuzzFrameCheck(f *testing.F) {
+	conf := *utils.GlobalObject
+	f.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.FrameFlags = true
+	utils.GlobalObject.FrameChecksum = true
+	utils.GlobalObject.FrameMagic = 0x7A6B
+	utils.GlobalObject.FrameErrorPolicy = FrameErrorResync
+}
+
+func FuzzFrameReader(f *testing.F) {
+	enableFuzzFrameCheck(f)
+	frames := packTestFrames(f, 3)
+	f.Add(bytes.Join(frames, nil))
+	f.Add(frames[0][:5])
+	f.Add(append(frames[1][3:], frames[2]...))
+	f.Add([]byte{0x6B, 0x7A, 0xFF, 0xFF, 0xFF, 0xFF})
+
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		// 任意数据流都不会panic，读到的消息长度都在限制之内
+		fr := newFrameReader(bytes.NewReader(stream))
+		for {
+			msg, _, err := fr.ReadFrame(NewDataPack())
+			if err != nil {
+				return
+			}
+			require.LessOrEqual(t, msg.GetDataLen(), utils.GlobalObject.MaxPacketSize)
+			require.Equal(t, int(msg.GetDataLen()), len(msg.GetData()))
+		}
+	})
+}
+
+func FuzzFrameCorruption(f *testing.F) {
+	enableFuzzFrameCheck(f)
+	stream := bytes.Join(packTestFrames(f, 5), nil)
+	f.Add(uint16(20), []byte{0x6B, 0x7A}, uint16(0))
+	f.Add(uint16(40), []byte{}, uint16(9))
+	f.Add(uint16(100), []byte{0xFF, 0x00, 0x6B}, uint16(30))
+
+	f.Fuzz(func(t *testing.T, pos uint16, garbage []byte, cut uint16) {
+		// 在正常的数据流中截断一段数据并插入垃圾数据，读到的消息都是完整正确的，并且保持原有顺序
+		start := int(pos) % (len(stream) + 1)
+		end := start + int(cut)%(len(stream)-start+1)
+		damaged := append(append(append([]byte{}, stream[:start]...), garbage...), stream[end:]...)
+
+		ids, _ := readTestFrames(t, damaged)
+		for i := 1; i < len(ids); i++ {
+			require.Less(t, ids[i-1], ids[i])
+		}
+	})
+}
@@ -0,0 +1,357 @@
+package tnet
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+	模糊测试：DataPack、SecureDataPack的拆包以及连接的Reader是仅有的直接处理不可信数据的代码
+	语料（包括发现过问题的输入）保存在testdata/fuzz中，go test时作为普通用例执行
+	持续进行模糊测试：go test ./tnet -run '^$' -fuzz FuzzDataPack -fuzztime 60s
+*/
+
+// 每个输入最多读取的消息数量
+const fuzzMaxFrames = 64
+
+// 回显消息，连接已经关闭时忽略发送错误
+type fuzzEchoRouter struct {
+	BaseRouter
+}
+
+func (router *fuzzEchoRouter) Handle(request tiface.IRequest) {
+	request.GetConnection().SendBuffMsg(request.GetMsgID(), request.GetData())
+}
+
+// 读取流式消息的全部数据，回复数据长度
+type fuzzStreamRouter struct{}
+
+func (r *fuzzStreamRouter) Handle(request tiface.IRequest, body io.Reader) {
+	n, _ := io.Copy(io.Discard, body)
+	request.GetConnection().SendBuffMsg(request.GetMsgID(), []byte{byte(n)})
+}
+
+// 根据模糊测试输入的mode设置封包配置，调用者负责恢复
+func setFuzzFrameConf(mode byte) {
+	utils.GlobalObject.FrameFlags = mode&1 != 0
+	utils.GlobalObject.FrameChecksum = mode&2 != 0
+	utils.GlobalObject.FrameMagic = 0
+	if mode&4 != 0 {
+		utils.GlobalObject.FrameMagic = 0x7A6B
+	}
+	utils.GlobalObject.FrameErrorPolicy = FrameErrorClose
+	if mode&8 != 0 {
+		utils.GlobalObject.FrameErrorPolicy = FrameErrorResync
+	}
+	// 不限制包长度时，头部声明的dataLen同样不能导致预先分配大量内存
+	utils.GlobalObject.MaxPacketSize = 4096
+	if mode&16 != 0 {
+		utils.GlobalObject.MaxPacketSize = 0
+	}
+}
+
+// 生成各种配置下封包好的消息作为种子
+func addFrameSeeds(f *testing.F) {
+	conf := *utils.GlobalObject
+	defer func() { *utils.GlobalObject = conf }()
+
+	for mode := byte(0); mode < 32; mode++ {
+		setFuzzFrameConf(mode)
+		dp := NewDataPack()
+		var stream []byte
+		for _, msg := range []tiface.IMessage{
+			NewMsgPackage(1, []byte("hello")),
+			NewMsgPackage(2, nil),
+			NewMsgPackage(3, bytes.Repeat([]byte("tigerkin "), 200)),
+		} {
+			frame, err := dp.Pack(msg)
+			if err != nil {
+				f.Fatal(err)
+			}
+			stream = append(stream, frame...)
+		}
+		if utils.GlobalObject.FrameFlags {
+			msg := NewMsgPackage(4, bytes.Repeat([]byte("tigerkin "), 200))
+			if err := CompressMsg(msg, GetCompressor("gzip"), 0); err != nil {
+				f.Fatal(err)
+			}
+			frames, err := packFragments(dp, msg, 128)
+			if err != nil {
+				f.Fatal(err)
+			}
+			stream = append(stream, frames...)
+		}
+		f.Add(mode, stream)
+		// 头部声明了很大的dataLen，之后没有数据
+		f.Add(mode, stream[:dp.GetHeadLen()])
+		f.Add(mode, append(bytes.Repeat([]byte{0xFF}, int(dp.GetHeadLen())), 0x7A, 0x6B))
+	}
+}
+
+// 读取任意数据流，校验通过的消息重新封包之后能够被同样读出
+func FuzzDataPack(f *testing.F) {
+	addFrameSeeds(f)
+	f.Fuzz(func(t *testing.T, mode byte, stream []byte) {
+		conf := *utils.GlobalObject
+		defer func() { *utils.GlobalObject = conf }()
+		setFuzzFrameConf(mode)
+		dp := NewDataPack()
+		fr := newFrameReader(bytes.NewReader(stream))
+		var assembler fragmentAssembler
+		for i := 0; i < fuzzMaxFrames; i++ {
+			msg, _, err := fr.ReadFrame(dp)
+			if err != nil {
+				return
+			}
+			require.Equal(t, int(msg.GetDataLen()), len(msg.GetData()))
+			if max := utils.GlobalObject.MaxPacketSize; max > 0 {
+				require.LessOrEqual(t, msg.GetDataLen(), max)
+			}
+
+			frame, err := dp.Pack(msg)
+			require.NoError(t, err)
+			again, dropped, err := newFrameReader(bytes.NewReader(frame)).ReadFrame(dp)
+			require.NoError(t, err)
+			require.False(t, dropped)
+			require.Equal(t, msg.GetMsgId(), again.GetMsgId())
+			require.Equal(t, msg.GetFlags(), again.GetFlags())
+			require.Equal(t, msg.GetData(), again.GetData())
+
+			// 与连接的处理顺序一致：分片重组之后解压
+			if msg.GetFlags()&FlagFragment != 0 {
+				if msg, err = assembler.add(msg); err != nil || msg == nil {
+					continue
+				}
+			}
+			if err := DecompressMsg(msg, utils.GlobalObject.MaxMsgSize); err == nil {
+				require.Equal(t, int(msg.GetDataLen()), len(msg.GetData()))
+			}
+		}
+	})
+}
+
+// 使用固定密钥创建加密封包模块，种子中的密文可以被解密
+func newFuzzSecureDataPack(t testing.TB, cipherName string) *SecureDataPack {
+	aead, err := newAEAD(cipherName, bytes.Repeat([]byte{0x5A}, 32))
+	require.NoError(t, err)
+	return &SecureDataPack{inner: NewDataPack(), sealer: aead, opener: aead}
+}
+
+// 解密任意数据流，解密成功的消息与重新加密之前的明文一致
+func FuzzSecureDataPack(f *testing.F) {
+	for _, chacha := range []bool{false, true} {
+		cipherName := SecureAESGCM
+		if chacha {
+			cipherName = SecureChaCha20
+		}
+		sdp := newFuzzSecureDataPack(f, cipherName)
+		var stream []byte
+		for i, data := range [][]byte{[]byte("move"), nil, bytes.Repeat([]byte("tigerkin "), 100)} {
+			frame, err := sdp.Pack(NewMsgPackage(uint32(i), data))
+			require.NoError(f, err)
+			stream = append(stream, frame...)
+		}
+		f.Add(chacha, stream)
+		f.Add(chacha, stream[:len(stream)-1])
+	}
+
+	f.Fuzz(func(t *testing.T, chacha bool, stream []byte) {
+		cipherName := SecureAESGCM
+		if chacha {
+			cipherName = SecureChaCha20
+		}
+		sdp := newFuzzSecureDataPack(t, cipherName)
+		fr := newFrameReader(bytes.NewReader(stream))
+		for i := 0; i < fuzzMaxFrames; i++ {
+			msg, _, err := fr.ReadFrame(sdp)
+			if err != nil {
+				return
+			}
+			if err := sdp.UnpackData(msg); err != nil {
+				return
+			}
+			require.Equal(t, int(msg.GetDataLen()), len(msg.GetData()))
+
+			// 解密之后的消息重新加密，由序号相同的接收方解密得到相同的明文
+			sender := newFuzzSecureDataPack(t, cipherName)
+			sender.sendSeq = sdp.recvSeq - 1
+			frame, err := sender.Pack(msg)
+			require.NoError(t, err)
+			receiver := newFuzzSecureDataPack(t, cipherName)
+			receiver.recvSeq = sdp.recvSeq - 1
+			again, err := readFrame(bytes.NewReader(frame), receiver)
+			require.NoError(t, err)
+			require.NoError(t, receiver.UnpackData(again))
+			require.Equal(t, msg.GetData(), again.GetData())
+		}
+	})
+}
+
+// 模糊测试连接使用的ConnID
+var fuzzConnID uint32 = 600
+
+// 连接的Reader处理任意数据流，连接最终能够停止，不会阻塞或者崩溃
+// 开启鉴权和会话，鉴权之前的消息同样来自不可信的客户端
+func FuzzConnectionReader(f *testing.F) {
+	conf := *utils.GlobalObject
+	f.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 0
+	utils.GlobalObject.FrameFlags = true
+	utils.GlobalObject.MaxConnRequests = 16
+	utils.GlobalObject.UnknownMsgReply = true
+	utils.GlobalObject.SessionGracePeriod = 1
+
+	auth := NewHMACAuthenticator([]byte("fuzz"))
+	s := NewServer()
+	s.SetAuthenticator(auth)
+	s.AddRouter(1, &fuzzEchoRouter{})
+	s.AddStreamRouter(2, &fuzzStreamRouter{})
+
+	// 鉴权并创建会话
+	dp := NewDataPack()
+	var login []byte
+	for _, msg := range []tiface.IMessage{
+		NewMsgPackage(utils.GlobalObject.AuthMsgId, []byte(auth.GenerateToken("fuzz", time.Hour))),
+		NewMsgPackage(utils.GlobalObject.SessionMsgId, nil),
+	} {
+		frame, err := dp.Pack(msg)
+		require.NoError(f, err)
+		login = append(login, frame...)
+	}
+	f.Add(login)
+
+	// 各种由连接直接处理的消息
+	stream := append([]byte{}, login...)
+	for _, msg := range []tiface.IMessage{
+		NewMsgPackage(1, []byte("hello")),
+		NewMsgPackage(utils.GlobalObject.CodecMsgId, []byte("json")),
+		NewMsgPackage(utils.GlobalObject.TopicMsgId, append([]byte{TopicOpSubscribe}, "room.*"...)),
+		NewMsgPackage(utils.GlobalObject.TopicMsgId, append([]byte{TopicOpUnsubscribe}, "room.*"...)),
+		NewMsgPackage(utils.GlobalObject.ReliableMsgId, packReliable(1, 1, []byte("reliable"))),
+		NewMsgPackage(utils.GlobalObject.ReliableMsgId, packAck(1)),
+		NewMsgPackage(99, []byte("unknown")),
+	} {
+		frame, err := dp.Pack(msg)
+		require.NoError(f, err)
+		stream = append(stream, frame...)
+		f.Add(append(append([]byte{}, login...), frame...))
+	}
+	f.Add(stream)
+	// 恢复不存在的会话
+	frame, err := dp.Pack(NewMsgPackage(utils.GlobalObject.SessionMsgId, []byte("unknown")))
+	require.NoError(f, err)
+	f.Add(append(append([]byte{}, login[:len(login)-int(dp.GetHeadLen())]...), frame...))
+	// 分片、压缩以及流式消息
+	for _, msgId := range []uint32{1, 2} {
+		msg := NewMsgPackage(msgId, bytes.Repeat([]byte("tigerkin "), 200))
+		require.NoError(f, CompressMsg(msg, GetCompressor("gzip"), 0))
+		frames, err := packFragments(dp, msg, 128)
+		require.NoError(f, err)
+		f.Add(append(append([]byte{}, login...), frames...))
+	}
+
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		server, client := net.Pipe()
+		c := NewConnection(s, server, atomic.AddUint32(&fuzzConnID, 1), s.(*Server).msgHandler)
+		go c.Start()
+		go io.Copy(io.Discard, client)
+
+		// 连接因为数据错误停止之后写入失败，不影响测试
+		client.Write(stream)
+		client.Close()
+		select {
+		case <-c.Context().Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("connection did not stop")
+		}
+	})
+}
+
+func TestFrameReaderLargeDataLen(t *testing.T) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.MaxPacketSize = 0
+
+	// 头部声明1GB的数据，实际只发送100字节，读取时不会预先分配1GB的内存
+	head, err := NewDataPack().Pack(&Message{Id: 1, DataLen: 1 << 30})
+	require.NoError(t, err)
+	stream := append(head, make([]byte, 100)...)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err = readFrame(bytes.NewReader(stream), NewDataPack())
+	runtime.ReadMemStats(&after)
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+	require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+}
+
+// 记录正在处理的请求，直到release被关闭才返回
+type blockingRouter struct {
+	BaseRouter
+	running chan uint32
+	release chan struct{}
+}
+
+func (router *blockingRouter) Handle(request tiface.IRequest) {
+	router.running <- request.GetMsgID()
+	<-router.release
+}
+
+func TestMaxConnRequests(t *testing.T) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 0
+	utils.GlobalObject.MaxConnRequests = 2
+
+	router := &blockingRouter{running: make(chan uint32, 5), release: make(chan struct{})}
+	s := NewServer()
+	s.AddRouter(1, router)
+	server, client := net.Pipe()
+	c := NewConnection(s, server, 620, s.(*Server).msgHandler)
+	go c.Start()
+	// 等待Reader退出之后再恢复配置，Reader读取消息时使用配置
+	t.Cleanup(c.stopAndWait)
+
+	frame, err := NewDataPack().Pack(NewMsgPackage(1, []byte("ping")))
+	require.NoError(t, err)
+	go func() {
+		for i := 0; i < 5; i++ {
+			if _, err := client.Write(frame); err != nil {
+				return
+			}
+		}
+	}()
+
+	// 同时处理的请求达到上限之后，不再读取新的消息
+	for i := 0; i < 2; i++ {
+		select {
+		case <-router.running:
+		case <-time.After(3 * time.Second):
+			t.Fatal("request not handled")
+		}
+	}
+	select {
+	case <-router.running:
+		t.Fatal("too many requests handled at the same time")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// 请求处理完成之后继续读取
+	close(router.release)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-router.running:
+		case <-time.After(3 * time.Second):
+			t.Fatal("request not handled")
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package tnet
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+)
+
+// 网关与后端之间的消息类型，位于GatewayMsgId消息数据的第一个字节
+const (
+	GatewayData       byte = 1 // 双向：客户端连接的消息，连接ID(uint32) + 原始msgId(uint32) + 原始数据
+	GatewayConnect    byte = 2 // 网关到后端：客户端连接第一次被转发到该后端，数据为gatewayConnInfo（JSON）
+	GatewayDisconnect byte = 3 // 网关到后端：客户端连接已经断开
+	GatewayKick       byte = 4 // 后端到网关：断开客户端连接
+)
+
+var (
+	ErrGatewayNoRoute     = errors.New("gateway no route")
+	ErrGatewayBackendDown = errors.New("gateway backend down")
+)
+
+/*
+	客户端连接第一次被转发到后端时携带的连接信息
+*/
+type gatewayConnInfo struct {
+	// 客户端的地址
+	Addr string `json:"addr"`
+	// 网关上需要传递给后端的链接属性
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// 封装网关与后端之间的消息
+func packGateway(kind byte, connID, msgId uint32, data []byte) []byte {
+	envelope := make([]byte, 9+len(data))
+	envelope[0] = kind
+	binary.LittleEndian.PutUint32(envelope[1:], connID)
+	binary.LittleEndian.PutUint32(envelope[5:], msgId)
+	copy(envelope[9:], data)
+	return envelope
+}
+
+// 解析网关与后端之间的消息
+func unpackGateway(envelope []byte) (kind byte, connID, msgId uint32, data []byte, err error) {
+	if len(envelope) < 9 {
+		return 0, 0, 0, nil, errors.New("too short gateway msg")
+	}
+	kind = envelope[0]
+	if kind < GatewayData || kind > GatewayKick {
+		return 0, 0, 0, nil, fmt.Errorf("unknown gateway msg kind = %d", kind)
+	}
+	return kind, binary.LittleEndian.Uint32(envelope[1:]), binary.LittleEndian.Uint32(envelope[5:]), envelope[9:], nil
+}
+
+/*
+	网关的路由规则：msgId在[min, max]之间的消息转发给backend，
+	property不为空时转发给名称为该链接属性值的后端
+*/
+type gatewayRule struct {
+	min, max uint32
+	backend  string
+	property string
+}
+
+/*
+	网关：持有客户端连接，将Server上没有注册处理方法的消息按照路由规则转发给后端Tigerkin服务器，
+	并将后端的回复转发给对应ConnID的客户端连接
+	与每个后端之间保持一条复用的长连接，断开之后自动重连
+*/
+type Gateway struct {
+	// 持有客户端连接的Server
+	server tiface.IServer
+	// 路由规则，按照添加的顺序匹配
+	rules []gatewayRule
+	// 后端名称 -> 与后端之间的长连接
+	links map[string]*gatewayLink
+	// 需要传递给后端的链接属性
+	properties []string
+
+	// 已经被转发过的客户端连接，连接断开时通知后端
+	watched map[uint32]bool
+	// 保护watched
+	lock sync.Mutex
+	// 通知长连接停止重连
+	exit     chan struct{}
+	stopOnce sync.Once
+}
+
+// 创建网关，需要在Server开始服务之前添加后端和路由规则并调用Start
+func NewGateway(server tiface.IServer) *Gateway {
+	return &Gateway{
+		server:  server,
+		links:   make(map[string]*gatewayLink),
+		watched: make(map[uint32]bool),
+		exit:    make(chan struct{}),
+	}
+}
+
+// 添加后端Tigerkin服务器，addr为"host:port"
+func (gw *Gateway) AddBackend(name, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+	gw.addLink(name, func() *Client { return NewClient(host, port) })
+	return nil
+}
+
+// 添加通过服务发现找到的后端，每次连接时从服务的实例中选择一个，实例列表变化时重新选择
+func (gw *Gateway) AddBackendService(name string, discovery tiface.IDiscovery, service string) {
+	// 同一条长连接重连时优先选择同一个实例
+	balanceKey := randomHex(8)
+	gw.addLink(name, func() *Client {
+		client := NewServiceClient(discovery, service)
+		client.SetBalanceKey(balanceKey)
+		return client
+	})
+}
+
+// 添加与后端之间的长连接
+func (gw *Gateway) addLink(name string, newClient func() *Client) {
+	gw.links[name] = &gatewayLink{
+		gateway:   gw,
+		name:      name,
+		newClient: newClient,
+		announced: make(map[uint32]bool),
+	}
+}
+
+// msgId在[min, max]之间的消息转发给名称为backend的后端
+func (gw *Gateway) RouteRange(min, max uint32, backend string) {
+	gw.rules = append(gw.rules, gatewayRule{min: min, max: max, backend: backend})
+}
+
+// msgId在[min, max]之间的消息转发给名称为该连接上链接属性key的值的后端，例如按照区服转发
+func (gw *Gateway) RouteByProperty(min, max uint32, key string) {
+	gw.rules = append(gw.rules, gatewayRule{min: min, max: max, property: key})
+}
+
+// 客户端连接第一次被转发到后端时，将这些链接属性（字符串）一同传递给后端，例如鉴权得到的身份
+func (gw *Gateway) ForwardProperties(keys ...string) {
+	gw.properties = append(gw.properties, keys...)
+}
+
+// 连接全部后端，并接管Server上没有注册处理方法的消息
+// 转发的消息不计入未知消息，没有路由规则的消息仍然按照未知消息处理（NotFound Router、错误回复、断开连接）
+func (gw *Gateway) Start() {
+	gw.server.GetMsgHandler().SetForwarder(gw.forward)
+	for _, link := range gw.links {
+		go link.run()
+	}
+}
+
+// 停止网关，断开与全部后端之间的长连接
+func (gw *Gateway) Stop() {
+	gw.stopOnce.Do(func() {
+		close(gw.exit)
+		for _, link := range gw.links {
+			link.close()
+		}
+	})
+}
+
+// 根据路由规则找到消息对应的后端
+func (gw *Gateway) route(conn tiface.IConnection, msgId uint32) (*gatewayLink, error) {
+	for _, rule := range gw.rules {
+		if msgId < rule.min || msgId > rule.max {
+			continue
+		}
+		name := rule.backend
+		if rule.property != "" {
+			value, err := conn.GetProperty(rule.property)
+			if err != nil {
+				return nil, fmt.Errorf("%w: msgId = %d, property %s not found", ErrGatewayNoRoute, msgId, rule.property)
+			}
+			name = fmt.Sprint(value)
+		}
+		if link, ok := gw.links[name]; ok {
+			return link, nil
+		}
+		return nil, fmt.Errorf("%w: msgId = %d, backend %s not found", ErrGatewayNoRoute, msgId, name)
+	}
+	return nil, fmt.Errorf("%w: msgId = %d", ErrGatewayNoRoute, msgId)
+}
+
+// 将客户端连接的消息转发给后端
+func (gw *Gateway) Forward(conn tiface.IConnection, msgId uint32, data []byte) error {
+	link, err := gw.route(conn, msgId)
+	if err != nil {
+		return err
+	}
+	gw.watch(conn)
+	return link.forward(conn, msgId, data)
+}
+
+// 客户端连接第一次被转发时，等待连接断开之后通知收到过该连接消息的后端
+func (gw *Gateway) watch(conn tiface.IConnection) {
+	gw.lock.Lock()
+	defer gw.lock.Unlock()
+
+	if gw.watched[conn.GetConnID()] {
+		return
+	}
+	gw.watched[conn.GetConnID()] = true
+	go func() {
+		select {
+		case <-conn.Context().Done():
+		case <-gw.exit:
+			return
+		}
+		gw.lock.Lock()
+		delete(gw.watched, conn.GetConnID())
+		gw.lock.Unlock()
+		for _, link := range gw.links {
+			link.disconnect(conn.GetConnID())
+		}
+	}()
+}
+
+// 客户端连接的信息
+func (gw *Gateway) connInfo(conn tiface.IConnection) ([]byte, error) {
+	info := gatewayConnInfo{Addr: conn.RemoteAddr().String()}
+	for _, key := range gw.properties {
+		if value, err := conn.GetProperty(key); err == nil {
+			if info.Properties == nil {
+				info.Properties = make(map[string]string)
+			}
+			info.Properties[key] = fmt.Sprint(value)
+		}
+	}
+	return json.Marshal(info)
+}
+
+// 将网关上没有注册处理方法的消息转发给后端，没有路由规则时返回false，由MsgHandle按照未知消息处理
+// 后端暂时断开等原因转发失败的消息属于网关处理的消息，不计入未知消息
+func (gw *Gateway) forward(request tiface.IRequest) bool {
+	err := gw.Forward(request.GetConnection(), request.GetMsgID(), request.GetData())
+	if errors.Is(err, ErrGatewayNoRoute) {
+		return false
+	}
+	if err != nil {
+		fmt.Println("Gateway forward msgId = ", request.GetMsgID(), " of ConnID = ", request.GetConnection().GetConnID(), " error: ", err)
+	}
+	return true
+}
+
+/*
+	网关与一个后端之间的长连接，所有客户端连接的消息复用这一条连接
+*/
+type gatewayLink struct {
+	gateway *Gateway
+	name    string
+	// 创建连接后端使用的客户端
+	newClient func() *Client
+
+	// 当前与后端之间的连接，断开期间为nil
+	client *Client
+	// 已经通知过后端的客户端连接，重连之后清空，再次转发时重新通知
+	announced map[uint32]bool
+	// 保护client和announced，同时保证同一个客户端连接的连接通知在消息之前发送
+	lock sync.Mutex
+}
+
+// 保持与后端之间的连接，断开之后等待GatewayReconnectInterval毫秒重连
+func (link *gatewayLink) run() {
+	interval := time.Duration(utils.GlobalObject.GatewayReconnectInterval) * time.Millisecond
+	for {
+		client := link.newClient()
+		if err := client.Start(); err != nil {
+			fmt.Println("Gateway connect backend ", link.name, " error: ", err)
+		} else {
+			fmt.Println("Gateway backend ", link.name, " connected")
+			link.lock.Lock()
+			select {
+			case <-link.gateway.exit:
+				// 连接期间网关已经停止
+				link.lock.Unlock()
+				client.Stop()
+				return
+			default:
+			}
+			link.client = client
+			link.announced = make(map[uint32]bool)
+			link.lock.Unlock()
+
+			link.read(client)
+
+			link.lock.Lock()
+			link.client = nil
+			link.lock.Unlock()
+			client.Stop()
+			fmt.Println("Gateway backend ", link.name, " disconnected")
+		}
+
+		select {
+		case <-link.gateway.exit:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// 读取后端发来的消息，转发给对应ConnID的客户端连接，连接断开时返回
+func (link *gatewayLink) read(client *Client) {
+	connMgr := link.gateway.server.GetConnMgr()
+	for {
+		msg, err := client.ReadMsg()
+		if err != nil {
+			return
+		}
+		if msg.GetMsgId() != utils.GlobalObject.GatewayMsgId {
+			fmt.Println("Gateway backend ", link.name, " sent msgId = ", msg.GetMsgId(), " without envelope")
+			continue
+		}
+		kind, connID, msgId, data, err := unpackGateway(msg.GetData())
+		if err != nil {
+			fmt.Println("Gateway backend ", link.name, " sent invalid msg: ", err)
+			continue
+		}
+		conn, err := connMgr.Get(connID)
+		if err != nil {
+			continue
+		}
+		switch kind {
+		case GatewayData:
+			if err := conn.SendBuffMsg(msgId, data); err != nil {
+				fmt.Println("Gateway reply to ConnID = ", connID, " error: ", err)
+			}
+		case GatewayKick:
+			conn.Stop()
+		}
+	}
+}
+
+// 将客户端连接的消息转发给后端，客户端连接第一次被转发时先通知后端
+func (link *gatewayLink) forward(conn tiface.IConnection, msgId uint32, data []byte) error {
+	link.lock.Lock()
+	defer link.lock.Unlock()
+
+	if link.client == nil {
+		return fmt.Errorf("%w: %s", ErrGatewayBackendDown, link.name)
+	}
+	connID := conn.GetConnID()
+	if !link.announced[connID] {
+		info, err := link.gateway.connInfo(conn)
+		if err != nil {
+			return err
+		}
+		if err := link.send(GatewayConnect, connID, 0, info); err != nil {
+			return err
+		}
+		link.announced[connID] = true
+	}
+	return link.send(GatewayData, connID, msgId, data)
+}
+
+// 客户端连接断开，通知收到过该连接消息的后端
+func (link *gatewayLink) disconnect(connID uint32) {
+	link.lock.Lock()
+	defer link.lock.Unlock()
+
+	if link.client == nil || !link.announced[connID] {
+		return
+	}
+	delete(link.announced, connID)
+	if err := link.send(GatewayDisconnect, connID, 0, nil); err != nil {
+		fmt.Println("Gateway notify backend ", link.name, " disconnect error: ", err)
+	}
+}
+
+// 发送网关消息，调用时需要持有锁
+func (link *gatewayLink) send(kind byte, connID, msgId uint32, data []byte) error {
+	return link.client.SendMsg(utils.GlobalObject.GatewayMsgId, packGateway(kind, connID, msgId, data))
+}
+
+// 关闭与后端之间的连接
+func (link *gatewayLink) close() {
+	link.lock.Lock()
+	defer link.lock.Unlock()
+
+	if link.client != nil {
+		link.client.Stop()
+	}
+}
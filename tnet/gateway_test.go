@@ -0,0 +1,230 @@
+package tnet
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// 为Server监听一个本地端口，接受的连接从connID开始依次编号，返回监听的地址
+func listenServer(t *testing.T, s tiface.IServer, connID uint32) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	var (
+		conns []*Connection
+		lock  sync.Mutex
+	)
+	acceptDone := make(chan struct{})
+	// 等待全部连接的Reader退出，之后测试可以修改配置
+	t.Cleanup(func() {
+		listener.Close()
+		<-acceptDone
+		s.Stop()
+		lock.Lock()
+		defer lock.Unlock()
+		for _, c := range conns {
+			c.stopAndWait()
+		}
+	})
+
+	go func() {
+		defer close(acceptDone)
+		for id := connID; ; id++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			c := NewConnection(s, conn.(*net.TCPConn), id, s.GetMsgHandler())
+			lock.Lock()
+			conns = append(conns, c)
+			lock.Unlock()
+			go c.Start()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// 等待网关与后端之间的连接建立
+func waitLinkReady(t *testing.T, gw *Gateway, name string) {
+	link := gw.links[name]
+	require.Eventually(t, func() bool {
+		link.lock.Lock()
+		defer link.lock.Unlock()
+		return link.client != nil
+	}, 3*time.Second, 5*time.Millisecond)
+}
+
+/*
+	后端上回复"后端名称:数据"的Router，数据为空时回复链接属性zone
+*/
+type backendRouter struct {
+	BaseRouter
+	name string
+}
+
+func (router *backendRouter) Handle(request tiface.IRequest) {
+	data := string(request.GetData())
+	if data == "" {
+		zone, _ := request.GetConnection().GetProperty("zone")
+		data = fmt.Sprint(zone)
+	}
+	if err := request.GetConnection().SendMsg(request.GetMsgID(), []byte(router.name+":"+data)); err != nil {
+		panic(err)
+	}
+}
+
+/*
+	后端上断开客户端连接的Router
+*/
+type kickRouter struct {
+	BaseRouter
+}
+
+func (router *kickRouter) Handle(request tiface.IRequest) {
+	request.GetConnection().Stop()
+}
+
+// 启动一个后端，连接事件写入events
+func newGatewayBackend(t *testing.T, name string, connID uint32, events chan string) (tiface.IServer, string) {
+	s := NewServer()
+	s.GetMsgHandler().StartWorkerPool()
+	backend := NewGatewayBackend(s)
+	backend.SetOnConnect(func(conn tiface.IConnection) {
+		events <- fmt.Sprintf("%s connect %d", name, conn.GetConnID())
+	})
+	backend.SetOnDisconnect(func(conn tiface.IConnection) {
+		require.Error(t, conn.SendMsg(1, nil))
+		events <- fmt.Sprintf("%s disconnect %d", name, conn.GetConnID())
+	})
+	return s, listenServer(t, s, connID)
+}
+
+func TestGateway(t *testing.T) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 2
+	utils.GlobalObject.GatewayReconnectInterval = 10
+
+	events := make(chan string, 10)
+	backendA, addrA := newGatewayBackend(t, "a", 550, events)
+	backendA.AddRouter(10, &backendRouter{name: "a"})
+	backendA.AddRouter(11, &kickRouter{})
+	backendB, addrB := newGatewayBackend(t, "zone-1", 560, events)
+	backendB.AddRouter(100, &backendRouter{name: "zone-1"})
+
+	front := NewServer()
+	front.GetMsgHandler().StartWorkerPool()
+	front.AddRouter(1, &EchoRouter{})
+	front.SetOnConnStart(func(conn tiface.IConnection) {
+		conn.SetProperty("zone", "zone-1")
+	})
+	gw := NewGateway(front)
+	require.NoError(t, gw.AddBackend("a", addrA))
+	require.NoError(t, gw.AddBackend("zone-1", addrB))
+	require.Error(t, gw.AddBackend("c", "127.0.0.1"))
+	gw.RouteRange(10, 99, "a")
+	gw.RouteByProperty(100, 199, "zone")
+	gw.ForwardProperties("zone")
+	gw.Start()
+	defer gw.Stop()
+	waitLinkReady(t, gw, "a")
+	waitLinkReady(t, gw, "zone-1")
+
+	// 网关上注册了处理方法的消息在网关处理，其余的按照msgId范围或者链接属性转发，回复转发给原来的客户端连接
+	client := newTestClient(t, front, 545)
+	require.NoError(t, client.Start())
+	for _, c := range []struct {
+		msgId uint32
+		data  string
+		reply string
+	}{{1, "ping", "ping"}, {10, "hello", "a:hello"}, {100, "", "zone-1:zone-1"}, {10, "again", "a:again"}} {
+		require.NoError(t, client.SendMsg(c.msgId, []byte(c.data)))
+		msg, err := client.ReadMsg()
+		require.NoError(t, err)
+		require.Equal(t, c.msgId, msg.GetMsgId())
+		require.Equal(t, c.reply, string(msg.GetData()))
+	}
+	require.Equal(t, "a connect 545", <-events)
+	require.Equal(t, "zone-1 connect 545", <-events)
+
+	// 后端断开客户端连接，收到过该连接消息的后端都收到断开通知
+	require.NoError(t, client.SendMsg(11, nil))
+	_, err := client.ReadMsg()
+	require.ErrorIs(t, err, io.EOF)
+	require.ElementsMatch(t, []string{"a disconnect 545", "zone-1 disconnect 545"}, []string{<-events, <-events})
+
+	// 网关与后端之间的连接断开时，后端上的虚拟连接全部断开，网关重连之后重新通知
+	client = newTestClient(t, front, 546)
+	require.NoError(t, client.Start())
+	require.NoError(t, client.SendMsg(10, []byte("one")))
+	_, err = client.ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, "a connect 546", <-events)
+	link, err := backendA.GetConnMgr().Get(550)
+	require.NoError(t, err)
+	link.Stop()
+	require.Equal(t, "a disconnect 546", <-events)
+
+	require.Eventually(t, func() bool {
+		_, err := backendA.GetConnMgr().Get(551)
+		return err == nil
+	}, 3*time.Second, 5*time.Millisecond)
+	waitLinkReady(t, gw, "a")
+	require.NoError(t, client.SendMsg(10, []byte("two")))
+	msg, err := client.ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, "a:two", string(msg.GetData()))
+	require.Equal(t, "a connect 546", <-events)
+
+	// 没有路由规则的消息不转发
+	_, err = gw.route(nil, 500)
+	require.ErrorIs(t, err, ErrGatewayNoRoute)
+}
+
+func TestGatewayUnknownMsg(t *testing.T) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 2
+	utils.GlobalObject.GatewayReconnectInterval = 10
+	utils.GlobalObject.UnknownMsgReply = true
+	utils.GlobalObject.UnknownMsgMaxCount = 3
+
+	events := make(chan string, 10)
+	backend, addr := newGatewayBackend(t, "a", 710, events)
+	backend.AddRouter(10, &backendRouter{name: "a"})
+
+	front := NewServer()
+	front.GetMsgHandler().StartWorkerPool()
+	gw := NewGateway(front)
+	require.NoError(t, gw.AddBackend("a", addr))
+	gw.RouteRange(10, 99, "a")
+	gw.Start()
+	defer gw.Stop()
+	waitLinkReady(t, gw, "a")
+
+	// 转发给后端的消息不是未知消息，超过UnknownMsgMaxCount也不会断开连接
+	client := newTestClient(t, front, 715)
+	require.NoError(t, client.Start())
+	for i := 0; i < 10; i++ {
+		require.NoError(t, client.SendMsg(10, []byte(fmt.Sprint(i))))
+		msg, err := client.ReadMsg()
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("a:%d", i), string(msg.GetData()))
+	}
+	require.Equal(t, "a connect 715", <-events)
+
+	// 没有路由规则的消息仍然是未知消息
+	require.NoError(t, client.SendMsg(500, nil))
+	msg, err := client.ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, utils.GlobalObject.UnknownMsgId, msg.GetMsgId())
+	require.Equal(t, tiface.UnknownMsgStats{NotFound: 1, Replied: 1}, front.GetUnknownMsgStats())
+}
@@ -0,0 +1,347 @@
+package tnet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrGatewayConnClosed = errors.New("gateway conn closed")
+	ErrGatewayReliable   = errors.New("reliable msg is not supported on gateway conn")
+)
+
+/*
+	网关的后端：处理网关转发来的消息
+	网关上的每个客户端连接在后端对应一个虚拟连接，消息以虚拟连接的身份分发给Router，
+	通过虚拟连接发送的消息经由网关转发给客户端；同一条网关长连接上的消息由该长连接所在的Worker按顺序处理
+*/
+type GatewayBackend struct {
+	BaseRouter
+	// 后端Server
+	server tiface.IServer
+	// 网关长连接的ConnID -> 客户端连接的ConnID -> 虚拟连接
+	conns map[uint32]map[uint32]*gatewayConn
+	// 保护conns
+	lock sync.Mutex
+
+	// 客户端连接第一次被转发到后端时调用
+	onConnect func(conn tiface.IConnection)
+	// 客户端连接断开或者网关长连接断开时调用
+	onDisconnect func(conn tiface.IConnection)
+}
+
+// 创建网关的后端，在Server上注册处理网关消息的Router
+func NewGatewayBackend(server tiface.IServer) *GatewayBackend {
+	b := &GatewayBackend{
+		server: server,
+		conns:  make(map[uint32]map[uint32]*gatewayConn),
+	}
+	server.AddRouter(utils.GlobalObject.GatewayMsgId, b)
+	return b
+}
+
+// 设置客户端连接第一次被转发到后端时调用的Hook函数
+func (b *GatewayBackend) SetOnConnect(hookFunc func(conn tiface.IConnection)) {
+	b.onConnect = hookFunc
+}
+
+// 设置客户端连接断开时调用的Hook函数
+func (b *GatewayBackend) SetOnDisconnect(hookFunc func(conn tiface.IConnection)) {
+	b.onDisconnect = hookFunc
+}
+
+// 获取网关长连接上客户端连接对应的虚拟连接
+func (b *GatewayBackend) GetConn(link tiface.IConnection, connID uint32) (tiface.IConnection, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if conn, ok := b.conns[link.GetConnID()][connID]; ok {
+		return conn, nil
+	}
+	return nil, errors.New("gateway connection not found")
+}
+
+// 获取全部虚拟连接的数量
+func (b *GatewayBackend) Len() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	n := 0
+	for _, conns := range b.conns {
+		n += len(conns)
+	}
+	return n
+}
+
+// 处理网关发来的消息
+func (b *GatewayBackend) Handle(request tiface.IRequest) {
+	link := request.GetConnection()
+	kind, connID, msgId, data, err := unpackGateway(request.GetData())
+	if err != nil {
+		fmt.Println("Gateway ConnID = ", link.GetConnID(), " sent invalid msg: ", err)
+		return
+	}
+
+	switch kind {
+	case GatewayConnect:
+		var info gatewayConnInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			fmt.Println("Gateway ConnID = ", link.GetConnID(), " sent invalid conn info: ", err)
+			return
+		}
+		b.connect(link, connID, info)
+	case GatewayData:
+		conn, err := b.GetConn(link, connID)
+		if err != nil {
+			fmt.Println("Gateway ConnID = ", link.GetConnID(), " sent msg of unknown ConnID = ", connID)
+			return
+		}
+		b.server.GetMsgHandler().DoMsgHandler(&Request{conn: conn, msg: NewMsgPackage(msgId, data)})
+	case GatewayDisconnect:
+		b.disconnect(link.GetConnID(), connID)
+	}
+}
+
+// 创建客户端连接对应的虚拟连接，网关长连接第一次出现时等待它断开
+func (b *GatewayBackend) connect(link tiface.IConnection, connID uint32, info gatewayConnInfo) {
+	ctx, cancel := context.WithCancel(link.Context())
+	conn := &gatewayConn{
+		IConnection: link,
+		backend:     b,
+		connID:      connID,
+		addr:        gatewayAddr(info.Addr),
+		property:    make(map[string]interface{}),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	for key, value := range info.Properties {
+		conn.property[key] = value
+	}
+
+	b.lock.Lock()
+	conns, ok := b.conns[link.GetConnID()]
+	if !ok {
+		conns = make(map[uint32]*gatewayConn)
+		b.conns[link.GetConnID()] = conns
+		go b.watch(link)
+	}
+	old := conns[connID]
+	conns[connID] = conn
+	b.lock.Unlock()
+
+	if old != nil {
+		b.close(old)
+	}
+	if b.onConnect != nil {
+		b.onConnect(conn)
+	}
+}
+
+// 网关长连接断开之后，其上的全部客户端连接都视为断开
+func (b *GatewayBackend) watch(link tiface.IConnection) {
+	<-link.Context().Done()
+
+	b.lock.Lock()
+	conns := b.conns[link.GetConnID()]
+	delete(b.conns, link.GetConnID())
+	b.lock.Unlock()
+
+	for _, conn := range conns {
+		b.close(conn)
+	}
+}
+
+// 客户端连接断开
+func (b *GatewayBackend) disconnect(linkID, connID uint32) {
+	b.lock.Lock()
+	conn, ok := b.conns[linkID][connID]
+	if ok {
+		delete(b.conns[linkID], connID)
+	}
+	b.lock.Unlock()
+
+	if ok {
+		b.close(conn)
+	}
+}
+
+// 关闭虚拟连接并调用断开的Hook函数
+func (b *GatewayBackend) close(conn *gatewayConn) {
+	conn.cancel()
+	if b.onDisconnect != nil {
+		b.onDisconnect(conn)
+	}
+}
+
+/*
+	网关上的客户端地址
+*/
+type gatewayAddr string
+
+func (addr gatewayAddr) Network() string {
+	return "tcp"
+}
+
+func (addr gatewayAddr) String() string {
+	return string(addr)
+}
+
+/*
+	后端上代表网关客户端连接的虚拟连接，嵌入的IConnection为网关长连接
+*/
+type gatewayConn struct {
+	tiface.IConnection
+	backend *GatewayBackend
+	// 客户端连接在网关上的ConnID
+	connID uint32
+	// 客户端的地址
+	addr net.Addr
+
+	// 链接属性集合，包含网关传递来的链接属性
+	property map[string]interface{}
+	// 保护链接属性修改的锁
+	propertyLock sync.RWMutex
+
+	// 客户端连接断开或者网关长连接断开时被取消
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// 虚拟连接不需要启动
+func (c *gatewayConn) Start() {}
+
+// 通知网关断开客户端连接
+func (c *gatewayConn) Stop() {
+	if c.closed() {
+		return
+	}
+	if err := c.IConnection.SendBuffMsg(utils.GlobalObject.GatewayMsgId, packGateway(GatewayKick, c.connID, 0, nil)); err != nil {
+		fmt.Println("Gateway kick ConnID = ", c.connID, " error: ", err)
+	}
+	c.backend.disconnect(c.IConnection.GetConnID(), c.connID)
+}
+
+// 判断虚拟连接是否已经断开
+func (c *gatewayConn) closed() bool {
+	return c.ctx.Err() != nil
+}
+
+// 虚拟连接没有对应的socket
+func (c *gatewayConn) GetTCPConnection() *net.TCPConn {
+	return nil
+}
+
+// 获取客户端连接在网关上的ConnID
+func (c *gatewayConn) GetConnID() uint32 {
+	return c.connID
+}
+
+// 获取客户端的地址
+func (c *gatewayConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// 通过网关长连接发送消息给客户端（无缓冲）
+func (c *gatewayConn) SendMsg(msgId uint32, data []byte) error {
+	if c.closed() {
+		return ErrGatewayConnClosed
+	}
+	return c.IConnection.SendMsg(utils.GlobalObject.GatewayMsgId, packGateway(GatewayData, c.connID, msgId, data))
+}
+
+// 通过网关长连接发送消息给客户端（有缓冲）
+func (c *gatewayConn) SendBuffMsg(msgId uint32, data []byte) error {
+	if c.closed() {
+		return ErrGatewayConnClosed
+	}
+	return c.IConnection.SendBuffMsg(utils.GlobalObject.GatewayMsgId, packGateway(GatewayData, c.connID, msgId, data))
+}
+
+// 将proto消息序列化之后发送给客户端
+func (c *gatewayConn) SendProto(msgId uint32, msg proto.Message) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.SendMsg(msgId, data)
+}
+
+// 使用msgId对应的序列化方式将v序列化之后发送给客户端
+func (c *gatewayConn) SendValue(msgId uint32, v interface{}) error {
+	data, err := c.GetCodec(msgId).Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.SendMsg(msgId, data)
+}
+
+// 可靠消息只在客户端与网关之间使用
+func (c *gatewayConn) SendReliable(msgId uint32, data []byte) (uint64, error) {
+	return 0, ErrGatewayReliable
+}
+
+func (c *gatewayConn) GetAckedSeq() uint64 {
+	return 0
+}
+
+// 客户端与网关协商的序列化方式后端无法得知，使用后端Server为msgId指定的或者默认的序列化方式
+func (c *gatewayConn) GetCodec(msgId uint32) tiface.ICodec {
+	server := c.IConnection.GetTcpServer()
+	if codec := server.GetMsgCodec(msgId); codec != nil {
+		return codec
+	}
+	return server.GetCodec()
+}
+
+// 设置链接属性
+func (c *gatewayConn) SetProperty(key string, value interface{}) {
+	c.propertyLock.Lock()
+	defer c.propertyLock.Unlock()
+	c.property[key] = value
+}
+
+// 获取链接属性
+func (c *gatewayConn) GetProperty(key string) (interface{}, error) {
+	c.propertyLock.RLock()
+	defer c.propertyLock.RUnlock()
+
+	if value, ok := c.property[key]; ok {
+		return value, nil
+	}
+	return nil, errors.New("the property was not found")
+}
+
+// 移除链接属性
+func (c *gatewayConn) RemoveProperty(key string) {
+	c.propertyLock.Lock()
+	defer c.propertyLock.Unlock()
+	delete(c.property, key)
+}
+
+// 限流在网关上进行
+func (c *gatewayConn) GetRateLimitStats() tiface.RateLimitStats {
+	return tiface.RateLimitStats{}
+}
+
+// 鉴权在网关上进行
+func (c *gatewayConn) IsAuthenticated() bool {
+	return true
+}
+
+// 会话在网关上保持
+func (c *gatewayConn) GetSessionID() string {
+	return ""
+}
+
+// 获取虚拟连接的context，客户端连接断开或者网关长连接断开时被取消
+func (c *gatewayConn) Context() context.Context {
+	return c.ctx
+}
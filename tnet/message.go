@@ -7,6 +7,8 @@ type Message struct {
 	Id uint32
 	// 消息的内容
 	Data []byte
+	// 消息的标志位，开启FrameFlags时随消息头部一起传输
+	Flags byte
 }
 
 // 创建一个Message消息包
@@ -33,6 +35,11 @@ func (msg *Message) GetData() []byte {
 	return msg.Data
 }
 
+// 获取消息标志位
+func (msg *Message) GetFlags() byte {
+	return msg.Flags
+}
+
 // 设置消息数据段长度
 func (msg *Message) SetDataLen(len uint32) {
 	msg.DataLen = len
@@ -47,3 +54,8 @@ func (msg *Message) SetMsgId(msgId uint32) {
 func (msg *Message) SetData(data []byte) {
 	msg.Data = data
 }
+
+// 设置消息标志位
+func (msg *Message) SetFlags(flags byte) {
+	msg.Flags = flags
+}
@@ -1,8 +1,13 @@
 package tnet
 
 import (
+	"encoding/binary"
 	"fmt"
+	"io"
+	"sort"
 	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/HOU-SZ/tigerkin/tiface"
 	"github.com/HOU-SZ/tigerkin/utils"
@@ -14,21 +19,54 @@ import (
 type MsgHandle struct {
 	// 存放每个MsgId 所对应的处理方法
 	Apis map[uint32]tiface.IRouter
+	// 存放每个MsgId 所对应的流式处理方法
+	StreamApis map[uint32]tiface.IStreamRouter
+	// 字符串路由映射的MsgId 所对应的路由名称
+	Names map[uint32]string
+	// 处理没有注册处理方法的消息的Router，为nil时按照配置回复错误消息
+	NotFoundRouter tiface.IRouter
+	// 转发没有注册处理方法的消息（例如网关），返回true表示已经转发，不计入未知消息
+	Forwarder func(request tiface.IRequest) bool
 	// 业务工作Worker池的worker数量
 	WorkerPoolSize uint32
 	// Worker取任务的消息队列
 	TaskQueue []chan tiface.IRequest
+
+	// 未知消息的统计信息
+	notFound     uint64
+	replied      uint64
+	disconnected uint64
 }
 
 // 创建MsgHandle的方法
 func NewMsgHandle() *MsgHandle {
 	return &MsgHandle{
 		Apis:           make(map[uint32]tiface.IRouter),
+		StreamApis:     make(map[uint32]tiface.IStreamRouter),
+		Names:          make(map[uint32]string),
 		WorkerPoolSize: utils.GlobalObject.WorkerPoolSize,                               //从全局配置中获取
 		TaskQueue:      make([]chan tiface.IRequest, utils.GlobalObject.WorkerPoolSize), // 一个worker对应一个queue
 	}
 }
 
+/*
+	交给Worker执行的任务（例如定时任务），与消息使用同一个任务队列，
+	因此与同一个Worker处理的连接的消息在同一个goroutine中按顺序执行
+*/
+type taskRequest struct {
+	tiface.IRequest
+	task func()
+}
+
+// 将任务交给workerKey对应的worker执行，未启动工作池时在新的goroutine中执行
+func (mh *MsgHandle) SendTaskToQueue(workerKey uint32, task func()) {
+	if mh.WorkerPoolSize == 0 || mh.TaskQueue[0] == nil {
+		go task()
+		return
+	}
+	mh.TaskQueue[workerKey%mh.WorkerPoolSize] <- &taskRequest{task: task}
+}
+
 // 将消息交给TaskQueue， 由worker进行处理
 func (mh *MsgHandle) SendMsgToTaskQueue(request tiface.IRequest) {
 	// 根据ConnID来分配当前的request应该由哪个worker负责处理
@@ -45,10 +83,25 @@ func (mh *MsgHandle) SendMsgToTaskQueue(request tiface.IRequest) {
 
 // 马上以非阻塞方式处理消息，调度/执行对应的Router消息处理方法
 func (mh *MsgHandle) DoMsgHandler(request tiface.IRequest) {
+	// 为请求创建context，处理结束时取消
+	if r, ok := request.(*Request); ok {
+		r.begin()
+		defer r.end()
+	}
+
+	// 注册了流式处理方法的消息，通过Reader读取消息体
+	if streamHandler, ok := mh.StreamApis[request.GetMsgID()]; ok {
+		body := request.GetBodyReader()
+		streamHandler.Handle(request, body)
+		// 丢弃未读完的数据，避免阻塞连接的Reader
+		io.Copy(io.Discard, body)
+		return
+	}
+
 	// 根据MsgID找到对应的Router
 	handler, ok := mh.Apis[request.GetMsgID()]
 	if !ok {
-		fmt.Println("api msgId = ", request.GetMsgID(), " is NOT FOUND!")
+		mh.handleNotFound(request)
 		return
 	}
 
@@ -58,17 +111,123 @@ func (mh *MsgHandle) DoMsgHandler(request tiface.IRequest) {
 	handler.PostHandle(request)
 }
 
+// 处理没有注册处理方法的消息：先交给Forwarder转发，无法转发的才是未知消息，
+// 时间窗口内的未知消息超出限制时断开连接，否则交给NotFound Router处理，没有设置时按照配置回复错误消息
+func (mh *MsgHandle) handleNotFound(request tiface.IRequest) {
+	if mh.Forwarder != nil && mh.Forwarder(request) {
+		return
+	}
+
+	atomic.AddUint64(&mh.notFound, 1)
+	conn := request.GetConnection()
+
+	if max := utils.GlobalObject.UnknownMsgMaxCount; max > 0 {
+		window := time.Duration(utils.GlobalObject.UnknownMsgWindow) * time.Second
+		if c, ok := conn.(interface {
+			countUnknownMsg(window time.Duration) uint32
+		}); ok && c.countUnknownMsg(window) > max {
+			fmt.Println("ConnID = ", conn.GetConnID(), " sent more than ", max, " unknown msgs, disconnect")
+			atomic.AddUint64(&mh.disconnected, 1)
+			conn.Stop()
+			return
+		}
+	}
+
+	if mh.NotFoundRouter != nil {
+		mh.NotFoundRouter.PreHandle(request)
+		mh.NotFoundRouter.Handle(request)
+		mh.NotFoundRouter.PostHandle(request)
+		return
+	}
+
+	fmt.Println("api msgId = ", request.GetMsgID(), " is NOT FOUND!")
+	if utils.GlobalObject.UnknownMsgReply {
+		data := make([]byte, 4)
+		binary.LittleEndian.PutUint32(data, request.GetMsgID())
+		if err := conn.SendBuffMsg(utils.GlobalObject.UnknownMsgId, data); err != nil {
+			fmt.Println("Send unknown msg reply error: ", err)
+			return
+		}
+		atomic.AddUint64(&mh.replied, 1)
+	}
+}
+
+// 设置处理没有注册处理方法的消息的Router
+func (mh *MsgHandle) SetNotFoundRouter(router tiface.IRouter) {
+	mh.NotFoundRouter = router
+}
+
+// 设置转发没有注册处理方法的消息的方法
+func (mh *MsgHandle) SetForwarder(forwarder func(request tiface.IRequest) bool) {
+	mh.Forwarder = forwarder
+}
+
+// 获取未知消息的统计信息
+func (mh *MsgHandle) GetUnknownMsgStats() tiface.UnknownMsgStats {
+	return tiface.UnknownMsgStats{
+		NotFound:     atomic.LoadUint64(&mh.notFound),
+		Replied:      atomic.LoadUint64(&mh.replied),
+		Disconnected: atomic.LoadUint64(&mh.disconnected),
+	}
+}
+
 // 为消息添加具体的处理逻辑
 func (mh *MsgHandle) AddRouter(msgId uint32, router tiface.IRouter) {
 	// 1 判断当前msg绑定的API处理方法是否已经存在
 	if _, ok := mh.Apis[msgId]; ok {
 		panic("repeated api , msgId = " + strconv.Itoa(int(msgId)))
 	}
+	if _, ok := mh.StreamApis[msgId]; ok {
+		panic("repeated api , msgId = " + strconv.Itoa(int(msgId)))
+	}
 	// 2 添加msg与api的绑定关系
 	mh.Apis[msgId] = router
 	// fmt.Println("[Tigerkin] Add api msgId = ", msgId, " success!")
 }
 
+// 为大消息添加流式处理逻辑
+func (mh *MsgHandle) AddStreamRouter(msgId uint32, router tiface.IStreamRouter) {
+	if _, ok := mh.Apis[msgId]; ok {
+		panic("repeated api , msgId = " + strconv.Itoa(int(msgId)))
+	}
+	if _, ok := mh.StreamApis[msgId]; ok {
+		panic("repeated api , msgId = " + strconv.Itoa(int(msgId)))
+	}
+	mh.StreamApis[msgId] = router
+}
+
+// 注册带有字符串路由名称的处理逻辑，不同的名称映射到同一个msgId时panic
+func (mh *MsgHandle) AddNamedRouter(name string, msgId uint32, router tiface.IRouter) {
+	if old, ok := mh.Names[msgId]; ok {
+		panic(fmt.Sprintf("repeated route %q, msgId = %d is used by route %q", name, msgId, old))
+	}
+	mh.AddRouter(msgId, router)
+	mh.Names[msgId] = name
+}
+
+// 获取路由表，按照msgId排序
+func (mh *MsgHandle) GetRoutes() []tiface.RouteInfo {
+	routes := make([]tiface.RouteInfo, 0, len(mh.Apis)+len(mh.StreamApis))
+	for msgId, router := range mh.Apis {
+		route := tiface.RouteInfo{MsgId: msgId, Name: mh.Names[msgId], Router: routerType(router)}
+		if r, ok := router.(*groupRouter); ok {
+			route.Middlewares = len(r.middlewares)
+		}
+		routes = append(routes, route)
+	}
+	for msgId, router := range mh.StreamApis {
+		routes = append(routes, tiface.RouteInfo{MsgId: msgId, Router: routerType(router), Stream: true})
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].MsgId < routes[j].MsgId })
+	return routes
+}
+
+// 判断msgId是否注册了流式处理逻辑
+func (mh *MsgHandle) HasStreamRouter(msgId uint32) bool {
+	_, ok := mh.StreamApis[msgId]
+	return ok
+}
+
 // 启动worker工作池（只执行一次，因为一个框架只能有一个工作池）
 func (mh *MsgHandle) StartWorkerPool() {
 	// 根据WorkerPoolSize依次开启worker，每个worker为一个goroutine
@@ -89,6 +248,10 @@ func (mh *MsgHandle) StartOneWorker(workerID int, taskQueue chan tiface.IRequest
 		select {
 		// 有消息则取出队列的Request，并执行绑定的业务方法
 		case request := <-taskQueue:
+			if task, ok := request.(*taskRequest); ok {
+				task.task()
+				continue
+			}
 			mh.DoMsgHandler(request)
 		}
 	}
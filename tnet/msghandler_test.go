@@ -0,0 +1,64 @@
+package tnet
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnknownMsg(t *testing.T) {
+	conf := *utils.GlobalObject
+	defer func() { *utils.GlobalObject = conf }()
+	utils.GlobalObject.WorkerPoolSize = 0
+	utils.GlobalObject.UnknownMsgReply = true
+	utils.GlobalObject.UnknownMsgMaxCount = 3
+
+	s := NewServer()
+	s.AddRouter(1, &EchoRouter{})
+	serverConn, client := newTCPPair(t)
+	defer client.Close()
+	conn := NewConnection(s, serverConn, 540, s.(*Server).msgHandler)
+	go conn.Start()
+	defer conn.stopAndWait()
+
+	// 未知消息回复错误消息，数据为未知消息的msgId
+	for i := 0; i < 3; i++ {
+		writeTestMsg(t, client, 7, nil)
+		msg := readTestMsg(t, client)
+		require.Equal(t, utils.GlobalObject.UnknownMsgId, msg.GetMsgId())
+		require.Equal(t, uint32(7), binary.LittleEndian.Uint32(msg.GetData()))
+	}
+	writeTestMsg(t, client, 1, []byte("ping"))
+	require.Equal(t, "ping", string(readTestMsg(t, client).GetData()))
+
+	// 时间窗口内超出限制之后断开连接
+	writeTestMsg(t, client, 7, nil)
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err := client.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, tiface.UnknownMsgStats{NotFound: 4, Replied: 3, Disconnected: 1}, s.GetUnknownMsgStats())
+
+	// 设置了NotFound Router时由Router处理
+	s.SetNotFoundRouter(&EchoRouter{})
+	serverConn, client = newTCPPair(t)
+	defer client.Close()
+	conn = NewConnection(s, serverConn, 541, s.(*Server).msgHandler)
+	go conn.Start()
+	defer conn.stopAndWait()
+	writeTestMsg(t, client, 8, []byte("lost"))
+	msg := readTestMsg(t, client)
+	require.Equal(t, uint32(8), msg.GetMsgId())
+	require.Equal(t, "lost", string(msg.GetData()))
+	require.Equal(t, tiface.UnknownMsgStats{NotFound: 5, Replied: 3, Disconnected: 1}, s.GetUnknownMsgStats())
+
+	// 时间窗口过去之后重新计数
+	require.Equal(t, uint32(2), conn.countUnknownMsg(time.Hour))
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, uint32(1), conn.countUnknownMsg(10*time.Millisecond))
+	conn.Stop()
+}
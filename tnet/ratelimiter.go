@@ -0,0 +1,244 @@
+package tnet
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+)
+
+// 超出限流后的处理策略
+const (
+	RateLimitDrop       = "drop"       // 直接丢弃该消息
+	RateLimitDelay      = "delay"      // 等待令牌补充后再分发该消息
+	RateLimitReply      = "reply"      // 丢弃该消息，并回复客户端一条限流错误消息
+	RateLimitDisconnect = "disconnect" // 丢弃该消息，超限次数达到上限后断开连接
+)
+
+/*
+	令牌桶，按照固定速率补充令牌，每条消息消耗一个令牌
+	nil的令牌桶表示不限流
+*/
+type TokenBucket struct {
+	// 每秒补充的令牌数量
+	rate float64
+	// 令牌桶的容量
+	burst float64
+	// 当前令牌数量，预留令牌时可能为负数
+	tokens float64
+	// 上一次补充令牌的时间
+	last time.Time
+	// 保护令牌数量的锁
+	lock sync.Mutex
+}
+
+// 创建一个令牌桶，rate小于等于0时返回nil，表示不限流
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// 根据距离上次补充的时间补充令牌
+func (tb *TokenBucket) refill(now time.Time) {
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+}
+
+// 尝试取出一个令牌，令牌不足时返回false
+func (tb *TokenBucket) Allow() bool {
+	if tb == nil {
+		return true
+	}
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	tb.refill(time.Now())
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+// 归还一个取出的令牌，不超过令牌桶的容量
+func (tb *TokenBucket) refund() {
+	if tb == nil {
+		return
+	}
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	tb.tokens++
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+}
+
+// 依次从多个令牌桶中取出令牌，某个令牌桶令牌不足时归还之前已经取出的令牌，
+// 被后面的令牌桶拒绝的消息不会消耗前面令牌桶的额度
+func allowAll(buckets ...*TokenBucket) bool {
+	for i, tb := range buckets {
+		if !tb.Allow() {
+			for _, prev := range buckets[:i] {
+				prev.refund()
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// 预留一个令牌，返回拿到该令牌需要等待的时间
+func (tb *TokenBucket) Reserve() time.Duration {
+	if tb == nil {
+		return 0
+	}
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	tb.refill(time.Now())
+	tb.tokens--
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+/*
+	Server级别的限流器，根据全局配置为每个连接创建连接级别的限流器
+*/
+type RateLimiter struct {
+	// 所有连接共享的令牌桶
+	global *TokenBucket
+	// 每个连接的限流参数
+	connConf utils.RateLimitConf
+	// 每个连接上各个MsgId的限流参数
+	msgConf map[uint32]utils.RateLimitConf
+	// 超出限流后的处理策略
+	policy string
+	// disconnect策略下允许的超限次数
+	maxViolations uint32
+	// reply策略下回复的错误消息ID
+	replyMsgId uint32
+}
+
+// 根据全局配置创建Server级别的限流器
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		global:        NewTokenBucket(utils.GlobalObject.RateLimitGlobal.Rate, utils.GlobalObject.RateLimitGlobal.Burst),
+		connConf:      utils.GlobalObject.RateLimitConn,
+		msgConf:       utils.GlobalObject.RateLimitMsg,
+		policy:        utils.GlobalObject.RateLimitPolicy,
+		maxViolations: utils.GlobalObject.RateLimitMaxViolations,
+		replyMsgId:    utils.GlobalObject.RateLimitMsgId,
+	}
+}
+
+// 为新建立的连接创建一个连接级别的限流器
+func (rl *RateLimiter) NewConnLimiter() tiface.IConnLimiter {
+	cl := &ConnLimiter{
+		limiter:    rl,
+		conn:       NewTokenBucket(rl.connConf.Rate, rl.connConf.Burst),
+		msgBuckets: make(map[uint32]*TokenBucket, len(rl.msgConf)),
+	}
+	for msgId, conf := range rl.msgConf {
+		if bucket := NewTokenBucket(conf.Rate, conf.Burst); bucket != nil {
+			cl.msgBuckets[msgId] = bucket
+		}
+	}
+	return cl
+}
+
+/*
+	连接级别的限流器，只在该连接的Reader goroutine中使用
+*/
+type ConnLimiter struct {
+	// 所属的Server级别限流器
+	limiter *RateLimiter
+	// 当前连接的令牌桶
+	conn *TokenBucket
+	// 当前连接上各个MsgId的令牌桶
+	msgBuckets map[uint32]*TokenBucket
+
+	// 限流统计信息
+	passed     uint64
+	dropped    uint64
+	delayed    uint64
+	replied    uint64
+	violations uint64
+}
+
+// 检查该连接上收到的消息是否允许继续分发
+func (cl *ConnLimiter) Allow(conn tiface.IConnection, msgId uint32) bool {
+	rl := cl.limiter
+	msgBucket := cl.msgBuckets[msgId]
+
+	// delay策略：预留令牌，等待令牌补充后再分发
+	if rl.policy == RateLimitDelay {
+		wait := msgBucket.Reserve()
+		if d := cl.conn.Reserve(); d > wait {
+			wait = d
+		}
+		if d := rl.global.Reserve(); d > wait {
+			wait = d
+		}
+		if wait > 0 {
+			atomic.AddUint64(&cl.violations, 1)
+			atomic.AddUint64(&cl.delayed, 1)
+			time.Sleep(wait)
+		}
+		atomic.AddUint64(&cl.passed, 1)
+		return true
+	}
+
+	// 依次检查MsgId、连接、全局的令牌桶，任意一个拒绝时都不消耗其他令牌桶的令牌
+	if allowAll(msgBucket, cl.conn, rl.global) {
+		atomic.AddUint64(&cl.passed, 1)
+		return true
+	}
+
+	violations := atomic.AddUint64(&cl.violations, 1)
+	switch rl.policy {
+	case RateLimitReply:
+		atomic.AddUint64(&cl.replied, 1)
+		if err := conn.SendBuffMsg(rl.replyMsgId, []byte(fmt.Sprintf("msgId = %d rate limit exceeded", msgId))); err != nil {
+			fmt.Println("Send rate limit reply error: ", err)
+		}
+	case RateLimitDisconnect:
+		atomic.AddUint64(&cl.dropped, 1)
+		if violations >= uint64(rl.maxViolations) {
+			fmt.Println("ConnID = ", conn.GetConnID(), " exceeded rate limit ", violations, " times, disconnect")
+			conn.Stop()
+		}
+	default:
+		atomic.AddUint64(&cl.dropped, 1)
+	}
+	return false
+}
+
+// 获取当前连接的限流统计信息
+func (cl *ConnLimiter) GetStats() tiface.RateLimitStats {
+	return tiface.RateLimitStats{
+		Passed:     atomic.LoadUint64(&cl.passed),
+		Dropped:    atomic.LoadUint64(&cl.dropped),
+		Delayed:    atomic.LoadUint64(&cl.delayed),
+		Replied:    atomic.LoadUint64(&cl.replied),
+		Violations: atomic.LoadUint64(&cl.violations),
+	}
+}
@@ -0,0 +1,161 @@
+package tnet
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// 创建一对通过本地回环地址相连的TCP连接，返回服务端和客户端
+func newTCPPair(t *testing.T) (*net.TCPConn, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	server, err := listener.Accept()
+	require.NoError(t, err)
+
+	return server.(*net.TCPConn), client
+}
+
+func TestTokenBucket(t *testing.T) {
+	// rate小于等于0时不限流
+	var unlimited *TokenBucket = NewTokenBucket(0, 10)
+	require.Nil(t, unlimited)
+	require.True(t, unlimited.Allow())
+	require.Equal(t, time.Duration(0), unlimited.Reserve())
+
+	tb := NewTokenBucket(10, 2)
+	require.True(t, tb.Allow())
+	require.True(t, tb.Allow())
+	require.False(t, tb.Allow())
+
+	// 令牌不足时预留令牌需要等待
+	require.Greater(t, tb.Reserve(), time.Duration(0))
+
+	// 等待令牌补充
+	time.Sleep(300 * time.Millisecond)
+	require.True(t, tb.Allow())
+}
+
+func TestConnLimiterRefund(t *testing.T) {
+	conf := *utils.GlobalObject
+	defer func() { *utils.GlobalObject = conf }()
+
+	utils.GlobalObject.RateLimitPolicy = RateLimitDrop
+	utils.GlobalObject.RateLimitGlobal = utils.RateLimitConf{Rate: 0.001, Burst: 1}
+	utils.GlobalObject.RateLimitConn = utils.RateLimitConf{Rate: 0.001, Burst: 2}
+	utils.GlobalObject.RateLimitMsg = map[uint32]utils.RateLimitConf{3: {Rate: 0.001, Burst: 1}}
+	rl := NewRateLimiter()
+
+	// 全局令牌被其他连接用完
+	require.True(t, rl.NewConnLimiter().Allow(nil, 1))
+
+	cl := rl.NewConnLimiter().(*ConnLimiter)
+	for i := 0; i < 3; i++ {
+		require.False(t, cl.Allow(nil, 3))
+	}
+	require.Equal(t, uint64(3), cl.GetStats().Dropped)
+
+	// 被全局令牌桶拒绝的消息没有消耗MsgId和连接的令牌
+	require.True(t, cl.msgBuckets[3].Allow())
+	require.True(t, cl.conn.Allow())
+	require.True(t, cl.conn.Allow())
+	require.False(t, cl.conn.Allow())
+}
+
+func TestConnLimiterPolicies(t *testing.T) {
+	conf := *utils.GlobalObject
+	defer func() { *utils.GlobalObject = conf }()
+
+	utils.GlobalObject.RateLimitConn = utils.RateLimitConf{Rate: 0.001, Burst: 1}
+	utils.GlobalObject.RateLimitMsg = map[uint32]utils.RateLimitConf{3: {Rate: 0.001, Burst: 1}}
+
+	s := NewServer()
+
+	t.Run("drop", func(t *testing.T) {
+		utils.GlobalObject.RateLimitPolicy = RateLimitDrop
+		s.SetRateLimiter(NewRateLimiter())
+
+		serverConn, client := newTCPPair(t)
+		defer client.Close()
+		c := NewConnection(s, serverConn, 100, NewMsgHandle())
+		defer c.Stop()
+
+		// MsgId 3 有单独的限流，第二条即被限流
+		require.True(t, c.limiter.Allow(c, 3))
+		require.False(t, c.limiter.Allow(c, 3))
+
+		stats := c.GetRateLimitStats()
+		require.Equal(t, uint64(1), stats.Passed)
+		require.Equal(t, uint64(1), stats.Dropped)
+		require.Equal(t, uint64(1), stats.Violations)
+	})
+
+	t.Run("reply", func(t *testing.T) {
+		utils.GlobalObject.RateLimitPolicy = RateLimitReply
+		s.SetRateLimiter(NewRateLimiter())
+
+		serverConn, client := newTCPPair(t)
+		defer client.Close()
+		c := NewConnection(s, serverConn, 101, NewMsgHandle())
+		go c.StartWriter()
+		defer c.Stop()
+
+		require.True(t, c.limiter.Allow(c, 1))
+		require.False(t, c.limiter.Allow(c, 1))
+
+		// 客户端会收到限流错误消息
+		dp := NewDataPack()
+		headData := make([]byte, dp.GetHeadLen())
+		_, err := io.ReadFull(client, headData)
+		require.NoError(t, err)
+		msg, err := dp.Unpack(headData)
+		require.NoError(t, err)
+		require.Equal(t, utils.GlobalObject.RateLimitMsgId, msg.GetMsgId())
+		require.Equal(t, uint64(1), c.GetRateLimitStats().Replied)
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		utils.GlobalObject.RateLimitPolicy = RateLimitDisconnect
+		utils.GlobalObject.RateLimitMaxViolations = 2
+		s.SetRateLimiter(NewRateLimiter())
+
+		serverConn, client := newTCPPair(t)
+		defer client.Close()
+		c := NewConnection(s, serverConn, 102, NewMsgHandle())
+
+		require.True(t, c.limiter.Allow(c, 1))
+		require.False(t, c.limiter.Allow(c, 1))
+		require.False(t, c.closed())
+
+		// 超限次数达到上限后连接被断开
+		require.False(t, c.limiter.Allow(c, 1))
+		require.True(t, c.closed())
+		_, err := s.GetConnMgr().Get(102)
+		require.Error(t, err)
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		utils.GlobalObject.RateLimitPolicy = RateLimitDelay
+		utils.GlobalObject.RateLimitConn = utils.RateLimitConf{Rate: 20, Burst: 1}
+		s.SetRateLimiter(NewRateLimiter())
+
+		serverConn, client := newTCPPair(t)
+		defer client.Close()
+		c := NewConnection(s, serverConn, 103, NewMsgHandle())
+		defer c.Stop()
+
+		start := time.Now()
+		require.True(t, c.limiter.Allow(c, 1))
+		require.True(t, c.limiter.Allow(c, 1))
+		require.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+		require.Equal(t, uint64(1), c.GetRateLimitStats().Delayed)
+	})
+}
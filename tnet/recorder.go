@@ -0,0 +1,315 @@
+package tnet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+)
+
+/*
+	录制文件格式（整数均为小端序）：
+
+	文件头
+		Magic      [4]byte  "TKRC"
+		Version    uint16   录制格式版本，当前为1
+		ConnID     uint32   连接ID
+		Start      int64    连接建立的时间（Unix纳秒）
+		AddrLen    uint16   客户端地址的长度
+		Addr       [AddrLen]byte
+
+	之后是按照发生顺序排列的记录，直到文件结束
+		Type       byte     1收到的消息，2发出的消息，3连接关闭
+		Offset     int64    距离连接建立的时间（纳秒）
+		MsgId      uint32
+		Flags      byte
+		DataLen    uint32
+		Data       [DataLen]byte
+
+	消息以帧为单位记录：收到的消息在解密之后、重组和解压之前，发出的消息在压缩和拆分之后、加密之前
+	密钥交换消息不被记录，连接关闭记录的MsgId、Flags和DataLen为0
+*/
+const (
+	RecordVersion = 1       // 录制格式版本
+	RecordFileExt = ".tkrc" // FileRecorder创建的录制文件的扩展名
+)
+
+// 录制文件开头的Magic
+var recordMagic = [4]byte{'T', 'K', 'R', 'C'}
+
+// 录制的记录类型
+type RecordType byte
+
+const (
+	RecordInbound  RecordType = iota + 1 // 收到客户端的消息
+	RecordOutbound                       // 发给客户端的消息
+	RecordClose                          // 连接关闭
+)
+
+func (rt RecordType) String() string {
+	switch rt {
+	case RecordInbound:
+		return "in"
+	case RecordOutbound:
+		return "out"
+	case RecordClose:
+		return "close"
+	default:
+		return "RecordType(" + strconv.Itoa(int(rt)) + ")"
+	}
+}
+
+/*
+	录制中的一条记录
+*/
+type Record struct {
+	Type RecordType
+	// 距离连接建立的时间
+	Offset time.Duration
+	MsgId  uint32
+	Flags  byte
+	Data   []byte
+}
+
+/*
+	一个连接的录制
+*/
+type Recording struct {
+	ConnID     uint32
+	RemoteAddr string
+	// 连接建立的时间
+	Start   time.Time
+	Records []Record
+}
+
+/*
+	录制文件的写入模块，写入文件头之后逐条写入记录，不是并发安全的
+*/
+type RecordWriter struct {
+	w     io.Writer
+	start time.Time
+	// 复用的记录缓冲，每条记录只调用一次Write
+	buf bytes.Buffer
+}
+
+// 创建一个写入w的RecordWriter，并写入文件头
+func NewRecordWriter(w io.Writer, connID uint32, remoteAddr string, start time.Time) (*RecordWriter, error) {
+	if len(remoteAddr) > 0xFFFF {
+		return nil, errors.New("too long remote addr")
+	}
+	rw := &RecordWriter{w: w, start: start}
+	rw.buf.Write(recordMagic[:])
+	binary.Write(&rw.buf, binary.LittleEndian, uint16(RecordVersion))
+	binary.Write(&rw.buf, binary.LittleEndian, connID)
+	binary.Write(&rw.buf, binary.LittleEndian, start.UnixNano())
+	binary.Write(&rw.buf, binary.LittleEndian, uint16(len(remoteAddr)))
+	rw.buf.WriteString(remoteAddr)
+	if _, err := w.Write(rw.buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+// 写入一条在at时刻发生的记录
+func (rw *RecordWriter) WriteRecord(typ RecordType, at time.Time, msg tiface.IMessage) error {
+	var (
+		msgId uint32
+		flags byte
+		data  []byte
+	)
+	if msg != nil {
+		msgId, flags, data = msg.GetMsgId(), msg.GetFlags(), msg.GetData()
+	}
+
+	rw.buf.Reset()
+	rw.buf.WriteByte(byte(typ))
+	binary.Write(&rw.buf, binary.LittleEndian, int64(at.Sub(rw.start)))
+	binary.Write(&rw.buf, binary.LittleEndian, msgId)
+	rw.buf.WriteByte(flags)
+	binary.Write(&rw.buf, binary.LittleEndian, uint32(len(data)))
+	rw.buf.Write(data)
+	_, err := rw.w.Write(rw.buf.Bytes())
+	return err
+}
+
+// 从r中读取一个完整的录制
+func ReadRecording(r io.Reader) (*Recording, error) {
+	br := bufio.NewReader(r)
+
+	var head struct {
+		Magic   [4]byte
+		Version uint16
+		ConnID  uint32
+		Start   int64
+		AddrLen uint16
+	}
+	if err := binary.Read(br, binary.LittleEndian, &head); err != nil {
+		return nil, fmt.Errorf("read recording head: %w", err)
+	}
+	if head.Magic != recordMagic {
+		return nil, errors.New("not a tigerkin recording")
+	}
+	if head.Version != RecordVersion {
+		return nil, fmt.Errorf("unsupported recording version %d", head.Version)
+	}
+	addr := make([]byte, head.AddrLen)
+	if _, err := io.ReadFull(br, addr); err != nil {
+		return nil, fmt.Errorf("read recording head: %w", err)
+	}
+	rec := &Recording{
+		ConnID:     head.ConnID,
+		RemoteAddr: string(addr),
+		Start:      time.Unix(0, head.Start),
+	}
+
+	for {
+		var rh struct {
+			Type    RecordType
+			Offset  int64
+			MsgId   uint32
+			Flags   byte
+			DataLen uint32
+		}
+		if err := binary.Read(br, binary.LittleEndian, &rh); err != nil {
+			if err == io.EOF {
+				return rec, nil
+			}
+			return nil, fmt.Errorf("read record %d: %w", len(rec.Records), err)
+		}
+		// 按照实际读到的数据分配内存，损坏的DataLen不会导致预先分配很大的内存
+		data, err := io.ReadAll(io.LimitReader(br, int64(rh.DataLen)))
+		if err == nil && uint32(len(data)) != rh.DataLen {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read record %d: %w", len(rec.Records), err)
+		}
+		if rh.DataLen == 0 {
+			data = nil
+		}
+		rec.Records = append(rec.Records, Record{
+			Type:   rh.Type,
+			Offset: time.Duration(rh.Offset),
+			MsgId:  rh.MsgId,
+			Flags:  rh.Flags,
+			Data:   data,
+		})
+	}
+}
+
+// 读取录制文件
+func LoadRecording(path string) (*Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadRecording(f)
+}
+
+/*
+	将每个连接的消息录制到目录中的单独文件，文件名为"连接建立时间-连接ID.tkrc"
+	录制文件中是解密之后的明文（包括鉴权Token），目录和文件只有当前用户可以访问
+*/
+type FileRecorder struct {
+	dir string
+}
+
+// 创建一个将录制文件保存在dir中的FileRecorder
+func NewFileRecorder(dir string) *FileRecorder {
+	return &FileRecorder{dir: dir}
+}
+
+// 为新建立的连接创建录制文件
+func (fr *FileRecorder) NewConnRecorder(conn tiface.IConnection) (tiface.IConnRecorder, error) {
+	if err := os.MkdirAll(fr.dir, 0700); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	name := fmt.Sprintf("%s-%d%s", start.Format("20060102-150405.000000"), conn.GetConnID(), RecordFileExt)
+	f, err := os.OpenFile(filepath.Join(fr.dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewRecordWriter(f, conn.GetConnID(), conn.RemoteAddr().String(), start)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &connRecorder{w: w, file: f}, nil
+}
+
+/*
+	连接级别的文件录制器
+	每条记录直接写入文件，服务器异常退出时已经记录的消息不会丢失
+*/
+type connRecorder struct {
+	w    *RecordWriter
+	file *os.File
+	// 写入出错或者已经关闭之后不再记录
+	closed bool
+	lock   sync.Mutex
+}
+
+// 记录一个收到的消息
+func (cr *connRecorder) RecordInbound(msg tiface.IMessage) {
+	cr.record(RecordInbound, msg)
+}
+
+// 记录一个发出的消息
+func (cr *connRecorder) RecordOutbound(msg tiface.IMessage) {
+	cr.record(RecordOutbound, msg)
+}
+
+func (cr *connRecorder) record(typ RecordType, msg tiface.IMessage) {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	if cr.closed {
+		return
+	}
+	if err := cr.w.WriteRecord(typ, time.Now(), msg); err != nil {
+		fmt.Println("Record ", cr.file.Name(), " error: ", err, ", stop recording")
+		cr.closed = true
+		cr.file.Close()
+	}
+}
+
+// 记录连接关闭并关闭录制文件
+func (cr *connRecorder) Close() error {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+	if cr.closed {
+		return nil
+	}
+	cr.closed = true
+	err := cr.w.WriteRecord(RecordClose, time.Now(), nil)
+	if closeErr := cr.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// 将封包好的一个或多个消息逐个记录为发出的消息，密钥交换消息不被记录
+func recordFrames(recorder tiface.IConnRecorder, dp tiface.IDataPack, frames []byte) error {
+	r := bytes.NewReader(frames)
+	for r.Len() > 0 {
+		msg, err := readFrame(r, dp)
+		if err != nil {
+			return err
+		}
+		if msg.GetMsgId() != utils.GlobalObject.KeyExchangeMsgId {
+			recorder.RecordOutbound(msg)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package tnet
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// 等待连接的录制文件记录了连接关闭，返回录制
+func waitRecording(t *testing.T, dir string, connID uint32) *Recording {
+	var rec *Recording
+	require.Eventually(t, func() bool {
+		files, err := filepath.Glob(filepath.Join(dir, fmt.Sprintf("*-%d%s", connID, RecordFileExt)))
+		if err != nil || len(files) != 1 {
+			return false
+		}
+		r, err := LoadRecording(files[0])
+		if err != nil || len(r.Records) == 0 || r.Records[len(r.Records)-1].Type != RecordClose {
+			return false
+		}
+		rec = r
+		return true
+	}, 3*time.Second, 10*time.Millisecond)
+	return rec
+}
+
+func TestRecordingFormat(t *testing.T) {
+	start := time.Unix(1700000000, 123)
+	var buf bytes.Buffer
+	w, err := NewRecordWriter(&buf, 7, "127.0.0.1:1234", start)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteRecord(RecordInbound, start.Add(time.Millisecond), NewMsgPackage(1, []byte("hi"))))
+	require.NoError(t, w.WriteRecord(RecordOutbound, start.Add(2*time.Millisecond), &Message{Id: 2, DataLen: 3, Data: []byte("abc"), Flags: FlagFragment}))
+	require.NoError(t, w.WriteRecord(RecordClose, start.Add(3*time.Millisecond), nil))
+
+	// 文件头4+2+4+8+2+14字节，每条记录18字节加上数据
+	require.Equal(t, 34+18*3+2+3, buf.Len())
+	rec, err := ReadRecording(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, uint32(7), rec.ConnID)
+	require.Equal(t, "127.0.0.1:1234", rec.RemoteAddr)
+	require.True(t, start.Equal(rec.Start))
+	require.Equal(t, []Record{
+		{Type: RecordInbound, Offset: time.Millisecond, MsgId: 1, Data: []byte("hi")},
+		{Type: RecordOutbound, Offset: 2 * time.Millisecond, MsgId: 2, Flags: FlagFragment, Data: []byte("abc")},
+		{Type: RecordClose, Offset: 3 * time.Millisecond},
+	}, rec.Records)
+	require.Equal(t, "out", RecordOutbound.String())
+
+	// 截断的记录、不是录制文件、不支持的版本
+	_, err = ReadRecording(bytes.NewReader(buf.Bytes()[:buf.Len()-19]))
+	require.Error(t, err)
+	_, err = ReadRecording(bytes.NewReader([]byte("not a recording at all")))
+	require.Error(t, err)
+	data := append([]byte(nil), buf.Bytes()...)
+	data[4] = 2
+	_, err = ReadRecording(bytes.NewReader(data))
+	require.Error(t, err)
+}
+
+func TestFileRecorder(t *testing.T) {
+	for i, secure := range []string{"", SecureAESGCM} {
+		t.Run("secure="+secure, func(t *testing.T) {
+			testFileRecorder(t, uint32(650+i), secure)
+		})
+	}
+}
+
+// 配置在连接退出之后才恢复，Cleanup按照注册的相反顺序执行，恢复配置最先注册
+func testFileRecorder(t *testing.T, connID uint32, secure string) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 0
+	utils.GlobalObject.FrameFlags = true
+	utils.GlobalObject.Compressor = "gzip"
+	utils.GlobalObject.CompressThreshold = 64
+	utils.GlobalObject.SecureChannel = secure
+	utils.GlobalObject.RecordDir = filepath.Join(t.TempDir(), "records")
+
+	s := NewServer()
+	s.AddRouter(1, &EchoRouter{})
+	client := newTestClient(t, s, connID)
+	require.NoError(t, client.Start())
+
+	// 超过MaxPacketSize的消息被压缩之后仍然需要拆分为多个分片
+	rnd := rand.New(rand.NewSource(1))
+	large := make([]byte, 12*utils.GlobalObject.MaxPacketSize)
+	for j := range large {
+		large[j] = byte('a' + rnd.Intn(4))
+	}
+	for _, data := range [][]byte{[]byte("hello"), large} {
+		require.NoError(t, client.SendMsg(1, data))
+		msg, err := client.ReadMsg()
+		require.NoError(t, err)
+		require.Equal(t, data, msg.GetData())
+	}
+	client.Stop()
+
+	rec := waitRecording(t, utils.GlobalObject.RecordDir, connID)
+	require.Equal(t, connID, rec.ConnID)
+	require.NotEmpty(t, rec.RemoteAddr)
+
+	// 录制中含有明文，目录和文件只有当前用户可以访问
+	info, err := os.Stat(utils.GlobalObject.RecordDir)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	files, err := filepath.Glob(filepath.Join(utils.GlobalObject.RecordDir, "*"+RecordFileExt))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	info, err = os.Stat(files[0])
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// 记录的是加密之前的明文消息，不包括密钥交换消息
+	var inbound, outbound []Record
+	for _, r := range rec.Records {
+		require.NotEqual(t, utils.GlobalObject.KeyExchangeMsgId, r.MsgId)
+		switch r.Type {
+		case RecordInbound:
+			inbound = append(inbound, r)
+		case RecordOutbound:
+			outbound = append(outbound, r)
+		}
+	}
+	require.Greater(t, len(inbound), 2)
+	require.Equal(t, len(inbound), len(outbound))
+	require.Equal(t, Record{Type: RecordInbound, Offset: inbound[0].Offset, MsgId: 1, Data: []byte("hello")}, inbound[0])
+	require.Equal(t, []byte("hello"), outbound[0].Data)
+	require.NotZero(t, inbound[1].Flags&FlagFragment)
+	require.NotZero(t, inbound[1].Flags&FlagCompressMask)
+	require.LessOrEqual(t, inbound[0].Offset, outbound[0].Offset)
+}
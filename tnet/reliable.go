@@ -0,0 +1,165 @@
+package tnet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/HOU-SZ/tigerkin/utils"
+)
+
+// 可靠消息的类型，位于ReliableMsgId消息数据的第一个字节
+const (
+	ReliableData byte = 1 // 数据：序号(uint64) + 原始msgId(uint32) + 原始数据
+	ReliableAck  byte = 2 // 确认：已经按顺序收到的最大序号(uint64)，确认之前的全部消息
+)
+
+var ErrReliableWindowFull = errors.New("reliable window full")
+
+/*
+	尚未被对端确认的可靠消息
+*/
+type reliableMsg struct {
+	seq uint64
+	// 封装好的ReliableData数据，重发时原样发送
+	envelope []byte
+}
+
+/*
+	可靠消息的收发状态
+	开启会话时保存在会话中，连接断开重连之后继续使用：未确认的消息被重发，重复收到的消息被丢弃
+*/
+type reliableState struct {
+	// 最后一个发送的消息序号
+	sendSeq atomic.Uint64
+	// 被对端确认的最大序号，收到确认时只更新它，发送缓冲在发送时再清理，避免Reader等待发送
+	ackedSeq atomic.Uint64
+	// 已经发送但可能尚未被对端确认的消息，按照序号排列
+	unacked []reliableMsg
+	// 保证序号分配顺序与发送顺序一致，保护unacked
+	sendLock sync.Mutex
+
+	// 已经按顺序收到的最大序号
+	recvSeq uint64
+	// 保护recvSeq，连接被新的连接接管时两个Reader可能同时收到消息
+	recvLock sync.Mutex
+}
+
+func newReliableState() *reliableState {
+	return &reliableState{}
+}
+
+// 封装可靠消息
+func packReliable(seq uint64, msgId uint32, data []byte) []byte {
+	envelope := make([]byte, 13+len(data))
+	envelope[0] = ReliableData
+	binary.LittleEndian.PutUint64(envelope[1:], seq)
+	binary.LittleEndian.PutUint32(envelope[9:], msgId)
+	copy(envelope[13:], data)
+	return envelope
+}
+
+// 封装确认消息
+func packAck(seq uint64) []byte {
+	envelope := make([]byte, 9)
+	envelope[0] = ReliableAck
+	binary.LittleEndian.PutUint64(envelope[1:], seq)
+	return envelope
+}
+
+// 解析可靠消息或者确认消息，确认消息的msgId和data为空
+func unpackReliable(envelope []byte) (kind byte, seq uint64, msgId uint32, data []byte, err error) {
+	if len(envelope) < 9 {
+		return 0, 0, 0, nil, errors.New("too short reliable msg")
+	}
+	kind, seq = envelope[0], binary.LittleEndian.Uint64(envelope[1:])
+	switch kind {
+	case ReliableAck:
+		return kind, seq, 0, nil, nil
+	case ReliableData:
+		if len(envelope) < 13 {
+			return 0, 0, 0, nil, errors.New("too short reliable msg")
+		}
+		return kind, seq, binary.LittleEndian.Uint32(envelope[9:]), envelope[13:], nil
+	default:
+		return 0, 0, 0, nil, fmt.Errorf("unknown reliable msg kind = %d", kind)
+	}
+}
+
+// 分配序号并保存到发送缓冲中，再通过send发送，返回消息的序号
+// send在持有锁时调用，保证对端按照序号顺序收到消息；send失败时消息仍然保留，恢复会话之后重发
+func (rs *reliableState) send(msgId uint32, data []byte, send func(envelope []byte) error) (uint64, error) {
+	rs.sendLock.Lock()
+	defer rs.sendLock.Unlock()
+
+	rs.trim()
+	if window := utils.GlobalObject.ReliableWindow; window > 0 && len(rs.unacked) >= window {
+		return 0, ErrReliableWindowFull
+	}
+	seq := rs.sendSeq.Add(1)
+	envelope := packReliable(seq, msgId, data)
+	rs.unacked = append(rs.unacked, reliableMsg{seq: seq, envelope: envelope})
+	return seq, send(envelope)
+}
+
+// 对端确认了seq及之前的全部消息
+func (rs *reliableState) ack(seq uint64) {
+	if seq > rs.sendSeq.Load() {
+		return
+	}
+	for {
+		acked := rs.ackedSeq.Load()
+		if seq <= acked || rs.ackedSeq.CompareAndSwap(acked, seq) {
+			return
+		}
+	}
+}
+
+// 将已经被确认的消息从发送缓冲中删除，调用时需要持有sendLock
+func (rs *reliableState) trim() {
+	acked := rs.ackedSeq.Load()
+	i := 0
+	for i < len(rs.unacked) && rs.unacked[i].seq <= acked {
+		i++
+	}
+	if i > 0 {
+		rs.unacked = append(rs.unacked[:0], rs.unacked[i:]...)
+	}
+}
+
+// 收到序号为seq的消息，返回是否为新的消息以及需要回复的确认序号
+// 重复的消息（恢复会话后对端的重发）和不连续的消息都不处理
+func (rs *reliableState) receive(seq uint64) (bool, uint64) {
+	rs.recvLock.Lock()
+	defer rs.recvLock.Unlock()
+
+	if seq != rs.recvSeq+1 {
+		if seq > rs.recvSeq {
+			fmt.Println("reliable msg seq = ", seq, " out of order, expect ", rs.recvSeq+1)
+		}
+		return false, rs.recvSeq
+	}
+	rs.recvSeq = seq
+	return true, seq
+}
+
+// 按照序号顺序重发全部尚未被确认的消息
+func (rs *reliableState) resend(send func(envelope []byte) error) error {
+	rs.sendLock.Lock()
+	defer rs.sendLock.Unlock()
+
+	rs.trim()
+	for _, msg := range rs.unacked {
+		if err := send(msg.envelope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 获取被对端确认的最大序号
+func (rs *reliableState) acked() uint64 {
+	return rs.ackedSeq.Load()
+}
@@ -0,0 +1,175 @@
+package tnet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReliableState(t *testing.T) {
+	conf := *utils.GlobalObject
+	defer func() { *utils.GlobalObject = conf }()
+	utils.GlobalObject.ReliableWindow = 3
+
+	var sent []uint64
+	send := func(envelope []byte) error {
+		kind, seq, msgId, data, err := unpackReliable(envelope)
+		require.NoError(t, err)
+		require.Equal(t, ReliableData, kind)
+		require.Equal(t, uint32(7), msgId)
+		require.Equal(t, "item", string(data))
+		sent = append(sent, seq)
+		return nil
+	}
+
+	rs := newReliableState()
+	for i := uint64(1); i <= 3; i++ {
+		seq, err := rs.send(7, []byte("item"), send)
+		require.NoError(t, err)
+		require.Equal(t, i, seq)
+	}
+	_, err := rs.send(7, []byte("item"), send)
+	require.ErrorIs(t, err, ErrReliableWindowFull)
+
+	// 确认是累积的，超出已发送序号的确认被忽略
+	rs.ack(5)
+	rs.ack(2)
+	rs.ack(1)
+	require.Equal(t, uint64(2), rs.acked())
+	sent = nil
+	require.NoError(t, rs.resend(send))
+	require.Equal(t, []uint64{3}, sent)
+	_, err = rs.send(7, []byte("item"), send)
+	require.NoError(t, err)
+
+	// 只接受连续的下一个序号，重复和跳跃的序号回复已经收到的最大序号
+	for _, c := range []struct {
+		seq    uint64
+		isNew  bool
+		ackSeq uint64
+	}{{1, true, 1}, {1, false, 1}, {3, false, 1}, {2, true, 2}, {3, true, 3}, {2, false, 3}} {
+		isNew, ackSeq := rs.receive(c.seq)
+		require.Equal(t, c.isNew, isNew, "seq = %d", c.seq)
+		require.Equal(t, c.ackSeq, ackSeq, "seq = %d", c.seq)
+	}
+
+	kind, seq, _, _, err := unpackReliable(packAck(9))
+	require.NoError(t, err)
+	require.Equal(t, ReliableAck, kind)
+	require.Equal(t, uint64(9), seq)
+	_, _, _, _, err = unpackReliable([]byte{ReliableData, 1, 0, 0, 0, 0, 0, 0, 0})
+	require.Error(t, err)
+	_, _, _, _, err = unpackReliable(append([]byte{9}, make([]byte, 8)...))
+	require.Error(t, err)
+}
+
+/*
+	记录收到的可靠消息次数，并使用可靠消息回复
+*/
+type purchaseRouter struct {
+	BaseRouter
+	lock   sync.Mutex
+	counts map[string]int
+}
+
+func (router *purchaseRouter) Handle(request tiface.IRequest) {
+	router.lock.Lock()
+	router.counts[string(request.GetData())]++
+	router.lock.Unlock()
+	if _, err := request.GetConnection().SendReliable(11, request.GetData()); err != nil {
+		panic(err)
+	}
+}
+
+func (router *purchaseRouter) count(item string) int {
+	router.lock.Lock()
+	defer router.lock.Unlock()
+	return router.counts[item]
+}
+
+func TestReliableConnection(t *testing.T) {
+	s, _ := newSessionServer(t, 0)
+	router := &purchaseRouter{counts: make(map[string]int)}
+	s.AddRouter(10, router)
+	newClient := newSessionListener(t, s, 505)
+
+	client := newClient()
+	require.NoError(t, client.Start())
+	for _, item := range []string{"sword", "shield"} {
+		seq, err := client.SendReliable(10, []byte(item))
+		require.NoError(t, err)
+		msg, err := client.ReadMsg()
+		require.NoError(t, err)
+		require.Equal(t, uint32(11), msg.GetMsgId())
+		require.Equal(t, item, string(msg.GetData()))
+		// 读取回复之前服务端的确认已经到达
+		require.Equal(t, seq, client.GetAckedSeq())
+	}
+
+	conn, err := s.GetConnMgr().Get(505)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return conn.GetAckedSeq() == 2 }, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestReliableResume(t *testing.T) {
+	s, hooks := newSessionServer(t, 5)
+	router := &purchaseRouter{counts: make(map[string]int)}
+	s.AddRouter(10, router)
+	newClient := newSessionListener(t, s, 510)
+
+	client := newClient()
+	require.NoError(t, client.Start())
+	_, err := client.StartSession()
+	require.NoError(t, err)
+
+	_, err = client.SendReliable(10, []byte("potion"))
+	require.NoError(t, err)
+	msg, err := client.ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, "potion", string(msg.GetData()))
+
+	// 服务端处理了购买请求，但是客户端在读取确认和回复之前断开
+	_, err = client.SendReliable(10, []byte("gem"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return router.count("gem") == 1 }, 3*time.Second, 10*time.Millisecond)
+	conn, err := s.GetConnMgr().Get(510)
+	require.NoError(t, err)
+	_, err = conn.SendReliable(12, []byte("grant"))
+	require.NoError(t, err)
+	client.Stop()
+	waitDetached(t, s, conn.GetSessionID())
+
+	// 会话等待恢复期间发送的可靠消息保留在发送缓冲中
+	_, err = conn.SendReliable(12, []byte("mail"))
+	require.NoError(t, err)
+
+	require.NoError(t, client.Reconnect())
+	resumed, err := client.StartSession()
+	require.NoError(t, err)
+	require.True(t, resumed)
+	<-hooks.resumes
+
+	// 双方重发尚未确认的消息，重复收到的消息被丢弃
+	for _, want := range []string{"gem", "grant", "mail"} {
+		msg, err := client.ReadMsg()
+		require.NoError(t, err)
+		require.Equal(t, want, string(msg.GetData()))
+	}
+	_, err = client.SendReliable(10, []byte("ring"))
+	require.NoError(t, err)
+	msg, err = client.ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, "ring", string(msg.GetData()))
+
+	require.Equal(t, 1, router.count("potion"))
+	require.Equal(t, 1, router.count("gem"))
+	require.Equal(t, 1, router.count("ring"))
+	require.Equal(t, uint64(3), client.GetAckedSeq())
+	resumedConn, err := s.GetConnMgr().Get(511)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return resumedConn.GetAckedSeq() == 5 }, 3*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,349 @@
+package tnet
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+)
+
+// 回放时比较、显示的消息数据的最大长度，超出部分以长度代替
+const replayDataPreview = 64
+
+// 回放给进程内服务器的连接使用的ID，从较大的值开始，避免与Server分配的ID冲突
+var replayConnID uint32 = 1 << 31
+
+/*
+	回放录制的参数
+*/
+type ReplayOptions struct {
+	// 回放速度，1表示按照录制时的时间间隔发送，2表示两倍速，0表示不等待，依次发送全部消息
+	Speed float64
+	// 按照录制的时间最后一个消息应当被收到之后，继续等待剩余回复的时长，为0时使用1秒
+	Timeout time.Duration
+	// 不进行比较的msgId，例如包含时间戳、随机数的消息
+	IgnoreMsgIds []uint32
+}
+
+/*
+	回放时收到的消息与录制不一致的地方
+	比较时不要求消息的顺序与录制一致：与录制中某个消息完全相同的消息都被认为一致，
+	剩余的消息按照msgId分别依次配对，例如同一个msgId的回复内容不同时，Expected和Actual分别为录制中和回放时的消息
+*/
+type ReplayDiff struct {
+	MsgId uint32
+	// Expected是录制中该msgId的第几个消息，从0开始，Expected为nil时为Actual在回放时的位置
+	Index int
+	// 录制中的消息，为nil表示回放时多收到的消息
+	Expected *Record
+	// 回放时收到的消息，为nil表示回放时没有收到的消息
+	Actual *Record
+}
+
+func (d ReplayDiff) String() string {
+	switch {
+	case d.Actual == nil:
+		return fmt.Sprintf("msgId = %d #%d: missing, expected %s", d.MsgId, d.Index, formatRecord(d.Expected))
+	case d.Expected == nil:
+		return fmt.Sprintf("msgId = %d #%d: unexpected %s", d.MsgId, d.Index, formatRecord(d.Actual))
+	default:
+		return fmt.Sprintf("msgId = %d #%d: expected %s, got %s", d.MsgId, d.Index, formatRecord(d.Expected), formatRecord(d.Actual))
+	}
+}
+
+// 显示一个消息的标志位和数据（十六进制）
+func formatRecord(r *Record) string {
+	data := r.Data
+	suffix := ""
+	if len(data) > replayDataPreview {
+		data = data[:replayDataPreview]
+		suffix = fmt.Sprintf("...(%d bytes)", len(r.Data))
+	}
+	return fmt.Sprintf("flags = %d, data = %s%s", r.Flags, hex.EncodeToString(data), suffix)
+}
+
+/*
+	回放的结果
+*/
+type ReplayResult struct {
+	// 发送的消息数量
+	Sent int
+	// 录制中发出的消息数量，不包括忽略的msgId
+	Expected int
+	// 回放时收到的消息数量，不包括忽略的msgId
+	Received int
+	// 回放结束之前连接是否被服务器关闭
+	Closed bool
+	// 回放时收到的消息与录制不一致的地方，为空表示一致
+	Diffs []ReplayDiff
+	// 回放的时长
+	Elapsed time.Duration
+}
+
+// 回放时收到的消息是否与录制一致
+func (r *ReplayResult) Match() bool {
+	return len(r.Diffs) == 0
+}
+
+// 将录制回放给addr上的服务器，开启加密通道时重新进行密钥交换
+func ReplayAddr(rec *Recording, addr string, opts ReplayOptions) (*ReplayResult, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+	client := NewClient(host, port)
+	if err := client.Start(); err != nil {
+		return nil, err
+	}
+	return replay(rec, client, opts)
+}
+
+// 将录制回放给进程内的服务器，通过net.Pipe建立连接，服务器不需要监听端口
+// 服务器可以只注册了Router而没有调用Serve，开启工作池时需要先调用GetMsgHandler().StartWorkerPool()
+func ReplayServer(rec *Recording, s tiface.IServer, opts ReplayOptions) (*ReplayResult, error) {
+	serverConn, clientConn := net.Pipe()
+	conn := NewConnection(s, serverConn, atomic.AddUint32(&replayConnID, 1), s.GetMsgHandler())
+	go conn.Start()
+
+	// 回放结束之后等待服务器一端的连接退出，返回之后连接不再使用服务器和配置
+	defer conn.stopAndWait()
+
+	client := NewClient("pipe", 0)
+	if err := client.StartConn(clientConn); err != nil {
+		return nil, err
+	}
+	return replay(rec, client, opts)
+}
+
+/*
+	回放时收到的消息，由读取goroutine追加
+*/
+type replayReceiver struct {
+	client  *Client
+	ignored map[uint32]bool
+	start   time.Time
+
+	records []Record
+	// 不包括忽略的msgId的消息数量
+	count int
+	lock  sync.Mutex
+
+	// 收到的消息数量达到want时被关闭
+	want   int
+	enough chan struct{}
+	// 读取goroutine退出时被关闭
+	done chan struct{}
+}
+
+// 读取服务器发来的消息，直到连接关闭
+func (rr *replayReceiver) run() {
+	defer close(rr.done)
+	for {
+		msg, err := rr.client.readFrame()
+		if err != nil {
+			return
+		}
+
+		rr.lock.Lock()
+		rr.records = append(rr.records, Record{
+			Type:   RecordOutbound,
+			Offset: time.Since(rr.start),
+			MsgId:  msg.GetMsgId(),
+			Flags:  msg.GetFlags(),
+			Data:   msg.GetData(),
+		})
+		if !rr.ignored[msg.GetMsgId()] {
+			rr.count++
+			if rr.count == rr.want {
+				close(rr.enough)
+			}
+		}
+		rr.lock.Unlock()
+	}
+}
+
+// 按照录制的时间发送收到的消息，等待服务器的回复，与录制中发出的消息进行比较
+func replay(rec *Recording, client *Client, opts ReplayOptions) (*ReplayResult, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Second
+	}
+	ignored := make(map[uint32]bool)
+	for _, msgId := range opts.IgnoreMsgIds {
+		ignored[msgId] = true
+	}
+
+	var (
+		expected []Record
+		last     time.Duration
+	)
+	for _, r := range rec.Records {
+		if r.Type == RecordClose {
+			continue
+		}
+		last = r.Offset
+		if r.Type == RecordOutbound && !ignored[r.MsgId] {
+			expected = append(expected, r)
+		}
+	}
+
+	start := time.Now()
+	receiver := &replayReceiver{
+		client:  client,
+		ignored: ignored,
+		start:   start,
+		want:    len(expected),
+		enough:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if receiver.want == 0 {
+		close(receiver.enough)
+	}
+	go receiver.run()
+
+	result := &ReplayResult{Expected: len(expected)}
+	for _, r := range rec.Records {
+		if r.Type != RecordInbound {
+			continue
+		}
+		if opts.Speed > 0 {
+			time.Sleep(time.Until(start.Add(time.Duration(float64(r.Offset) / opts.Speed))))
+		}
+		msg := &Message{Id: r.MsgId, DataLen: uint32(len(r.Data)), Data: r.Data, Flags: r.Flags}
+		if err := client.sendFrame(msg); err != nil {
+			// 连接已经被服务器关闭，剩余的消息不再发送
+			break
+		}
+		result.Sent++
+	}
+
+	// 等待录制中的回复全部收到，最多等待到最后一个消息应当被收到之后的Timeout
+	deadline := time.Now()
+	if opts.Speed > 0 {
+		deadline = start.Add(time.Duration(float64(last) / opts.Speed))
+	}
+	timer := time.NewTimer(time.Until(deadline.Add(opts.Timeout)))
+	select {
+	case <-receiver.enough:
+	case <-receiver.done:
+	case <-timer.C:
+	}
+	timer.Stop()
+
+	select {
+	case <-receiver.done:
+		result.Closed = true
+	default:
+	}
+	client.Stop()
+	<-receiver.done
+	result.Elapsed = time.Since(start)
+
+	var actual []Record
+	for _, r := range receiver.records {
+		if !ignored[r.MsgId] {
+			actual = append(actual, r)
+		}
+	}
+	result.Received = len(actual)
+	result.Diffs = diffRecords(expected, actual)
+	return result, nil
+}
+
+// 比较录制中发出的消息与回放时收到的消息
+// 先不考虑顺序找出完全相同的消息，剩余的消息按照msgId分别依次配对，作为不一致的地方
+func diffRecords(expected, actual []Record) []ReplayDiff {
+	// 每个消息是该msgId的第几个消息
+	wantIndex, gotIndex := msgIndexes(expected), msgIndexes(actual)
+
+	// 内容相同的消息，按照出现顺序排队
+	same := make(map[string][]int)
+	for j, r := range actual {
+		key := recordKey(r)
+		same[key] = append(same[key], j)
+	}
+	matched := make([]bool, len(actual))
+	var (
+		order    []uint32
+		wantLeft = make(map[uint32][]int)
+		gotLeft  = make(map[uint32][]int)
+	)
+	for i, r := range expected {
+		key := recordKey(r)
+		if queue := same[key]; len(queue) > 0 {
+			matched[queue[0]] = true
+			same[key] = queue[1:]
+			continue
+		}
+		if !containsMsgId(order, r.MsgId) {
+			order = append(order, r.MsgId)
+		}
+		wantLeft[r.MsgId] = append(wantLeft[r.MsgId], i)
+	}
+	for j, r := range actual {
+		if matched[j] {
+			continue
+		}
+		if !containsMsgId(order, r.MsgId) {
+			order = append(order, r.MsgId)
+		}
+		gotLeft[r.MsgId] = append(gotLeft[r.MsgId], j)
+	}
+
+	var diffs []ReplayDiff
+	for _, msgId := range order {
+		want, got := wantLeft[msgId], gotLeft[msgId]
+		for k := 0; k < len(want) || k < len(got); k++ {
+			diff := ReplayDiff{MsgId: msgId}
+			if k < len(want) {
+				diff.Expected = &expected[want[k]]
+				diff.Index = wantIndex[want[k]]
+			}
+			if k < len(got) {
+				diff.Actual = &actual[got[k]]
+				if diff.Expected == nil {
+					diff.Index = gotIndex[got[k]]
+				}
+			}
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs
+}
+
+// 每个消息是该msgId的第几个消息
+func msgIndexes(records []Record) []int {
+	counts := make(map[uint32]int)
+	indexes := make([]int, len(records))
+	for i, r := range records {
+		indexes[i] = counts[r.MsgId]
+		counts[r.MsgId]++
+	}
+	return indexes
+}
+
+// 比较消息时使用的key，msgId、标志位和数据都相同的消息相同
+func recordKey(r Record) string {
+	var head [5]byte
+	binary.LittleEndian.PutUint32(head[:4], r.MsgId)
+	head[4] = r.Flags
+	return string(head[:]) + string(r.Data)
+}
+
+func containsMsgId(msgIds []uint32, msgId uint32) bool {
+	for _, id := range msgIds {
+		if id == msgId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package tnet
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// 回复请求数据的大写形式
+type upperRouter struct {
+	BaseRouter
+}
+
+func (router *upperRouter) Handle(request tiface.IRequest) {
+	request.GetConnection().SendMsg(request.GetMsgID(), bytes.ToUpper(request.GetData()))
+}
+
+// 回复当前时间，每次回复都不同
+type clockRouter struct {
+	BaseRouter
+}
+
+func (router *clockRouter) Handle(request tiface.IRequest) {
+	request.GetConnection().SendMsg(request.GetMsgID(), []byte(time.Now().Format(time.RFC3339Nano)))
+}
+
+// 收到消息时关闭连接
+type closeRouter struct {
+	BaseRouter
+}
+
+func (router *closeRouter) Handle(request tiface.IRequest) {
+	request.GetConnection().Stop()
+}
+
+// 连接s，依次发送msgs，每个消息读取一个回复，返回该连接的录制
+func recordConn(t *testing.T, s tiface.IServer, connID uint32, msgs []*Message) *Recording {
+	client := newTestClient(t, s, connID)
+	require.NoError(t, client.Start())
+	for _, msg := range msgs {
+		require.NoError(t, client.SendMsg(msg.Id, msg.Data))
+		// 请求之间间隔一段时间，回放时按照录制的时间间隔发送
+		time.Sleep(20 * time.Millisecond)
+		reply, err := client.ReadMsg()
+		require.NoError(t, err)
+		require.Equal(t, msg.Id, reply.GetMsgId())
+	}
+	client.Stop()
+	return waitRecording(t, utils.GlobalObject.RecordDir, connID)
+}
+
+func TestReplay(t *testing.T) {
+	for i, secure := range []string{"", SecureAESGCM} {
+		t.Run("secure="+secure, func(t *testing.T) {
+			testReplay(t, i, secure)
+		})
+	}
+}
+
+// 录制一个连接之后回放给不同的服务器
+// 配置在全部连接退出之后才恢复，Cleanup按照注册的相反顺序执行，恢复配置最先注册
+func testReplay(t *testing.T, i int, secure string) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 0
+	utils.GlobalObject.FrameFlags = true
+	utils.GlobalObject.Compressor = "gzip"
+	utils.GlobalObject.CompressThreshold = 64
+	utils.GlobalObject.SecureChannel = secure
+	utils.GlobalObject.RecordDir = t.TempDir()
+
+	s := NewServer()
+	s.AddRouter(1, &EchoRouter{})
+	s.AddRouter(3, &clockRouter{})
+
+	rnd := rand.New(rand.NewSource(2))
+	large := make([]byte, 12*utils.GlobalObject.MaxPacketSize)
+	for j := range large {
+		large[j] = byte('a' + rnd.Intn(4))
+	}
+	rec := recordConn(t, s, uint32(660+i), []*Message{
+		NewMsgPackage(1, []byte("hello")),
+		NewMsgPackage(3, nil),
+		NewMsgPackage(1, large),
+	})
+	var inbound, outbound int
+	for _, r := range rec.Records {
+		switch r.Type {
+		case RecordInbound:
+			inbound++
+		case RecordOutbound:
+			outbound++
+		}
+	}
+
+	// 进程内回放，忽略每次都不同的时间
+	result, err := ReplayServer(rec, s, ReplayOptions{IgnoreMsgIds: []uint32{3}})
+	require.NoError(t, err)
+	require.True(t, result.Match(), "%v", result.Diffs)
+	require.Equal(t, inbound, result.Sent)
+	require.Equal(t, outbound-1, result.Expected)
+	require.Equal(t, result.Expected, result.Received)
+	require.False(t, result.Closed)
+
+	// 不忽略时，时间的回复不一致
+	result, err = ReplayServer(rec, s, ReplayOptions{})
+	require.NoError(t, err)
+	require.Len(t, result.Diffs, 1)
+	require.Equal(t, uint32(3), result.Diffs[0].MsgId)
+	require.NotNil(t, result.Diffs[0].Expected)
+	require.NotNil(t, result.Diffs[0].Actual)
+
+	// 按照录制时间间隔的两倍速回放给监听端口的服务器
+	addr := listenServer(t, s, uint32(670+10*i))
+	result, err = ReplayAddr(rec, addr, ReplayOptions{Speed: 2, IgnoreMsgIds: []uint32{3}})
+	require.NoError(t, err)
+	require.True(t, result.Match(), "%v", result.Diffs)
+	require.Greater(t, result.Elapsed, 20*time.Millisecond)
+
+	// 行为改变之后的服务器
+	changed := NewServer()
+	changed.AddRouter(1, &upperRouter{})
+	changed.AddRouter(3, &clockRouter{})
+	result, err = ReplayServer(rec, changed, ReplayOptions{IgnoreMsgIds: []uint32{3}})
+	require.NoError(t, err)
+	require.False(t, result.Match())
+	// 未开启工作池时请求被并发处理，回复的顺序不确定，只检查不一致的消息
+	var want, got [][]byte
+	for _, diff := range result.Diffs {
+		require.Equal(t, uint32(1), diff.MsgId)
+		want = append(want, diff.Expected.Data)
+		got = append(got, diff.Actual.Data)
+	}
+	require.Contains(t, want, []byte("hello"))
+	require.Contains(t, got, []byte("HELLO"))
+
+	// 服务器关闭连接，之后的回复都没有收到
+	closing := NewServer()
+	closing.AddRouter(1, &closeRouter{})
+	result, err = ReplayServer(rec, closing, ReplayOptions{IgnoreMsgIds: []uint32{3}})
+	require.NoError(t, err)
+	require.True(t, result.Closed)
+	require.Equal(t, result.Expected, len(result.Diffs))
+	require.Zero(t, result.Received)
+	require.Nil(t, result.Diffs[0].Actual)
+}
+
+func TestDiffRecords(t *testing.T) {
+	record := func(msgId uint32, data string) Record {
+		return Record{Type: RecordOutbound, MsgId: msgId, Data: []byte(data)}
+	}
+	expected := []Record{record(1, "a"), record(2, "b"), record(1, "c"), record(5, "e")}
+	// 消息的顺序不影响比较
+	actual := []Record{record(2, "b"), record(1, "x"), record(1, "a"), record(4, "z")}
+
+	diffs := diffRecords(expected, actual)
+	require.Len(t, diffs, 3)
+	require.Equal(t, ReplayDiff{MsgId: 1, Index: 1, Expected: &expected[2], Actual: &actual[1]}, diffs[0])
+	require.Equal(t, ReplayDiff{MsgId: 5, Index: 0, Expected: &expected[3]}, diffs[1])
+	require.Equal(t, ReplayDiff{MsgId: 4, Index: 0, Actual: &actual[3]}, diffs[2])
+	require.Equal(t, "msgId = 5 #0: missing, expected flags = 0, data = 65", diffs[1].String())
+	require.Equal(t, "msgId = 4 #0: unexpected flags = 0, data = 7a", diffs[2].String())
+
+	long := Record{MsgId: 1, Data: bytes.Repeat([]byte{0xAB}, replayDataPreview+1)}
+	require.Contains(t, formatRecord(&long), "...(65 bytes)")
+	require.Empty(t, diffRecords(expected, expected))
+}
@@ -1,12 +1,29 @@
 package tnet
 
-import "github.com/HOU-SZ/tigerkin/tiface"
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+)
 
 type Request struct {
 	// 已经和客户端建立好的链接
 	conn tiface.IConnection
 	// 客户端请求的数据
 	msg tiface.IMessage
+	// 流式请求的消息体，为nil时表示普通请求
+	body io.Reader
+
+	// 请求的context，开始处理时由连接的context派生，处理结束时被取消
+	ctx    context.Context
+	cancel context.CancelFunc
+	// 请求范围内的值
+	values map[string]interface{}
+	// 保护请求范围内的值，处理方法可能在其他goroutine中访问
+	valuesLock sync.RWMutex
 }
 
 // 获取请求的链接信息
@@ -23,3 +40,61 @@ func (r *Request) GetData() []byte {
 func (r *Request) GetMsgID() uint32 {
 	return r.msg.GetMsgId()
 }
+
+// 获取读取消息数据的Reader，流式请求的数据只能通过它读取
+func (r *Request) GetBodyReader() io.Reader {
+	if r.body != nil {
+		return r.body
+	}
+	return bytes.NewReader(r.msg.GetData())
+}
+
+// 获取请求的context，尚未开始处理时返回连接的context
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	if r.conn != nil {
+		return r.conn.Context()
+	}
+	return context.Background()
+}
+
+// 设置请求范围内的值
+func (r *Request) Set(key string, value interface{}) {
+	r.valuesLock.Lock()
+	defer r.valuesLock.Unlock()
+
+	if r.values == nil {
+		r.values = make(map[string]interface{})
+	}
+	r.values[key] = value
+}
+
+// 获取请求范围内的值
+func (r *Request) Get(key string) (interface{}, bool) {
+	r.valuesLock.RLock()
+	defer r.valuesLock.RUnlock()
+
+	value, ok := r.values[key]
+	return value, ok
+}
+
+// 开始处理请求：由连接的context派生请求的context，为msgId设置了处理超时时间时带上截止时间
+func (r *Request) begin() {
+	ctx := r.Context()
+	if r.conn != nil {
+		if timeout := r.conn.GetTcpServer().GetMsgTimeout(r.GetMsgID()); timeout > 0 {
+			r.ctx, r.cancel = context.WithTimeout(ctx, timeout)
+			return
+		}
+	}
+	r.ctx, r.cancel = context.WithCancel(ctx)
+}
+
+// 处理结束，取消请求的context
+func (r *Request) end() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
@@ -0,0 +1,132 @@
+package tnet
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+)
+
+// 字符串路由映射的msgId范围为[RouteIDBase, 0xFFFF0000)，数字路由应当使用小于RouteIDBase的msgId，
+// 0xFFFF0000之后是框架保留的msgId
+const RouteIDBase uint32 = 0x80000000
+
+// 分组路由处理请求时，将路由名称保存在请求范围内的值中使用的key
+const RouteKey = "route"
+
+// 字符串路由映射的msgId，客户端和服务端使用同一个方法计算
+func RouteID(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return RouteIDBase + h.Sum32()%(0xFFFF0000-RouteIDBase)
+}
+
+/*
+	路由分组，分组中的路由名称带有分组的前缀，并依次执行各级分组的中间件
+*/
+type RouteGroup struct {
+	// 注册路由的消息管理模块
+	msgHandler tiface.IMsgHandle
+	// 分组的前缀，根分组为空
+	prefix string
+	// 分组的中间件，包含上级分组的中间件
+	middlewares []tiface.Middleware
+}
+
+// 创建路由分组
+func NewRouteGroup(msgHandler tiface.IMsgHandle, prefix string, middlewares ...tiface.Middleware) *RouteGroup {
+	return &RouteGroup{
+		msgHandler:  msgHandler,
+		prefix:      prefix,
+		middlewares: middlewares,
+	}
+}
+
+// 创建子分组
+func (g *RouteGroup) Group(prefix string, middlewares ...tiface.Middleware) tiface.IRouteGroup {
+	all := make([]tiface.Middleware, 0, len(g.middlewares)+len(middlewares))
+	all = append(all, g.middlewares...)
+	all = append(all, middlewares...)
+	return NewRouteGroup(g.msgHandler, g.join(prefix), all...)
+}
+
+// 为分组添加中间件，只对之后注册的路由生效
+func (g *RouteGroup) Use(middlewares ...tiface.Middleware) {
+	g.middlewares = append(g.middlewares, middlewares...)
+}
+
+// 注册字符串路由，返回映射的msgId
+func (g *RouteGroup) AddRoute(name string, router tiface.IRouter) uint32 {
+	name = g.join(name)
+	if name == "" || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.Contains(name, "..") {
+		panic("invalid route name: " + name)
+	}
+	msgId := RouteID(name)
+	g.msgHandler.AddNamedRouter(name, msgId, g.wrap(name, router))
+	return msgId
+}
+
+// 注册数字路由，同样执行分组的中间件
+func (g *RouteGroup) AddRouter(msgId uint32, router tiface.IRouter) {
+	g.msgHandler.AddRouter(msgId, g.wrap("", router))
+}
+
+// 拼接分组前缀和名称
+func (g *RouteGroup) join(name string) string {
+	if g.prefix == "" {
+		return name
+	}
+	if name == "" {
+		return g.prefix
+	}
+	return g.prefix + "." + name
+}
+
+// 包装router，没有路由名称和中间件时直接使用router
+func (g *RouteGroup) wrap(name string, router tiface.IRouter) tiface.IRouter {
+	if name == "" && len(g.middlewares) == 0 {
+		return router
+	}
+	// 复制中间件，之后添加的中间件不影响已经注册的路由
+	middlewares := make([]tiface.Middleware, len(g.middlewares))
+	copy(middlewares, g.middlewares)
+	return &groupRouter{name: name, router: router, middlewares: middlewares}
+}
+
+/*
+	分组中的路由：依次执行中间件，最后执行router的PreHandle、Handle和PostHandle
+*/
+type groupRouter struct {
+	BaseRouter
+	// 路由名称，数字路由为空
+	name        string
+	router      tiface.IRouter
+	middlewares []tiface.Middleware
+}
+
+func (r *groupRouter) Handle(request tiface.IRequest) {
+	if r.name != "" {
+		request.Set(RouteKey, r.name)
+	}
+	r.handle(request, 0)
+}
+
+// 执行第i个中间件，中间件全部执行完之后执行router
+func (r *groupRouter) handle(request tiface.IRequest, i int) {
+	if i == len(r.middlewares) {
+		r.router.PreHandle(request)
+		r.router.Handle(request)
+		r.router.PostHandle(request)
+		return
+	}
+	r.middlewares[i](request, func() { r.handle(request, i+1) })
+}
+
+// 路由表中显示的处理方法类型
+func routerType(router interface{}) string {
+	if r, ok := router.(*groupRouter); ok {
+		return routerType(r.router)
+	}
+	return fmt.Sprintf("%T", router)
+}
@@ -0,0 +1,112 @@
+package tnet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+	回复路由名称以及中间件的执行记录
+*/
+type traceRouter struct {
+	BaseRouter
+}
+
+func (router *traceRouter) Handle(request tiface.IRequest) {
+	route, _ := request.Get(RouteKey)
+	trace, _ := request.Get("trace")
+	reply, _ := route.(string)
+	if trace != nil {
+		reply += " " + trace.(string)
+	}
+	if err := request.GetConnection().SendMsg(request.GetMsgID(), []byte(reply)); err != nil {
+		panic(err)
+	}
+}
+
+// 将name追加到请求的执行记录中的中间件
+func traceMiddleware(name string) tiface.Middleware {
+	return func(request tiface.IRequest, next func()) {
+		trace, _ := request.Get("trace")
+		if trace == nil {
+			request.Set("trace", name)
+		} else {
+			request.Set("trace", trace.(string)+">"+name)
+		}
+		next()
+	}
+}
+
+func TestRouteGroup(t *testing.T) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 0
+
+	require.Equal(t, RouteID("player.move"), RouteID("player.move"))
+	require.NotEqual(t, RouteID("player.move"), RouteID("player.stop"))
+	require.GreaterOrEqual(t, RouteID("player.move"), RouteIDBase)
+
+	s := NewServer()
+	s.AddRouter(1, &EchoRouter{})
+	chatID := s.AddRoute("chat.world", &traceRouter{})
+	require.Equal(t, RouteID("chat.world"), chatID)
+
+	player := s.Group("player", traceMiddleware("log"))
+	player.AddRoute("move", &traceRouter{})
+	// 之后添加的中间件只对之后注册的路由生效
+	player.Use(traceMiddleware("metrics"))
+	player.AddRouter(2, &traceRouter{})
+	// 不调用next的中间件中断请求的处理
+	admin := player.Group("admin", func(request tiface.IRequest, next func()) {
+		if _, err := request.GetConnection().GetProperty("admin"); err != nil {
+			request.GetConnection().SendMsg(request.GetMsgID(), []byte("denied"))
+			return
+		}
+		next()
+	})
+	admin.AddRoute("kick", &traceRouter{})
+
+	// 重复的路由名称
+	require.Panics(t, func() { s.AddRoute("player.move", &traceRouter{}) })
+	require.Panics(t, func() { s.AddRoute("", &traceRouter{}) })
+	require.Panics(t, func() { s.AddRoute("player..move", &traceRouter{}) })
+
+	client := newTestClient(t, s, 535)
+	require.NoError(t, client.Start())
+	for _, c := range []struct {
+		send  func() error
+		reply string
+	}{
+		{func() error { return client.SendMsg(1, []byte("ping")) }, "ping"},
+		{func() error { return client.SendRoute("chat.world", nil) }, "chat.world"},
+		{func() error { return client.SendRoute("player.move", nil) }, "player.move log"},
+		{func() error { return client.SendMsg(2, nil) }, " log>metrics"},
+		{func() error { return client.SendRoute("player.admin.kick", nil) }, "denied"},
+	} {
+		require.NoError(t, c.send())
+		msg, err := client.ReadMsg()
+		require.NoError(t, err)
+		require.Equal(t, c.reply, string(msg.GetData()))
+	}
+
+	routes := s.GetRoutes()
+	require.Len(t, routes, 5)
+	require.Equal(t, tiface.RouteInfo{MsgId: 1, Router: "*tnet.EchoRouter"}, routes[0])
+	require.Equal(t, tiface.RouteInfo{MsgId: 2, Router: "*tnet.traceRouter", Middlewares: 2}, routes[1])
+	for _, route := range routes[2:] {
+		require.Equal(t, RouteID(route.Name), route.MsgId)
+	}
+
+	var buf bytes.Buffer
+	s.DumpRoutes(&buf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 6)
+	require.Equal(t, []string{"MSGID", "NAME", "ROUTER", "MIDDLEWARES"}, strings.Fields(lines[0]))
+	require.Equal(t, []string{"0x00000001", "*tnet.EchoRouter", "0"}, strings.Fields(lines[1]))
+	require.Contains(t, buf.String(), "player.admin.kick")
+}
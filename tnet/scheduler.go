@@ -0,0 +1,297 @@
+package tnet
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+)
+
+// 分层时间轮的参数：每层64个槽，共4层，第0层每个槽为一个tick
+// 以10ms的tick计算，各层覆盖的时间为 640ms、41s、44min、46.6h，更长的定时任务在最高层中多次轮转
+const (
+	wheelBits   = 6
+	wheelSize   = 1 << wheelBits
+	wheelMask   = wheelSize - 1
+	wheelLevels = 4
+)
+
+/*
+	定时任务
+*/
+type timerTask struct {
+	id uint64
+	// 到期的tick
+	expire uint64
+	// 周期（tick），0表示只执行一次
+	interval uint64
+	// 执行任务的Worker
+	workerKey uint32
+	// 绑定的连接，为nil表示没有绑定连接
+	conn tiface.IConnection
+	f    func()
+}
+
+/*
+	基于分层时间轮的定时任务调度器
+	时间轮只负责计时，到期的任务交给dispatch在Worker中执行
+*/
+type TimerScheduler struct {
+	// 时间轮每一格的时长
+	tick time.Duration
+	// 将到期的任务交给workerKey对应的Worker执行
+	dispatch func(workerKey uint32, task func())
+
+	// 各层时间轮的槽，槽中的任务被取消时只从timers中删除，到期时跳过
+	slots [wheelLevels][wheelSize][]*timerTask
+	// 尚未执行完的定时任务，定时任务ID -> 定时任务
+	timers map[uint64]*timerTask
+	// 时间轮已经走过的tick数
+	current uint64
+	// 最后一个分配的定时任务ID
+	nextID uint64
+	// 保护时间轮的锁
+	lock sync.Mutex
+
+	// 时间轮开始转动的时间
+	start time.Time
+	// 第一次添加定时任务时才开始转动
+	startOnce sync.Once
+	// 通知时间轮停止转动
+	exit     chan struct{}
+	stopOnce sync.Once
+}
+
+/*
+	创建一个定时任务调度器
+*/
+func NewTimerScheduler(tick time.Duration, dispatch func(workerKey uint32, task func())) *TimerScheduler {
+	if tick <= 0 {
+		tick = 10 * time.Millisecond
+	}
+	return &TimerScheduler{
+		tick:     tick,
+		dispatch: dispatch,
+		timers:   make(map[uint64]*timerTask),
+		exit:     make(chan struct{}),
+	}
+}
+
+// key对应的Worker，同一个key总是对应同一个Worker
+func workerKeyOf(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// d之后执行一次f
+func (ts *TimerScheduler) AfterFunc(d time.Duration, f func()) uint64 {
+	return ts.add(nil, 0, false, false, d, f)
+}
+
+// 每隔d执行一次f
+func (ts *TimerScheduler) Every(d time.Duration, f func()) uint64 {
+	return ts.add(nil, 0, false, true, d, f)
+}
+
+// d之后在连接所在的Worker中执行一次f
+func (ts *TimerScheduler) AfterFuncConn(conn tiface.IConnection, d time.Duration, f func()) uint64 {
+	return ts.add(conn, conn.GetConnID(), true, false, d, f)
+}
+
+// 每隔d在连接所在的Worker中执行一次f
+func (ts *TimerScheduler) EveryConn(conn tiface.IConnection, d time.Duration, f func()) uint64 {
+	return ts.add(conn, conn.GetConnID(), true, true, d, f)
+}
+
+// d之后在key对应的Worker中执行一次f
+func (ts *TimerScheduler) AfterFuncKey(key string, d time.Duration, f func()) uint64 {
+	return ts.add(nil, workerKeyOf(key), true, false, d, f)
+}
+
+// 每隔d在key对应的Worker中执行一次f
+func (ts *TimerScheduler) EveryKey(key string, d time.Duration, f func()) uint64 {
+	return ts.add(nil, workerKeyOf(key), true, true, d, f)
+}
+
+// 将时长换算为tick数，不足一个tick的部分向上取整，至少为一个tick
+func (ts *TimerScheduler) ticks(d time.Duration) uint64 {
+	if d <= ts.tick {
+		return 1
+	}
+	return uint64((d + ts.tick - 1) / ts.tick)
+}
+
+// 添加定时任务，bound表示绑定了Worker，第一次添加时时间轮开始转动
+func (ts *TimerScheduler) add(conn tiface.IConnection, workerKey uint32, bound, repeat bool, d time.Duration, f func()) uint64 {
+	ts.startOnce.Do(func() {
+		ts.start = time.Now()
+		go ts.run()
+	})
+
+	var interval uint64
+	if repeat {
+		interval = ts.ticks(d)
+	}
+	// 按照实际经过的时间计算到期的tick，时间轮落后于实际时间时任务也不会提前执行
+	expire := ts.ticks(time.Since(ts.start) + d)
+	return ts.schedule(conn, workerKey, bound, expire, interval, f)
+}
+
+// 添加在第expire个tick到期的定时任务，已经过去的tick在下一个tick到期
+func (ts *TimerScheduler) schedule(conn tiface.IConnection, workerKey uint32, bound bool, expire, interval uint64, f func()) uint64 {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	if expire <= ts.current {
+		expire = ts.current + 1
+	}
+	ts.nextID++
+	task := &timerTask{
+		id:        ts.nextID,
+		expire:    expire,
+		interval:  interval,
+		workerKey: workerKey,
+		conn:      conn,
+		f:         f,
+	}
+	if !bound {
+		// 没有绑定的任务轮流分配给各个Worker
+		task.workerKey = uint32(task.id)
+	}
+	ts.timers[task.id] = task
+	ts.insert(task)
+	return task.id
+}
+
+// 将定时任务放入对应的槽中，调用时需要持有锁
+// 距离到期还有delta个tick的任务放在第一个能覆盖delta的层中，超出最高层的任务先放在最高层最远的槽中
+func (ts *TimerScheduler) insert(task *timerTask) {
+	delta := task.expire - ts.current
+	expire := task.expire
+	level := 0
+	for ; level < wheelLevels-1; level++ {
+		if delta < 1<<(wheelBits*(level+1)) {
+			break
+		}
+	}
+	if delta >= 1<<(wheelBits*wheelLevels) {
+		expire = ts.current + 1<<(wheelBits*wheelLevels) - 1
+	}
+	slot := (expire >> (wheelBits * level)) & wheelMask
+	ts.slots[level][slot] = append(ts.slots[level][slot], task)
+}
+
+// 时间轮转动到now个tick，执行期间到期的任务
+func (ts *TimerScheduler) advance(now uint64) {
+	var due []*timerTask
+
+	ts.lock.Lock()
+	for ts.current < now {
+		ts.current++
+
+		// 低层转完一圈时，将高层当前槽中的任务重新放入低层
+		for level := 1; level < wheelLevels; level++ {
+			if (ts.current>>(wheelBits*(level-1)))&wheelMask != 0 {
+				break
+			}
+			ts.cascade(level, (ts.current>>(wheelBits*level))&wheelMask)
+		}
+
+		slot := ts.current & wheelMask
+		tasks := ts.slots[0][slot]
+		ts.slots[0][slot] = nil
+		for _, task := range tasks {
+			if ts.timers[task.id] != task {
+				// 已经被取消
+				continue
+			}
+			if task.expire > ts.current {
+				ts.insert(task)
+				continue
+			}
+			due = append(due, task)
+			if task.interval > 0 {
+				task.expire = ts.current + task.interval
+				ts.insert(task)
+			} else {
+				delete(ts.timers, task.id)
+			}
+		}
+	}
+	ts.lock.Unlock()
+
+	for _, task := range due {
+		ts.dispatch(task.workerKey, ts.wrap(task))
+	}
+}
+
+// 将level层slot槽中的任务重新放入时间轮，调用时需要持有锁
+func (ts *TimerScheduler) cascade(level int, slot uint64) {
+	tasks := ts.slots[level][slot]
+	ts.slots[level][slot] = nil
+	for _, task := range tasks {
+		if ts.timers[task.id] == task {
+			ts.insert(task)
+		}
+	}
+}
+
+// 包装到期任务：绑定的连接已经停止时取消定时任务，不再执行
+func (ts *TimerScheduler) wrap(task *timerTask) func() {
+	return func() {
+		if task.conn != nil {
+			if c, ok := task.conn.(interface{ closed() bool }); ok && c.closed() {
+				ts.Cancel(task.id)
+				return
+			}
+		}
+		task.f()
+	}
+}
+
+// 取消定时任务
+func (ts *TimerScheduler) Cancel(timerID uint64) bool {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	if _, ok := ts.timers[timerID]; !ok {
+		return false
+	}
+	delete(ts.timers, timerID)
+	return true
+}
+
+// 获取尚未执行完的定时任务数量
+func (ts *TimerScheduler) Len() int {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	return len(ts.timers)
+}
+
+// 时间轮按照实际经过的时间转动，tick被延迟时一次转动多格
+func (ts *TimerScheduler) run() {
+	ticker := time.NewTicker(ts.tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ts.advance(uint64(time.Since(ts.start) / ts.tick))
+		case <-ts.exit:
+			return
+		}
+	}
+}
+
+// 停止调度
+func (ts *TimerScheduler) Stop() {
+	ts.stopOnce.Do(func() {
+		close(ts.exit)
+		ts.lock.Lock()
+		fmt.Println("[Tigerkin] Timer scheduler stopped, drop ", len(ts.timers), " timers")
+		ts.lock.Unlock()
+	})
+}
@@ -0,0 +1,157 @@
+package tnet
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimerWheel(t *testing.T) {
+	fired := make(map[uint64][]uint64)
+	var ts *TimerScheduler
+	ts = NewTimerScheduler(time.Millisecond, func(workerKey uint32, task func()) { task() })
+	schedule := func(expire, interval uint64) uint64 {
+		var id uint64
+		id = ts.schedule(nil, 0, false, expire, interval, func() {
+			fired[id] = append(fired[id], ts.current)
+		})
+		return id
+	}
+
+	// 覆盖各层的边界以及超出最高层的定时任务
+	expires := []uint64{1, 2, 63, 64, 65, 127, 128, 4095, 4096, 4097, 262143, 262144, 300000, 1<<24 - 1, 1 << 24, 1<<24 + 5, 3 << 24}
+	ids := make(map[uint64]uint64)
+	for _, expire := range expires {
+		ids[expire] = schedule(expire, 0)
+	}
+	every := schedule(50, 100)
+	canceled := schedule(500, 0)
+	require.True(t, ts.Cancel(canceled))
+	require.False(t, ts.Cancel(canceled))
+	require.Equal(t, len(expires)+1, ts.Len())
+
+	// 逐个tick转动，之后直接转动到更远的定时任务到期之前和到期时
+	for now := uint64(1); now <= 300000; now++ {
+		ts.advance(now)
+		if now == 1050 {
+			require.Equal(t, []uint64{50, 150, 250, 350, 450, 550, 650, 750, 850, 950, 1050}, fired[every])
+			require.True(t, ts.Cancel(every))
+		}
+	}
+	for _, expire := range expires {
+		if expire > 300000 {
+			ts.advance(expire - 1)
+			require.Empty(t, fired[ids[expire]], "expire = %d", expire)
+			ts.advance(expire)
+		}
+	}
+
+	for expire, id := range ids {
+		require.Equal(t, []uint64{expire}, fired[id], "expire = %d", expire)
+	}
+	require.Len(t, fired[every], 11)
+	require.Empty(t, fired[canceled])
+	require.Equal(t, 0, ts.Len())
+
+	// 已经过去的tick在下一个tick到期
+	id := schedule(5, 0)
+	ts.advance(3<<24 + 1)
+	require.Equal(t, []uint64{3<<24 + 1}, fired[id])
+}
+
+/*
+	与定时任务修改同一个计数的Router，使用-race运行时可以检查两者在同一个goroutine中执行
+*/
+type counterRouter struct {
+	BaseRouter
+	count *int
+	done  chan struct{}
+}
+
+func (router *counterRouter) Handle(request tiface.IRequest) {
+	*router.count++
+	router.done <- struct{}{}
+}
+
+func TestScheduler(t *testing.T) {
+	conf := *utils.GlobalObject
+	defer func() { *utils.GlobalObject = conf }()
+	utils.GlobalObject.WorkerPoolSize = 4
+	utils.GlobalObject.TimerTick = 1
+
+	s := NewServer()
+	defer s.Stop()
+	s.(*Server).msgHandler.StartWorkerPool()
+	scheduler := s.GetScheduler()
+
+	// 没有加锁的计数，连接的消息和绑定连接的定时任务由同一个Worker执行
+	count := 0
+	done := make(chan struct{}, 100)
+	s.AddRouter(1, &counterRouter{count: &count, done: done})
+
+	serverConn, client := newTCPPair(t)
+	defer client.Close()
+	conn := NewConnection(s, serverConn, 520, s.(*Server).msgHandler)
+	go conn.Start()
+	defer conn.stopAndWait()
+
+	id := scheduler.EveryConn(conn, 2*time.Millisecond, func() {
+		count++
+		done <- struct{}{}
+	})
+	for i := 0; i < 5; i++ {
+		writeTestMsg(t, client, 1, nil)
+	}
+	for i := 0; i < 20; i++ {
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatal("timer or router not executed")
+		}
+	}
+
+	// 连接停止之后，绑定连接的定时任务不再执行
+	conn.Stop()
+	require.Eventually(t, func() bool { return !scheduler.Cancel(id) }, 3*time.Second, time.Millisecond)
+
+	// 绑定key的定时任务总是由同一个Worker执行
+	var keyed int32
+	var lock sync.Mutex
+	workers := make(map[uint32]bool)
+	ts := NewTimerScheduler(time.Millisecond, func(workerKey uint32, task func()) {
+		lock.Lock()
+		workers[workerKey%4] = true
+		lock.Unlock()
+		s.(*Server).msgHandler.SendTaskToQueue(workerKey, task)
+	})
+	defer ts.Stop()
+	for i := 0; i < 10; i++ {
+		ts.AfterFuncKey("boss-1", time.Duration(i)*time.Millisecond, func() { atomic.AddInt32(&keyed, 1) })
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&keyed) == 10 }, 3*time.Second, time.Millisecond)
+	lock.Lock()
+	require.Len(t, workers, 1)
+	lock.Unlock()
+
+	// 取消尚未执行的定时任务
+	var executed int32
+	start := time.Now()
+	cancelID := scheduler.AfterFunc(50*time.Millisecond, func() { atomic.AddInt32(&executed, 1) })
+	var elapsed int64
+	afterID := scheduler.AfterFunc(20*time.Millisecond, func() {
+		atomic.StoreInt64(&elapsed, int64(time.Since(start)))
+		atomic.AddInt32(&executed, 10)
+	})
+	require.True(t, scheduler.Cancel(cancelID))
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&executed) == 10 }, 3*time.Second, time.Millisecond)
+	// 定时任务不会提前执行
+	require.GreaterOrEqual(t, time.Duration(atomic.LoadInt64(&elapsed)), 20*time.Millisecond)
+	time.Sleep(60 * time.Millisecond)
+	require.Equal(t, int32(10), atomic.LoadInt32(&executed))
+	require.False(t, scheduler.Cancel(afterID))
+}
@@ -0,0 +1,239 @@
+package tnet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// 应用层加密支持的算法
+const (
+	SecureAESGCM   = "aes-gcm"
+	SecureChaCha20 = "chacha20-poly1305"
+)
+
+// 加密后消息体增加的长度：8字节序号 + 16字节认证标签
+const SecureOverhead = 8 + 16
+
+/*
+	X25519密钥交换
+	连接建立时双方各自生成一对临时密钥，交换公钥之后协商出每个方向独立的会话密钥
+*/
+type KeyExchange struct {
+	// 本端的临时私钥
+	privateKey *ecdh.PrivateKey
+}
+
+// 生成一对临时的X25519密钥
+func NewKeyExchange() (*KeyExchange, error) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyExchange{
+		privateKey: privateKey,
+	}, nil
+}
+
+// 获取本端公钥，发送给对端
+func (kx *KeyExchange) PublicKey() []byte {
+	return kx.privateKey.PublicKey().Bytes()
+}
+
+// 根据对端公钥协商会话密钥，返回对inner进行包装的加密封包模块
+// isServer表示本端是否为服务端，用于区分两个方向的密钥
+func (kx *KeyExchange) NewSecureDataPack(inner tiface.IDataPack, peerPublicKey []byte, cipherName string, isServer bool) (*SecureDataPack, error) {
+	peerKey, err := ecdh.X25519().NewPublicKey(peerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := kx.privateKey.ECDH(peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// HKDF-SHA256：以双方公钥作为salt提取伪随机密钥，再分别扩展出两个方向的密钥
+	serverKey, clientKey := kx.PublicKey(), peerPublicKey
+	if !isServer {
+		serverKey, clientKey = peerPublicKey, kx.PublicKey()
+	}
+	prk := hmacSum(append(append([]byte{}, serverKey...), clientKey...), shared)
+	c2sKey := hmacSum(prk, []byte("tigerkin c2s\x01"))
+	s2cKey := hmacSum(prk, []byte("tigerkin s2c\x01"))
+
+	sendKey, recvKey := c2sKey, s2cKey
+	if isServer {
+		sendKey, recvKey = s2cKey, c2sKey
+	}
+	sealer, err := newAEAD(cipherName, sendKey)
+	if err != nil {
+		return nil, err
+	}
+	opener, err := newAEAD(cipherName, recvKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecureDataPack{
+		inner:  inner,
+		sealer: sealer,
+		opener: opener,
+	}, nil
+}
+
+func hmacSum(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// 根据算法名称创建AEAD
+func newAEAD(cipherName string, key []byte) (cipher.AEAD, error) {
+	switch cipherName {
+	case SecureAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case SecureChaCha20:
+		return chacha20poly1305.New(key)
+	default:
+		return nil, fmt.Errorf("unknown secure channel cipher: %s", cipherName)
+	}
+}
+
+/*
+	加密封包模块，对inner封包模块进行包装，对每个消息体进行加密
+	加密后的消息体格式为 Seq uint64(8字节) + 密文和认证标签，Seq同时作为nonce
+	每个方向的Seq从1开始严格递增，接收到重复或乱序的Seq时拒绝该消息，防止重放
+*/
+type SecureDataPack struct {
+	// 被包装的封包模块
+	inner tiface.IDataPack
+	// 发送方向的AEAD
+	sealer cipher.AEAD
+	// 接收方向的AEAD
+	opener cipher.AEAD
+	// 最后一个发送消息的序号
+	sendSeq uint64
+	// 最后一个接收消息的序号
+	recvSeq uint64
+	// 保护发送序号的锁
+	sendLock sync.Mutex
+}
+
+// 获取包头长度方法
+func (sdp *SecureDataPack) GetHeadLen() uint32 {
+	return sdp.inner.GetHeadLen()
+}
+
+// 封包方法，加密消息体后交给inner封包
+// 序号按照调用顺序分配，调用者需要保证封包的顺序与发送的顺序一致
+func (sdp *SecureDataPack) Pack(msg tiface.IMessage) ([]byte, error) {
+	sdp.sendLock.Lock()
+	defer sdp.sendLock.Unlock()
+
+	sdp.sendSeq++
+	data := make([]byte, 8, 8+len(msg.GetData())+sdp.sealer.Overhead())
+	binary.LittleEndian.PutUint64(data, sdp.sendSeq)
+	data = sdp.sealer.Seal(data, secureNonce(sdp.sendSeq, sdp.sealer.NonceSize()), msg.GetData(), secureAdditionalData(msg))
+
+	sealed := &Message{
+		Id:      msg.GetMsgId(),
+		DataLen: uint32(len(data)),
+		Data:    data,
+		Flags:   msg.GetFlags(),
+	}
+	return sdp.inner.Pack(sealed)
+}
+
+// 拆包方法，只拆出包头
+func (sdp *SecureDataPack) Unpack(binaryData []byte) (tiface.IMessage, error) {
+	return sdp.inner.Unpack(binaryData)
+}
+
+// 解密并校验消息体
+func (sdp *SecureDataPack) UnpackData(msg tiface.IMessage) error {
+	if err := unpackData(sdp.inner, msg); err != nil {
+		return err
+	}
+
+	data := msg.GetData()
+	if len(data) < SecureOverhead {
+		return errors.New("too short secure msg data")
+	}
+	seq := binary.LittleEndian.Uint64(data)
+	if seq != sdp.recvSeq+1 {
+		return fmt.Errorf("secure msg seq = %d rejected, expect %d", seq, sdp.recvSeq+1)
+	}
+	plain, err := sdp.opener.Open(data[8:8], secureNonce(seq, sdp.opener.NonceSize()), data[8:], secureAdditionalData(msg))
+	if err != nil {
+		return err
+	}
+	sdp.recvSeq = seq
+
+	msg.SetData(plain)
+	msg.SetDataLen(uint32(len(plain)))
+	return nil
+}
+
+// 校验完整消息，交给inner进行校验
+func (sdp *SecureDataPack) checkFrame(frame []byte) error {
+	if checker, ok := sdp.inner.(frameChecker); ok {
+		return checker.checkFrame(frame)
+	}
+	return nil
+}
+
+// 对已经使用inner封包好的一个或多个明文消息重新进行加密封包
+func (sdp *SecureDataPack) SealFrames(frames []byte) ([]byte, error) {
+	headLen := sdp.inner.GetHeadLen()
+	sealed := make([]byte, 0, len(frames)+SecureOverhead)
+	for len(frames) > 0 {
+		if uint32(len(frames)) < headLen {
+			return nil, errors.New("truncated frame")
+		}
+		msg, err := sdp.inner.Unpack(frames[:headLen])
+		if err != nil {
+			return nil, err
+		}
+		if uint32(len(frames)) < headLen+msg.GetDataLen() {
+			return nil, errors.New("truncated frame")
+		}
+		msg.SetData(frames[headLen : headLen+msg.GetDataLen()])
+		frames = frames[headLen+msg.GetDataLen():]
+
+		frame, err := sdp.Pack(msg)
+		if err != nil {
+			return nil, err
+		}
+		sealed = append(sealed, frame...)
+	}
+	return sealed, nil
+}
+
+// 根据序号生成nonce
+func secureNonce(seq uint64, size int) []byte {
+	nonce := make([]byte, size)
+	binary.LittleEndian.PutUint64(nonce[size-8:], seq)
+	return nonce
+}
+
+// 消息ID和标志位作为附加认证数据，防止被篡改
+func secureAdditionalData(msg tiface.IMessage) []byte {
+	ad := make([]byte, 5)
+	binary.LittleEndian.PutUint32(ad, msg.GetMsgId())
+	ad[4] = msg.GetFlags()
+	return ad
+}
@@ -0,0 +1,118 @@
+package tnet
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// 在本地回环地址的随机端口上为s接受一个连接，返回该连接对应的客户端
+func newTestClient(t *testing.T, s tiface.IServer, connID uint32) *Client {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		c := NewConnection(s, conn.(*net.TCPConn), connID, s.(*Server).msgHandler)
+		t.Cleanup(c.stopAndWait)
+		go c.Start()
+	}()
+
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+	portNum, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	client := NewClient(host, portNum)
+	t.Cleanup(client.Stop)
+	return client
+}
+
+func TestSecureDataPack(t *testing.T) {
+	for _, cipherName := range []string{SecureAESGCM, SecureChaCha20} {
+		serverKx, err := NewKeyExchange()
+		require.NoError(t, err)
+		clientKx, err := NewKeyExchange()
+		require.NoError(t, err)
+
+		server, err := serverKx.NewSecureDataPack(NewDataPack(), clientKx.PublicKey(), cipherName, true)
+		require.NoError(t, err)
+		client, err := clientKx.NewSecureDataPack(NewDataPack(), serverKx.PublicKey(), cipherName, false)
+		require.NoError(t, err)
+
+		frame, err := client.Pack(NewMsgPackage(3, []byte("move")))
+		require.NoError(t, err)
+		require.False(t, bytes.Contains(frame, []byte("move")))
+
+		msg, err := readFrame(bytes.NewReader(frame), server)
+		require.NoError(t, err)
+		require.NoError(t, server.UnpackData(msg))
+		require.Equal(t, uint32(3), msg.GetMsgId())
+		require.Equal(t, "move", string(msg.GetData()))
+
+		// 重放同一个消息被拒绝
+		msg, err = readFrame(bytes.NewReader(frame), server)
+		require.NoError(t, err)
+		require.Error(t, server.UnpackData(msg))
+
+		// 篡改消息ID被拒绝
+		frame, err = client.Pack(NewMsgPackage(3, []byte("move")))
+		require.NoError(t, err)
+		frame[4] = 4
+		msg, err = readFrame(bytes.NewReader(frame), server)
+		require.NoError(t, err)
+		require.Error(t, server.UnpackData(msg))
+
+		// 同一方向的密钥不能解密自己发送的消息
+		frame, err = client.Pack(NewMsgPackage(3, []byte("move")))
+		require.NoError(t, err)
+		msg, err = readFrame(bytes.NewReader(frame), client)
+		require.NoError(t, err)
+		require.Error(t, client.UnpackData(msg))
+	}
+}
+
+func TestSecureConnection(t *testing.T) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 0
+	utils.GlobalObject.SecureChannel = SecureChaCha20
+	utils.GlobalObject.FrameFlags = true
+	utils.GlobalObject.Compressor = "deflate"
+	utils.GlobalObject.CompressThreshold = 64
+
+	s := NewServer()
+	s.AddRouter(2, &EchoRouter{})
+	started := make(chan struct{})
+	s.SetOnConnStart(func(conn tiface.IConnection) {
+		// 加密通道建立之后才会执行OnConnStart，此时发送的消息已经被加密
+		require.NoError(t, conn.SendMsg(1, []byte("welcome")))
+		close(started)
+	})
+
+	client := newTestClient(t, s, 400)
+	require.NoError(t, client.Start())
+	<-started
+
+	msg, err := client.ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, "welcome", string(msg.GetData()))
+
+	data := bytes.Repeat([]byte("secret chat "), 100)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, client.SendMsg(2, data))
+		msg, err = client.ReadMsg()
+		require.NoError(t, err)
+		require.Equal(t, uint32(2), msg.GetMsgId())
+		require.Equal(t, data, msg.GetData())
+	}
+}
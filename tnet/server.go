@@ -3,7 +3,10 @@ package tnet
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"text/tabwriter"
+	"time"
 
 	"github.com/HOU-SZ/tigerkin/tiface"
 	"github.com/HOU-SZ/tigerkin/utils"
@@ -32,10 +35,34 @@ type Server struct {
 	msgHandler tiface.IMsgHandle
 	//当前Server的链接管理器
 	ConnMgr tiface.IConnManager
+	//当前Server的主题订阅管理器
+	TopicMgr tiface.ITopicManager
+	//当前Server的会话管理器
+	SessionMgr tiface.ISessionManager
+	//当前Server的定时任务调度器
+	Scheduler tiface.IScheduler
 	// 该Server的连接创建时Hook函数
 	OnConnStart func(conn tiface.IConnection)
 	// 该Server的连接断开时的Hook函数
 	OnConnStop func(conn tiface.IConnection)
+	// 该Server的会话恢复时的Hook函数
+	OnConnResume func(conn tiface.IConnection)
+	// 该Server的业务处理出错时的Hook函数
+	OnHandlerError func(request tiface.IRequest, err error)
+	// 该Server的限流器，为nil时不限流
+	rateLimiter tiface.IRateLimiter
+	// 该Server的鉴权器，为nil时不需要鉴权
+	authenticator tiface.IAuthenticator
+	// 该Server的流量录制器，为nil时不录制
+	recorder tiface.IRecorder
+	// 该Server的封包拆包模块
+	dataPack tiface.IDataPack
+	// 该Server默认的序列化方式
+	codec tiface.ICodec
+	// 为msgId单独指定的序列化方式
+	msgCodecs map[uint32]tiface.ICodec
+	// 为msgId设置的处理超时时间
+	msgTimeouts map[uint32]time.Duration
 }
 
 //============== 定义当前客户端链接的handle api ===========
@@ -118,6 +145,10 @@ func (s *Server) Stop() {
 
 	// 将其他需要清理的连接信息或者其他信息 也要一并停止或者清理
 	s.ConnMgr.ClearConn()
+	// 连接停止之后会话仍在等待恢复，结束全部会话
+	s.SessionMgr.ClearSession()
+	// 停止定时任务
+	s.Scheduler.Stop()
 }
 
 // 运行服务
@@ -135,11 +166,75 @@ func (s *Server) AddRouter(msgId uint32, router tiface.IRouter) {
 	s.msgHandler.AddRouter(msgId, router)
 }
 
+//路由功能：给当前服务注册一个流式路由方法，用于处理被拆分为多个分片的大消息
+func (s *Server) AddStreamRouter(msgId uint32, router tiface.IStreamRouter) {
+	s.msgHandler.AddStreamRouter(msgId, router)
+}
+
+// 路由功能：注册字符串路由，返回映射的msgId
+func (s *Server) AddRoute(name string, router tiface.IRouter) uint32 {
+	return NewRouteGroup(s.msgHandler, "").AddRoute(name, router)
+}
+
+// 路由功能：创建带有前缀和中间件的路由分组
+func (s *Server) Group(prefix string, middlewares ...tiface.Middleware) tiface.IRouteGroup {
+	return NewRouteGroup(s.msgHandler, prefix, middlewares...)
+}
+
+// 得到路由表，按照msgId排序
+func (s *Server) GetRoutes() []tiface.RouteInfo {
+	return s.msgHandler.GetRoutes()
+}
+
+// 设置处理没有注册处理方法的消息的Router，替代默认的错误回复
+func (s *Server) SetNotFoundRouter(router tiface.IRouter) {
+	s.msgHandler.SetNotFoundRouter(router)
+}
+
+// 得到未知消息的统计信息
+func (s *Server) GetUnknownMsgStats() tiface.UnknownMsgStats {
+	return s.msgHandler.GetUnknownMsgStats()
+}
+
+// 将路由表以表格形式输出到w
+func (s *Server) DumpRoutes(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MSGID\tNAME\tROUTER\tMIDDLEWARES")
+	for _, route := range s.GetRoutes() {
+		router := route.Router
+		if route.Stream {
+			router += " (stream)"
+		}
+		fmt.Fprintf(tw, "0x%08X\t%s\t%s\t%d\n", route.MsgId, route.Name, router, route.Middlewares)
+	}
+	tw.Flush()
+}
+
 // 得到当前server的链接管理模块
 func (s *Server) GetConnMgr() tiface.IConnManager {
 	return s.ConnMgr
 }
 
+// 得到当前server的主题订阅管理模块
+func (s *Server) GetTopicMgr() tiface.ITopicManager {
+	return s.TopicMgr
+}
+
+// 得到当前server的会话管理模块
+func (s *Server) GetSessionMgr() tiface.ISessionManager {
+	return s.SessionMgr
+}
+
+// 得到当前server的定时任务调度模块
+func (s *Server) GetScheduler() tiface.IScheduler {
+	return s.Scheduler
+}
+
+// 得到当前server的消息处理模块
+func (s *Server) GetMsgHandler() tiface.IMsgHandle {
+	return s.msgHandler
+}
+
 // 设置该Server的连接创建时Hook函数
 func (s *Server) SetOnConnStart(hookFunc func(tiface.IConnection)) {
 	s.OnConnStart = hookFunc
@@ -166,6 +261,103 @@ func (s *Server) CallOnConnStop(conn tiface.IConnection) {
 	}
 }
 
+// 设置该Server的会话恢复时的Hook函数
+func (s *Server) SetOnConnResume(hookFunc func(tiface.IConnection)) {
+	s.OnConnResume = hookFunc
+}
+
+// 调用会话恢复时的Hook函数
+func (s *Server) CallOnConnResume(conn tiface.IConnection) {
+	if s.OnConnResume != nil {
+		fmt.Println("------Call onConnResume()------")
+		s.OnConnResume(conn)
+	}
+}
+
+// 设置该Server的业务处理出错时的Hook函数
+func (s *Server) SetOnHandlerError(hookFunc func(tiface.IRequest, error)) {
+	s.OnHandlerError = hookFunc
+}
+
+// 调用业务处理出错时的Hook函数，未设置时打印错误信息
+func (s *Server) CallOnHandlerError(request tiface.IRequest, err error) {
+	if s.OnHandlerError != nil {
+		s.OnHandlerError(request, err)
+		return
+	}
+	fmt.Println("connID = ", request.GetConnection().GetConnID(), " msgId = ", request.GetMsgID(), " handle error: ", err)
+}
+
+// 设置该Server的限流器
+func (s *Server) SetRateLimiter(limiter tiface.IRateLimiter) {
+	s.rateLimiter = limiter
+}
+
+// 得到该Server的限流器
+func (s *Server) GetRateLimiter() tiface.IRateLimiter {
+	return s.rateLimiter
+}
+
+// 设置该Server的鉴权器，设置之后连接必须先通过鉴权才能使用路由
+func (s *Server) SetAuthenticator(authenticator tiface.IAuthenticator) {
+	s.authenticator = authenticator
+}
+
+// 得到该Server的鉴权器
+func (s *Server) GetAuthenticator() tiface.IAuthenticator {
+	return s.authenticator
+}
+
+// 设置该Server的流量录制器，为nil时不录制
+func (s *Server) SetRecorder(recorder tiface.IRecorder) {
+	s.recorder = recorder
+}
+
+// 得到该Server的流量录制器
+func (s *Server) GetRecorder() tiface.IRecorder {
+	return s.recorder
+}
+
+// 设置该Server的封包拆包模块
+func (s *Server) SetDataPack(dataPack tiface.IDataPack) {
+	s.dataPack = dataPack
+}
+
+// 得到该Server的封包拆包模块
+func (s *Server) GetDataPack() tiface.IDataPack {
+	return s.dataPack
+}
+
+// 设置该Server默认的序列化方式
+func (s *Server) SetCodec(codec tiface.ICodec) {
+	s.codec = codec
+}
+
+// 得到该Server默认的序列化方式
+func (s *Server) GetCodec() tiface.ICodec {
+	return s.codec
+}
+
+// 为某个msgId单独指定序列化方式，需要在Serve之前调用
+func (s *Server) SetMsgCodec(msgId uint32, codec tiface.ICodec) {
+	s.msgCodecs[msgId] = codec
+}
+
+// 得到为msgId单独指定的序列化方式，未指定时返回nil
+func (s *Server) GetMsgCodec(msgId uint32) tiface.ICodec {
+	return s.msgCodecs[msgId]
+}
+
+// 为某个msgId设置处理超时时间，需要在Serve之前调用
+func (s *Server) SetMsgTimeout(msgId uint32, timeout time.Duration) {
+	s.msgTimeouts[msgId] = timeout
+}
+
+// 得到msgId的处理超时时间，为0表示不超时
+func (s *Server) GetMsgTimeout(msgId uint32) time.Duration {
+	return s.msgTimeouts[msgId]
+}
+
 /*
   创建一个服务器句柄
 */
@@ -176,12 +368,29 @@ func NewServer() tiface.IServer {
 	// utils.GlobalObject.Reload()
 
 	s := &Server{
-		Name:       utils.GlobalObject.Name, //从全局参数GlobalObject获取
-		IPVersion:  "tcp4",
-		IP:         utils.GlobalObject.Host,    //从全局参数GlobalObject获取
-		Port:       utils.GlobalObject.TcpPort, //从全局参数GlobalObject获取
-		msgHandler: NewMsgHandle(),
-		ConnMgr:    NewConnManager(),
+		Name:        utils.GlobalObject.Name, //从全局参数GlobalObject获取
+		IPVersion:   "tcp4",
+		IP:          utils.GlobalObject.Host,    //从全局参数GlobalObject获取
+		Port:        utils.GlobalObject.TcpPort, //从全局参数GlobalObject获取
+		msgHandler:  NewMsgHandle(),
+		ConnMgr:     NewConnManager(),
+		TopicMgr:    NewTopicManager(),
+		rateLimiter: NewRateLimiter(),
+		dataPack:    NewDataPack(),
+		codec:       GetCodec(utils.GlobalObject.Codec),
+		msgCodecs:   make(map[uint32]tiface.ICodec),
+		msgTimeouts: make(map[uint32]time.Duration),
+	}
+	s.SessionMgr = NewSessionManager(s)
+	// 到期的定时任务交给工作池执行
+	s.Scheduler = NewTimerScheduler(time.Duration(utils.GlobalObject.TimerTick)*time.Millisecond, s.msgHandler.SendTaskToQueue)
+	if s.codec == nil {
+		fmt.Println("Codec ", utils.GlobalObject.Codec, " is NOT FOUND, use proto codec")
+		s.codec = GetCodec(CodecProto)
+	}
+	// 配置了录制目录时，将每个连接收发的消息录制到该目录
+	if utils.GlobalObject.RecordDir != "" {
+		s.recorder = NewFileRecorder(utils.GlobalObject.RecordDir)
 	}
 
 	return s
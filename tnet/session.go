@@ -0,0 +1,337 @@
+package tnet
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+)
+
+var (
+	ErrSessionNotFound    = errors.New("session not found or expired")
+	ErrSessionIdentity    = errors.New("session belongs to another identity")
+	ErrSessionPendingFull = errors.New("session pending msg full")
+)
+
+/*
+	支持会话的连接
+*/
+type sessionConn interface {
+	framedConn
+	getProperties() map[string]interface{}
+	setSessionID(sessionID string)
+	getReliable() *reliableState
+	setReliable(reliable *reliableState)
+}
+
+/*
+	会话信息
+*/
+type Session struct {
+	// 会话ID，可以公开，用于标识会话
+	id string
+	// 恢复会话时需要提供的密钥
+	secret string
+	// 创建会话时连接的身份信息，恢复会话的连接身份需要一致，创建之后不再改变
+	identity interface{}
+	// 当前绑定的连接，等待恢复期间为最后一个连接
+	conn tiface.IConnection
+	// 连接是否已经断开，正在等待恢复
+	detached bool
+	// 等待恢复的超时定时器
+	timer *time.Timer
+
+	// 连接断开时保存的链接属性、加入的分组、订阅的主题，恢复时设置到新的连接上
+	properties map[string]interface{}
+	groups     []string
+	topics     []string
+	// 等待恢复期间发送给会话的消息（已经封包），恢复后发送给新的连接
+	pending [][]byte
+	// 可靠消息的收发状态，恢复后继续在新的连接上使用
+	reliable *reliableState
+}
+
+/*
+	会话管理模块
+*/
+type SessionManager struct {
+	// 会话所属的Server
+	server tiface.IServer
+	// 管理的会话信息，会话ID -> 会话
+	sessions map[string]*Session
+	// 保护会话信息的锁
+	lock sync.Mutex
+}
+
+/*
+	创建一个会话管理
+*/
+func NewSessionManager(server tiface.IServer) *SessionManager {
+	return &SessionManager{
+		server:   server,
+		sessions: make(map[string]*Session),
+	}
+}
+
+// 是否开启了会话
+func sessionEnabled() bool {
+	return utils.GlobalObject.SessionGracePeriod > 0
+}
+
+// 生成n字节的随机字符串
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// 为连接创建新的会话，返回客户端恢复会话使用的令牌，格式为 会话ID.密钥
+func (sm *SessionManager) Create(conn tiface.IConnection) string {
+	sess := &Session{
+		id:       randomHex(8),
+		secret:   randomHex(16),
+		conn:     conn,
+		reliable: conn.(sessionConn).getReliable(),
+	}
+	sess.identity, _ = conn.GetProperty(AuthIdentityProperty)
+
+	sm.lock.Lock()
+	sm.sessions[sess.id] = sess
+	sm.lock.Unlock()
+
+	conn.(sessionConn).setSessionID(sess.id)
+	fmt.Println("ConnID = ", conn.GetConnID(), " create session ", sess.id)
+	return sess.id + "." + sess.secret
+}
+
+// 将令牌对应的会话绑定到新的连接上，原来的连接仍未断开时将其停止
+func (sm *SessionManager) Resume(conn tiface.IConnection, token string) error {
+	id, secret, _ := strings.Cut(token, ".")
+	identity, _ := conn.GetProperty(AuthIdentityProperty)
+
+	sm.lock.Lock()
+	sess, ok := sm.sessions[id]
+	sm.lock.Unlock()
+	if !ok || subtle.ConstantTimeCompare([]byte(secret), []byte(sess.secret)) != 1 {
+		return ErrSessionNotFound
+	}
+	// 自定义鉴权器保存的身份可以是任意类型（包括不能用==比较的map、slice），不持有锁进行比较
+	if sess.identity != nil && !reflect.DeepEqual(sess.identity, identity) {
+		return ErrSessionIdentity
+	}
+
+	sm.lock.Lock()
+	if sm.sessions[id] != sess {
+		// 比较身份期间会话已经过期
+		sm.lock.Unlock()
+		return ErrSessionNotFound
+	}
+
+	old, attached := sess.conn, !sess.detached
+	if attached {
+		// 原来的连接还没有发现自己已经断开（例如网络切换），由新的连接接管
+		sm.save(sess)
+	} else {
+		sess.timer.Stop()
+	}
+	sess.conn = conn
+	sess.detached = false
+	properties, groups, topics, pending := sess.properties, sess.groups, sess.topics, sess.pending
+	sess.properties, sess.groups, sess.topics, sess.pending = nil, nil, nil, nil
+	sm.lock.Unlock()
+
+	// 会话已经绑定到新的连接，原来的连接停止时不再调用OnConnStop
+	if attached {
+		old.Stop()
+	}
+
+	conn.(sessionConn).setSessionID(sess.id)
+	conn.(sessionConn).setReliable(sess.reliable)
+	for key, value := range properties {
+		conn.SetProperty(key, value)
+	}
+	for _, group := range groups {
+		sm.server.GetConnMgr().JoinGroup(group, conn)
+	}
+	for _, topic := range topics {
+		if err := sm.server.GetTopicMgr().Subscribe(conn, topic); err != nil {
+			fmt.Println("ConnID = ", conn.GetConnID(), " resubscribe topic ", topic, " error: ", err)
+		}
+	}
+	// 先按照序号重发尚未被确认的可靠消息，客户端会丢弃已经收到过的消息
+	if err := sess.reliable.resend(func(envelope []byte) error {
+		return conn.SendBuffMsg(utils.GlobalObject.ReliableMsgId, envelope)
+	}); err != nil {
+		return err
+	}
+	for _, frames := range pending {
+		if err := conn.(sessionConn).sendBuffFrames(frames); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("ConnID = ", conn.GetConnID(), " resume session ", sess.id, ", ", len(pending), " pending msgs")
+	return nil
+}
+
+// 保存会话当前连接的链接属性、加入的分组和订阅的主题，调用时需要持有锁
+func (sm *SessionManager) save(sess *Session) {
+	sess.properties = sess.conn.(sessionConn).getProperties()
+	sess.groups = sm.server.GetConnMgr().GetConnGroups(sess.conn.GetConnID())
+	sess.topics = sm.server.GetTopicMgr().GetSubscriptions(sess.conn.GetConnID())
+}
+
+// 连接断开时保留其会话，等待客户端恢复，超时之后结束会话并调用OnConnStop
+// 需要在连接被移出连接管理和取消订阅之前调用
+func (sm *SessionManager) Detach(conn tiface.IConnection) bool {
+	id := conn.GetSessionID()
+	if id == "" {
+		return false
+	}
+
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	sess, ok := sm.sessions[id]
+	if !ok {
+		// 会话已经结束
+		return false
+	}
+	if sess.conn != conn || sess.detached {
+		// 会话已经绑定到新的连接
+		return true
+	}
+
+	sm.save(sess)
+	sess.detached = true
+	sess.timer = time.AfterFunc(time.Duration(utils.GlobalObject.SessionGracePeriod)*time.Second, func() {
+		sm.expire(sess)
+	})
+	fmt.Println("ConnID = ", conn.GetConnID(), " detach session ", sess.id)
+	return true
+}
+
+// 会话等待恢复超时，结束会话并对最后一个连接调用OnConnStop
+func (sm *SessionManager) expire(sess *Session) {
+	sm.lock.Lock()
+	if !sess.detached || sm.sessions[sess.id] != sess {
+		sm.lock.Unlock()
+		return
+	}
+	delete(sm.sessions, sess.id)
+	sm.lock.Unlock()
+
+	fmt.Println("session ", sess.id, " expired")
+	sm.server.CallOnConnStop(sess.conn)
+}
+
+// 给会话当前绑定的连接发送消息，会话等待恢复期间消息暂存在会话中
+func (sm *SessionManager) Send(sessionID string, msgId uint32, data []byte) error {
+	sm.lock.Lock()
+	sess, ok := sm.sessions[sessionID]
+	if !ok {
+		sm.lock.Unlock()
+		return ErrSessionNotFound
+	}
+	conn := sess.conn
+	sm.lock.Unlock()
+
+	// 连接已经关闭时，SendBuffMsg会将消息交给queue暂存
+	return conn.SendBuffMsg(msgId, data)
+}
+
+// 已经关闭的连接发送消息：会话等待恢复时暂存消息，会话已经绑定到新的连接时转发给新的连接
+func (sm *SessionManager) queue(conn tiface.IConnection, sessionID string, msgId uint32, data []byte) error {
+	sm.lock.Lock()
+	sess, ok := sm.sessions[sessionID]
+	if !ok {
+		sm.lock.Unlock()
+		return ErrSessionNotFound
+	}
+	if current := sess.conn; current != conn {
+		sm.lock.Unlock()
+		return current.SendBuffMsg(msgId, data)
+	}
+	defer sm.lock.Unlock()
+
+	if max := utils.GlobalObject.SessionMaxPending; max > 0 && len(sess.pending) >= max {
+		return ErrSessionPendingFull
+	}
+	frames, err := conn.(sessionConn).packMsg(msgId, data)
+	if err != nil {
+		return err
+	}
+	sess.pending = append(sess.pending, frames)
+	return nil
+}
+
+// 已经关闭的连接发送不需要暂存的消息：会话已经绑定到新的连接时转发给新的连接，会话等待恢复时不发送
+func (sm *SessionManager) forward(conn tiface.IConnection, sessionID string, msgId uint32, data []byte) error {
+	sm.lock.Lock()
+	sess, ok := sm.sessions[sessionID]
+	if !ok {
+		sm.lock.Unlock()
+		return ErrSessionNotFound
+	}
+	current := sess.conn
+	sm.lock.Unlock()
+
+	if current == conn {
+		return nil
+	}
+	return current.SendBuffMsg(msgId, data)
+}
+
+// 立即结束会话：等待恢复的会话调用OnConnStop，仍然绑定连接的会话停止其连接
+func (sm *SessionManager) Close(sessionID string) {
+	sm.lock.Lock()
+	sess, ok := sm.sessions[sessionID]
+	if !ok {
+		sm.lock.Unlock()
+		return
+	}
+	delete(sm.sessions, sessionID)
+	if sess.detached {
+		sess.timer.Stop()
+	}
+	sm.lock.Unlock()
+
+	if sess.detached {
+		sm.server.CallOnConnStop(sess.conn)
+	} else {
+		// 会话已经删除，连接停止时正常调用OnConnStop
+		sess.conn.Stop()
+	}
+}
+
+// 获取当前会话总数
+func (sm *SessionManager) Len() int {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	return len(sm.sessions)
+}
+
+// 结束全部会话
+func (sm *SessionManager) ClearSession() {
+	sm.lock.Lock()
+	ids := make([]string, 0, len(sm.sessions))
+	for id := range sm.sessions {
+		ids = append(ids, id)
+	}
+	sm.lock.Unlock()
+
+	for _, id := range ids {
+		sm.Close(id)
+	}
+	fmt.Println("Clear All Sessions successfully: session num = ", sm.Len())
+}
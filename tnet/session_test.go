@@ -0,0 +1,277 @@
+package tnet
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// 启动一个不断接受连接的监听，返回新的客户端，ConnID从connID开始递增
+func newSessionListener(t *testing.T, s tiface.IServer, connID uint32) func() *Client {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	// 停止全部连接并结束全部会话
+	t.Cleanup(s.Stop)
+	go func() {
+		for cid := connID; ; cid++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go NewConnection(s, conn.(*net.TCPConn), cid, s.(*Server).msgHandler).Start()
+		}
+	}()
+
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+	portNum, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	return func() *Client {
+		client := NewClient(host, portNum)
+		t.Cleanup(client.Stop)
+		return client
+	}
+}
+
+/*
+	记录连接hook调用情况的Server
+*/
+type sessionHooks struct {
+	starts  int32
+	stops   int32
+	resumes chan tiface.IConnection
+	stopped chan tiface.IConnection
+}
+
+func newSessionServer(t *testing.T, gracePeriod int) (tiface.IServer, *sessionHooks) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 0
+	utils.GlobalObject.SessionGracePeriod = gracePeriod
+
+	hooks := &sessionHooks{
+		resumes: make(chan tiface.IConnection, 10),
+		stopped: make(chan tiface.IConnection, 10),
+	}
+	s := NewServer()
+	s.AddRouter(1, &EchoRouter{})
+	s.SetOnConnStart(func(conn tiface.IConnection) {
+		atomic.AddInt32(&hooks.starts, 1)
+		conn.SetProperty("pid", int(conn.GetConnID()))
+		s.GetConnMgr().JoinGroup("world", conn)
+	})
+	s.SetOnConnStop(func(conn tiface.IConnection) {
+		atomic.AddInt32(&hooks.stops, 1)
+		hooks.stopped <- conn
+	})
+	s.SetOnConnResume(func(conn tiface.IConnection) {
+		hooks.resumes <- conn
+	})
+	return s, hooks
+}
+
+// 等待会话进入等待恢复的状态
+func waitDetached(t *testing.T, s tiface.IServer, sessionID string) {
+	sm := s.GetSessionMgr().(*SessionManager)
+	require.Eventually(t, func() bool {
+		sm.lock.Lock()
+		defer sm.lock.Unlock()
+		sess, ok := sm.sessions[sessionID]
+		return ok && sess.detached
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestSessionResume(t *testing.T) {
+	s, hooks := newSessionServer(t, 5)
+	newClient := newSessionListener(t, s, 490)
+
+	client := newClient()
+	require.NoError(t, client.Start())
+	// 创建会话之前发送的消息被丢弃
+	require.NoError(t, client.SendMsg(1, []byte("dropped")))
+	resumed, err := client.StartSession()
+	require.NoError(t, err)
+	require.False(t, resumed)
+	require.NoError(t, client.Subscribe("room.>"))
+
+	require.NoError(t, client.SendMsg(1, []byte("hello")))
+	msg, err := client.ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(msg.GetData()))
+
+	old, err := s.GetConnMgr().Get(490)
+	require.NoError(t, err)
+	sessionID := old.GetSessionID()
+	require.NotEmpty(t, sessionID)
+
+	// 客户端断开，会话保留，不调用OnConnStop
+	client.Stop()
+	waitDetached(t, s, sessionID)
+	require.Eventually(t, func() bool {
+		_, err := s.GetConnMgr().Get(490)
+		return err != nil
+	}, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, int32(0), atomic.LoadInt32(&hooks.stops))
+
+	// 等待恢复期间发送的消息暂存在会话中，旧的连接也可以继续使用
+	require.NoError(t, s.GetSessionMgr().Send(sessionID, 2, []byte("queued 1")))
+	require.NoError(t, old.SendMsg(2, []byte("queued 2")))
+
+	require.NoError(t, client.Reconnect())
+	resumed, err = client.StartSession()
+	require.NoError(t, err)
+	require.True(t, resumed)
+
+	var conn tiface.IConnection
+	select {
+	case conn = <-hooks.resumes:
+	case <-time.After(3 * time.Second):
+		t.Fatal("OnConnResume not called")
+	}
+	require.Equal(t, uint32(491), conn.GetConnID())
+	require.Equal(t, sessionID, conn.GetSessionID())
+	require.Equal(t, int32(1), atomic.LoadInt32(&hooks.starts))
+	require.Equal(t, int32(0), atomic.LoadInt32(&hooks.stops))
+
+	// 链接属性、分组、订阅都恢复到新的连接上
+	pid, err := conn.GetProperty("pid")
+	require.NoError(t, err)
+	require.Equal(t, 490, pid)
+	require.Equal(t, []string{"world"}, s.GetConnMgr().GetConnGroups(491))
+	require.Equal(t, []string{"room.>"}, s.GetTopicMgr().GetSubscriptions(491))
+
+	for _, want := range []string{"queued 1", "queued 2"} {
+		msg, err := client.ReadMsg()
+		require.NoError(t, err)
+		require.Equal(t, want, string(msg.GetData()))
+	}
+
+	// 会话恢复之后，旧的连接发送的消息转发给新的连接
+	require.NoError(t, old.SendMsg(2, []byte("forwarded")))
+	require.NoError(t, s.GetTopicMgr().Publish("room.1", 3, []byte("published")))
+	require.NoError(t, s.GetConnMgr().Multicast("world", 4, []byte("multicast")))
+	for _, want := range []string{"forwarded", "published", "multicast"} {
+		msg, err := client.ReadMsg()
+		require.NoError(t, err)
+		require.Equal(t, want, string(msg.GetData()))
+	}
+
+	// 结束会话时正常调用OnConnStop
+	s.GetSessionMgr().Close(sessionID)
+	select {
+	case stopped := <-hooks.stopped:
+		require.Equal(t, uint32(491), stopped.GetConnID())
+	case <-time.After(3 * time.Second):
+		t.Fatal("OnConnStop not called")
+	}
+	require.Equal(t, 0, s.GetSessionMgr().Len())
+}
+
+func TestSessionExpire(t *testing.T) {
+	s, hooks := newSessionServer(t, 1)
+	newClient := newSessionListener(t, s, 495)
+
+	client := newClient()
+	require.NoError(t, client.Start())
+	_, err := client.StartSession()
+	require.NoError(t, err)
+	token := client.SessionToken()
+	require.NotEmpty(t, token)
+
+	// 会话超时之后调用OnConnStop，此时仍然可以获取链接属性
+	client.Stop()
+	select {
+	case stopped := <-hooks.stopped:
+		require.Equal(t, uint32(495), stopped.GetConnID())
+		pid, err := stopped.GetProperty("pid")
+		require.NoError(t, err)
+		require.Equal(t, 495, pid)
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnConnStop not called")
+	}
+	require.Equal(t, 0, s.GetSessionMgr().Len())
+
+	// 会话已经过期，重新连接之后创建新的会话
+	require.NoError(t, client.Reconnect())
+	resumed, err := client.StartSession()
+	require.NoError(t, err)
+	require.False(t, resumed)
+	require.NotEqual(t, token, client.SessionToken())
+	require.Equal(t, int32(2), atomic.LoadInt32(&hooks.starts))
+
+	// 错误的令牌不能恢复会话
+	wrong := newClient()
+	require.NoError(t, wrong.Start())
+	wrong.SetSessionToken(client.SessionToken() + "0")
+	resumed, err = wrong.StartSession()
+	require.NoError(t, err)
+	require.False(t, resumed)
+}
+
+func TestSessionTakeover(t *testing.T) {
+	s, hooks := newSessionServer(t, 5)
+	newClient := newSessionListener(t, s, 500)
+
+	first := newClient()
+	require.NoError(t, first.Start())
+	_, err := first.StartSession()
+	require.NoError(t, err)
+
+	// 原来的连接还没有断开时，新的连接使用令牌接管会话，原来的连接被关闭
+	second := newClient()
+	require.NoError(t, second.Start())
+	second.SetSessionToken(first.SessionToken())
+	resumed, err := second.StartSession()
+	require.NoError(t, err)
+	require.True(t, resumed)
+
+	_, err = first.ReadMsg()
+	require.Error(t, err)
+	<-hooks.resumes
+	require.Equal(t, int32(1), atomic.LoadInt32(&hooks.starts))
+	require.Equal(t, int32(0), atomic.LoadInt32(&hooks.stops))
+	_, err = s.GetConnMgr().Get(500)
+	require.Error(t, err)
+	require.Equal(t, []string{"world"}, s.GetConnMgr().GetConnGroups(501))
+
+	require.NoError(t, second.SendMsg(1, []byte("still here")))
+	msg, err := second.ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, "still here", string(msg.GetData()))
+}
+
+func TestSessionIdentity(t *testing.T) {
+	s, _ := newSessionServer(t, 5)
+	var conns []*Connection
+	for i, identity := range []string{"alice", "bob"} {
+		serverConn, client := newTCPPair(t)
+		defer client.Close()
+		conn := NewConnection(s, serverConn, 498+uint32(i), s.(*Server).msgHandler)
+		defer conn.Stop()
+		conn.SetProperty(AuthIdentityProperty, identity)
+		conns = append(conns, conn)
+	}
+	a, b := conns[0], conns[1]
+	token := s.GetSessionMgr().Create(a)
+	require.Equal(t, a.GetSessionID()+".", token[:len(a.GetSessionID())+1])
+	require.ErrorIs(t, s.GetSessionMgr().Resume(b, token), ErrSessionIdentity)
+	require.ErrorIs(t, s.GetSessionMgr().Resume(b, "unknown.token"), ErrSessionNotFound)
+
+	// 自定义鉴权器保存的身份可以是不能用==比较的类型，按照内容比较
+	a.SetProperty(AuthIdentityProperty, map[string]int{"uid": 1})
+	token = s.GetSessionMgr().Create(a)
+	b.SetProperty(AuthIdentityProperty, map[string]int{"uid": 2})
+	require.ErrorIs(t, s.GetSessionMgr().Resume(b, token), ErrSessionIdentity)
+	b.SetProperty(AuthIdentityProperty, map[string]int{"uid": 1})
+	require.NoError(t, s.GetSessionMgr().Resume(b, token))
+	require.Equal(t, a.GetSessionID(), b.GetSessionID())
+}
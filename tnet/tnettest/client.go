@@ -0,0 +1,107 @@
+package tnettest
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/tnet"
+)
+
+/*
+	测试客户端，包装tnet.Client，发送和读取出错时测试失败
+	Send、Expect等方法需要在创建测试服务器的测试goroutine中调用
+	通过net.Pipe建立的连接没有缓冲，服务器回复的消息被读取之前，回复的Router会一直阻塞
+*/
+type Client struct {
+	*tnet.Client
+	server *Server
+	t      testing.TB
+}
+
+// 连接到测试服务器，开启加密通道时完成密钥交换，设置了序列化方式时与服务器协商，失败时测试失败
+func (c *Client) Start() {
+	c.t.Helper()
+	if err := c.server.connect(c.Client); err != nil {
+		c.t.Fatalf("tnettest: start client: %v", err)
+	}
+}
+
+// 发送消息，失败时测试失败
+func (c *Client) Send(msgId uint32, data []byte) {
+	c.t.Helper()
+	if err := c.SendMsg(msgId, data); err != nil {
+		c.t.Fatalf("tnettest: send msgId = %d: %v", msgId, err)
+	}
+}
+
+// 在timeout之内读取下一个消息，超时或者消息的msgId不是期望的msgId时测试失败
+func (c *Client) Expect(msgId uint32, timeout time.Duration) tiface.IMessage {
+	c.t.Helper()
+	msg, err := c.Next(timeout)
+	if err != nil {
+		c.t.Fatalf("tnettest: expect msgId = %d: %v", msgId, err)
+	}
+	if msg.GetMsgId() != msgId {
+		c.t.Fatalf("tnettest: expect msgId = %d, but got msgId = %d, data = %q", msgId, msg.GetMsgId(), msg.GetData())
+	}
+	return msg
+}
+
+// 读取下一个msgId的消息并使用对应的序列化方式反序列化到v中，失败时测试失败
+func (c *Client) ExpectValue(msgId uint32, v interface{}, timeout time.Duration) tiface.IMessage {
+	c.t.Helper()
+	msg := c.Expect(msgId, timeout)
+	if err := c.GetCodec(msgId).Unmarshal(msg.GetData(), v); err != nil {
+		c.t.Fatalf("tnettest: unmarshal msgId = %d: %v", msgId, err)
+	}
+	return msg
+}
+
+// 在timeout之内没有收到任何消息，收到消息时测试失败
+func (c *Client) ExpectNone(timeout time.Duration) {
+	c.t.Helper()
+	msg, err := c.Next(timeout)
+	if err == nil {
+		c.t.Fatalf("tnettest: expect no msg, but got msgId = %d, data = %q", msg.GetMsgId(), msg.GetData())
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		c.t.Fatalf("tnettest: expect no msg: %v", err)
+	}
+}
+
+// 在timeout之内连接被服务器关闭，之前收到的消息被忽略，超时时测试失败
+func (c *Client) ExpectClosed(timeout time.Duration) {
+	c.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := c.next(deadline)
+		if err == nil {
+			continue
+		}
+		// 超时之外的读取错误（EOF、连接被重置等）都表示连接已经关闭
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			c.t.Fatalf("tnettest: connection not closed in %v", timeout)
+		}
+		return
+	}
+}
+
+// 在timeout之内读取下一个消息，超时时返回net.Error
+func (c *Client) Next(timeout time.Duration) (tiface.IMessage, error) {
+	return c.next(time.Now().Add(timeout))
+}
+
+func (c *Client) next(deadline time.Time) (tiface.IMessage, error) {
+	conn := c.Conn()
+	if conn == nil {
+		return nil, errors.New("client not started")
+	}
+	conn.SetReadDeadline(deadline)
+	defer conn.SetReadDeadline(time.Time{})
+	return c.ReadMsg()
+}
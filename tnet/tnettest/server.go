@@ -0,0 +1,277 @@
+/*
+	tnettest 为Router和Server的单元测试提供测试服务器和测试客户端
+
+	测试服务器默认通过net.Pipe建立连接，不占用端口，不需要等待服务器启动，不同的测试可以并行执行
+	需要真实TCP连接的测试使用NewTCPServer，监听127.0.0.1上的临时端口
+	服务器和客户端使用utils.GlobalObject中的配置，并行执行的测试不能修改配置
+*/
+package tnettest
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/tnet"
+	"github.com/HOU-SZ/tigerkin/utils"
+)
+
+// 连接事件的类型
+type EventType int
+
+const (
+	ConnStart EventType = iota + 1 // 调用了OnConnStart
+	ConnStop                       // 调用了OnConnStop
+)
+
+func (et EventType) String() string {
+	switch et {
+	case ConnStart:
+		return "ConnStart"
+	case ConnStop:
+		return "ConnStop"
+	default:
+		return "EventType(" + strconv.Itoa(int(et)) + ")"
+	}
+}
+
+/*
+	测试服务器记录的连接事件
+*/
+type Event struct {
+	Type EventType
+	Conn tiface.IConnection
+}
+
+/*
+	测试服务器，包装tnet.Server，测试结束时自动停止
+	创建之后即可注册路由、Hook函数，再通过Dial建立连接
+	OnConnStart、OnConnStop事件被记录下来，可以通过ExpectConnStart、ExpectConnStop等待
+*/
+type Server struct {
+	tiface.IServer
+	t testing.TB
+
+	// 监听的临时端口，为nil时通过net.Pipe建立连接
+	listener net.Listener
+	// 最后一个连接的ID
+	connID uint32
+	// 全部连接，测试结束时等待它们退出
+	conns     []*tnet.Connection
+	connsLock sync.Mutex
+	// accept退出时被关闭，通过net.Pipe建立连接时为nil
+	acceptDone chan struct{}
+
+	// 用户设置的Hook函数
+	onConnStart func(tiface.IConnection)
+	onConnStop  func(tiface.IConnection)
+	hookLock    sync.RWMutex
+
+	// 按照发生顺序记录的全部事件
+	events []Event
+	// 尚未被Expect取出的事件
+	pending map[EventType][]tiface.IConnection
+	// 有新的事件时被关闭并替换
+	notify     chan struct{}
+	eventsLock sync.Mutex
+}
+
+// 创建通过net.Pipe建立连接的测试服务器
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	return newServer(t, nil)
+}
+
+// 创建监听127.0.0.1临时端口的测试服务器，客户端通过TCP连接
+func NewTCPServer(t testing.TB) *Server {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("tnettest: listen error: %v", err)
+	}
+	return newServer(t, listener)
+}
+
+func newServer(t testing.TB, listener net.Listener) *Server {
+	s := &Server{
+		IServer:  tnet.NewServer(),
+		t:        t,
+		listener: listener,
+		pending:  make(map[EventType][]tiface.IConnection),
+		notify:   make(chan struct{}),
+	}
+	s.IServer.SetOnConnStart(func(conn tiface.IConnection) { s.callHook(ConnStart, conn) })
+	s.IServer.SetOnConnStop(func(conn tiface.IConnection) { s.callHook(ConnStop, conn) })
+
+	if utils.GlobalObject.WorkerPoolSize > 0 {
+		s.GetMsgHandler().StartWorkerPool()
+	}
+	if listener != nil {
+		s.acceptDone = make(chan struct{})
+		go s.accept()
+	}
+
+	// 等待全部连接退出之后测试才结束，之后的测试可以修改配置
+	t.Cleanup(func() {
+		if s.listener != nil {
+			s.listener.Close()
+			<-s.acceptDone
+		}
+		s.IServer.Stop()
+		s.connsLock.Lock()
+		defer s.connsLock.Unlock()
+		for _, c := range s.conns {
+			c.Stop()
+			c.Wait()
+		}
+	})
+	return s
+}
+
+// 接受TCP连接，与tnet.Server一致，超过MaxConn的连接被关闭
+func (s *Server) accept() {
+	defer close(s.acceptDone)
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.serveConn(conn)
+	}
+}
+
+// 为一个连接创建Connection并开始处理
+func (s *Server) serveConn(conn net.Conn) {
+	if s.GetConnMgr().Len() >= utils.GlobalObject.MaxConn {
+		conn.Close()
+		return
+	}
+	c := tnet.NewConnection(s.IServer, conn, atomic.AddUint32(&s.connID, 1), s.GetMsgHandler())
+	s.connsLock.Lock()
+	s.conns = append(s.conns, c)
+	s.connsLock.Unlock()
+	go c.Start()
+}
+
+// 监听的地址，通过net.Pipe建立连接时为空
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// 设置连接创建时的Hook函数，事件在Hook函数返回之后被记录
+func (s *Server) SetOnConnStart(hookFunc func(tiface.IConnection)) {
+	s.hookLock.Lock()
+	defer s.hookLock.Unlock()
+	s.onConnStart = hookFunc
+}
+
+// 设置连接断开时的Hook函数，事件在Hook函数返回之后被记录
+func (s *Server) SetOnConnStop(hookFunc func(tiface.IConnection)) {
+	s.hookLock.Lock()
+	defer s.hookLock.Unlock()
+	s.onConnStop = hookFunc
+}
+
+// 调用用户设置的Hook函数并记录事件
+func (s *Server) callHook(typ EventType, conn tiface.IConnection) {
+	s.hookLock.RLock()
+	hook := s.onConnStart
+	if typ == ConnStop {
+		hook = s.onConnStop
+	}
+	s.hookLock.RUnlock()
+	if hook != nil {
+		hook(conn)
+	}
+
+	s.eventsLock.Lock()
+	defer s.eventsLock.Unlock()
+	s.events = append(s.events, Event{Type: typ, Conn: conn})
+	s.pending[typ] = append(s.pending[typ], conn)
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// 按照发生顺序返回已经记录的全部事件
+func (s *Server) Events() []Event {
+	s.eventsLock.Lock()
+	defer s.eventsLock.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// 等待下一个OnConnStart事件，返回对应的连接，超时时测试失败
+func (s *Server) ExpectConnStart(timeout time.Duration) tiface.IConnection {
+	s.t.Helper()
+	return s.expectEvent(ConnStart, timeout)
+}
+
+// 等待下一个OnConnStop事件，返回对应的连接，超时时测试失败
+func (s *Server) ExpectConnStop(timeout time.Duration) tiface.IConnection {
+	s.t.Helper()
+	return s.expectEvent(ConnStop, timeout)
+}
+
+func (s *Server) expectEvent(typ EventType, timeout time.Duration) tiface.IConnection {
+	s.t.Helper()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		s.eventsLock.Lock()
+		if conns := s.pending[typ]; len(conns) > 0 {
+			s.pending[typ] = conns[1:]
+			s.eventsLock.Unlock()
+			return conns[0]
+		}
+		notify := s.notify
+		s.eventsLock.Unlock()
+
+		select {
+		case <-notify:
+		case <-deadline.C:
+			s.t.Fatalf("tnettest: no %s event in %v", typ, timeout)
+			return nil
+		}
+	}
+}
+
+// 创建一个连接到测试服务器的客户端，尚未连接，可以在Start之前设置序列化方式等
+func (s *Server) NewClient() *Client {
+	client := &Client{server: s, t: s.t}
+	if s.listener != nil {
+		addr := s.listener.Addr().(*net.TCPAddr)
+		client.Client = tnet.NewClient(addr.IP.String(), addr.Port)
+	} else {
+		client.Client = tnet.NewClient("pipe", 0)
+	}
+	s.t.Cleanup(client.Stop)
+	return client
+}
+
+// 创建一个客户端并连接到测试服务器，测试结束时自动关闭
+func (s *Server) Dial() *Client {
+	s.t.Helper()
+	client := s.NewClient()
+	client.Start()
+	return client
+}
+
+// 建立客户端到测试服务器的连接，通过net.Pipe建立连接时服务端立即开始处理
+func (s *Server) connect(client *tnet.Client) error {
+	if s.listener != nil {
+		return client.Start()
+	}
+	serverConn, clientConn := net.Pipe()
+	s.serveConn(serverConn)
+	if err := client.StartConn(clientConn); err != nil {
+		return fmt.Errorf("start pipe client: %w", err)
+	}
+	return nil
+}
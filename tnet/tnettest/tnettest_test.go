@@ -0,0 +1,118 @@
+package tnettest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/tnet"
+	"github.com/stretchr/testify/require"
+)
+
+type greeting struct {
+	Name string `json:"name"`
+	Text string `json:"text"`
+}
+
+// 回复请求数据的Router
+type echoRouter struct {
+	tnet.BaseRouter
+}
+
+func (router *echoRouter) Handle(request tiface.IRequest) {
+	request.GetConnection().SendMsg(request.GetMsgID(), request.GetData())
+}
+
+func TestServerParallel(t *testing.T) {
+	// 每个子测试使用自己的服务器，不需要固定端口，可以同时执行
+	for i := 0; i < 4; i++ {
+		i := i
+		t.Run(fmt.Sprintf("pipe-%d", i), func(t *testing.T) {
+			t.Parallel()
+			s := NewServer(t)
+			s.AddRouter(1, &echoRouter{})
+			require.Empty(t, s.Addr())
+
+			client := s.Dial()
+			data := []byte(fmt.Sprintf("hello %d", i))
+			client.Send(1, data)
+			require.Equal(t, data, client.Expect(1, time.Second).GetData())
+		})
+		t.Run(fmt.Sprintf("tcp-%d", i), func(t *testing.T) {
+			t.Parallel()
+			s := NewTCPServer(t)
+			s.AddRouter(1, &echoRouter{})
+
+			client := s.Dial()
+			data := []byte(fmt.Sprintf("hello %d", i))
+			client.Send(1, data)
+			require.Equal(t, data, client.Expect(1, time.Second).GetData())
+		})
+	}
+}
+
+func TestClientCodec(t *testing.T) {
+	s := NewServer(t)
+	tnet.AddHandler(s, 1, func(ctx context.Context, request tiface.IRequest, msg *greeting) error {
+		return request.GetConnection().SendValue(2, &greeting{Text: "hello " + msg.Name})
+	})
+
+	// 在Start之前设置客户端提出的序列化方式
+	client := s.NewClient()
+	client.SetCodecs(tnet.CodecJSON)
+	client.Start()
+	require.NoError(t, client.SendValue(1, &greeting{Name: "tigerkin"}))
+
+	var reply greeting
+	client.ExpectValue(2, &reply, time.Second)
+	require.Equal(t, "hello tigerkin", reply.Text)
+	client.ExpectNone(20 * time.Millisecond)
+}
+
+func TestServerEvents(t *testing.T) {
+	s := NewServer(t)
+	var started []uint32
+	s.SetOnConnStart(func(conn tiface.IConnection) {
+		started = append(started, conn.GetConnID())
+		conn.SetProperty("started", true)
+	})
+
+	first := s.Dial()
+	conn1 := s.ExpectConnStart(time.Second)
+	second := s.Dial()
+	conn2 := s.ExpectConnStart(time.Second)
+	require.NotEqual(t, conn1.GetConnID(), conn2.GetConnID())
+	require.Equal(t, []uint32{conn1.GetConnID(), conn2.GetConnID()}, started)
+
+	// 事件在Hook函数返回之后才被记录
+	value, err := conn2.GetProperty("started")
+	require.NoError(t, err)
+	require.Equal(t, true, value)
+
+	second.Stop()
+	require.Equal(t, conn2, s.ExpectConnStop(time.Second))
+	first.Stop()
+	require.Equal(t, conn1, s.ExpectConnStop(time.Second))
+	require.Equal(t, []Event{
+		{Type: ConnStart, Conn: conn1},
+		{Type: ConnStart, Conn: conn2},
+		{Type: ConnStop, Conn: conn2},
+		{Type: ConnStop, Conn: conn1},
+	}, s.Events())
+	require.Equal(t, "ConnStop", ConnStop.String())
+}
+
+func TestClientExpectClosed(t *testing.T) {
+	s := NewServer(t)
+	s.AddRouter(1, &echoRouter{})
+
+	client := s.Dial()
+	conn := s.ExpectConnStart(time.Second)
+
+	// 服务器关闭连接
+	client.Send(1, []byte("bye"))
+	conn.Stop()
+	client.ExpectClosed(time.Second)
+}
@@ -0,0 +1,262 @@
+package tnet
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+)
+
+// 客户端订阅主题消息的操作类型，为消息数据的第一个字节，之后为主题
+const (
+	TopicOpSubscribe   byte = 1
+	TopicOpUnsubscribe byte = 2
+)
+
+// 主题的通配符
+const (
+	TopicWildcardOne  = "*" // 匹配任意一段
+	TopicWildcardTail = ">" // 匹配之后的一段或多段
+)
+
+/*
+	主题前缀树中的一个节点，每一段对应一层
+*/
+type topicNode struct {
+	// 下一段对应的子节点，通配符'*'也作为一个子节点
+	children map[string]*topicNode
+	// 在该节点结束的订阅
+	subs map[uint32]tiface.IConnection
+	// 在该节点之后以'>'结束的订阅
+	tailSubs map[uint32]tiface.IConnection
+}
+
+func newTopicNode() *topicNode {
+	return &topicNode{
+		children: make(map[string]*topicNode),
+		subs:     make(map[uint32]tiface.IConnection),
+		tailSubs: make(map[uint32]tiface.IConnection),
+	}
+}
+
+// 节点上没有任何订阅和子节点时可以被删除
+func (n *topicNode) empty() bool {
+	return len(n.children) == 0 && len(n.subs) == 0 && len(n.tailSubs) == 0
+}
+
+/*
+	主题订阅管理模块
+*/
+type TopicManager struct {
+	// 订阅的前缀树
+	root *topicNode
+	// 每个连接的订阅，ConnID -> 订阅的主题
+	connSubs map[uint32]map[string]struct{}
+	// 客户端通过消息订阅主题时的权限检查
+	authorizer func(conn tiface.IConnection, pattern string) error
+	// 保护订阅信息的读写锁
+	topicLock sync.RWMutex
+}
+
+// 创建一个主题订阅管理模块
+func NewTopicManager() *TopicManager {
+	return &TopicManager{
+		root:     newTopicNode(),
+		connSubs: make(map[uint32]map[string]struct{}),
+	}
+}
+
+// 将主题拆分为多段，检查每一段都不为空，allowWildcard为false时不能包含通配符
+func splitTopic(topic string, allowWildcard bool) ([]string, error) {
+	if topic == "" {
+		return nil, errors.New("empty topic")
+	}
+	segments := strings.Split(topic, ".")
+	for i, segment := range segments {
+		switch {
+		case segment == "":
+			return nil, fmt.Errorf("topic %q has empty segment", topic)
+		case !allowWildcard && (segment == TopicWildcardOne || segment == TopicWildcardTail):
+			return nil, fmt.Errorf("topic %q can not contain wildcard", topic)
+		case segment == TopicWildcardTail && i != len(segments)-1:
+			return nil, fmt.Errorf("wildcard %s must be the last segment of %q", TopicWildcardTail, topic)
+		}
+	}
+	return segments, nil
+}
+
+// 连接订阅主题
+func (tm *TopicManager) Subscribe(conn tiface.IConnection, pattern string) error {
+	segments, err := splitTopic(pattern, true)
+	if err != nil {
+		return err
+	}
+
+	tm.topicLock.Lock()
+	defer tm.topicLock.Unlock()
+
+	// 已经停止的连接不能再订阅，避免残留订阅（连接停止时先标记关闭，再取消全部订阅）
+	if c, ok := conn.(interface{ closed() bool }); ok && c.closed() {
+		return errors.New("connection closed")
+	}
+
+	subs := tm.connSubs[conn.GetConnID()]
+	if _, ok := subs[pattern]; ok {
+		return nil
+	}
+	if max := utils.GlobalObject.TopicMaxSubscriptions; max > 0 && len(subs) >= max {
+		return fmt.Errorf("too many subscriptions, max = %d", max)
+	}
+
+	node := tm.root
+	last := segments[len(segments)-1]
+	if last == TopicWildcardTail {
+		segments = segments[:len(segments)-1]
+	}
+	for _, segment := range segments {
+		child, ok := node.children[segment]
+		if !ok {
+			child = newTopicNode()
+			node.children[segment] = child
+		}
+		node = child
+	}
+	if last == TopicWildcardTail {
+		node.tailSubs[conn.GetConnID()] = conn
+	} else {
+		node.subs[conn.GetConnID()] = conn
+	}
+
+	if subs == nil {
+		subs = make(map[string]struct{})
+		tm.connSubs[conn.GetConnID()] = subs
+	}
+	subs[pattern] = struct{}{}
+	return nil
+}
+
+// 连接取消订阅主题
+func (tm *TopicManager) Unsubscribe(conn tiface.IConnection, pattern string) {
+	tm.topicLock.Lock()
+	defer tm.topicLock.Unlock()
+
+	tm.unsubscribe(conn.GetConnID(), pattern)
+}
+
+// 连接取消全部订阅
+func (tm *TopicManager) UnsubscribeAll(conn tiface.IConnection) {
+	tm.topicLock.Lock()
+	defer tm.topicLock.Unlock()
+
+	for pattern := range tm.connSubs[conn.GetConnID()] {
+		tm.unsubscribe(conn.GetConnID(), pattern)
+	}
+}
+
+// 取消订阅，并删除不再需要的节点，调用者需要持有写锁
+func (tm *TopicManager) unsubscribe(connID uint32, pattern string) {
+	subs, ok := tm.connSubs[connID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[pattern]; !ok {
+		return
+	}
+	delete(subs, pattern)
+	if len(subs) == 0 {
+		delete(tm.connSubs, connID)
+	}
+
+	segments := strings.Split(pattern, ".")
+	tail := segments[len(segments)-1] == TopicWildcardTail
+	if tail {
+		segments = segments[:len(segments)-1]
+	}
+
+	// 记录经过的节点，删除订阅之后从下往上删除空节点
+	path := []*topicNode{tm.root}
+	node := tm.root
+	for _, segment := range segments {
+		node = node.children[segment]
+		if node == nil {
+			return
+		}
+		path = append(path, node)
+	}
+	if tail {
+		delete(node.tailSubs, connID)
+	} else {
+		delete(node.subs, connID)
+	}
+	for i := len(segments); i > 0 && path[i].empty(); i-- {
+		delete(path[i-1].children, segments[i-1])
+	}
+}
+
+// 获取连接的全部订阅
+func (tm *TopicManager) GetSubscriptions(connID uint32) []string {
+	tm.topicLock.RLock()
+	defer tm.topicLock.RUnlock()
+
+	patterns := make([]string, 0, len(tm.connSubs[connID]))
+	for pattern := range tm.connSubs[connID] {
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// 给订阅了主题的全部连接发送消息，一个连接有多个订阅匹配时只发送一次
+func (tm *TopicManager) Publish(topic string, msgId uint32, data []byte) error {
+	segments, err := splitTopic(topic, false)
+	if err != nil {
+		return err
+	}
+
+	matched := make(map[uint32]tiface.IConnection)
+	tm.topicLock.RLock()
+	tm.match(tm.root, segments, matched)
+	tm.topicLock.RUnlock()
+
+	conns := make([]tiface.IConnection, 0, len(matched))
+	for _, conn := range matched {
+		conns = append(conns, conn)
+	}
+	return sendToConns(conns, msgId, data, nil)
+}
+
+// 在前缀树中查找与主题匹配的全部订阅
+func (tm *TopicManager) match(node *topicNode, segments []string, matched map[uint32]tiface.IConnection) {
+	if len(segments) == 0 {
+		for connID, conn := range node.subs {
+			matched[connID] = conn
+		}
+		return
+	}
+
+	// '>'匹配剩余的一段或多段
+	for connID, conn := range node.tailSubs {
+		matched[connID] = conn
+	}
+	if child, ok := node.children[segments[0]]; ok {
+		tm.match(child, segments[1:], matched)
+	}
+	if child, ok := node.children[TopicWildcardOne]; ok {
+		tm.match(child, segments[1:], matched)
+	}
+}
+
+// 设置客户端通过消息订阅主题时的权限检查
+func (tm *TopicManager) SetAuthorizer(authorizer func(conn tiface.IConnection, pattern string) error) {
+	tm.authorizer = authorizer
+}
+
+// 检查客户端能否订阅主题，未设置权限检查时全部允许
+func (tm *TopicManager) Authorize(conn tiface.IConnection, pattern string) error {
+	if tm.authorizer == nil {
+		return nil
+	}
+	return tm.authorizer(conn, pattern)
+}
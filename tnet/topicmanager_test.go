@@ -0,0 +1,119 @@
+package tnet
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopicMatch(t *testing.T) {
+	s := NewServer()
+	tm := s.GetTopicMgr().(*TopicManager)
+
+	var conns []*Connection
+	for i := uint32(1); i <= 4; i++ {
+		serverConn, client := newTCPPair(t)
+		defer client.Close()
+		conns = append(conns, NewConnection(s, serverConn, 470+i, s.(*Server).msgHandler))
+	}
+	require.NoError(t, tm.Subscribe(conns[0], "market.gold.price"))
+	require.NoError(t, tm.Subscribe(conns[1], "market.*.price"))
+	require.NoError(t, tm.Subscribe(conns[2], "market.>"))
+	require.NoError(t, tm.Subscribe(conns[3], "guild.1.>"))
+	// 多个订阅同时匹配时只发送一次
+	require.NoError(t, tm.Subscribe(conns[2], "market.gold.*"))
+
+	received := func() []uint32 {
+		var ids []uint32
+		for _, c := range conns {
+			for len(c.msgBuffChan) > 0 {
+				<-c.msgBuffChan
+				ids = append(ids, c.GetConnID())
+			}
+		}
+		return ids
+	}
+
+	require.NoError(t, tm.Publish("market.gold.price", 1, []byte("1900")))
+	require.Equal(t, []uint32{471, 472, 473}, received())
+	require.NoError(t, tm.Publish("market.silver.price", 1, []byte("24")))
+	require.Equal(t, []uint32{472, 473}, received())
+	// '>'至少匹配一段
+	require.NoError(t, tm.Publish("market", 1, nil))
+	require.Empty(t, received())
+	require.NoError(t, tm.Publish("guild.1.event.war", 1, nil))
+	require.Equal(t, []uint32{474}, received())
+
+	// 发布的主题不能包含通配符，订阅的主题格式必须正确
+	require.Error(t, tm.Publish("market.*", 1, nil))
+	require.Error(t, tm.Subscribe(conns[0], "market.>.price"))
+	require.Error(t, tm.Subscribe(conns[0], "market..price"))
+	require.Error(t, tm.Subscribe(conns[0], ""))
+
+	tm.Unsubscribe(conns[1], "market.*.price")
+	require.NoError(t, tm.Publish("market.silver.price", 1, nil))
+	require.Equal(t, []uint32{473}, received())
+	require.ElementsMatch(t, []string{"market.>", "market.gold.*"}, tm.GetSubscriptions(473))
+
+	// 连接停止时取消全部订阅，空节点被删除
+	conns[2].Stop()
+	require.Empty(t, tm.GetSubscriptions(473))
+	require.Error(t, tm.Subscribe(conns[2], "market.>"))
+	tm.Unsubscribe(conns[0], "market.gold.price")
+	conns[3].Stop()
+	require.True(t, tm.root.empty())
+}
+
+func TestTopicMaxSubscriptions(t *testing.T) {
+	conf := *utils.GlobalObject
+	defer func() { *utils.GlobalObject = conf }()
+	utils.GlobalObject.TopicMaxSubscriptions = 2
+
+	s := NewServer()
+	serverConn, client := newTCPPair(t)
+	defer client.Close()
+	conn := NewConnection(s, serverConn, 480, s.(*Server).msgHandler)
+
+	require.NoError(t, s.GetTopicMgr().Subscribe(conn, "a"))
+	require.NoError(t, s.GetTopicMgr().Subscribe(conn, "b"))
+	require.NoError(t, s.GetTopicMgr().Subscribe(conn, "a"))
+	require.Error(t, s.GetTopicMgr().Subscribe(conn, "c"))
+}
+
+func TestTopicClientSubscribe(t *testing.T) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 0
+
+	s := NewServer()
+	s.GetTopicMgr().SetAuthorizer(func(conn tiface.IConnection, pattern string) error {
+		if strings.HasPrefix(pattern, "admin.") {
+			return errors.New("permission denied")
+		}
+		return nil
+	})
+
+	client := newTestClient(t, s, 481)
+	require.NoError(t, client.Start())
+	require.NoError(t, client.Subscribe("zone.*.weather"))
+	require.ErrorContains(t, client.Subscribe("admin.>"), "permission denied")
+	require.ErrorContains(t, client.Subscribe("zone.>.weather"), "must be the last segment")
+
+	require.NoError(t, s.GetTopicMgr().Publish("zone.3.weather", 7, []byte("rain")))
+	msg, err := client.ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, uint32(7), msg.GetMsgId())
+	require.Equal(t, "rain", string(msg.GetData()))
+
+	require.NoError(t, client.Unsubscribe("zone.*.weather"))
+	require.NoError(t, s.GetTopicMgr().Publish("zone.3.weather", 7, []byte("sun")))
+	require.NoError(t, client.Subscribe("zone.4.weather"))
+	require.NoError(t, s.GetTopicMgr().Publish("zone.4.weather", 7, []byte("snow")))
+	msg, err = client.ReadMsg()
+	require.NoError(t, err)
+	require.Equal(t, "snow", string(msg.GetData()))
+}
@@ -0,0 +1,88 @@
+package tnet
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"google.golang.org/protobuf/proto"
+)
+
+// 可以被解码的proto消息类型约束：PT是*T，并且实现了proto.Message
+type ProtoMessage[T any] interface {
+	*T
+	proto.Message
+}
+
+// 消息的校验接口（例如protoc-gen-validate生成的Validate方法），解码之后自动进行校验
+type validator interface {
+	Validate() error
+}
+
+/*
+	类型化路由，将请求数据解码为T类型的消息并校验之后交给handler处理
+	解码、校验失败或者handler返回错误时，统一交给Server的OnHandlerError处理
+*/
+type typedRouter[T any] struct {
+	BaseRouter
+	// 路由所属的Server
+	server tiface.IServer
+	// 获取解码请求数据使用的序列化方式
+	codec func(request tiface.IRequest) tiface.ICodec
+	// 处理解码后消息的业务方法
+	handler func(ctx context.Context, request tiface.IRequest, msg *T) error
+}
+
+func (r *typedRouter[T]) Handle(request tiface.IRequest) {
+	if err := r.handle(request); err != nil {
+		r.server.CallOnHandlerError(request, err)
+	}
+}
+
+func (r *typedRouter[T]) handle(request tiface.IRequest) error {
+	msg := new(T)
+	if err := r.codec(request).Unmarshal(request.GetData(), msg); err != nil {
+		return fmt.Errorf("unmarshal %s error: %w", typeName(msg), err)
+	}
+	if v, ok := any(msg).(validator); ok {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("validate %s error: %w", typeName(msg), err)
+		}
+	}
+	return r.handler(request.Context(), request, msg)
+}
+
+// 错误信息中使用的消息类型名称，proto消息使用proto中定义的全名
+func typeName(msg interface{}) string {
+	if m, ok := msg.(proto.Message); ok {
+		return string(m.ProtoReflect().Descriptor().FullName())
+	}
+	return fmt.Sprintf("%T", msg)
+}
+
+// 给Server注册一个处理proto消息的业务方法，请求数据被解码为handler参数的类型，例如：
+//
+//	tnet.AddProtoHandler(s, 3, func(ctx context.Context, request tiface.IRequest, msg *pb.Position) error {...})
+func AddProtoHandler[T any, PT ProtoMessage[T]](s tiface.IServer, msgId uint32, handler func(ctx context.Context, request tiface.IRequest, msg PT) error) {
+	codec := GetCodec(CodecProto)
+	s.AddRouter(msgId, &typedRouter[T]{
+		server: s,
+		codec:  func(tiface.IRequest) tiface.ICodec { return codec },
+		handler: func(ctx context.Context, request tiface.IRequest, msg *T) error {
+			return handler(ctx, request, PT(msg))
+		},
+	})
+}
+
+// 给Server注册一个处理类型化消息的业务方法，请求数据使用连接上msgId对应的序列化方式解码为handler参数的类型，例如：
+//
+//	tnet.AddHandler(s, 5, func(ctx context.Context, request tiface.IRequest, msg *LoginReq) error {...})
+func AddHandler[T any](s tiface.IServer, msgId uint32, handler func(ctx context.Context, request tiface.IRequest, msg *T) error) {
+	s.AddRouter(msgId, &typedRouter[T]{
+		server: s,
+		codec: func(request tiface.IRequest) tiface.ICodec {
+			return request.GetConnection().GetCodec(request.GetMsgID())
+		},
+		handler: handler,
+	})
+}
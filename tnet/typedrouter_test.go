@@ -0,0 +1,77 @@
+package tnet
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/HOU-SZ/tigerkin/tiface"
+	"github.com/HOU-SZ/tigerkin/utils"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// 带有Validate方法的proto消息，内容不能为空
+type validatedName struct {
+	wrapperspb.StringValue
+}
+
+func (v *validatedName) Validate() error {
+	if v.Value == "" {
+		return errors.New("empty name")
+	}
+	return nil
+}
+
+func TestAddProtoHandler(t *testing.T) {
+	conf := *utils.GlobalObject
+	t.Cleanup(func() { *utils.GlobalObject = conf })
+	utils.GlobalObject.WorkerPoolSize = 0
+
+	s := NewServer()
+	handleErrors := make(chan error, 10)
+	s.SetOnHandlerError(func(request tiface.IRequest, err error) {
+		handleErrors <- err
+	})
+
+	// 将名字转换为大写之后回复
+	AddProtoHandler(s, 1, func(ctx context.Context, request tiface.IRequest, msg *wrapperspb.StringValue) error {
+		if msg.Value == "fail" {
+			return errors.New("handler failed")
+		}
+		return request.GetConnection().SendProto(1, wrapperspb.String(strings.ToUpper(msg.Value)))
+	})
+	AddProtoHandler(s, 2, func(ctx context.Context, request tiface.IRequest, msg *validatedName) error {
+		return request.GetConnection().SendProto(2, wrapperspb.String("hello "+msg.Value))
+	})
+
+	client := newTestClient(t, s, 430)
+	require.NoError(t, client.Start())
+
+	require.NoError(t, client.SendProto(1, wrapperspb.String("tigerkin")))
+	msg, err := client.ReadMsg()
+	require.NoError(t, err)
+	reply := &wrapperspb.StringValue{}
+	require.NoError(t, proto.Unmarshal(msg.GetData(), reply))
+	require.Equal(t, "TIGERKIN", reply.Value)
+
+	// handler返回的错误
+	require.NoError(t, client.SendProto(1, wrapperspb.String("fail")))
+	require.EqualError(t, <-handleErrors, "handler failed")
+
+	// 解码失败
+	require.NoError(t, client.SendMsg(1, []byte{0xFF}))
+	require.ErrorContains(t, <-handleErrors, "unmarshal google.protobuf.StringValue error")
+
+	// 校验失败
+	require.NoError(t, client.SendProto(2, wrapperspb.String("")))
+	require.ErrorContains(t, <-handleErrors, "empty name")
+
+	require.NoError(t, client.SendProto(2, wrapperspb.String("player")))
+	msg, err = client.ReadMsg()
+	require.NoError(t, err)
+	require.NoError(t, proto.Unmarshal(msg.GetData(), reply))
+	require.Equal(t, "hello player", reply.Value)
+}
@@ -28,16 +28,104 @@ type GlobalObj struct {
 	*/
 	Version       string //当前Tigerkin版本号
 	MaxPacketSize uint32 //当前框架数据包的最大值
+	MaxMsgSize    uint32 //超过MaxPacketSize的消息被拆分为多个分片发送，重组后的消息总长度不能超过该值
 	MaxConn       int    //当前服务器主机允许的最大链接个数
 
 	WorkerPoolSize   uint32 //业务工作Worker池的goroutine数量
 	MaxWorkerTaskLen uint32 //每个worker对应的消息队列中任务数量的最大值
+	MaxConnRequests  uint32 //未开启工作池时每个连接同时处理的请求数量上限，以及每个连接同时处理的流式消息数量上限，达到上限时暂停读取该连接，0表示不限制
+	TimerTick        int    //定时任务时间轮每一格的时长（毫秒），定时任务的精度
 
 	MaxMsgChanLen uint32 //SendBuffMsg发送消息的缓冲最大长度
 
+	StreamIdleTimeout int //流式消息两个分片之间等待的超时时间（毫秒），超时的连接将被关闭，0表示不限制
+
+	FrameFlags        bool   //消息头部是否携带1字节的标志位（压缩算法等），通信双方必须一致
+	Compressor        string //发送消息时使用的压缩算法：gzip、deflate或自行注册的压缩算法，为空表示不压缩，需要开启FrameFlags
+	CompressThreshold uint32 //消息数据达到该长度时才进行压缩
+
+	FrameChecksum    bool   //消息头部是否携带4字节的CRC32C校验和，通信双方必须一致
+	FrameMagic       uint16 //每个消息开头的2字节同步标记，为0表示不使用，通信双方必须一致
+	FrameErrorPolicy string //消息校验失败时的处理策略：close（关闭连接）、resync（丢弃损坏的消息并重新同步）
+
+	/*
+		RateLimit
+	*/
+	RateLimitGlobal        RateLimitConf            //整个Server所有连接共享的限流参数
+	RateLimitConn          RateLimitConf            //每个连接的限流参数
+	RateLimitMsg           map[uint32]RateLimitConf //每个连接上各个MsgId的限流参数
+	RateLimitPolicy        string                   //超出限流后的处理策略：drop、delay、reply、disconnect
+	RateLimitMaxViolations uint32                   //disconnect策略下，连接被断开之前允许的超限次数
+	RateLimitMsgId         uint32                   //reply策略下，回复给客户端的限流错误消息ID
+
+	/*
+		Auth
+	*/
+	AuthMsgId   uint32 //鉴权消息ID，连接在鉴权通过之前只能发送该消息
+	AuthTimeout int    //连接建立后等待鉴权的超时时间（秒），超时未通过鉴权的连接将被关闭
+
+	/*
+		SecureChannel
+	*/
+	SecureChannel    string //应用层加密使用的算法：aes-gcm、chacha20-poly1305，为空表示不加密
+	KeyExchangeMsgId uint32 //连接建立时交换X25519公钥使用的消息ID
+
+	/*
+		Codec
+	*/
+	Codec      string   //默认的序列化方式：json、proto、gob或自行注册的序列化方式
+	Codecs     []string //客户端可以协商使用的序列化方式，为空表示可以使用任意已注册的序列化方式
+	CodecMsgId uint32   //客户端协商序列化方式使用的消息ID
+
+	/*
+		Topic
+	*/
+	TopicMsgId            uint32 //客户端订阅、取消订阅主题使用的消息ID
+	TopicMaxSubscriptions int    //每个连接最多订阅的主题数量，0表示不限制
+
+	/*
+		Session
+	*/
+	SessionMsgId       uint32 //客户端创建、恢复会话使用的消息ID
+	SessionGracePeriod int    //连接断开之后会话保留的时间（秒），0表示不开启会话
+	SessionMaxPending  int    //会话等待恢复期间最多暂存的消息数量，0表示不限制
+
+	/*
+		Reliable
+	*/
+	ReliableMsgId  uint32 //可靠消息和确认消息使用的消息ID
+	ReliableWindow int    //最多保留的尚未被对端确认的可靠消息数量，超出时SendReliable返回错误，0表示不限制
+
+	/*
+		UnknownMsg
+	*/
+	UnknownMsgReply    bool   //收到没有注册处理方法的消息时，是否回复错误消息（设置了NotFound Router时由Router处理）
+	UnknownMsgId       uint32 //回复给客户端的未知消息错误消息ID，数据为未知消息的msgId（uint32小端序）
+	UnknownMsgMaxCount uint32 //时间窗口内每个连接允许的未知消息数量，超出后断开连接，0表示不限制
+	UnknownMsgWindow   int    //统计未知消息数量的时间窗口（秒）
+
+	/*
+		Gateway
+	*/
+	GatewayMsgId             uint32 //网关与后端之间转发消息使用的消息ID
+	GatewayReconnectInterval int    //网关与后端之间的连接断开之后重连的间隔（毫秒）
+
+	/*
+		Record
+	*/
+	RecordDir string //录制每个连接收发的消息的目录，每个连接一个文件，为空表示不录制，录制中含有鉴权Token等明文
+
 	ConfFilePath string // 配置文件路径
 }
 
+/*
+	令牌桶限流参数
+*/
+type RateLimitConf struct {
+	Rate  float64 //每秒产生的令牌数量，即每秒允许的消息数量，小于等于0表示不限流
+	Burst int     //令牌桶的容量，即允许的突发消息数量
+}
+
 /*
 	定义一个全局的对象
 */
@@ -79,11 +167,47 @@ func init() {
 		Host:          "0.0.0.0",
 		MaxConn:       100,
 		MaxPacketSize: 4096,
+		MaxMsgSize:    1 << 20,
 
 		WorkerPoolSize:   10,
 		MaxWorkerTaskLen: 1024,
+		MaxConnRequests:  1024,
+		TimerTick:        10,
 		MaxMsgChanLen:    1024,
 
+		StreamIdleTimeout: 10000,
+
+		CompressThreshold: 1024,
+
+		FrameErrorPolicy: "close",
+
+		RateLimitPolicy:        "drop",
+		RateLimitMaxViolations: 10,
+		RateLimitMsgId:         0xFFFF0001,
+
+		AuthMsgId:   0xFFFF0002,
+		AuthTimeout: 10,
+
+		KeyExchangeMsgId: 0xFFFF0003,
+
+		Codec:      "proto",
+		CodecMsgId: 0xFFFF0004,
+
+		TopicMsgId:            0xFFFF0005,
+		TopicMaxSubscriptions: 100,
+
+		SessionMsgId:      0xFFFF0006,
+		SessionMaxPending: 1024,
+
+		ReliableMsgId:  0xFFFF0007,
+		ReliableWindow: 1024,
+
+		UnknownMsgId:     0xFFFF0008,
+		UnknownMsgWindow: 60,
+
+		GatewayMsgId:             0xFFFF0009,
+		GatewayReconnectInterval: 1000,
+
 		ConfFilePath: pwd + "/conf/tigerkin.json",
 	}
 